@@ -0,0 +1,432 @@
+package app
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/SUSE/fissile/compilator"
+	"github.com/SUSE/fissile/model"
+	"github.com/SUSE/fissile/util"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// cacheBundleFormat is the version of the format of compilation cache
+	// bundles written
+	cacheBundleFormat = 1
+	// cacheBundleManifestName is the name of the manifest in the bundle,
+	// which is its first entry
+	cacheBundleManifestName = "manifest.json"
+	// cacheBundlePackagesDir is the directory of the bundle holding a tar.gz
+	// archive per compiled package, named after its fingerprint
+	cacheBundlePackagesDir = "packages"
+)
+
+// CacheBundleManifest describes the contents of a compilation cache bundle.
+// The stemcell identity is missing from bundles of older versions of
+// fissile, and of packages compiled by them.
+type CacheBundleManifest struct {
+	Format           int                          `json:"format"`
+	FissileVersion   string                       `json:"fissile_version"`
+	Stemcell         string                       `json:"stemcell"`
+	StemcellIdentity *compilator.StemcellIdentity `json:"stemcell_identity,omitempty"`
+	Created          time.Time                    `json:"created"`
+	Packages         []CacheBundlePackage         `json:"packages"`
+}
+
+// CacheBundlePackage describes a compiled package in a compilation cache
+// bundle; Modified is the time it was compiled at
+type CacheBundlePackage struct {
+	Release     string    `json:"release"`
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"`
+	SHA256      string    `json:"sha256"`
+	Modified    time.Time `json:"modified"`
+}
+
+// cacheBundleFingerprint matches the fingerprints which are safe to use as
+// directory names
+var cacheBundleFingerprint = regexp.MustCompile(`^[0-9a-zA-Z_][0-9a-zA-Z_.-]*$`)
+
+// ExportCompilationCache writes the packages of the releases and roles which
+// are compiled on the stemcell image into a bundle, for ImportCompilationCache
+// on other hosts. If the image was compiled on for several stemcells, the
+// packages of the stemcell compiled on last are written. Packages which are
+// not compiled are skipped.
+func (f *Fissile) ExportCompilationCache(bundlePath, compilationDir, stemcellImageName, roleManifestPath string, roleNames, releaseNames []string) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	releases, err := f.getReleasesByName(releaseNames)
+	if err != nil {
+		return err
+	}
+
+	roles, err := roleManifest.SelectRoles(roleNames)
+	if err != nil {
+		return fmt.Errorf("Error selecting packages to export: %s", err.Error())
+	}
+
+	tempDir, err := ioutil.TempDir("", "fissile-cache-export")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	workDir, err := compilator.FindStemcellCompilationDir(compilationDir, compilator.StemcellIdentity{Image: stemcellImageName})
+	if err != nil {
+		return err
+	}
+	stemcell, err := compilator.ReadStemcellCompilationDir(workDir)
+	if err != nil {
+		return err
+	}
+	manifest := CacheBundleManifest{
+		Format:           cacheBundleFormat,
+		FissileVersion:   f.Version,
+		Stemcell:         stemcellImageName,
+		StemcellIdentity: stemcell,
+		Created:          time.Now().UTC(),
+		Packages:         []CacheBundlePackage{},
+	}
+
+	for _, pkg := range rolePackages(releases, roles) {
+		compiledDir := pkg.GetPackageCompiledDir(workDir)
+		info, err := os.Stat(compiledDir)
+		if os.IsNotExist(err) {
+			f.UI.Printf("skipped: %s/%s is not compiled\n",
+				color.YellowString(pkg.Release.Name),
+				color.YellowString(pkg.Name))
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		digest, err := writePackageArchive(compiledDir, filepath.Join(tempDir, pkg.Fingerprint+".tgz"))
+		if err != nil {
+			return fmt.Errorf("Error archiving package %s/%s: %s", pkg.Release.Name, pkg.Name, err.Error())
+		}
+
+		manifest.Packages = append(manifest.Packages, CacheBundlePackage{
+			Release:     pkg.Release.Name,
+			Name:        pkg.Name,
+			Fingerprint: pkg.Fingerprint,
+			SHA256:      digest,
+			Modified:    info.ModTime().UTC(),
+		})
+	}
+
+	if len(manifest.Packages) == 0 {
+		return fmt.Errorf("None of the selected packages are compiled for stemcell %s", stemcellImageName)
+	}
+
+	if err := writeCacheBundle(bundlePath, tempDir, manifest); err != nil {
+		return fmt.Errorf("Error writing bundle %s: %s", bundlePath, err.Error())
+	}
+
+	f.UI.Printf("Exported %s packages to %s\n",
+		color.MagentaString(fmt.Sprintf("%d", len(manifest.Packages))),
+		color.MagentaString(bundlePath))
+	return nil
+}
+
+// rolePackages returns the packages of the releases used by the roles,
+// including their dependencies, once per fingerprint
+func rolePackages(releases []*model.Release, roles model.Roles) model.Packages {
+	selected := map[*model.Release]bool{}
+	for _, release := range releases {
+		selected[release] = true
+	}
+
+	var packages model.Packages
+	seen := map[string]bool{}
+	var add func(pkg *model.Package)
+	add = func(pkg *model.Package) {
+		if seen[pkg.Fingerprint] || !selected[pkg.Release] {
+			return
+		}
+		seen[pkg.Fingerprint] = true
+		packages = append(packages, pkg)
+		for _, dep := range pkg.Dependencies {
+			add(dep)
+		}
+	}
+
+	for _, role := range roles {
+		for _, roleJob := range role.RoleJobs {
+			for _, pkg := range roleJob.Packages {
+				add(pkg)
+			}
+		}
+	}
+
+	return packages
+}
+
+// writePackageArchive archives the compiled package directory as a tar.gz
+// file, and returns its SHA256 digest
+func writePackageArchive(compiledDir, archivePath string) (string, error) {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if err := util.WriteTargz(compiledDir, io.MultiWriter(file, hash)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), file.Close()
+}
+
+// writeCacheBundle writes the bundle as a tar file holding the manifest, then
+// the package archives found in the archive directory
+func writeCacheBundle(bundlePath, archiveDir string, manifest CacheBundleManifest) error {
+	manifestContents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stream := tar.NewWriter(file)
+	err = util.WriteToTarStream(stream, manifestContents, tar.Header{
+		Name:    cacheBundleManifestName,
+		ModTime: manifest.Created,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range manifest.Packages {
+		err := util.CopyFileToTarStream(stream, filepath.Join(archiveDir, pkg.Fingerprint+".tgz"), &tar.Header{
+			Name:    path.Join(cacheBundlePackagesDir, pkg.Fingerprint+".tgz"),
+			ModTime: pkg.Modified,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := stream.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ImportCompilationCache merges the packages of a bundle written by
+// ExportCompilationCache into the compilation directory. The whole bundle is
+// validated against its manifest before anything is merged. Packages which
+// are compiled already, at the same time as in the bundle or later, are
+// kept. If the stemcell image is given, the bundle must have been exported
+// for it. The packages are recorded as compiled on the stemcell of the
+// bundle; bundles which do not identify it, from older versions of fissile,
+// are imported for the stemcell image name alone.
+func (f *Fissile) ImportCompilationCache(bundlePath, compilationDir, stemcellImageName string) error {
+	tempDir, err := ioutil.TempDir("", "fissile-cache-import")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	manifest, err := readCacheBundle(bundlePath, tempDir)
+	if err != nil {
+		return fmt.Errorf("Error reading bundle %s: %s", bundlePath, err.Error())
+	}
+
+	if stemcellImageName != "" && stemcellImageName != manifest.Stemcell {
+		return fmt.Errorf("Bundle %s was exported for stemcell %s, not %s", bundlePath, manifest.Stemcell, stemcellImageName)
+	}
+	if manifest.FissileVersion != f.Version {
+		f.UI.Printf("%s: Bundle %s was exported by fissile %s\n",
+			color.YellowString("Warning"), bundlePath, manifest.FissileVersion)
+	}
+
+	stemcell := compilator.StemcellIdentity{Image: manifest.Stemcell}
+	if manifest.StemcellIdentity != nil {
+		stemcell = *manifest.StemcellIdentity
+	} else {
+		f.UI.Printf("%s: Bundle %s does not identify the stemcell; its packages are used by \"build packages\" with --reuse-unrecorded-packages only\n",
+			color.YellowString("Warning"), bundlePath)
+	}
+	workDir := compilator.StemcellCompilationDir(compilationDir, stemcell)
+	if manifest.StemcellIdentity != nil {
+		if err := compilator.RecordStemcellCompilationDir(workDir, stemcell); err != nil {
+			return err
+		}
+	}
+
+	imported := 0
+	for _, bundlePkg := range manifest.Packages {
+		pkg := &model.Package{Fingerprint: bundlePkg.Fingerprint}
+		compiledDir := pkg.GetPackageCompiledDir(workDir)
+
+		info, err := os.Stat(compiledDir)
+		if err == nil && !info.ModTime().Before(bundlePkg.Modified) {
+			f.UI.Printf("kept:     %s/%s is up to date\n",
+				color.YellowString(bundlePkg.Release),
+				color.YellowString(bundlePkg.Name))
+			continue
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := importPackageArchive(filepath.Join(tempDir, bundlePkg.Fingerprint+".tgz"), pkg, workDir, manifest.StemcellIdentity); err != nil {
+			return fmt.Errorf("Error importing package %s/%s: %s", bundlePkg.Release, bundlePkg.Name, err.Error())
+		}
+		if err := os.Chtimes(compiledDir, bundlePkg.Modified, bundlePkg.Modified); err != nil {
+			return err
+		}
+
+		f.UI.Printf("imported: %s/%s\n",
+			color.GreenString(bundlePkg.Release),
+			color.GreenString(bundlePkg.Name))
+		imported++
+	}
+
+	f.UI.Printf("Imported %s of %s packages into %s\n",
+		color.MagentaString(fmt.Sprintf("%d", imported)),
+		color.MagentaString(fmt.Sprintf("%d", len(manifest.Packages))),
+		color.MagentaString(workDir))
+	return nil
+}
+
+// readCacheBundle reads the manifest of the bundle, and extracts the package
+// archives into the directory after checking them against the manifest
+func readCacheBundle(bundlePath, archiveDir string) (*CacheBundleManifest, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stream := tar.NewReader(file)
+	header, err := stream.Next()
+	if err == io.EOF || (err == nil && header.Name != cacheBundleManifestName) {
+		return nil, fmt.Errorf("The bundle does not start with %s", cacheBundleManifestName)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var manifest CacheBundleManifest
+	if err := json.NewDecoder(stream).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %s", cacheBundleManifestName, err.Error())
+	}
+	if manifest.Format != cacheBundleFormat {
+		return nil, fmt.Errorf("Unsupported bundle format %d, expected %d", manifest.Format, cacheBundleFormat)
+	}
+	if manifest.Stemcell == "" {
+		return nil, fmt.Errorf("The manifest does not name the stemcell")
+	}
+
+	pending := map[string]CacheBundlePackage{}
+	for _, pkg := range manifest.Packages {
+		if !cacheBundleFingerprint.MatchString(pkg.Fingerprint) {
+			return nil, fmt.Errorf("Invalid fingerprint %q of package %s/%s", pkg.Fingerprint, pkg.Release, pkg.Name)
+		}
+		pending[path.Join(cacheBundlePackagesDir, pkg.Fingerprint+".tgz")] = pkg
+	}
+
+	for {
+		header, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		pkg, ok := pending[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("Unexpected entry %s", header.Name)
+		}
+		delete(pending, header.Name)
+
+		digest, err := copyWithDigest(stream, filepath.Join(archiveDir, pkg.Fingerprint+".tgz"))
+		if err != nil {
+			return nil, err
+		}
+		if digest != pkg.SHA256 {
+			return nil, fmt.Errorf("Checksum mismatch for package %s/%s: expected %s, got %s", pkg.Release, pkg.Name, pkg.SHA256, digest)
+		}
+	}
+
+	for _, pkg := range manifest.Packages {
+		if _, ok := pending[path.Join(cacheBundlePackagesDir, pkg.Fingerprint+".tgz")]; ok {
+			return nil, fmt.Errorf("Package %s/%s is missing", pkg.Release, pkg.Name)
+		}
+	}
+
+	return &manifest, nil
+}
+
+// copyWithDigest writes the contents to the file, and returns their SHA256
+// digest
+func copyWithDigest(contents io.Reader, filePath string) (string, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), contents); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), file.Close()
+}
+
+// importPackageArchive extracts the archive of a compiled package into the
+// temporary compilation directory of the package, then replaces its compiled
+// directory with it, recording the stemcell for it if known
+func importPackageArchive(archivePath string, pkg *model.Package, workDir string, stemcell *compilator.StemcellIdentity) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	compiledTempDir := pkg.GetPackageCompiledTempDir(workDir)
+	if err := os.RemoveAll(compiledTempDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(compiledTempDir)
+
+	if err := util.ExtractCompiledPackageTargz(archivePath, archive, compiledTempDir); err != nil {
+		return err
+	}
+
+	// The stemcell recorded for the package replaced does not apply to
+	// the imported one
+	stemcellPath := compilator.PackageStemcellPath(pkg, workDir)
+	if err := os.Remove(stemcellPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := compilator.InstallCompiledPackage(pkg, compiledTempDir, workDir); err != nil {
+		return err
+	}
+	if stemcell == nil {
+		return nil
+	}
+	return compilator.RecordPackageStemcell(pkg, workDir, *stemcell)
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SUSE/fissile/compilator"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cacheBundleTestStemcell = "splatform/fissile-stemcell-opensuse:42.2"
+
+var cacheBundleTestIdentity = compilator.StemcellIdentity{
+	Image:   cacheBundleTestStemcell,
+	ImageID: "sha256:c0ffee",
+	OS:      "opensuse/42.2",
+}
+
+// setupCacheBundleTest returns an application with the tor release loaded,
+// the path of a role manifest using it, and a compilation directory holding
+// all packages of the release
+func setupCacheBundleTest(t *testing.T) (*Fissile, string, string) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+
+	f := NewFissileApplication("1.2.3", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	require.NoError(t, f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, filepath.Join(releasePath, "bosh-cache"), ""))
+
+	compilationDir, err := ioutil.TempDir("", "fissile-cache-bundle-test")
+	require.NoError(t, err)
+	stemcellDir := compilator.StemcellCompilationDir(compilationDir, cacheBundleTestIdentity)
+	require.NoError(t, compilator.RecordStemcellCompilationDir(stemcellDir, cacheBundleTestIdentity))
+	for _, pkg := range f.releases[0].Packages {
+		binDir := filepath.Join(pkg.GetPackageCompiledDir(stemcellDir), "bin")
+		require.NoError(t, os.MkdirAll(binDir, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, pkg.Name), []byte(pkg.Fingerprint), 0755))
+		// Compiled packages link to other packages, absolutely and relatively
+		require.NoError(t, os.Symlink("/var/vcap/packages/libevent/lib", filepath.Join(pkg.GetPackageCompiledDir(stemcellDir), "lib")))
+		require.NoError(t, os.Symlink("../../openssl/bin/openssl", filepath.Join(binDir, "openssl")))
+	}
+
+	return f, roleManifestPath, compilationDir
+}
+
+func TestCompilationCacheBundle(t *testing.T) {
+	assert := assert.New(t)
+
+	f, roleManifestPath, compilationDir := setupCacheBundleTest(t)
+	defer os.RemoveAll(compilationDir)
+
+	bundleDir, err := ioutil.TempDir("", "fissile-cache-bundle-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(bundleDir)
+	bundlePath := filepath.Join(bundleDir, "bundle.tar")
+
+	require.NoError(t, f.ExportCompilationCache(bundlePath, compilationDir, cacheBundleTestStemcell, roleManifestPath, nil, nil))
+
+	targetDir, err := ioutil.TempDir("", "fissile-cache-bundle-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+
+	err = f.ImportCompilationCache(bundlePath, targetDir, "other/stemcell:1")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "was exported for stemcell "+cacheBundleTestStemcell)
+	}
+
+	require.NoError(t, f.ImportCompilationCache(bundlePath, targetDir, cacheBundleTestStemcell))
+
+	stemcellDir := compilator.StemcellCompilationDir(targetDir, cacheBundleTestIdentity)
+	for _, pkg := range f.releases[0].Packages {
+		contents, err := ioutil.ReadFile(filepath.Join(pkg.GetPackageCompiledDir(stemcellDir), "bin", pkg.Name))
+		if assert.NoError(err) {
+			assert.Equal(pkg.Fingerprint, string(contents))
+		}
+		link, err := os.Readlink(filepath.Join(pkg.GetPackageCompiledDir(stemcellDir), "lib"))
+		if assert.NoError(err) {
+			assert.Equal("/var/vcap/packages/libevent/lib", link)
+		}
+		link, err = os.Readlink(filepath.Join(pkg.GetPackageCompiledDir(stemcellDir), "bin", "openssl"))
+		if assert.NoError(err) {
+			assert.Equal("../../openssl/bin/openssl", link)
+		}
+		stemcell, err := compilator.ReadPackageStemcell(pkg, stemcellDir)
+		if assert.NoError(err) && assert.NotNil(stemcell) {
+			assert.Equal(cacheBundleTestIdentity, *stemcell)
+		}
+	}
+	found, err := compilator.FindStemcellCompilationDir(targetDir, compilator.StemcellIdentity{Image: cacheBundleTestStemcell})
+	assert.NoError(err)
+	assert.Equal(stemcellDir, found)
+
+	// Entries compiled later than the bundled ones are kept, older ones
+	// are replaced
+	newer, older := f.releases[0].Packages[0], f.releases[0].Packages[1]
+	later := time.Now().Add(time.Hour)
+	earlier := time.Now().Add(-24 * time.Hour)
+	for _, test := range []struct {
+		pkgDir   string
+		modified time.Time
+	}{
+		{newer.GetPackageCompiledDir(stemcellDir), later},
+		{older.GetPackageCompiledDir(stemcellDir), earlier},
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(test.pkgDir, "bin", "local"), []byte("local"), 0644))
+		require.NoError(t, os.Chtimes(test.pkgDir, test.modified, test.modified))
+	}
+
+	require.NoError(t, f.ImportCompilationCache(bundlePath, targetDir, ""))
+
+	_, err = os.Stat(filepath.Join(newer.GetPackageCompiledDir(stemcellDir), "bin", "local"))
+	assert.NoError(err, "Newer package should be kept")
+	_, err = os.Stat(filepath.Join(older.GetPackageCompiledDir(stemcellDir), "bin", "local"))
+	assert.True(os.IsNotExist(err), "Older package should be replaced")
+}
+
+func TestCompilationCacheBundleNothingCompiled(t *testing.T) {
+	f, roleManifestPath, compilationDir := setupCacheBundleTest(t)
+	defer os.RemoveAll(compilationDir)
+
+	err := f.ExportCompilationCache(filepath.Join(compilationDir, "bundle.tar"), compilationDir, "other/stemcell:1", roleManifestPath, nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "None of the selected packages are compiled for stemcell other/stemcell:1")
+	}
+}
+
+func TestCompilationCacheBundleCorrupt(t *testing.T) {
+	assert := assert.New(t)
+
+	f, roleManifestPath, compilationDir := setupCacheBundleTest(t)
+	defer os.RemoveAll(compilationDir)
+
+	bundlePath := filepath.Join(compilationDir, "bundle.tar")
+	require.NoError(t, f.ExportCompilationCache(bundlePath, compilationDir, cacheBundleTestStemcell, roleManifestPath, nil, nil))
+
+	// Rewrite the bundle with a wrong digest for the first package
+	bundle, err := ioutil.ReadFile(bundlePath)
+	require.NoError(t, err)
+	corruptPath := filepath.Join(compilationDir, "corrupt.tar")
+	corrupt, err := os.Create(corruptPath)
+	require.NoError(t, err)
+	reader := tar.NewReader(bytes.NewReader(bundle))
+	writer := tar.NewWriter(corrupt)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		contents, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		if header.Name == cacheBundleManifestName {
+			var manifest CacheBundleManifest
+			require.NoError(t, json.Unmarshal(contents, &manifest))
+			manifest.Packages[0].SHA256 = "0000"
+			contents, err = json.Marshal(manifest)
+			require.NoError(t, err)
+			header.Size = int64(len(contents))
+		}
+		require.NoError(t, writer.WriteHeader(header))
+		_, err = writer.Write(contents)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	require.NoError(t, corrupt.Close())
+
+	targetDir, err := ioutil.TempDir("", "fissile-cache-bundle-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(targetDir)
+
+	err = f.ImportCompilationCache(corruptPath, targetDir, "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Checksum mismatch for package")
+	}
+	cached, err := filepath.Glob(filepath.Join(targetDir, "*", "*"))
+	assert.NoError(err)
+	assert.Empty(cached, "Nothing is merged from a corrupt bundle")
+}
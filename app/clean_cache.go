@@ -0,0 +1,280 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/SUSE/fissile/compilator"
+
+	"github.com/docker/go-units"
+	"github.com/fatih/color"
+)
+
+// cachedPackage is a compiled package in the compilation cache
+type cachedPackage struct {
+	path        string // <compilation dir>/<stemcell>/<fingerprint>
+	stemcellDir string
+	fingerprint string
+	name        string // <release>/<package>, or the fingerprint if unknown
+	lastUsed    time.Time
+	size        int64
+	referenced  bool // by the loaded releases
+}
+
+// CleanCache inspects the compilation cache and removes packages which are
+// not needed anymore.
+//
+// Without a retention policy, all packages which are not referenced by the
+// loaded releases are removed. Otherwise, releases are optional, and their
+// packages are always kept. Other packages are kept if they were used within
+// the last keepDays days, or are among the keepVersions last used packages of
+// the same name for the stemcell; packages not kept by either policy in use
+// are removed. Finally, the least recently used packages are removed until
+// the cache holds at most maxSize bytes. Package use is tracked in the access
+// index of Compile; packages missing from it count as last used when their
+// directory was last modified.
+//
+// With dryRun, the packages which would be removed are reported, but kept.
+func (f *Fissile) CleanCache(targetPath string, keepDays, keepVersions int, maxSize int64, dryRun bool) error {
+	withPolicy := keepDays > 0 || keepVersions > 0 || maxSize > 0
+	if len(f.releases) == 0 && !withPolicy {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	// 1. Collect list of packages referenced by the releases. A
+	//    variant of the code in ListPackages, we keep only the
+	//    hashes.
+
+	referenced := make(map[string]int)
+	for _, release := range f.releases {
+		for _, pkg := range release.Packages {
+			referenced[pkg.Version] = 1
+		}
+	}
+
+	/// 2. Scan local compilation cache, and decide what to
+	///    remove.
+
+	f.UI.Printf("Cleaning up %s\n", color.MagentaString(targetPath))
+
+	cached, err := listCachedPackages(targetPath)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range cached {
+		_, pkg.referenced = referenced[pkg.fingerprint]
+	}
+
+	var remove []*cachedPackage
+	if withPolicy {
+		remove = selectByRetention(cached, keepDays, keepVersions, maxSize, time.Now())
+	} else {
+		for _, pkg := range cached {
+			if !pkg.referenced {
+				remove = append(remove, pkg)
+			}
+		}
+	}
+
+	/// 3. Remove the packages selected, and drop them from the
+	///    access index.
+
+	action := "Removing"
+	if dryRun {
+		action = "Would remove"
+	}
+
+	var freed int64
+	removedByStemcell := map[string][]string{}
+	for _, pkg := range remove {
+		relpath, err := filepath.Rel(targetPath, pkg.path)
+		if err != nil {
+			relpath = pkg.fingerprint
+		}
+		f.UI.Printf("- %s %s (%s, %s, last used %s)\n",
+			action,
+			color.YellowString(relpath),
+			pkg.name,
+			units.BytesSize(float64(pkg.size)),
+			pkg.lastUsed.Format(time.RFC3339))
+		freed += pkg.size
+
+		if dryRun {
+			continue
+		}
+		if err := os.RemoveAll(pkg.path); err != nil {
+			return err
+		}
+		removedByStemcell[pkg.stemcellDir] = append(removedByStemcell[pkg.stemcellDir], pkg.fingerprint)
+	}
+
+	for stemcellDir, fingerprints := range removedByStemcell {
+		index, err := compilator.ReadAccessIndex(stemcellDir)
+		if err != nil {
+			return err
+		}
+		for _, fingerprint := range fingerprints {
+			delete(index, fingerprint)
+		}
+		if err := compilator.WriteAccessIndex(stemcellDir, index); err != nil {
+			return err
+		}
+	}
+
+	if len(remove) == 0 {
+		f.UI.Println("Nothing found to remove")
+		return nil
+	}
+
+	plural := ""
+	if len(remove) > 1 {
+		plural = "s"
+	}
+	summary := "Removed"
+	if dryRun {
+		summary = "Would remove"
+	}
+	f.UI.Printf("%s %s package%s, freeing %s\n",
+		summary,
+		color.MagentaString(fmt.Sprintf("%d", len(remove))),
+		plural,
+		color.MagentaString(units.BytesSize(float64(freed))))
+
+	return nil
+}
+
+// listCachedPackages returns the packages in the compilation cache, with
+// their name, size and last use
+func listCachedPackages(targetPath string) ([]*cachedPackage, error) {
+	paths, err := filepath.Glob(filepath.Join(targetPath, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Names of packages missing from the access index are taken from
+	// their compilation status, if any
+	statuses, err := compilator.ReadPackageStatuses(targetPath, "")
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, status := range statuses {
+		names[status.Fingerprint] = fmt.Sprintf("%s/%s", status.Release, status.Package)
+	}
+
+	indexes := map[string]map[string]compilator.PackageAccess{}
+	var cached []*cachedPackage
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() || filepath.Base(filepath.Dir(path)) == compilator.StemcellRootfsName {
+			// The access index of the stemcell, or the root filesystem of
+			// a stemcell image
+			continue
+		}
+
+		stemcellDir := filepath.Dir(path)
+		index, ok := indexes[stemcellDir]
+		if !ok {
+			if index, err = compilator.ReadAccessIndex(stemcellDir); err != nil {
+				return nil, err
+			}
+			indexes[stemcellDir] = index
+		}
+
+		pkg := &cachedPackage{
+			path:        path,
+			stemcellDir: stemcellDir,
+			fingerprint: filepath.Base(path),
+			name:        filepath.Base(path),
+			lastUsed:    info.ModTime(),
+		}
+		if name, ok := names[pkg.fingerprint]; ok {
+			pkg.name = name
+		}
+		if access, ok := index[pkg.fingerprint]; ok {
+			pkg.name = fmt.Sprintf("%s/%s", access.Release, access.Package)
+			pkg.lastUsed = access.LastUsed
+		}
+		if pkg.size, err = directorySize(path); err != nil {
+			return nil, err
+		}
+		cached = append(cached, pkg)
+	}
+
+	return cached, nil
+}
+
+// selectByRetention returns the packages to remove by the retention
+// policies, see CleanCache, least recently used first
+func selectByRetention(cached []*cachedPackage, keepDays, keepVersions int, maxSize int64, now time.Time) []*cachedPackage {
+	sorted := make([]*cachedPackage, len(cached))
+	copy(sorted, cached)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].lastUsed.After(sorted[j].lastUsed)
+	})
+
+	// Walk the packages from the most recently used, keeping those any
+	// policy in use keeps
+	var kept, remove []*cachedPackage
+	versions := map[string]int{}
+	for _, pkg := range sorted {
+		group := pkg.stemcellDir + "\x00" + pkg.name
+		versions[group]++
+
+		keep := pkg.referenced || (keepDays == 0 && keepVersions == 0)
+		if keepDays > 0 && now.Sub(pkg.lastUsed) < time.Duration(keepDays)*24*time.Hour {
+			keep = true
+		}
+		if keepVersions > 0 && versions[group] <= keepVersions {
+			keep = true
+		}
+
+		if keep {
+			kept = append(kept, pkg)
+		} else {
+			remove = append(remove, pkg)
+		}
+	}
+
+	// Evict the least recently used packages kept beyond the size
+	// limit; packages of the loaded releases stay
+	if maxSize > 0 {
+		var total int64
+		for _, pkg := range kept {
+			total += pkg.size
+		}
+		for i := len(kept) - 1; i >= 0 && total > maxSize; i-- {
+			if kept[i].referenced {
+				continue
+			}
+			total -= kept[i].size
+			remove = append(remove, kept[i])
+		}
+	}
+
+	sort.SliceStable(remove, func(i, j int) bool {
+		return remove[i].lastUsed.Before(remove[j].lastUsed)
+	})
+	return remove
+}
+
+// directorySize returns the size of the files below the directory
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SUSE/fissile/compilator"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupCleanCacheTest returns a compilation directory holding packages for
+// two stemcells, with their last use recorded in days ago, and sized 1KiB
+// per day
+func setupCleanCacheTest(t *testing.T) string {
+	compilationDir, err := ioutil.TempDir("", "fissile-clean-cache-test")
+	require.NoError(t, err)
+
+	now := time.Now()
+	for stemcell, packages := range map[string]map[string]int{
+		"opensuse": {"ruby-1": 40, "ruby-2": 10, "ruby-3": 1, "go-1": 20},
+		"ubuntu":   {"ruby-1": 3},
+	} {
+		stemcellDir := filepath.Join(compilationDir, stemcell)
+		index := map[string]compilator.PackageAccess{}
+		for fingerprint, daysAgo := range packages {
+			compiledDir := filepath.Join(stemcellDir, fingerprint, "compiled")
+			require.NoError(t, os.MkdirAll(compiledDir, 0755))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(compiledDir, "lib"), make([]byte, 1024*daysAgo), 0644))
+			index[fingerprint] = compilator.PackageAccess{
+				Release:  "test-release",
+				Package:  fingerprint[:len(fingerprint)-2],
+				LastUsed: now.Add(-time.Duration(daysAgo) * 24 * time.Hour),
+			}
+		}
+		require.NoError(t, compilator.WriteAccessIndex(stemcellDir, index))
+	}
+
+	// The root filesystem of a stemcell image is not a cached package
+	rootfsDir := compilator.StemcellRootfsDir(compilationDir, "sha256:c0ffee")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfsDir, "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfsDir, "bin", "sh"), make([]byte, 100*1024), 0755))
+
+	return compilationDir
+}
+
+func cachedFingerprints(t *testing.T, compilationDir string) []string {
+	paths, err := filepath.Glob(filepath.Join(compilationDir, "*", "*-*"))
+	require.NoError(t, err)
+	var fingerprints []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(compilationDir, path)
+		require.NoError(t, err)
+		fingerprints = append(fingerprints, rel)
+	}
+	return fingerprints
+}
+
+func TestCleanCacheRetention(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		keepDays     int
+		keepVersions int
+		maxSize      int64
+		expected     []string
+	}{
+		{
+			name:     "keep days",
+			keepDays: 15,
+			expected: []string{"opensuse/ruby-2", "opensuse/ruby-3", "ubuntu/ruby-1"},
+		},
+		{
+			name:         "keep versions",
+			keepVersions: 2,
+			expected:     []string{"opensuse/go-1", "opensuse/ruby-2", "opensuse/ruby-3", "ubuntu/ruby-1"},
+		},
+		{
+			name:         "keep days or versions",
+			keepDays:     5,
+			keepVersions: 1,
+			expected:     []string{"opensuse/go-1", "opensuse/ruby-3", "ubuntu/ruby-1"},
+		},
+		{
+			name:     "max size",
+			maxSize:  20 * 1024,
+			expected: []string{"opensuse/ruby-2", "opensuse/ruby-3", "ubuntu/ruby-1"},
+		},
+		{
+			name:         "keep versions and max size",
+			keepVersions: 2,
+			maxSize:      4 * 1024,
+			expected:     []string{"opensuse/ruby-3", "ubuntu/ruby-1"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			compilationDir := setupCleanCacheTest(t)
+			defer os.RemoveAll(compilationDir)
+
+			f := NewFissileApplication("1.2.3", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+			require.NoError(t, f.CleanCache(compilationDir, test.keepDays, test.keepVersions, test.maxSize, false))
+			assert.Equal(test.expected, cachedFingerprints(t, compilationDir))
+			_, err := os.Stat(compilator.StemcellRootfsDir(compilationDir, "sha256:c0ffee"))
+			assert.NoError(err, "Stemcell root filesystems are kept")
+
+			// Removed packages are dropped from the access index
+			index, err := compilator.ReadAccessIndex(filepath.Join(compilationDir, "opensuse"))
+			if assert.NoError(err) {
+				for fingerprint := range index {
+					assert.Contains(test.expected, "opensuse/"+fingerprint)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanCacheDryRun(t *testing.T) {
+	assert := assert.New(t)
+
+	compilationDir := setupCleanCacheTest(t)
+	defer os.RemoveAll(compilationDir)
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication("1.2.3", termui.New(&bytes.Buffer{}, output, nil))
+	require.NoError(t, f.CleanCache(compilationDir, 15, 0, 0, true))
+
+	assert.Len(cachedFingerprints(t, compilationDir), 5, "Dry runs remove nothing")
+	assert.Contains(output.String(), "Would remove opensuse/ruby-1 (test-release/ruby, 40 KiB")
+	assert.Contains(output.String(), "Would remove opensuse/go-1 (test-release/go, 20 KiB")
+	assert.Contains(output.String(), "Would remove 2 packages, freeing 60 KiB")
+}
+
+func TestCleanCacheWithoutReleases(t *testing.T) {
+	compilationDir := setupCleanCacheTest(t)
+	defer os.RemoveAll(compilationDir)
+
+	f := NewFissileApplication("1.2.3", termui.New(&bytes.Buffer{}, ioutil.Discard, nil))
+	err := f.CleanCache(compilationDir, 0, 0, 0, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Releases not loaded")
+	}
+	assert.Len(t, cachedFingerprints(t, compilationDir), 5)
+}
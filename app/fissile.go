@@ -3,12 +3,16 @@ package app
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
+	"text/tabwriter"
 
 	"github.com/SUSE/fissile/builder"
 	"github.com/SUSE/fissile/compilator"
@@ -16,8 +20,10 @@ import (
 	"github.com/SUSE/fissile/helm"
 	"github.com/SUSE/fissile/kube"
 	"github.com/SUSE/fissile/model"
+	"github.com/SUSE/fissile/pkgcache"
 	"github.com/SUSE/fissile/scripts/compilation"
 	"github.com/SUSE/fissile/util"
+	"github.com/SUSE/fissile/validation"
 	"github.com/SUSE/stampy"
 	"github.com/SUSE/termui"
 
@@ -35,6 +41,9 @@ const (
 	OutputFormatHuman = "human" // output for human consumption
 	OutputFormatJSON  = "json"  // output as JSON
 	OutputFormatYAML  = "yaml"  // output as YAML
+	OutputFormatJUnit = "junit" // output as JUnit XML report
+	OutputFormatSARIF = "sarif" // output as SARIF log
+	OutputFormatDOT   = "dot"   // output as graphviz DOT graph
 )
 
 // Fissile represents a fissile application
@@ -80,7 +89,12 @@ func (f *Fissile) ListPackages(verbose bool) error {
 				}
 			}
 
-			f.UI.Printf("%s (%s)%s\n", color.YellowString(pkg.Name), color.WhiteString(pkg.Version), isCached)
+			var precompiled string
+			if pkg.IsCompiled() {
+				precompiled = color.CyanString(" (precompiled for %s)", pkg.Stemcell)
+			}
+
+			f.UI.Printf("%s (%s)%s%s\n", color.YellowString(pkg.Name), color.WhiteString(pkg.Version), precompiled, isCached)
 		}
 
 		f.UI.Printf(
@@ -130,8 +144,10 @@ func (f *Fissile) ListJobs(verbose bool) error {
 	return nil
 }
 
-// ListProperties will list all properties in all jobs within a list of releases
-func (f *Fissile) ListProperties(outputFormat OutputFormat) error {
+// ListProperties will list all properties in all jobs within a list of releases.
+// JSON and YAML output map each property to its default value; with
+// withExamples, they map it to its default, example and type instead.
+func (f *Fissile) ListProperties(outputFormat OutputFormat, withExamples bool) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
@@ -144,14 +160,63 @@ func (f *Fissile) ListProperties(outputFormat OutputFormat) error {
 		// -- map[interface {}]interface {}
 		// Such types can occur when the default value has sub-structure.
 
-		buf, err := util.JSONMarshal(f.collectProperties())
+		buf, err := util.JSONMarshal(f.collectProperties(withExamples))
 		if err != nil {
 			return err
 		}
 
 		f.UI.Printf("%s", buf)
 	case OutputFormatYAML:
-		buf, err := yaml.Marshal(f.collectProperties())
+		buf, err := yaml.Marshal(f.collectProperties(withExamples))
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", outputFormat)
+	}
+
+	return nil
+}
+
+// ListLinks prints the BOSH links consumed by the jobs in the role manifest,
+// together with the role and job providing them
+func (f *Fissile) ListLinks(roleManifestPath string, outputFormat OutputFormat) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	links := roleManifest.ResolvedLinks()
+
+	switch outputFormat {
+	case OutputFormatHuman:
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ROLE\tJOB\tLINK\tTYPE\tPROVIDER ROLE\tPROVIDER JOB\tSERVICE")
+		for _, link := range links {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				link.Role, link.Job, link.Name, link.Type, link.ProviderRole, link.ProviderJob, link.Service)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf.String())
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(links, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s\n", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(links)
 		if err != nil {
 			return err
 		}
@@ -220,14 +285,22 @@ func (f *Fissile) listPropertiesForHuman() {
 			for _, property := range job.Properties {
 				f.UI.Printf("\t%s: %v\n", color.YellowString(property.Name),
 					property.Default)
+				if property.Type != "" {
+					f.UI.Printf("\t\ttype: %s\n", property.Type)
+				}
+				if property.Example != nil {
+					f.UI.Printf("\t\texample: %v\n", property.Example)
+				}
 			}
 		}
 	}
 }
 
-func (f *Fissile) collectProperties() map[string]map[string]map[string]interface{} {
+func (f *Fissile) collectProperties(withExamples bool) map[string]map[string]map[string]interface{} {
 	// Generate a triple map (release -> job -> property -> default value)
-	// which is easy to convert and dump to JSON or YAML.
+	// which is easy to convert and dump to JSON or YAML. With withExamples,
+	// the default value is replaced by information about the property: the
+	// default value, and the example and type where the job spec has them.
 
 	result := make(map[string]map[string]map[string]interface{})
 
@@ -237,7 +310,20 @@ func (f *Fissile) collectProperties() map[string]map[string]map[string]interface
 		for _, job := range release.Jobs {
 			result[release.Name][job.Name] = make(map[string]interface{})
 			for _, property := range job.Properties {
-				result[release.Name][job.Name][property.Name] = property.Default
+				if !withExamples {
+					result[release.Name][job.Name][property.Name] = property.Default
+					continue
+				}
+				info := map[string]interface{}{
+					"default": property.Default,
+				}
+				if property.Example != nil {
+					info["example"] = property.Example
+				}
+				if property.Type != "" {
+					info["type"] = property.Type
+				}
+				result[release.Name][job.Name][property.Name] = info
 			}
 		}
 	}
@@ -300,28 +386,63 @@ func newPropertyInfo(maybeHash bool) *propertyInfo {
 	}
 }
 
-// Compile will compile a list of dev BOSH releases
-func (f *Fissile) Compile(stemcellImageName string, targetPath, roleManifestPath, metricsPath string, roleNames, releaseNames []string, workerCount int, dockerNetworkMode string, withoutDocker, verbose bool) error {
+// CompileOptions are the options of Compile
+type CompileOptions struct {
+	// StemcellImageName is the docker image of the stemcell to compile on
+	StemcellImageName string
+	// Stemcell is the BOSH stemcell (<os>/<version>) the stemcell image is
+	// built from; packages of compiled releases built for it are used without
+	// compiling them
+	Stemcell string
+	// CompilationDir is the directory packages are compiled into, below the
+	// directory of the stemcell, see compilator.StemcellCompilationDir
+	CompilationDir   string
+	RoleManifestPath string
+	MetricsPath      string
+	// RoleNames and ReleaseNames select the packages to compile; all are
+	// compiled without them
+	RoleNames    []string
+	ReleaseNames []string
+	WorkerCount  int
+	// DockerNetworkMode is the network mode of the compilation containers
+	DockerNetworkMode string
+	// StemcellRootfs is the path of an archive of the stemcell image; with
+	// it, packages are compiled without docker in a chroot of the root
+	// filesystem of the stemcell
+	StemcellRootfs string
+	WithoutDocker  bool
+	Verbose        bool
+	// PackageCacheLocation is the package cache to share compiled packages
+	// through, if any; with PackageCacheReadOnly, packages are only pulled
+	// from it
+	PackageCacheLocation string
+	PackageCacheReadOnly bool
+	// KeepGoing compiles all packages whose dependencies compiled, instead
+	// of stopping at the first failure
+	KeepGoing bool
+	// ReuseUnrecordedPackages uses packages compiled without recording the
+	// stemcell, by older versions of fissile, instead of compiling them
+	ReuseUnrecordedPackages bool
+}
+
+// Compile will compile a list of dev BOSH releases, as given by the options.
+// Cancelling the context interrupts the compilation.
+func (f *Fissile) Compile(ctx context.Context, options CompileOptions) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
 
-	if metricsPath != "" {
-		stampy.Stamp(metricsPath, "fissile", "compile-packages", "start")
-		defer stampy.Stamp(metricsPath, "fissile", "compile-packages", "done")
+	if options.MetricsPath != "" {
+		stampy.Stamp(options.MetricsPath, "fissile", "compile-packages", "start")
+		defer stampy.Stamp(options.MetricsPath, "fissile", "compile-packages", "done")
 	}
 
-	dockerManager, err := docker.NewImageManager()
-	if err != nil {
-		return fmt.Errorf("Error connecting to docker: %s", err.Error())
-	}
-
-	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
+	roleManifest, err := model.LoadRoleManifest(options.RoleManifestPath, f.releases, f)
 	if err != nil {
 		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
 	}
 
-	releases, err := f.getReleasesByName(releaseNames)
+	releases, err := f.getReleasesByName(options.ReleaseNames)
 	if err != nil {
 		return err
 	}
@@ -331,96 +452,279 @@ func (f *Fissile) Compile(stemcellImageName string, targetPath, roleManifestPath
 		f.UI.Printf("         %s (%s)\n", color.YellowString(release.Name), color.MagentaString(release.Version))
 	}
 
+	// The image ID tells stemcell images apart when the image name is
+	// retagged. The stemcell archive has it already; docker is only needed
+	// to compile with it, compiling without docker does not need the image
+	// locally
+	stemcellImageID := ""
+	rootfsDir := ""
+	var dockerManager *docker.ImageManager
+	switch {
+	case options.StemcellRootfs != "":
+		rootfs, err := compilator.PrepareStemcellRootfs(options.StemcellRootfs, options.StemcellImageName, options.CompilationDir, f.UI)
+		if err != nil {
+			return fmt.Errorf("Error preparing the stemcell root filesystem: %s", err.Error())
+		}
+		stemcellImageID = rootfs.ImageID
+		rootfsDir = compilator.StemcellRootfsDir(options.CompilationDir, rootfs.ImageID)
+	case options.WithoutDocker:
+		if imageManager, err := docker.NewImageManager(); err == nil {
+			if stemcellImage, err := imageManager.FindImage(options.StemcellImageName); err == nil {
+				stemcellImageID = stemcellImage.ID
+			}
+		}
+	default:
+		dockerManager, err = docker.NewImageManager()
+		if err != nil {
+			return fmt.Errorf("Error connecting to docker: %s", err.Error())
+		}
+		stemcellImage, err := dockerManager.FindImage(options.StemcellImageName)
+		if err != nil {
+			return fmt.Errorf("Error looking up the stemcell image: %s", err.Error())
+		}
+		stemcellImageID = stemcellImage.ID
+	}
+	targetPath := compilator.StemcellCompilationDir(options.CompilationDir, compilator.StemcellIdentity{
+		Image:   options.StemcellImageName,
+		ImageID: stemcellImageID,
+		OS:      options.Stemcell,
+	})
+
 	var comp *compilator.Compilator
-	if withoutDocker {
-		comp, err = compilator.NewMountNSCompilator(targetPath, metricsPath, stemcellImageName, compilation.LinuxBase, f.Version, f.UI, f)
+	if options.StemcellRootfs != "" {
+		comp, err = compilator.NewChrootCompilator(targetPath, rootfsDir, options.MetricsPath, options.StemcellImageName, options.Stemcell, compilation.LinuxBase, f.Version, f.UI, f)
+		if err != nil {
+			return fmt.Errorf("Error creating a new compilator: %s", err.Error())
+		}
+	} else if options.WithoutDocker {
+		comp, err = compilator.NewMountNSCompilator(targetPath, options.MetricsPath, options.StemcellImageName, options.Stemcell, compilation.LinuxBase, f.Version, f.UI, f)
 		if err != nil {
 			return fmt.Errorf("Error creating a new compilator: %s", err.Error())
 		}
 	} else {
-		comp, err = compilator.NewDockerCompilator(dockerManager, targetPath, metricsPath, stemcellImageName, compilation.LinuxBase, f.Version, dockerNetworkMode, false, f.UI, f)
+		comp, err = compilator.NewDockerCompilator(dockerManager, targetPath, options.MetricsPath, options.StemcellImageName, options.Stemcell, compilation.LinuxBase, f.Version, options.DockerNetworkMode, false, f.UI, f)
 		if err != nil {
 			return fmt.Errorf("Error creating a new compilator: %s", err.Error())
 		}
 	}
 
-	roles, err := roleManifest.SelectRoles(roleNames)
+	comp.SetStemcellImageID(stemcellImageID)
+	if options.ReuseUnrecordedPackages {
+		// Older versions of fissile kept packages by image name alone
+		comp.ReuseUnrecordedPackages(compilator.StemcellCompilationDir(options.CompilationDir, compilator.StemcellIdentity{Image: options.StemcellImageName}))
+	}
+
+	if options.PackageCacheLocation != "" {
+		backend, err := pkgcache.NewBackend(options.PackageCacheLocation)
+		if err != nil {
+			return fmt.Errorf("Error opening the package cache: %s", err.Error())
+		}
+		comp.UsePackageCache(pkgcache.New(backend, comp.StemcellIdentity().Key()), options.PackageCacheReadOnly)
+	}
+
+	comp.SetKeepGoing(options.KeepGoing)
+
+	roles, err := roleManifest.SelectRoles(options.RoleNames)
 	if err != nil {
 		return fmt.Errorf("Error selecting packages to build: %s", err.Error())
 	}
 
-	if err := comp.Compile(workerCount, releases, roles, verbose); err != nil {
+	if err := comp.Compile(ctx, options.WorkerCount, releases, roles, options.Verbose); err != nil {
 		return fmt.Errorf("Error compiling packages: %s", err.Error())
 	}
 
 	return nil
 }
 
-// CleanCache inspects the compilation cache and removes all packages
-// which are not referenced (anymore).
-func (f *Fissile) CleanCache(targetPath string) error {
-	// 1. Collect list of packages referenced by the releases. A
-	//    variant of the code in ListPackages, we keep only the
-	//    hashes.
-
+// PlanCompilation prints what Compile would do with the same arguments,
+// without compiling anything: the packages compiled already, and the
+// packages to compile, in the order they are queued in for the workers, with
+// the depth of their dependencies. The packages compiled already are looked
+// up for the stemcell compiled on last with the image and stemcell.
+func (f *Fissile) PlanCompilation(stemcellImageName, stemcell string, compilationDir, roleManifestPath string, roleNames, releaseNames []string, workerCount int, outputFormat OutputFormat) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
 
-	referenced := make(map[string]int)
-	for _, release := range f.releases {
-		for _, pkg := range release.Packages {
-			referenced[pkg.Version] = 1
-		}
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
+	if err != nil {
+		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
 	}
 
-	/// 2. Scan local compilation cache, compare to referenced,
-	///    remove anything not found.
+	releases, err := f.getReleasesByName(releaseNames)
+	if err != nil {
+		return err
+	}
 
-	f.UI.Printf("Cleaning up %s\n", color.MagentaString(targetPath))
+	roles, err := roleManifest.SelectRoles(roleNames)
+	if err != nil {
+		return fmt.Errorf("Error selecting packages to build: %s", err.Error())
+	}
 
-	cached, err := filepath.Glob(targetPath + "/*/*")
+	// Planning does not run anything, so there is no need for docker
+	targetPath, err := compilator.FindStemcellCompilationDir(compilationDir, compilator.StemcellIdentity{Image: stemcellImageName, OS: stemcell})
+	if err != nil {
+		return err
+	}
+	recorded, err := compilator.ReadStemcellCompilationDir(targetPath)
 	if err != nil {
 		return err
 	}
+	comp, err := compilator.NewDockerCompilator(nil, targetPath, "", stemcellImageName, stemcell, compilation.LinuxBase, f.Version, "", false, f.UI, nil)
+	if err != nil {
+		return fmt.Errorf("Error creating a new compilator: %s", err.Error())
+	}
+	if recorded != nil {
+		comp.SetStemcellImageID(recorded.ImageID)
+	}
 
-	removed := 0
-	for _, cache := range cached {
-		key := filepath.Base(cache)
-		if _, ok := referenced[key]; ok {
-			continue
+	plan, err := comp.Plan(workerCount, releases, roles)
+	if err != nil {
+		return fmt.Errorf("Error planning compilation: %s", err.Error())
+	}
+
+	switch outputFormat {
+	case OutputFormatHuman:
+		counts := map[string]int{}
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "STATE\tORDER\tDEPTH\tPACKAGE\tFINGERPRINT\tDEPENDENCIES")
+		for _, pkg := range plan.Packages {
+			counts[pkg.State]++
+			order := "-"
+			if pkg.Order > 0 {
+				order = fmt.Sprintf("%d", pkg.Order)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s/%s\t%s\t%s\n",
+				pkg.State, order, pkg.Depth, pkg.Release, pkg.Name, pkg.Fingerprint, strings.Join(pkg.Dependencies, ", "))
+		}
+		if err := w.Flush(); err != nil {
+			return err
 		}
 
-		relpath, err := filepath.Rel(targetPath, cache)
+		f.UI.Printf("Compilation plan for %s:\n", color.YellowString(stemcellImageName))
+		f.UI.Printf("%s", buf.String())
+		f.UI.Printf("\n%d cached, %d precompiled, %d to compile\n",
+			counts[compilator.PlanCached], counts[compilator.PlanPrecompiled], counts[compilator.PlanCompile])
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(plan, "", "  ")
 		if err != nil {
-			relpath = key
+			return err
 		}
-		f.UI.Printf("- Removing %s\n", color.YellowString(relpath))
-		if err := os.RemoveAll(cache); err != nil {
+
+		f.UI.Printf("%s\n", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(plan)
+		if err != nil {
 			return err
 		}
-		removed++
+
+		f.UI.Printf("%s", buf)
+	case OutputFormatDOT:
+		f.UI.Printf("%s", compilationPlanDOT(plan))
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, yaml, or dot", outputFormat)
 	}
 
-	if removed == 0 {
-		f.UI.Println("Nothing found to remove")
-		return nil
+	return nil
+}
+
+// compilationPlanDOT returns the compilation plan as a graphviz graph, with
+// an edge from each package to the packages depending on it. Packages to
+// compile are labeled with their position in the queue.
+func compilationPlanDOT(plan *compilator.Plan) string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph compilation {\n")
+	buf.WriteString("  rankdir=LR;\n")
+	buf.WriteString("  node [shape=box];\n")
+	for _, pkg := range plan.Packages {
+		name := fmt.Sprintf("%s/%s", pkg.Release, pkg.Name)
+		if pkg.State == compilator.PlanCompile {
+			fmt.Fprintf(&buf, "  %q [label=\"%s\\n#%d, depth %d\"];\n", name, name, pkg.Order, pkg.Depth)
+		} else {
+			fmt.Fprintf(&buf, "  %q [label=\"%s\\n%s\", style=filled, fillcolor=lightgrey];\n", name, name, pkg.State)
+		}
 	}
+	for _, pkg := range plan.Packages {
+		for _, dep := range pkg.Dependencies {
+			fmt.Fprintf(&buf, "  %q -> %q;\n", dep, fmt.Sprintf("%s/%s", pkg.Release, pkg.Name))
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
 
-	plural := ""
-	if removed > 1 {
-		plural = "s"
+// compilationStatusOrder is the order of the package statuses shown by
+// ShowCompilation, problems first
+var compilationStatusOrder = map[string]int{
+	compilator.StatusFailed:   0,
+	compilator.StatusSkipped:  1,
+	compilator.StatusCached:   2,
+	compilator.StatusCompiled: 3,
+}
+
+// ShowCompilation lists the status of the packages handled by the last
+// compilations below the compilation directory, for the stemcell image, or
+// all stemcell images if it is empty. Failed and skipped packages are listed
+// first, with the path of their compilation log.
+func (f *Fissile) ShowCompilation(compilationDir, stemcellImageName string, outputFormat OutputFormat) error {
+	statuses, err := compilator.ReadPackageStatuses(compilationDir, stemcellImageName)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return compilationStatusOrder[statuses[i].Status] < compilationStatusOrder[statuses[j].Status]
+	})
+
+	switch outputFormat {
+	case OutputFormatHuman:
+		if len(statuses) == 0 {
+			f.UI.Printf("No compilation status recorded in %s\n", color.MagentaString(compilationDir))
+			return nil
+		}
+
+		counts := map[string]int{}
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "STATUS\tPACKAGE\tFINISHED\tLOG\tERROR")
+		for _, status := range statuses {
+			counts[status.Status]++
+			fmt.Fprintf(w, "%s\t%s/%s\t%s\t%s\t%s\n",
+				status.Status, status.Release, status.Package,
+				status.Finished.Local().Format("2006-01-02 15:04:05"), status.Log, status.Error)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf.String())
+		f.UI.Printf("\n%d compiled, %d cached, %d failed, %d skipped\n",
+			counts[compilator.StatusCompiled], counts[compilator.StatusCached],
+			counts[compilator.StatusFailed], counts[compilator.StatusSkipped])
+	case OutputFormatJSON:
+		buf, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s\n", buf)
+	case OutputFormatYAML:
+		buf, err := yaml.Marshal(statuses)
+		if err != nil {
+			return err
+		}
+
+		f.UI.Printf("%s", buf)
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, or yaml", outputFormat)
 	}
-	f.UI.Printf("Removed %s package%s\n",
-		color.MagentaString(fmt.Sprintf("%d", removed)),
-		plural)
 
 	return nil
 }
 
 // GeneratePackagesRoleImage builds the docker image for the packages layer
 // where all packages are included
-func (f *Fissile) GeneratePackagesRoleImage(stemcellImageName string, roleManifest *model.RoleManifest, noBuild, force bool, roles model.Roles, packagesImageBuilder *builder.PackagesImageBuilder, labels map[string]string) error {
+func (f *Fissile) GeneratePackagesRoleImage(ctx context.Context, stemcellImageName string, roleManifest *model.RoleManifest, noBuild, force bool, roles model.Roles, packagesImageBuilder *builder.PackagesImageBuilder, labels map[string]string) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
@@ -461,7 +765,10 @@ func (f *Fissile) GeneratePackagesRoleImage(stemcellImageName string, roleManife
 	)
 
 	tarPopulator := packagesImageBuilder.NewDockerPopulator(roles, labels, force)
-	err = dockerManager.BuildImageFromCallback(packagesLayerImageName, stdoutWriter, tarPopulator)
+	err = dockerManager.BuildImageFromCallback(ctx, packagesLayerImageName, stdoutWriter, tarPopulator)
+	if ctx.Err() != nil {
+		return fmt.Errorf("Building the packages layer docker image %s was interrupted", packagesLayerImageName)
+	}
 	if err != nil {
 		log.WriteTo(f.UI)
 		return fmt.Errorf("Error building packages layer docker image: %s", err.Error())
@@ -521,8 +828,11 @@ func (f *Fissile) GeneratePackagesRoleTarball(repository string, roleManifest *m
 	return nil
 }
 
-// GenerateRoleImages generates all role images using releases
-func (f *Fissile) GenerateRoleImages(targetPath, registry, organization, repository, stemcellImageName, stemcellImageID, metricsPath string, noBuild, force bool, tagExtra string, roleNames []string, workerCount int, roleManifestPath, compiledPackagesPath, lightManifestPath, darkManifestPath, outputDirectory string, labels map[string]string) error {
+// GenerateRoleImages generates all role images using releases. Cancelling
+// the context interrupts the image builds. The compiled packages are taken
+// from the directory of the stemcell, see compilator.FindStemcellCompilationDir;
+// stemcell, the BOSH stemcell (<os>/<version>), may be empty.
+func (f *Fissile) GenerateRoleImages(ctx context.Context, targetPath, registry, organization, repository, stemcellImageName, stemcellImageID, stemcell, metricsPath string, noBuild, force bool, tagExtra string, roleNames []string, workerCount int, roleManifestPath, compiledPackagesPath, lightManifestPath, darkManifestPath, opinionsVarsPath, outputDirectory string, labels map[string]string) error {
 	if len(f.releases) == 0 {
 		return fmt.Errorf("Releases not loaded")
 	}
@@ -537,12 +847,14 @@ func (f *Fissile) GenerateRoleImages(targetPath, registry, organization, reposit
 		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
 	}
 
-	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath, opinionsVarsPath)
 	if err != nil {
 		return err
 	}
-	if errs := f.validateManifestAndOpinions(roleManifest, opinions); len(errs) != 0 {
-		return fmt.Errorf(errs.Errors())
+	errs := f.validateManifestAndOpinions(roleManifest, opinions)
+	f.printValidationNotes(errs)
+	if errs = errs.OfSeverity(validation.SeverityError); len(errs) != 0 {
+		return fmt.Errorf("%s", describeValidationErrors(errs))
 	}
 
 	if outputDirectory != "" {
@@ -557,11 +869,40 @@ func (f *Fissile) GenerateRoleImages(targetPath, registry, organization, reposit
 		}
 	}
 
+	// Packages are compiled into a directory per stemcell image ID. Without
+	// docker, or without the image, they are looked up by the image name and
+	// the BOSH stemcell instead, taking the image ID from the record of the
+	// packages found
+	if stemcellImageID == "" {
+		if dockerManager, err := docker.NewImageManager(); err == nil {
+			if stemcellImage, err := dockerManager.FindImage(stemcellImageName); err == nil {
+				stemcellImageID = stemcellImage.ID
+			}
+		}
+	}
+	stemcellCompiledPackagesPath, err := compilator.FindStemcellCompilationDir(compiledPackagesPath, compilator.StemcellIdentity{
+		Image:   stemcellImageName,
+		ImageID: stemcellImageID,
+		OS:      stemcell,
+	})
+	if err != nil {
+		return err
+	}
+	if stemcellImageID == "" {
+		recorded, err := compilator.ReadStemcellCompilationDir(stemcellCompiledPackagesPath)
+		if err != nil {
+			return err
+		}
+		if recorded != nil {
+			stemcellImageID = recorded.ImageID
+		}
+	}
+
 	packagesImageBuilder, err := builder.NewPackagesImageBuilder(
 		repository,
 		stemcellImageName,
 		stemcellImageID,
-		compiledPackagesPath,
+		stemcellCompiledPackagesPath,
 		targetPath,
 		f.Version,
 		f.UI,
@@ -574,9 +915,15 @@ func (f *Fissile) GenerateRoleImages(targetPath, registry, organization, reposit
 	if err != nil {
 		return err
 	}
+	// Docker roles run existing images, there is nothing to build for them
+	roles = roles.WithoutDockerRoles()
+	if len(roles) == 0 {
+		f.UI.Println("No role images to build")
+		return nil
+	}
 
 	if outputDirectory == "" {
-		err = f.GeneratePackagesRoleImage(stemcellImageName, roleManifest, noBuild, force, roles, packagesImageBuilder, labels)
+		err = f.GeneratePackagesRoleImage(ctx, stemcellImageName, roleManifest, noBuild, force, roles, packagesImageBuilder, labels)
 	} else {
 		err = f.GeneratePackagesRoleTarball(stemcellImageName, roleManifest, noBuild, force, roles, outputDirectory, packagesImageBuilder, labels)
 	}
@@ -595,6 +942,7 @@ func (f *Fissile) GenerateRoleImages(targetPath, registry, organization, reposit
 		targetPath,
 		lightManifestPath,
 		darkManifestPath,
+		opinionsVarsPath,
 		metricsPath,
 		tagExtra,
 		f.Version,
@@ -605,11 +953,11 @@ func (f *Fissile) GenerateRoleImages(targetPath, registry, organization, reposit
 		return err
 	}
 
-	return roleBuilder.BuildRoleImages(roles, registry, organization, repository, packagesLayerImageName, outputDirectory, force, noBuild, workerCount)
+	return roleBuilder.BuildRoleImages(ctx, roles, registry, organization, repository, packagesLayerImageName, outputDirectory, force, noBuild, workerCount)
 }
 
 // ListRoleImages lists all dev role images
-func (f *Fissile) ListRoleImages(registry, organization, repository, roleManifestPath, opinionsPath, darkOpinionsPath string, existingOnDocker, withVirtualSize bool, tagExtra string) error {
+func (f *Fissile) ListRoleImages(registry, organization, repository, roleManifestPath, opinionsPath, darkOpinionsPath, opinionsVarsPath string, existingOnDocker, withVirtualSize bool, tagExtra string) error {
 	if withVirtualSize && !existingOnDocker {
 		return fmt.Errorf("Cannot list image virtual sizes if not matching image names with docker")
 	}
@@ -633,12 +981,12 @@ func (f *Fissile) ListRoleImages(registry, organization, repository, roleManifes
 		return fmt.Errorf("Error loading roles manifest: %s", err.Error())
 	}
 
-	opinions, err := model.NewOpinions(opinionsPath, darkOpinionsPath)
+	opinions, err := model.NewOpinions(opinionsPath, darkOpinionsPath, opinionsVarsPath)
 	if err != nil {
 		return fmt.Errorf("Error loading opinions: %s", err.Error())
 	}
 
-	for _, role := range roleManifest.Roles {
+	for _, role := range roleManifest.Roles.WithoutDockerRoles() {
 		devVersion, err := role.GetRoleDevVersion(opinions, tagExtra, f.Version, f)
 		if err != nil {
 			return fmt.Errorf("Error creating role checksum: %s", err.Error())
@@ -673,10 +1021,19 @@ func (f *Fissile) ListRoleImages(registry, organization, repository, roleManifes
 	return nil
 }
 
-//LoadReleases loads information about BOSH releases
-func (f *Fissile) LoadReleases(releasePaths, releaseNames, releaseVersions []string, cacheDir string) error {
+//LoadReleases loads information about BOSH releases. Release tarballs are
+//extracted into releasesWorkDir, which also holds the cache of job specs.
+//Releases are loaded concurrently.
+func (f *Fissile) LoadReleases(releasePaths, releaseNames, releaseVersions []string, cacheDir, releasesWorkDir string) error {
 	releases := make([]*model.Release, len(releasePaths))
+	errs := make([]error, len(releasePaths))
 
+	var specCache *model.JobSpecCache
+	if releasesWorkDir != "" {
+		specCache = model.NewJobSpecCache(filepath.Join(releasesWorkDir, "specs"))
+	}
+
+	var wg sync.WaitGroup
 	for idx, releasePath := range releasePaths {
 		var releaseName, releaseVersion string
 
@@ -688,22 +1045,18 @@ func (f *Fissile) LoadReleases(releasePaths, releaseNames, releaseVersions []str
 			releaseVersion = releaseVersions[idx]
 		}
 
-		var release *model.Release
-		var err error
-		if _, err = isFinalReleasePath(releasePath); err == nil {
-			// For final releases, only can use release name and version defined in release.MF, cannot specify them through flags.
-			release, err = model.NewFinalRelease(releasePath)
-			if err != nil {
-				return fmt.Errorf("Error loading final release information: %s", err.Error())
-			}
-		} else {
-			release, err = model.NewDevRelease(releasePath, releaseName, releaseVersion, cacheDir)
-			if err != nil {
-				return fmt.Errorf("Error loading dev release information: %s", err.Error())
-			}
-		}
+		wg.Add(1)
+		go func(idx int, releasePath, releaseName, releaseVersion string) {
+			defer wg.Done()
+			releases[idx], errs[idx] = loadRelease(releasePath, releaseName, releaseVersion, cacheDir, releasesWorkDir, specCache)
+		}(idx, releasePath, releaseName, releaseVersion)
+	}
+	wg.Wait()
 
-		releases[idx] = release
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
 
 	f.releases = releases
@@ -711,6 +1064,41 @@ func (f *Fissile) LoadReleases(releasePaths, releaseNames, releaseVersions []str
 	return nil
 }
 
+func loadRelease(releasePath, releaseName, releaseVersion, cacheDir, releasesWorkDir string, specCache *model.JobSpecCache) (*model.Release, error) {
+	if model.IsReleaseTarball(releasePath) {
+		// Release tarballs are always final releases
+		release, err := model.NewFinalReleaseFromTarball(releasePath, releasesWorkDir, specCache)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading final release tarball %s: %s", releasePath, err.Error())
+		}
+		return release, nil
+	}
+
+	if model.IsDevReleaseSourceTree(releasePath) {
+		// Release source trees without dev releases get their jobs and packages built in place
+		release, err := model.NewDevReleaseFromSource(releasePath, releaseName, releaseVersion, cacheDir, specCache)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating dev release from %s: %s", releasePath, err.Error())
+		}
+		return release, nil
+	}
+
+	if _, err := isFinalReleasePath(releasePath); err == nil {
+		// For final releases, only can use release name and version defined in release.MF, cannot specify them through flags.
+		release, err := model.NewFinalReleaseWithSpecCache(releasePath, specCache)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading final release information: %s", err.Error())
+		}
+		return release, nil
+	}
+
+	release, err := model.NewDevReleaseWithSpecCache(releasePath, releaseName, releaseVersion, cacheDir, specCache)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading dev release information: %s", err.Error())
+	}
+	return release, nil
+}
+
 func isFinalReleasePath(releasePath string) (bool, error) {
 	if err := util.ValidatePath(releasePath, true, "release directory"); err != nil {
 		return false, err
@@ -761,8 +1149,8 @@ releaseNameLoop:
 }
 
 // DiffConfigurationBases generates a diff comparing the specs for two different BOSH releases
-func (f *Fissile) DiffConfigurationBases(releasePaths []string, cacheDir string) error {
-	hashDiffs, err := f.GetDiffConfigurationBases(releasePaths, cacheDir)
+func (f *Fissile) DiffConfigurationBases(releasePaths []string, cacheDir, releasesWorkDir string) error {
+	hashDiffs, err := f.GetDiffConfigurationBases(releasePaths, cacheDir, releasesWorkDir)
 	if err != nil {
 		return err
 	}
@@ -771,12 +1159,12 @@ func (f *Fissile) DiffConfigurationBases(releasePaths []string, cacheDir string)
 }
 
 // GetDiffConfigurationBases calculates the difference in configs and returns a hash
-func (f *Fissile) GetDiffConfigurationBases(releasePaths []string, cacheDir string) (*HashDiffs, error) {
+func (f *Fissile) GetDiffConfigurationBases(releasePaths []string, cacheDir, releasesWorkDir string) (*HashDiffs, error) {
 	if len(releasePaths) != 2 {
 		return nil, fmt.Errorf("expected two release paths, got %d", len(releasePaths))
 	}
 	defaultValues := []string{}
-	err := f.LoadReleases(releasePaths, defaultValues, defaultValues, cacheDir)
+	err := f.LoadReleases(releasePaths, defaultValues, defaultValues, cacheDir, releasesWorkDir)
 	if err != nil {
 		return nil, fmt.Errorf("dev config diff: error loading release information: %s", err)
 	}
@@ -1059,10 +1447,7 @@ func (f *Fissile) generateBoshTaskRole(outputFile *os.File, role *model.Role, se
 
 // roleIsStateful returns true if a given role needs to be a StatefulSet
 func (f *Fissile) roleIsStateful(role *model.Role) bool {
-	if role.HasTag("clustered") || role.HasTag("indexed") {
-		return true
-	}
-	return false
+	return role.IsStateful()
 }
 
 // roleHasStorage returns true if a given role uses shared or
@@ -1115,7 +1500,7 @@ func (f *Fissile) generateKubeRoles(settings kube.ExportSettings) error {
 				return err
 			}
 
-		case model.RoleTypeBosh:
+		case model.RoleTypeBosh, model.RoleTypeDocker:
 			enc := helm.NewEncoder(outputFile)
 
 			isStateful := f.roleIsStateful(role)
@@ -12,6 +12,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/SUSE/fissile/compilator"
 	"github.com/SUSE/fissile/kube"
 	"github.com/SUSE/fissile/model"
 	"github.com/SUSE/fissile/testhelpers"
@@ -33,9 +34,9 @@ func TestCleanCacheEmpty(t *testing.T) {
 	releasePathCacheDir := filepath.Join(releasePath, "bosh-cache")
 
 	f := NewFissileApplication(".", ui)
-	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir, "")
 	if assert.NoError(err) {
-		err = f.CleanCache(workDir + "compilation")
+		err = f.CleanCache(workDir+"compilation", 0, 0, 0, false)
 		assert.Nil(err, "Expected CleanCache to find the release")
 	}
 }
@@ -53,10 +54,10 @@ func TestListPackages(t *testing.T) {
 	releasePathCacheDir := filepath.Join(releasePath, "bosh-cache")
 
 	f := NewFissileApplication(".", ui)
-	err = f.LoadReleases([]string{badReleasePath}, []string{""}, []string{""}, badReleasePathCacheDir)
+	err = f.LoadReleases([]string{badReleasePath}, []string{""}, []string{""}, badReleasePathCacheDir, "")
 	assert.Error(err, "Expected ListPackages to not find the release")
 
-	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir, "")
 	if assert.NoError(err) {
 		err = f.ListPackages(false)
 		assert.Nil(err, "Expected ListPackages to find the release")
@@ -77,10 +78,10 @@ func TestListJobs(t *testing.T) {
 
 	f := NewFissileApplication(".", ui)
 
-	err = f.LoadReleases([]string{badReleasePath}, []string{""}, []string{""}, badReleasePathCacheDir)
+	err = f.LoadReleases([]string{badReleasePath}, []string{""}, []string{""}, badReleasePathCacheDir, "")
 	assert.Error(err, "Expected ListJobs to not find the release")
 
-	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir, "")
 	if assert.NoError(err) {
 		err = f.ListJobs(false)
 		assert.Nil(err, "Expected ListJobs to find the release")
@@ -101,22 +102,82 @@ func TestListProperties(t *testing.T) {
 
 	f := NewFissileApplication(".", ui)
 
-	err = f.LoadReleases([]string{badReleasePath}, []string{""}, []string{""}, badReleasePathCacheDir)
+	err = f.LoadReleases([]string{badReleasePath}, []string{""}, []string{""}, badReleasePathCacheDir, "")
 	assert.Error(err, "Expected ListProperties to not find the release")
 
-	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir, "")
 	if assert.NoError(err) {
-		err = f.ListProperties("human")
+		err = f.ListProperties("human", false)
 		assert.NoError(err, "Expected ListProperties to list release properties for human consumption")
 
-		err = f.ListProperties("json")
+		err = f.ListProperties("json", false)
 		assert.NoError(err, "Expected ListProperties to list release properties in JSON")
 
-		err = f.ListProperties("yaml")
+		err = f.ListProperties("yaml", false)
 		assert.NoError(err, "Expected ListProperties to list release properties in YAML")
 	}
 }
 
+func TestListPropertiesExamples(t *testing.T) {
+	assert := assert.New(t)
+
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	f := NewFissileApplication(".", ui)
+
+	job := &model.Job{Name: "job"}
+	job.Properties = []*model.JobProperty{
+		&model.JobProperty{Name: "plain", Default: 1, Job: job},
+		&model.JobProperty{Name: "cert", Example: "some-cert", Type: "certificate", Job: job},
+	}
+	f.releases = []*model.Release{&model.Release{Name: "release", Jobs: model.Jobs{job}}}
+
+	// By default, properties are mapped to their default value
+	if assert.NoError(f.ListProperties(OutputFormatJSON, false)) {
+		assert.JSONEq(`{"release": {"job": {"plain": 1, "cert": null}}}`, output.String())
+	}
+
+	output.Reset()
+	if assert.NoError(f.ListProperties(OutputFormatYAML, false)) {
+		var actual map[string]interface{}
+		if assert.NoError(yaml.Unmarshal(output.Bytes(), &actual)) {
+			assert.Equal(map[interface{}]interface{}{
+				"plain": 1,
+				"cert":  nil,
+			}, actual["release"].(map[interface{}]interface{})["job"])
+		}
+	}
+
+	output.Reset()
+	if assert.NoError(f.ListProperties(OutputFormatJSON, true)) {
+		assert.JSONEq(`{
+			"release": {
+				"job": {
+					"plain": {"default": 1},
+					"cert": {"default": null, "example": "some-cert", "type": "certificate"}
+				}
+			}
+		}`, output.String())
+	}
+
+	output.Reset()
+	if assert.NoError(f.ListProperties(OutputFormatYAML, true)) {
+		var actual map[string]interface{}
+		if assert.NoError(yaml.Unmarshal(output.Bytes(), &actual)) {
+			assert.Equal(map[interface{}]interface{}{
+				"plain": map[interface{}]interface{}{"default": 1},
+				"cert":  map[interface{}]interface{}{"default": nil, "example": "some-cert", "type": "certificate"},
+			}, actual["release"].(map[interface{}]interface{})["job"])
+		}
+	}
+
+	output.Reset()
+	if assert.NoError(f.ListProperties(OutputFormatHuman, false)) {
+		assert.Contains(output.String(), "type: certificate")
+		assert.Contains(output.String(), "example: some-cert")
+	}
+}
+
 var testSerializeInput struct {
 	releases []*model.Release
 	once     sync.Once
@@ -203,6 +264,66 @@ func initTestSerializeInput() {
 	testSerializeInput.releases = releases
 }
 
+func TestListLinks(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+	f := NewFissileApplication(".", ui)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/multiple-good.yml")
+	err = f.ListLinks(roleManifestPath, OutputFormatHuman)
+	assert.EqualError(err, "Releases not loaded")
+
+	for _, dirName := range []string{"ntp-release", "tor-boshrelease"} {
+		releasePath := filepath.Join(workDir, "../test-assets", dirName)
+		release, err := model.NewDevRelease(releasePath, "", "", filepath.Join(releasePath, "bosh-cache"))
+		if !assert.NoError(err) {
+			return
+		}
+		f.releases = append(f.releases, release)
+	}
+
+	if assert.NoError(f.ListLinks(roleManifestPath, OutputFormatJSON)) {
+		var links []model.ResolvedLink
+		if assert.NoError(json.Unmarshal(output.Bytes(), &links)) {
+			assert.Equal([]model.ResolvedLink{
+				{
+					Role:         "myrole",
+					Job:          "ntpd",
+					Name:         "ntp-client",
+					Type:         "ntp",
+					ProviderRole: "myrole",
+					ProviderJob:  "ntpd",
+				},
+				{
+					Role:         "myrole",
+					Job:          "ntpd",
+					Name:         "ntp-server",
+					Type:         "ntpd",
+					ProviderRole: "myrole",
+					ProviderJob:  "ntpd",
+				},
+			}, links)
+		}
+	}
+
+	output.Reset()
+	if assert.NoError(f.ListLinks(roleManifestPath, OutputFormatHuman)) {
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		if assert.Len(lines, 3) {
+			assert.Equal([]string{"ROLE", "JOB", "LINK", "TYPE", "PROVIDER", "ROLE", "PROVIDER", "JOB", "SERVICE"}, strings.Fields(lines[0]))
+			// myrole has no exposed ports, so there is no service for it
+			assert.Equal([]string{"myrole", "ntpd", "ntp-client", "ntp", "myrole", "ntpd"}, strings.Fields(lines[1]))
+		}
+	}
+
+	assert.Error(f.ListLinks(roleManifestPath, "xml"))
+}
+
 func TestSerializePackages(t *testing.T) {
 	assert := assert.New(t)
 	testSerializeInput.once.Do(initTestSerializeInput)
@@ -349,7 +470,7 @@ func TestGenerateAuth(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, roleManifest)
 
-	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache, "")
 	require.NoError(t, err)
 
 	outDir, err := ioutil.TempDir("", "fissile-generate-auth-")
@@ -557,7 +678,7 @@ func TestFissileSelectRolesToBuild(t *testing.T) {
 	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
 
 	f := NewFissileApplication(",", ui)
-	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir, "")
 	if !assert.NoError(err) {
 		return
 	}
@@ -615,7 +736,7 @@ func TestFissileGetReleasesByName(t *testing.T) {
 	cacheDir := filepath.Join(workDir, "../test-assets/extracted-license/bosh-cache")
 
 	f := NewFissileApplication(",", ui)
-	err = f.LoadReleases(releasePaths, []string{"test-dev", "test2"}, []string{}, cacheDir)
+	err = f.LoadReleases(releasePaths, []string{"test-dev", "test2"}, []string{}, cacheDir, "")
 	if !assert.NoError(err) {
 		return
 	}
@@ -658,7 +779,7 @@ func TestFissileGenerateKubeRoles(t *testing.T) {
 	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/exposed-ports-no-ports.yml")
 
 	f := NewFissileApplication(".", ui)
-	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir)
+	err = f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, releasePathCacheDir, "")
 	require.NoError(t, err, "Failed to load release from %s", releasePath)
 
 	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
@@ -678,3 +799,108 @@ func TestFissileGenerateKubeRoles(t *testing.T) {
 		assert.NoError(t, err, "Failed to find output %s", name)
 	}
 }
+
+func TestShowCompilation(t *testing.T) {
+	assert := assert.New(t)
+
+	compilationDir, err := ioutil.TempDir("", "fissile-tests")
+	require.NoError(t, err)
+	defer os.RemoveAll(compilationDir)
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication("", termui.New(&bytes.Buffer{}, output, nil))
+
+	require.NoError(t, f.ShowCompilation(compilationDir, "", OutputFormatHuman))
+	assert.Contains(output.String(), "No compilation status recorded")
+
+	workDir := compilator.StemcellCompilationDir(compilationDir, compilator.StemcellIdentity{Image: "stemcell:latest"})
+	for _, status := range []compilator.PackageStatus{
+		{Release: "tor", Package: "libevent", Status: compilator.StatusCompiled},
+		{Release: "tor", Package: "tor", Status: compilator.StatusSkipped, Error: "dependency tor/openssl failed"},
+		{Release: "tor", Package: "openssl", Status: compilator.StatusFailed, Error: "exited with code 2", Log: "/work/openssl/compile.log"},
+	} {
+		status.Fingerprint = status.Package
+		status.Stemcell = "stemcell:latest"
+		buf, err := json.Marshal(status)
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Join(workDir, status.Fingerprint), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(workDir, status.Fingerprint, "status.json"), buf, 0644))
+	}
+
+	output.Reset()
+	require.NoError(t, f.ShowCompilation(compilationDir, "stemcell:latest", OutputFormatJSON))
+	var statuses []compilator.PackageStatus
+	require.NoError(t, json.Unmarshal(output.Bytes(), &statuses))
+	var actual []string
+	for _, status := range statuses {
+		actual = append(actual, status.Status+" "+status.Package)
+	}
+	assert.Equal([]string{"failed openssl", "skipped tor", "compiled libevent"}, actual)
+
+	output.Reset()
+	require.NoError(t, f.ShowCompilation(compilationDir, "", OutputFormatHuman))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if assert.Len(lines, 6) {
+		assert.Regexp(`^STATUS +PACKAGE +FINISHED +LOG +ERROR$`, lines[0])
+		assert.Regexp(`^failed +tor/openssl +.* +/work/openssl/compile.log +exited with code 2$`, lines[1])
+		assert.Equal("1 compiled, 0 cached, 1 failed, 1 skipped", lines[5])
+	}
+
+	output.Reset()
+	require.NoError(t, f.ShowCompilation(compilationDir, "stemcell:other", OutputFormatJSON))
+	assert.Equal("[]\n", output.String())
+}
+
+func TestPlanCompilation(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml")
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication("", termui.New(&bytes.Buffer{}, output, nil))
+	require.NoError(t, f.LoadReleases([]string{releasePath}, []string{""}, []string{""}, filepath.Join(releasePath, "bosh-cache"), ""))
+
+	compilationDir, err := ioutil.TempDir("", "fissile-tests")
+	require.NoError(t, err)
+	defer os.RemoveAll(compilationDir)
+
+	// libevent is compiled already, tor depends on it; the plan is made for
+	// the stemcell image compiled on last
+	stemcell := compilator.StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:aaa"}
+	stemcellDir := compilator.StemcellCompilationDir(compilationDir, stemcell)
+	require.NoError(t, compilator.RecordStemcellCompilationDir(stemcellDir, stemcell))
+	libevent, err := f.releases[0].LookupPackage("libevent")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(libevent.GetPackageCompiledDir(stemcellDir), "lib"), 0755))
+	require.NoError(t, compilator.RecordPackageStemcell(libevent, stemcellDir, stemcell))
+
+	require.NoError(t, f.PlanCompilation("stemcell:latest", "", compilationDir, roleManifestPath, nil, nil, 1, OutputFormatJSON))
+	var plan compilator.Plan
+	require.NoError(t, json.Unmarshal(output.Bytes(), &plan))
+	assert.Equal("stemcell:latest", plan.Stemcell)
+	if assert.Len(plan.Packages, 2) {
+		assert.Equal("libevent", plan.Packages[0].Name)
+		assert.Equal(compilator.PlanCached, plan.Packages[0].State)
+		assert.Equal("tor", plan.Packages[1].Name)
+		assert.Equal(compilator.PlanCompile, plan.Packages[1].State)
+		assert.Equal(1, plan.Packages[1].Order)
+		assert.Equal(1, plan.Packages[1].Depth)
+		assert.Equal([]string{"tor/libevent"}, plan.Packages[1].Dependencies)
+	}
+
+	output.Reset()
+	require.NoError(t, f.PlanCompilation("stemcell:latest", "", compilationDir, roleManifestPath, nil, nil, 1, OutputFormatHuman))
+	assert.Contains(output.String(), "1 cached, 0 precompiled, 1 to compile")
+
+	output.Reset()
+	require.NoError(t, f.PlanCompilation("stemcell:latest", "", compilationDir, roleManifestPath, nil, nil, 1, OutputFormatDOT))
+	assert.Contains(output.String(), "digraph compilation {")
+	assert.Contains(output.String(), `"tor/tor" [label="tor/tor\n#1, depth 1"];`)
+	assert.Contains(output.String(), `"tor/libevent" -> "tor/tor";`)
+
+	err = f.PlanCompilation("stemcell:latest", "", compilationDir, roleManifestPath, nil, nil, 1, OutputFormatJUnit)
+	assert.Error(err)
+}
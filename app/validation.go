@@ -1,15 +1,251 @@
 package app
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/SUSE/fissile/model"
 	"github.com/SUSE/fissile/validation"
 
 	"github.com/fatih/color"
+	"github.com/joho/godotenv"
 )
 
+// The IDs of the rules checked by the validator, in addition to those of
+// the role manifest itself
+const (
+	ruleAmbiguousDefault          = "ambiguous-default"
+	ruleDarkOpinionInLight        = "dark-opinion-in-light"
+	ruleDifferingBOSHDefaults     = "differing-bosh-defaults"
+	ruleLightOpinionIsDefault     = "light-opinion-is-default"
+	ruleManifestDuplicatesOpinion = "manifest-duplicates-opinion"
+	ruleUndeclaredEnvVariable     = "undeclared-env-variable"
+	ruleUndefinedBOSHProperty     = "undefined-bosh-property"
+	ruleUntemplatedDarkOpinion    = "untemplated-dark-opinion"
+)
+
+// validatorRules lists the IDs of the rules checked by the validator, in
+// addition to model.Rules
+var validatorRules = []string{
+	ruleAmbiguousDefault,
+	ruleDarkOpinionInLight,
+	ruleDifferingBOSHDefaults,
+	ruleLightOpinionIsDefault,
+	ruleManifestDuplicatesOpinion,
+	ruleUndeclaredEnvVariable,
+	ruleUndefinedBOSHProperty,
+	ruleUntemplatedDarkOpinion,
+}
+
+// validatorRuleDescriptions describes the rules checked by the validator,
+// including those of model.Rules, by their IDs, for SARIF reports
+var validatorRuleDescriptions = func() map[string]string {
+	descriptions := map[string]string{
+		ruleAmbiguousDefault:          "Light opinions are not compared to ambiguous defaults",
+		ruleDarkOpinionInLight:        "Dark opinions have no light opinions",
+		ruleDifferingBOSHDefaults:     "Properties have the same default across jobs",
+		ruleLightOpinionIsDefault:     "Light opinions differ from the defaults",
+		ruleManifestDuplicatesOpinion: "Templates do not duplicate light opinions",
+		ruleUndeclaredEnvVariable:     "Variables of the env files are declared",
+		ruleUndefinedBOSHProperty:     "Properties are defined by a BOSH release",
+		ruleUntemplatedDarkOpinion:    "Dark opinions have templates",
+	}
+	for rule, description := range model.RuleDescriptions {
+		descriptions[rule] = description
+	}
+	return descriptions
+}()
+
+// ValidationFailedError is returned by Validate when it wrote a machine
+// readable report of the issues found, which the error does not repeat
+type ValidationFailedError struct {
+	Count int
+}
+
+func (e ValidationFailedError) Error() string {
+	return fmt.Sprintf("Validation failed with %d errors", e.Count)
+}
+
+// Validate runs all checks of the role manifest validator on the role
+// manifest, opinions and env files, without building anything. Loading the
+// role manifest performs the checks on the role manifest alone; the others
+// are run here, unless loading failed. All errors found are reported in the
+// returned error for human output, after printing the warnings and infos, or
+// written as a JSON, JUnit or SARIF report otherwise, with the files, lines
+// and columns they were found at. Strict validation fails on warnings too.
+func (f *Fissile) Validate(roleManifestPath, lightManifestPath, darkManifestPath, opinionsVarsPath, scriptsDir string, defaultFiles []string, strict bool, outputFormat OutputFormat) error {
+	if len(f.releases) == 0 {
+		return fmt.Errorf("Releases not loaded")
+	}
+
+	switch outputFormat {
+	case OutputFormatHuman, OutputFormatJSON, OutputFormatJUnit, OutputFormatSARIF:
+	default:
+		return fmt.Errorf("Invalid output format '%s', expected one of human, json, junit, or sarif", outputFormat)
+	}
+
+	allErrs, err := f.validate(roleManifestPath, lightManifestPath, darkManifestPath, opinionsVarsPath, scriptsDir, defaultFiles)
+	if err != nil {
+		return err
+	}
+
+	// Errors without a file are about the role manifest itself
+	baseManifestPath := strings.Split(roleManifestPath, ",")[0]
+	for _, err := range allErrs {
+		if err.File == "" {
+			err.File = baseManifestPath
+		}
+	}
+
+	if strict {
+		allErrs.PromoteWarnings()
+	}
+	failures := allErrs.OfSeverity(validation.SeverityError)
+
+	if outputFormat == OutputFormatHuman {
+		f.printValidationNotes(allErrs)
+		if len(failures) != 0 {
+			return fmt.Errorf("%s", describeValidationErrors(failures))
+		}
+		f.UI.Println(color.GreenString("Role manifest and opinions are valid"))
+		return nil
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	switch outputFormat {
+	case OutputFormatJSON:
+		err = validation.WriteJSON(f.UI, allErrs, workDir)
+	case OutputFormatJUnit:
+		err = validation.WriteJUnit(f.UI, allErrs, workDir)
+	case OutputFormatSARIF:
+		err = validation.WriteSARIF(f.UI, allErrs, workDir, f.Version, validatorRuleDescriptions)
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing validation report: %s", err.Error())
+	}
+
+	if len(failures) != 0 {
+		return ValidationFailedError{Count: len(failures)}
+	}
+	return nil
+}
+
+// printValidationNotes prints the warnings and infos among the validation
+// errors
+func (f *Fissile) printValidationNotes(allErrs validation.ErrorList) {
+	for _, err := range allErrs.OfSeverity(validation.SeverityWarning) {
+		f.UI.Printf("%s: %s\n", color.YellowString("Warning"), describeValidationErrors(validation.ErrorList{err}))
+	}
+	for _, err := range allErrs.OfSeverity(validation.SeverityInfo) {
+		f.UI.Printf("%s: %s\n", color.CyanString("Info"), describeValidationErrors(validation.ErrorList{err}))
+	}
+}
+
+// describeValidationErrors returns the messages of the errors, one per line,
+// with the IDs of the violated rules, so that they can be suppressed
+func describeValidationErrors(allErrs validation.ErrorList) string {
+	lines := make([]string, 0, len(allErrs))
+	for _, err := range allErrs {
+		if err.Rule == "" {
+			lines = append(lines, err.Error())
+		} else {
+			lines = append(lines, fmt.Sprintf("%s [%s]", err.Error(), err.Rule))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validate runs the checks of Validate and returns the issues found
+func (f *Fissile) validate(roleManifestPath, lightManifestPath, darkManifestPath, opinionsVarsPath, scriptsDir string, defaultFiles []string) (validation.ErrorList, error) {
+	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
+	if err != nil {
+		if allErrs, ok := err.(validation.ErrorList); ok {
+			return allErrs, nil
+		}
+		return nil, fmt.Errorf("Error loading roles manifest: %s", err.Error())
+	}
+
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath, opinionsVarsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading opinions: %s", err.Error())
+	}
+
+	allErrs := f.validateManifestAndOpinions(roleManifest, opinions)
+
+	// All vars in env files must exist in the role manifest
+	envErrs, err := checkForUndeclaredEnvVariables(defaultFiles, roleManifest)
+	if err != nil {
+		return nil, err
+	}
+	allErrs = append(allErrs, envErrs...)
+
+	// All role manifest templates must be sorted
+	allErrs = append(allErrs, roleManifest.ValidateTemplateSorting()...)
+
+	// All of the scripts must be used
+	allErrs = append(allErrs, roleManifest.ValidateScriptUsage(scriptsDir)...)
+
+	return allErrs, nil
+}
+
+// checkForUndeclaredEnvVariables reports all variables set in the env files
+// which are not declared in the role manifest
+func checkForUndeclaredEnvVariables(defaultFiles []string, roleManifest *model.RoleManifest) (validation.ErrorList, error) {
+	allErrs := validation.ErrorList{}
+	declared := model.MakeMapOfVariables(roleManifest)
+
+	for _, defaultFile := range defaultFiles {
+		defaults, err := godotenv.Read(defaultFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading env file %s: %s", defaultFile, err.Error())
+		}
+
+		names := make([]string, 0, len(defaults))
+		for name := range defaults {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if _, ok := declared[name]; ok {
+				continue
+			}
+			err := validation.NotFound("variables", name).WithRule(ruleUndeclaredEnvVariable, validation.SeverityError)
+			err.Locate(defaultFile, envVariableLine(defaultFile, name), 1)
+			allErrs = append(allErrs, err)
+		}
+	}
+
+	return allErrs, nil
+}
+
+// envVariableLine returns the line of the env file the variable is set on,
+// or 0 if it is not found
+func envVariableLine(envFile, name string) int {
+	file, err := os.Open(envFile)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		content := strings.TrimSpace(scanner.Text())
+		content = strings.TrimSpace(strings.TrimPrefix(content, "export "))
+		if strings.HasPrefix(content, name+"=") {
+			return line
+		}
+	}
+	return 0
+}
+
 // validateManifestAndOpinions applies a series of checks to the role
 // manifest and opinions, testing for consistency against each other
 // and the loaded bosh releases. The result is a (possibly empty)
@@ -24,22 +260,22 @@ func (f *Fissile) validateManifestAndOpinions(roleManifest *model.RoleManifest,
 
 	// All properties must be defined in a BOSH release
 	allErrs = append(allErrs, checkForUndefinedBOSHProperties("role-manifest",
-		manifestProperties, boshPropertyDefaultsAndJobs)...)
+		manifestProperties, boshPropertyDefaultsAndJobs, manifestPropertyLocator(roleManifest))...)
 
 	// All light opinions must exists in a bosh release
 	allErrs = append(allErrs, checkForUndefinedBOSHProperties("light opinion",
-		lightOpinions, boshPropertyDefaultsAndJobs)...)
+		lightOpinions, boshPropertyDefaultsAndJobs, opinions.LightPosition)...)
 
 	// All dark opinions must exists in a bosh release
 	allErrs = append(allErrs, checkForUndefinedBOSHProperties("dark opinion",
-		darkOpinions, boshPropertyDefaultsAndJobs)...)
+		darkOpinions, boshPropertyDefaultsAndJobs, opinions.DarkPosition)...)
 
 	// All dark opinions must be configured as templates
 	allErrs = append(allErrs, checkForUntemplatedDarkOpinions(darkOpinions,
-		manifestProperties)...)
+		manifestProperties, opinions.DarkPosition)...)
 
 	// No dark opinions must have defaults in light opinions
-	allErrs = append(allErrs, checkForDarkInTheLight(darkOpinions, lightOpinions)...)
+	allErrs = append(allErrs, checkForDarkInTheLight(darkOpinions, lightOpinions, opinions.LightPosition)...)
 
 	// No duplicates must exist between role manifest and light
 	// opinions
@@ -47,23 +283,55 @@ func (f *Fissile) validateManifestAndOpinions(roleManifest *model.RoleManifest,
 
 	// All bosh properties in a release should have the same
 	// default across jobs -- WARNING only, not error
-	f.checkBOSHDefaults(boshPropertyDefaultsAndJobs)
+	allErrs = append(allErrs, checkBOSHDefaults(boshPropertyDefaultsAndJobs)...)
 
 	// All light opinions should differ from their defaults in the
 	// BOSH releases
-	allErrs = append(allErrs, f.checkLightDefaults(lightOpinions,
-		boshPropertyDefaultsAndJobs)...)
+	allErrs = append(allErrs, checkLightDefaults(lightOpinions,
+		boshPropertyDefaultsAndJobs, opinions.LightPosition)...)
 
-	return allErrs
+	// Roles and variables must only suppress rules which exist
+	allErrs = append(allErrs, roleManifest.ValidateSuppressedRules(validatorRules...)...)
+
+	return roleManifest.WithoutSuppressed(allErrs)
+}
+
+// propertyLocator returns the file, line and column where a property, such
+// as properties.tor.hostname, is set. The file is empty if it is not known.
+type propertyLocator func(property string) (string, int, int)
+
+// locateProperty records in the error where the property it is about is set
+func locateProperty(err *validation.Error, locate propertyLocator, property string) *validation.Error {
+	if file, line, column := locate(property); file != "" {
+		err.Locate(file, line, column)
+	}
+	return err
+}
+
+// manifestPropertyLocator finds properties in the templates of the role
+// manifest, global ones first
+func manifestPropertyLocator(roleManifest *model.RoleManifest) propertyLocator {
+	return func(property string) (string, int, int) {
+		fields := []string{fmt.Sprintf("configuration.templates[%s]", property)}
+		for _, role := range roleManifest.Roles {
+			fields = append(fields, fmt.Sprintf("roles[%s].configuration.templates[%s]", role.Name, property))
+		}
+		for _, field := range fields {
+			if file, line, column := roleManifest.Position(field); line != 0 {
+				return file, line, column
+			}
+		}
+		return "", 0, 0
+	}
 }
 
 // Check that the given 'properties' are all defined in a 'bosh'
 // release.
-func checkForUndefinedBOSHProperties(label string, properties map[string]string, bosh propertyDefaults) validation.ErrorList {
+func checkForUndefinedBOSHProperties(label string, properties map[string]string, bosh propertyDefaults, locate propertyLocator) validation.ErrorList {
 	// All provided properties must be defined in a BOSH release
 	allErrs := validation.ErrorList{}
 
-	for property := range properties {
+	for _, property := range sortedProperties(properties) {
 		// Ignore specials (without the "properties." prefix)
 		if !strings.HasPrefix(property, "properties.") {
 			continue
@@ -83,14 +351,26 @@ func checkForUndefinedBOSHProperties(label string, properties map[string]string,
 				continue
 			}
 
-			allErrs = append(allErrs, validation.NotFound(
-				fmt.Sprintf("%s '%s'", label, p), "In any BOSH release"))
+			allErrs = append(allErrs, locateProperty(validation.NotFound(
+				fmt.Sprintf("%s '%s'", label, p), "In any BOSH release").WithRule(ruleUndefinedBOSHProperty, validation.SeverityError),
+				locate, property))
 		}
 	}
 
 	return allErrs
 }
 
+// sortedProperties returns the names of the properties in order, so that
+// they are reported in the same order on each run
+func sortedProperties(properties map[string]string) []string {
+	names := make([]string, 0, len(properties))
+	for property := range properties {
+		names = append(names, property)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // checkParentsOfUndefined walks the chain of parents for `p` from the
 // bottom up and checks if any of them exist. The elements of the
 // chain are separated by dots.
@@ -145,31 +425,34 @@ func collectManifestProperties(roleManifest *model.RoleManifest) map[string]stri
 
 // checkForUntemplatedDarkOpinions reports all dark opinions which are
 // not configured as templates in the manifest.
-func checkForUntemplatedDarkOpinions(dark map[string]string, properties map[string]string) validation.ErrorList {
+func checkForUntemplatedDarkOpinions(dark map[string]string, properties map[string]string, locate propertyLocator) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	for property := range dark {
+	for _, property := range sortedProperties(dark) {
 		if _, ok := properties[property]; ok {
 			continue
 		}
-		allErrs = append(allErrs, validation.NotFound(
-			property, "Dark opinion is missing template in role-manifest"))
+		allErrs = append(allErrs, locateProperty(validation.NotFound(
+			property, "Dark opinion is missing template in role-manifest").WithRule(ruleUntemplatedDarkOpinion, validation.SeverityError),
+			locate, property))
 	}
 
 	return allErrs
 }
 
 // checkForDarkInTheLight reports all dark opinions which have
-// defaults in light opinions, which is forbidden
-func checkForDarkInTheLight(dark map[string]string, light map[string]string) validation.ErrorList {
+// defaults in light opinions, which is forbidden. They are located in the
+// light opinions, where they need to be removed.
+func checkForDarkInTheLight(dark map[string]string, light map[string]string, locate propertyLocator) validation.ErrorList {
 	allErrs := validation.ErrorList{}
 
-	for property := range dark {
+	for _, property := range sortedProperties(dark) {
 		if _, ok := light[property]; !ok {
 			continue
 		}
-		allErrs = append(allErrs, validation.Forbidden(
-			property, "Dark opinion found in light opinions"))
+		allErrs = append(allErrs, locateProperty(validation.Forbidden(
+			property, "Dark opinion found in light opinions").WithRule(ruleDarkOpinionInLight, validation.SeverityError),
+			locate, property))
 	}
 
 	return allErrs
@@ -204,6 +487,12 @@ func checkForDuplicatesBetweenManifestAndLight(light map[string]string, roleMani
 		}
 	}
 
+	for _, err := range allErrs {
+		if file, line, column := roleManifest.Position(err.Field); line != 0 {
+			err.Locate(file, line, column)
+		}
+	}
+
 	return allErrs
 }
 
@@ -219,70 +508,63 @@ func checkForDuplicateProperty(prefix, property, value string, light map[string]
 
 	if lightvalue == value {
 		return append(allErrs, validation.Forbidden(fmt.Sprintf("%s[%s]", prefix, property),
-			"Role-manifest duplicates opinion, remove from manifest"))
+			"Role-manifest duplicates opinion, remove from manifest").WithRule(ruleManifestDuplicatesOpinion, validation.SeverityError))
 	}
 
 	if conflicts {
 		return append(allErrs, validation.Forbidden(fmt.Sprintf("%s[%s]", prefix, property),
-			"Role-manifest overrides opinion, remove opinion"))
+			"Role-manifest overrides opinion, remove opinion").WithRule(ruleManifestDuplicatesOpinion, validation.SeverityError))
 	}
 
 	return allErrs
 }
 
-// checkBOSHDefaults reports all properties which were given differing
+// checkBOSHDefaults warns about all properties which were given differing
 // defaults across BOSH releases and the jobs inside.
-func (f *Fissile) checkBOSHDefaults(pd propertyDefaults) {
-	for property, pInfo := range pd {
+func checkBOSHDefaults(pd propertyDefaults) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	properties := make([]string, 0, len(pd))
+	for property := range pd {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+
+	for _, property := range properties {
+		pInfo := pd[property]
 		// Ignore properties with a single default across all definitions.
 		if len(pInfo.defaults) == 1 {
 			continue
 		}
 
-		f.UI.Printf("%s: Property %s has %s defaults:\n",
-			color.YellowString("Warning"),
-			color.YellowString(property),
-			color.YellowString(fmt.Sprintf("%d", len(pInfo.defaults))))
-
-		maxlen := 0
-		for defaultv := range pInfo.defaults {
-			ds := fmt.Sprintf("%v", defaultv)
-			if len(ds) > maxlen {
-				maxlen = len(ds)
-			}
-		}
-
-		leftjustified := fmt.Sprintf("%%-%ds", maxlen)
-
+		var defaults []string
 		for defaultv, jobs := range pInfo.defaults {
-			ds := fmt.Sprintf("%v", defaultv)
-			if len(jobs) == 1 {
-				job := jobs[0]
-				f.UI.Printf("- Default %s: Release %s, job %s\n",
-					color.CyanString(fmt.Sprintf(leftjustified, ds)),
-					color.CyanString(job.Release.Name),
-					color.CyanString(job.Name))
-			} else {
-				f.UI.Printf("- Default %s:\n", color.CyanString(ds))
-				for _, job := range jobs {
-					f.UI.Printf("  - Release %s, job %s\n",
-						color.CyanString(job.Release.Name),
-						color.CyanString(job.Name))
-				}
+			var jobNames []string
+			for _, job := range jobs {
+				jobNames = append(jobNames, fmt.Sprintf("%s/%s", job.Release.Name, job.Name))
 			}
+			defaults = append(defaults, fmt.Sprintf("%v (%s)", defaultv, strings.Join(jobNames, ", ")))
 		}
+		sort.Strings(defaults)
+
+		allErrs = append(allErrs, validation.Invalid("properties."+property,
+			strings.Join(defaults, "; "),
+			fmt.Sprintf("Has %d different defaults", len(pInfo.defaults))).WithRule(ruleDifferingBOSHDefaults, validation.SeverityWarning))
 	}
+
+	return allErrs
 }
 
 // checkLightDefaults reports all light opinions whose value is
 // identical to their default in the BOSH releases
-func (f *Fissile) checkLightDefaults(light map[string]string, pd propertyDefaults) validation.ErrorList {
+func checkLightDefaults(light map[string]string, pd propertyDefaults, locate propertyLocator) validation.ErrorList {
 
 	// light :: (property.name -> value-of-opinion)
 	// pd    :: (property.name -> (default.string -> [*job...])
 	allErrs := validation.ErrorList{}
 
-	for property, opinion := range light {
+	for _, property := range sortedProperties(light) {
+		opinion := light[property]
 		// Ignore specials (without the "properties." prefix)
 		if !strings.HasPrefix(property, "properties.") {
 			continue
@@ -295,11 +577,11 @@ func (f *Fissile) checkLightDefaults(light map[string]string, pd propertyDefault
 			continue
 		}
 
-		// Ignore properties with ambigous defaults. Warn however.
+		// Ignore properties with ambigous defaults. Note that however.
 		if len(pInfo.defaults) > 1 {
-			f.UI.Printf("light opinion %s ignored, %s\n",
-				color.YellowString(p),
-				color.YellowString("ambiguous default"))
+			allErrs = append(allErrs, locateProperty(validation.Invalid(property, opinion,
+				"Light opinion not compared to the ambiguous default").WithRule(ruleAmbiguousDefault, validation.SeverityInfo),
+				locate, property))
 			continue
 		}
 
@@ -310,9 +592,9 @@ func (f *Fissile) checkLightDefaults(light map[string]string, pd propertyDefault
 			if opinion != thedefault {
 				continue
 			}
-			allErrs = append(allErrs, validation.Forbidden(property,
+			allErrs = append(allErrs, locateProperty(validation.Forbidden(property,
 				fmt.Sprintf("Light opinion matches default of '%v'",
-					thedefault)))
+					thedefault)).WithRule(ruleLightOpinionIsDefault, validation.SeverityError), locate, property))
 		}
 	}
 
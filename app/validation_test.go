@@ -2,12 +2,16 @@ package app
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/SUSE/fissile/model"
+	"github.com/SUSE/fissile/validation"
 	"github.com/SUSE/termui"
 	"github.com/stretchr/testify/assert"
 )
@@ -26,13 +30,13 @@ func TestValidation(t *testing.T) {
 	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
 	f := NewFissileApplication(".", ui)
 
-	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache, "")
 	assert.NoError(err)
 
 	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
 	assert.NoError(err)
 
-	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath, "")
 	assert.NoError(err)
 
 	errs := f.validateManifestAndOpinions(roleManifest, opinions)
@@ -67,6 +71,12 @@ func TestValidation(t *testing.T) {
 		assert.Contains(actual, expected)
 	}
 	assert.Len(errs, len(allExpected))
+
+	// Errors are reported in the same order on each run
+	for i := 0; i < 10; i++ {
+		again := f.validateManifestAndOpinions(roleManifest, opinions)
+		assert.Equal(actual, again.Errors())
+	}
 }
 
 func TestValidationOk(t *testing.T) {
@@ -84,13 +94,13 @@ func TestValidationOk(t *testing.T) {
 
 	f := NewFissileApplication(".", ui)
 
-	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache, "")
 	assert.NoError(err)
 
 	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
 	assert.NoError(err)
 
-	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath, "")
 	assert.NoError(err)
 
 	errs := f.validateManifestAndOpinions(roleManifest, opinions)
@@ -112,13 +122,13 @@ func TestValidationHash(t *testing.T) {
 	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/hashmat-dark.yml")
 	f := NewFissileApplication(".", ui)
 
-	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache)
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache, "")
 	assert.NoError(err)
 
 	roleManifest, err := model.LoadRoleManifest(roleManifestPath, f.releases, f)
 	assert.NoError(err)
 
-	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath)
+	opinions, err := model.NewOpinions(lightManifestPath, darkManifestPath, "")
 	assert.NoError(err)
 
 	errs := f.validateManifestAndOpinions(roleManifest, opinions)
@@ -133,3 +143,176 @@ func TestValidationHash(t *testing.T) {
 	}
 	assert.Len(errs, len(allExpected))
 }
+
+func TestValidateOk(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	validateDir := filepath.Join(workDir, "../test-assets/validate/good")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-dark-opinions.yml")
+
+	f := NewFissileApplication(".", ui)
+
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache, "")
+	assert.NoError(err)
+
+	err = f.Validate(filepath.Join(validateDir, "role-manifest.yml"), lightManifestPath, darkManifestPath, "", "",
+		[]string{filepath.Join(validateDir, "defaults.env")}, false, OutputFormatHuman)
+	assert.NoError(err)
+}
+
+func TestValidateErrors(t *testing.T) {
+	ui := termui.New(&bytes.Buffer{}, ioutil.Discard, nil)
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	validateDir := filepath.Join(workDir, "../test-assets/validate/bad")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-dark-opinions.yml")
+	envFile := filepath.Join(validateDir, "defaults.env")
+
+	f := NewFissileApplication(".", ui)
+
+	err = f.Validate(filepath.Join(validateDir, "role-manifest.yml"), lightManifestPath, darkManifestPath, "", "", nil, false, OutputFormatHuman)
+	if assert.Error(err) {
+		assert.Equal("Releases not loaded", err.Error())
+	}
+
+	err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache, "")
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(validateDir, "role-manifest.yml")
+	err = f.Validate(roleManifestPath, lightManifestPath, darkManifestPath, "", "",
+		[]string{envFile}, false, OutputFormatHuman)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			envFile + `:2:1: variables: Not found: "UNDECLARED" [undeclared-env-variable]`,
+			roleManifestPath + `:21:5: configuration.templates: Invalid value: "properties.tor.hostname": Does not sort before 'properties.tor.hashed_control_password' [template-sorting]`,
+			roleManifestPath + `:10:7: roles[myrole].configuration.templates: Invalid value: "properties.tor.private_key": Does not sort before 'properties.tor.client_keys' [template-sorting]`,
+			filepath.Join(validateDir, "scripts/lib/helper.sh") + `: scripts: Invalid value: "scripts/lib/helper.sh": Not used by any role [unused-script]`,
+			filepath.Join(validateDir, "scripts/unused.sh") + `: scripts: Invalid value: "scripts/unused.sh": Not used by any role [unused-script]`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+
+	err = f.Validate(roleManifestPath, lightManifestPath, darkManifestPath, "", "", nil, false, OutputFormatYAML)
+	if assert.Error(err) {
+		assert.Equal("Invalid output format 'yaml', expected one of human, json, junit, or sarif", err.Error())
+	}
+
+	// An explicit scripts directory replaces the default one
+	err = f.Validate(filepath.Join(validateDir, "role-manifest.yml"), lightManifestPath, darkManifestPath, "",
+		filepath.Join(validateDir, "scripts/lib"), nil, false, OutputFormatHuman)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), `scripts: Invalid value: "scripts/lib/helper.sh": Not used by any role`)
+		assert.NotContains(err.Error(), "scripts/unused.sh")
+	}
+}
+
+func TestValidateReport(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	lightManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-opinions.yml")
+	darkManifestPath := filepath.Join(workDir, "../test-assets/test-opinions/good-dark-opinions.yml")
+
+	for _, dir := range []string{"good", "bad"} {
+		validateDir := filepath.Join(workDir, "../test-assets/validate", dir)
+		output := &bytes.Buffer{}
+		f := NewFissileApplication(".", termui.New(&bytes.Buffer{}, output, nil))
+
+		err = f.LoadReleases([]string{torReleasePath}, []string{""}, []string{""}, torReleasePathBoshCache, "")
+		assert.NoError(err)
+
+		err = f.Validate(filepath.Join(validateDir, "role-manifest.yml"), lightManifestPath, darkManifestPath, "", "",
+			[]string{filepath.Join(validateDir, "defaults.env")}, false, OutputFormatJSON)
+
+		var report struct {
+			Valid  bool
+			Errors []struct {
+				File   string
+				Line   int
+				Column int
+				Field  string
+				Type   string
+			}
+		}
+		if !assert.NoError(json.Unmarshal(output.Bytes(), &report), output.String()) {
+			continue
+		}
+
+		if dir == "good" {
+			assert.NoError(err)
+			assert.True(report.Valid)
+			assert.Empty(report.Errors)
+			continue
+		}
+
+		assert.Equal(ValidationFailedError{Count: 5}, err)
+		assert.False(report.Valid)
+		if assert.Len(report.Errors, 5) {
+			// Paths outside of the working directory stay absolute
+			assert.Equal(filepath.Join(validateDir, "defaults.env"), report.Errors[0].File)
+			assert.Equal(2, report.Errors[0].Line)
+			assert.Equal("FieldValueNotFound", report.Errors[0].Type)
+			assert.Equal(filepath.Join(validateDir, "role-manifest.yml"), report.Errors[1].File)
+			assert.Equal(21, report.Errors[1].Line)
+			assert.Equal(5, report.Errors[1].Column)
+			assert.Equal("configuration.templates", report.Errors[1].Field)
+		}
+	}
+}
+
+func TestValidatorRuleDescriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	// All rules are described as in the rule table of the docs
+	docs, err := ioutil.ReadFile(filepath.Join(workDir, "../docs/validator-description.md"))
+	assert.NoError(err)
+
+	rules := append(append([]string{}, model.Rules...), validatorRules...)
+	assert.Len(validatorRuleDescriptions, len(rules))
+	for _, rule := range rules {
+		if assert.Contains(validatorRuleDescriptions, rule) {
+			assert.Contains(string(docs), fmt.Sprintf("`%s` | %s", rule, validatorRuleDescriptions[rule]))
+		}
+	}
+}
+
+func TestCheckBOSHDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	release := &model.Release{Name: "tor"}
+	pd := propertyDefaults{
+		"tor.hostname": &propertyInfo{defaults: map[string][]*model.Job{
+			"localhost": {{Name: "tor", Release: release}},
+		}},
+		"tor.port": &propertyInfo{defaults: map[string][]*model.Job{
+			"9050": {{Name: "tor", Release: release}, {Name: "proxy", Release: release}},
+			"9051": {{Name: "relay", Release: release}},
+		}},
+	}
+
+	errs := checkBOSHDefaults(pd)
+	if assert.Len(errs, 1) {
+		assert.Equal(`properties.tor.port: Invalid value: "9050 (tor/tor, tor/proxy); 9051 (tor/relay)": Has 2 different defaults`, errs[0].Error())
+		assert.Equal(ruleDifferingBOSHDefaults, errs[0].Rule)
+		assert.Equal(validation.SeverityWarning, errs[0].Severity)
+	}
+}
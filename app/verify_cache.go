@@ -0,0 +1,66 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/SUSE/fissile/compilator"
+	"github.com/SUSE/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// VerifyCompilationCache checks the packages compiled for all stemcells
+// below the compilation directory against their manifests, and fails if any
+// of them does not match. Packages compiled by older versions of fissile
+// have no manifest, and are reported as unverified.
+func (f *Fissile) VerifyCompilationCache(compilationDir string) error {
+	compiledDirs, err := filepath.Glob(filepath.Join(compilationDir, "*", "*", "compiled"))
+	if err != nil {
+		return err
+	}
+
+	statuses, err := compilator.ReadPackageStatuses(compilationDir, "")
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	for _, status := range statuses {
+		names[status.Fingerprint] = fmt.Sprintf("%s/%s", status.Release, status.Package)
+	}
+
+	f.UI.Printf("Verifying %s\n", color.MagentaString(compilationDir))
+
+	var verified, unverified, corrupt int
+	for _, compiledDir := range compiledDirs {
+		pkgDir := filepath.Dir(compiledDir)
+		pkg := &model.Package{Fingerprint: filepath.Base(pkgDir)}
+
+		relpath, err := filepath.Rel(compilationDir, pkgDir)
+		if err != nil {
+			relpath = pkg.Fingerprint
+		}
+		if name, ok := names[pkg.Fingerprint]; ok {
+			relpath = fmt.Sprintf("%s (%s)", relpath, name)
+		}
+
+		hasManifest, err := compilator.VerifyCompiledPackage(pkg, filepath.Dir(pkgDir))
+		switch {
+		case err != nil:
+			corrupt++
+			f.UI.Printf("- %s %s: %s\n", color.RedString("corrupt"), relpath, err.Error())
+		case !hasManifest:
+			unverified++
+			f.UI.Printf("- %s %s: no manifest\n", color.YellowString("unverified"), relpath)
+		default:
+			verified++
+		}
+	}
+
+	f.UI.Printf("%d verified, %d without manifest, %d corrupt\n", verified, unverified, corrupt)
+
+	if corrupt > 0 {
+		return fmt.Errorf("%d compiled packages do not match their manifest; build packages compiles them again", corrupt)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/fissile/compilator"
+	"github.com/SUSE/fissile/model"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCompilationCache(t *testing.T) {
+	assert := assert.New(t)
+
+	compilationDir, err := ioutil.TempDir("", "fissile-verify-cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(compilationDir)
+
+	workDir := filepath.Join(compilationDir, "stemcell")
+	for _, fingerprint := range []string{"good", "corrupt", "legacy"} {
+		pkg := &model.Package{Name: fingerprint, Fingerprint: fingerprint}
+		tempDir := pkg.GetPackageCompiledTempDir(workDir)
+		require.NoError(t, os.MkdirAll(tempDir, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "lib"), []byte(fingerprint), 0644))
+		require.NoError(t, compilator.InstallCompiledPackage(pkg, tempDir, workDir))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(workDir, "corrupt", "compiled", "lib"), []byte("changed"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(workDir, "legacy", "compiled.manifest.json")))
+
+	output := &bytes.Buffer{}
+	f := NewFissileApplication("1.2.3", termui.New(&bytes.Buffer{}, output, nil))
+	err = f.VerifyCompilationCache(compilationDir)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "1 compiled packages do not match their manifest")
+	}
+	assert.Contains(output.String(), "- corrupt stemcell/corrupt: lib: contents changed")
+	assert.Contains(output.String(), "- unverified stemcell/legacy: no manifest")
+	assert.Contains(output.String(), "1 verified, 1 without manifest, 1 corrupt")
+
+	require.NoError(t, os.RemoveAll(filepath.Join(workDir, "corrupt")))
+	assert.NoError(f.VerifyCompilationCache(compilationDir))
+}
@@ -0,0 +1,167 @@
+// Package blobstore implements read access to the blobstores that BOSH
+// releases keep their final job and package archives and their blobs in.
+package blobstore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Blobstore is a store of release blobs, addressed by their blobstore ID
+type Blobstore interface {
+	// Get writes the contents of the blob with the given ID to w
+	Get(id string, w io.Writer) error
+}
+
+// Config is the blobstore section of a release's config/final.yml
+type Config struct {
+	Provider string                 `yaml:"provider"`
+	Options  map[string]interface{} `yaml:"options"`
+}
+
+// releaseConfig describes the parts of config/final.yml and config/private.yml
+// that are relevant to the blobstore
+type releaseConfig struct {
+	Blobstore *Config `yaml:"blobstore"`
+}
+
+// LoadReleaseConfig reads the blobstore configuration of the release source
+// tree at releasePath. Options from config/private.yml (usually credentials)
+// override the ones in config/final.yml. It returns nil if the release has no
+// blobstore configured.
+func LoadReleaseConfig(releasePath string) (*Config, error) {
+	final, err := loadReleaseConfigFile(filepath.Join(releasePath, "config", "final.yml"))
+	if err != nil || final.Blobstore == nil {
+		return nil, err
+	}
+
+	private, err := loadReleaseConfigFile(filepath.Join(releasePath, "config", "private.yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{
+		Provider: final.Blobstore.Provider,
+		Options:  map[string]interface{}{},
+	}
+	for key, value := range final.Blobstore.Options {
+		config.Options[key] = value
+	}
+	if private.Blobstore != nil {
+		for key, value := range private.Blobstore.Options {
+			config.Options[key] = value
+		}
+	}
+
+	return config, nil
+}
+
+func loadReleaseConfigFile(path string) (*releaseConfig, error) {
+	config := &releaseConfig{}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("Error loading blobstore configuration from %s: %s", path, err.Error())
+	}
+
+	return config, nil
+}
+
+// New creates the blobstore described by config. Relative paths of local
+// blobstores are resolved against releasePath.
+func New(config *Config, releasePath string) (Blobstore, error) {
+	switch config.Provider {
+	case "local":
+		return newLocalBlobstore(config, releasePath)
+	case "http":
+		return newHTTPBlobstore(config, false)
+	case "dav":
+		return newHTTPBlobstore(config, true)
+	case "s3":
+		return newS3Blobstore(config)
+	case "":
+		return nil, fmt.Errorf("No blobstore provider configured")
+	}
+
+	return nil, fmt.Errorf("Unsupported blobstore provider %s", config.Provider)
+}
+
+// Fetch downloads the blob with the given ID to destination. The blob is
+// downloaded next to destination first and only moved into place once verify
+// accepts it, so destination never holds a partial or corrupt blob.
+func Fetch(store Blobstore, id, destination string, verify func(path string) error) (err error) {
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(destination), ".download-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tempFile.Close()
+		if err != nil {
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	if err := store.Get(id, tempFile); err != nil {
+		return fmt.Errorf("Error downloading blob %s: %s", id, err.Error())
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	if verify != nil {
+		if err := verify(tempFile.Name()); err != nil {
+			return fmt.Errorf("Error verifying blob %s: %s", id, err.Error())
+		}
+	}
+
+	return os.Rename(tempFile.Name(), destination)
+}
+
+// stringOption returns the value of a string option, or the empty string if it
+// is not set
+func stringOption(config *Config, name string) (string, error) {
+	value, ok := config.Options[name]
+	if !ok || value == nil {
+		return "", nil
+	}
+
+	result, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("Blobstore option %s was not a string, type: %T, value: %v", name, value, value)
+	}
+
+	return result, nil
+}
+
+// boolOption returns the value of a boolean option, or defaultValue if it is
+// not set
+func boolOption(config *Config, name string, defaultValue bool) (bool, error) {
+	value, ok := config.Options[name]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("Blobstore option %s was not a boolean, type: %T, value: %v", name, value, value)
+	}
+
+	return result, nil
+}
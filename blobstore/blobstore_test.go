@@ -0,0 +1,234 @@
+package blobstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadReleaseConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	releasePath, err := ioutil.TempDir("", "fissile-blobstore")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(releasePath)
+
+	// A release without a configuration has no blobstore
+	config, err := LoadReleaseConfig(releasePath)
+	assert.NoError(err)
+	assert.Nil(config)
+
+	configDir := filepath.Join(releasePath, "config")
+	assert.NoError(os.MkdirAll(configDir, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(configDir, "final.yml"), []byte(`---
+final_name: test
+blobstore:
+  provider: s3
+  options:
+    bucket_name: test-bucket
+    access_key_id: public
+`), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(configDir, "private.yml"), []byte(`---
+blobstore:
+  options:
+    access_key_id: private-key
+    secret_access_key: private-secret
+`), 0644))
+
+	config, err = LoadReleaseConfig(releasePath)
+	if assert.NoError(err) && assert.NotNil(config) {
+		assert.Equal("s3", config.Provider)
+		assert.Equal(map[string]interface{}{
+			"bucket_name":       "test-bucket",
+			"access_key_id":     "private-key",
+			"secret_access_key": "private-secret",
+		}, config.Options)
+	}
+}
+
+func TestNewUnsupportedProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(&Config{Provider: "gcs"}, "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Unsupported blobstore provider gcs")
+	}
+
+	_, err = New(&Config{Provider: "s3", Options: map[string]interface{}{"bucket_name": 3}}, "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "bucket_name was not a string")
+	}
+}
+
+func TestLocalBlobstore(t *testing.T) {
+	assert := assert.New(t)
+
+	releasePath, err := ioutil.TempDir("", "fissile-blobstore")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(releasePath)
+
+	assert.NoError(os.MkdirAll(filepath.Join(releasePath, "store"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(releasePath, "store", "some-id"), []byte("contents"), 0644))
+
+	// Relative paths are relative to the release
+	store, err := New(&Config{Provider: "local", Options: map[string]interface{}{"blobstore_path": "store"}}, releasePath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(store.Get("some-id", &buffer))
+	assert.Equal("contents", buffer.String())
+
+	assert.Error(store.Get("other-id", &buffer))
+}
+
+func TestHTTPBlobstore(t *testing.T) {
+	assert := assert.New(t)
+
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		fmt.Fprintf(w, "blob %s", filepath.Base(r.URL.Path))
+	}))
+	defer server.Close()
+
+	options := map[string]interface{}{
+		"endpoint": server.URL + "/base",
+		"user":     "user",
+		"password": "secret",
+	}
+
+	store, err := New(&Config{Provider: "http", Options: options}, "")
+	if !assert.NoError(err) {
+		return
+	}
+	var buffer bytes.Buffer
+	assert.NoError(store.Get("some-id", &buffer))
+	assert.Equal("blob some-id", buffer.String())
+
+	// WebDAV blobstores put each blob into a directory named after the first
+	// byte of the SHA1 of its ID
+	store, err = New(&Config{Provider: "dav", Options: options}, "")
+	if !assert.NoError(err) {
+		return
+	}
+	buffer.Reset()
+	assert.NoError(store.Get("some-id", &buffer))
+	assert.Equal("blob some-id", buffer.String())
+
+	assert.Equal([]string{"/base/some-id", "/base/95/some-id"}, paths)
+
+	options["password"] = "wrong"
+	store, err = New(&Config{Provider: "http", Options: options}, "")
+	if assert.NoError(err) {
+		err = store.Get("some-id", &buffer)
+		if assert.Error(err) {
+			assert.Contains(err.Error(), "401 Unauthorized")
+		}
+	}
+}
+
+func TestS3Blobstore(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		fmt.Fprintf(w, "blob %s", filepath.Base(r.URL.Path))
+	}))
+	defer server.Close()
+
+	options := map[string]interface{}{
+		"bucket_name": "test-bucket",
+		"folder":      "blobs",
+		"host":        strings.TrimPrefix(server.URL, "http://"),
+		"use_ssl":     false,
+		"region":      "eu-west-1",
+	}
+
+	// Without credentials, buckets are accessed anonymously
+	store, err := New(&Config{Provider: "s3", Options: options}, "")
+	if !assert.NoError(err) {
+		return
+	}
+	var buffer bytes.Buffer
+	assert.NoError(store.Get("some-id", &buffer))
+	assert.Equal("blob some-id", buffer.String())
+
+	options["access_key_id"] = "key"
+	options["secret_access_key"] = "secret"
+	store, err = New(&Config{Provider: "s3", Options: options}, "")
+	if !assert.NoError(err) {
+		return
+	}
+	buffer.Reset()
+	assert.NoError(store.Get("some-id", &buffer))
+	assert.Equal("blob some-id", buffer.String())
+
+	if assert.Len(requests, 2) {
+		assert.Equal("/test-bucket/blobs/some-id", requests[0].URL.Path)
+		assert.Empty(requests[0].Header.Get("Authorization"))
+
+		assert.Equal("/test-bucket/blobs/some-id", requests[1].URL.Path)
+		authorization := requests[1].Header.Get("Authorization")
+		assert.True(strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=key/"), authorization)
+		assert.Contains(authorization, "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	assert := assert.New(t)
+
+	tempDir, err := ioutil.TempDir("", "fissile-blobstore")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(tempDir, "some-id"), []byte("contents"), 0644))
+	store, err := New(&Config{Provider: "local", Options: map[string]interface{}{"blobstore_path": tempDir}}, "")
+	if !assert.NoError(err) {
+		return
+	}
+
+	destination := filepath.Join(tempDir, "cache", "blob")
+
+	err = Fetch(store, "some-id", destination, func(path string) error {
+		return fmt.Errorf("rejected")
+	})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Error verifying blob some-id: rejected")
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(destination))
+	assert.NoError(err)
+	assert.Empty(entries, "Rejected blobs must be removed")
+
+	err = Fetch(store, "some-id", destination, func(path string) error {
+		contents, err := ioutil.ReadFile(path)
+		assert.NoError(err)
+		assert.Equal("contents", string(contents))
+		return nil
+	})
+	assert.NoError(err)
+	contents, err := ioutil.ReadFile(destination)
+	assert.NoError(err)
+	assert.Equal("contents", string(contents))
+}
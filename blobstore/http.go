@@ -0,0 +1,93 @@
+package blobstore
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// httpBlobstore fetches blobs from a web server. WebDAV blobstores, as set up
+// by BOSH, spread the blobs across directories named after the first byte of
+// the SHA1 of the blob ID.
+type httpBlobstore struct {
+	endpoint string
+	user     string
+	password string
+	prefixed bool
+	client   *http.Client
+}
+
+func newHTTPBlobstore(config *Config, prefixed bool) (*httpBlobstore, error) {
+	store := &httpBlobstore{
+		prefixed: prefixed,
+		client:   http.DefaultClient,
+	}
+
+	var err error
+	if store.endpoint, err = stringOption(config, "endpoint"); err != nil {
+		return nil, err
+	}
+	if store.user, err = stringOption(config, "user"); err != nil {
+		return nil, err
+	}
+	if store.password, err = stringOption(config, "password"); err != nil {
+		return nil, err
+	}
+
+	if store.endpoint == "" {
+		return nil, fmt.Errorf("%s blobstore has no endpoint", config.Provider)
+	}
+	if _, err := url.Parse(store.endpoint); err != nil {
+		return nil, fmt.Errorf("Invalid blobstore endpoint %s: %s", store.endpoint, err.Error())
+	}
+
+	return store, nil
+}
+
+func (b *httpBlobstore) blobURL(id string) string {
+	blobURL, _ := url.Parse(b.endpoint)
+
+	if b.prefixed {
+		blobURL.Path = path.Join(blobURL.Path, fmt.Sprintf("%02x", sha1.Sum([]byte(id))[0]), id)
+	} else {
+		blobURL.Path = path.Join(blobURL.Path, id)
+	}
+
+	return blobURL.String()
+}
+
+// Get implements Blobstore
+func (b *httpBlobstore) Get(id string, w io.Writer) error {
+	request, err := http.NewRequest("GET", b.blobURL(id), nil)
+	if err != nil {
+		return err
+	}
+	if b.user != "" {
+		request.SetBasicAuth(b.user, b.password)
+	}
+
+	return doGet(b.client, request, w)
+}
+
+// doGet performs a GET request and copies the response body to w
+func doGet(client *http.Client, request *http.Request, w io.Writer) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		// Keep a bit of the body around; error responses usually explain themselves
+		body := make([]byte, 512)
+		n, _ := io.ReadFull(response.Body, body)
+		return fmt.Errorf("GET %s: %s %s", request.URL.String(), response.Status, strings.TrimSpace(string(body[:n])))
+	}
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
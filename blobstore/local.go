@@ -0,0 +1,41 @@
+package blobstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// localBlobstore keeps blobs as files in a directory
+type localBlobstore struct {
+	path string
+}
+
+func newLocalBlobstore(config *Config, releasePath string) (*localBlobstore, error) {
+	path, err := stringOption(config, "blobstore_path")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("Local blobstore has no blobstore_path")
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(releasePath, path)
+	}
+
+	return &localBlobstore{path: path}, nil
+}
+
+// Get implements Blobstore
+func (b *localBlobstore) Get(id string, w io.Writer) error {
+	blob, err := os.Open(filepath.Join(b.path, filepath.Base(id)))
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	_, err = io.Copy(w, blob)
+	return err
+}
@@ -0,0 +1,133 @@
+package blobstore
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SUSE/fissile/util"
+)
+
+const s3DefaultRegion = "us-east-1"
+
+// s3Blobstore fetches blobs from an S3 bucket, or from any other service with
+// an S3 compatible API. Buckets are addressed path-style, and requests are
+// signed with AWS signature version 4 unless the bucket is accessed
+// anonymously.
+type s3Blobstore struct {
+	endpoint        *url.URL
+	bucket          string
+	folder          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	client          *http.Client
+	now             func() time.Time
+}
+
+func newS3Blobstore(config *Config) (*s3Blobstore, error) {
+	store := &s3Blobstore{
+		client: http.DefaultClient,
+		now:    time.Now,
+	}
+
+	var host, port, credentialsSource string
+	for name, target := range map[string]*string{
+		"bucket_name":        &store.bucket,
+		"folder":             &store.folder,
+		"region":             &store.region,
+		"host":               &host,
+		"access_key_id":      &store.accessKeyID,
+		"secret_access_key":  &store.secretAccessKey,
+		"credentials_source": &credentialsSource,
+	} {
+		value, err := stringOption(config, name)
+		if err != nil {
+			return nil, err
+		}
+		*target = value
+	}
+
+	if value, ok := config.Options["port"]; ok && value != nil {
+		port = fmt.Sprintf("%v", value)
+	}
+
+	useSSL, err := boolOption(config, "use_ssl", true)
+	if err != nil {
+		return nil, err
+	}
+
+	if store.bucket == "" {
+		return nil, fmt.Errorf("S3 blobstore has no bucket_name")
+	}
+
+	if store.region == "" {
+		store.region = s3DefaultRegion
+	}
+
+	if host == "" {
+		if store.region == s3DefaultRegion {
+			host = "s3.amazonaws.com"
+		} else {
+			host = fmt.Sprintf("s3.%s.amazonaws.com", store.region)
+		}
+	}
+	if port != "" {
+		host = host + ":" + port
+	}
+
+	scheme := "https"
+	if !useSSL {
+		scheme = "http"
+	}
+	store.endpoint = &url.URL{Scheme: scheme, Host: host}
+
+	switch credentialsSource {
+	case "", "static":
+		// Use the keys from the configuration; without keys, access is anonymous
+	case "none":
+		store.accessKeyID = ""
+		store.secretAccessKey = ""
+	case "env_or_profile":
+		store.accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		store.secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		store.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	default:
+		return nil, fmt.Errorf("Unsupported S3 credentials_source %s", credentialsSource)
+	}
+
+	return store, nil
+}
+
+func (b *s3Blobstore) blobURL(id string) *url.URL {
+	segments := []string{b.bucket}
+	if b.folder != "" {
+		segments = append(segments, strings.Trim(b.folder, "/"))
+	}
+	segments = append(segments, id)
+
+	blobURL := *b.endpoint
+	blobURL.Path = "/" + strings.Join(segments, "/")
+
+	return &blobURL
+}
+
+// Get implements Blobstore
+func (b *s3Blobstore) Get(id string, w io.Writer) error {
+	request, err := http.NewRequest("GET", b.blobURL(id).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if b.accessKeyID != "" {
+		request.Header.Set("X-Amz-Content-Sha256", util.S3EmptyPayloadHash)
+		util.SignS3Request(request, b.accessKeyID, b.secretAccessKey, b.sessionToken, b.region, b.now())
+	}
+
+	return doGet(b.client, request, w)
+}
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"html/template"
@@ -34,7 +35,9 @@ type PackagesImageBuilder struct {
 // baseImageOverride is used for tests; if not set, we use the correct one
 var baseImageOverride string
 
-// NewPackagesImageBuilder creates a new PackagesImageBuilder
+// NewPackagesImageBuilder creates a new PackagesImageBuilder. The compiled
+// packages are taken from compiledPackagesPath, the directory of the packages
+// compiled on the stemcell, see compilator.FindStemcellCompilationDir.
 func NewPackagesImageBuilder(repository, stemcellImageName, stemcellImageID, compiledPackagesPath, targetPath, fissileVersion string, ui *termui.UI) (*PackagesImageBuilder, error) {
 	if err := os.MkdirAll(targetPath, 0755); err != nil {
 		return nil, err
@@ -57,27 +60,25 @@ func NewPackagesImageBuilder(repository, stemcellImageName, stemcellImageID, com
 		stemcellImageID = stemcellImage.ID
 	}
 
-	hasher := sha1.New()
-	if _, err := hasher.Write([]byte(stemcellImageName)); err != nil {
-		return nil, err
-	}
-
 	return &PackagesImageBuilder{
 		repository:           repository,
 		stemcellImageID:      stemcellImageID,
 		stemcellImageName:    stemcellImageName,
-		compiledPackagesPath: filepath.Join(compiledPackagesPath, hex.EncodeToString(hasher.Sum(nil))),
+		compiledPackagesPath: compiledPackagesPath,
 		targetPath:           targetPath,
 		fissileVersion:       fissileVersion,
 		ui:                   ui,
 	}, nil
 }
 
-// tarWalker is a helper to copy files into a tar stream
+// tarWalker is a helper to copy files into a tar stream. With a manifest,
+// the files copied are checked against it, see verify.
 type tarWalker struct {
-	stream *tar.Writer // The stream to copy the files into
-	root   string      // The base directory on disk where the walking started
-	prefix string      // The prefix in the tar file the names should have
+	stream   *tar.Writer       // The stream to copy the files into
+	root     string            // The base directory on disk where the walking started
+	prefix   string            // The prefix in the tar file the names should have
+	manifest *util.DirManifest // The expected contents of the directory, if known
+	actual   util.DirManifest  // The contents copied so far
 }
 
 func (w *tarWalker) walk(path string, info os.FileInfo, err error) error {
@@ -109,6 +110,15 @@ func (w *tarWalker) walk(path string, info os.FileInfo, err error) error {
 	}
 
 	if !info.Mode().IsRegular() {
+		if w.manifest != nil && relPath != "." {
+			entry, err := util.NewDirManifestEntry(relPath, path, info)
+			if err != nil {
+				return err
+			}
+			if entry != nil {
+				w.actual.Entries = append(w.actual.Entries, *entry)
+			}
+		}
 		return nil
 	}
 
@@ -118,8 +128,30 @@ func (w *tarWalker) walk(path string, info os.FileInfo, err error) error {
 	}
 	defer file.Close()
 
-	_, err = io.CopyN(w.stream, file, info.Size())
-	return err
+	// Hash the contents as they are copied, rather than reading the
+	// files twice
+	hasher := sha256.New()
+	_, err = io.CopyN(w.stream, io.TeeReader(file, hasher), info.Size())
+	if err != nil || w.manifest == nil {
+		return err
+	}
+	w.actual.Entries = append(w.actual.Entries, util.DirManifestEntry{
+		Path:   filepath.ToSlash(relPath),
+		Type:   util.DirManifestFile,
+		Mode:   fmt.Sprintf("%04o", info.Mode().Perm()),
+		Size:   info.Size(),
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+	})
+	return nil
+}
+
+// verify checks the files copied against the manifest, once the walk is
+// complete
+func (w *tarWalker) verify() error {
+	if w.manifest == nil {
+		return nil
+	}
+	return util.DirManifestError(w.manifest.Diff(&w.actual))
 }
 
 func (p *PackagesImageBuilder) fissileVersionLabel() string {
@@ -229,15 +261,27 @@ func (p *PackagesImageBuilder) NewDockerPopulator(roles model.Roles, labels map[
 		}
 
 		// Actually insert the packages into the tar stream
+		// Compiled packages are checked against their manifest, if they
+		// have one, so that corrupt packages do not end up in images
 		for _, pkg := range packages {
+			manifest, err := util.ReadDirManifest(pkg.GetPackageCompiledManifestPath(p.compiledPackagesPath))
+			if os.IsNotExist(err) {
+				manifest = nil
+			} else if err != nil {
+				return err
+			}
 			walker := &tarWalker{
-				stream: tarWriter,
-				root:   pkg.GetPackageCompiledDir(p.compiledPackagesPath),
-				prefix: filepath.Join("packages-src", pkg.Fingerprint),
+				stream:   tarWriter,
+				root:     pkg.GetPackageCompiledDir(p.compiledPackagesPath),
+				prefix:   filepath.Join("packages-src", pkg.Fingerprint),
+				manifest: manifest,
 			}
 			if err = filepath.Walk(walker.root, walker.walk); err != nil {
 				return err
 			}
+			if err = walker.verify(); err != nil {
+				return fmt.Errorf("Compiled package %s/%s does not match its manifest, run build packages to compile it again: %s", pkg.Release.Name, pkg.Name, err.Error())
+			}
 		}
 
 		return nil
@@ -289,7 +333,7 @@ func (p *PackagesImageBuilder) GetPackagesLayerImageName(roleManifest *model.Rol
 	hasher := sha1.New()
 	hasher.Write([]byte(fmt.Sprintf("%s:%s", p.fissileVersion, p.stemcellImageID)))
 	for _, pkg := range pkgs {
-		hasher.Write([]byte(strings.Join([]string{"", pkg.Fingerprint, pkg.Name, pkg.SHA1}, "\000")))
+		hasher.Write([]byte(strings.Join([]string{"", pkg.Fingerprint, pkg.Name, pkg.StrongestDigest()}, "\000")))
 	}
 
 	imageName := util.SanitizeDockerName(fmt.Sprintf("%s-role-packages", p.repository))
@@ -299,7 +343,7 @@ func (p *PackagesImageBuilder) GetPackagesLayerImageName(roleManifest *model.Rol
 	if grapher != nil {
 		grapher.GraphNode(result, map[string]string{"label": "pkglayer/" + result})
 		for _, pkg := range pkgs {
-			grapher.GraphEdge(pkg.Fingerprint, result, map[string]string{"label": fmt.Sprintf("pkg/%s:%s", pkg.Name, pkg.SHA1)})
+			grapher.GraphEdge(pkg.Fingerprint, result, map[string]string{"label": fmt.Sprintf("pkg/%s:%s", pkg.Name, pkg.StrongestDigest())})
 		}
 	}
 
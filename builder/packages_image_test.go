@@ -14,6 +14,7 @@ import (
 
 	"github.com/SUSE/fissile/docker"
 	"github.com/SUSE/fissile/model"
+	"github.com/SUSE/fissile/util"
 
 	"github.com/SUSE/termui"
 	"github.com/stretchr/testify/assert"
@@ -63,7 +64,7 @@ func TestGenerateDockerfile(t *testing.T) {
 	workDir, err := os.Getwd()
 	assert.NoError(err)
 
-	compiledPackagesDir := filepath.Join(workDir, "../test-assets/tor-boshrelease-fake-compiled")
+	compiledPackagesDir := filepath.Join(workDir, "../test-assets/tor-boshrelease-fake-compiled/4d51b43d077ed5a7b7ae4fb200aeb216b7736a96")
 	targetPath, err := ioutil.TempDir("", "fissile-test")
 	assert.NoError(err)
 	defer os.RemoveAll(targetPath)
@@ -105,7 +106,7 @@ func TestNewDockerPopulator(t *testing.T) {
 	releasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
 	releasePathCache := filepath.Join(releasePath, "bosh-cache")
 
-	compiledPackagesDir := filepath.Join(workDir, "../test-assets/tor-boshrelease-fake-compiled")
+	compiledPackagesDir := filepath.Join(workDir, "../test-assets/tor-boshrelease-fake-compiled/4d51b43d077ed5a7b7ae4fb200aeb216b7736a96")
 	targetPath, err := ioutil.TempDir("", "fissile-test")
 	assert.NoError(err)
 	defer os.RemoveAll(targetPath)
@@ -204,6 +205,55 @@ func TestNewDockerPopulator(t *testing.T) {
 	assert.Empty(testFunctions, "Missing files in tar stream")
 }
 
+func TestTarWalkerManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	compiledDir, err := ioutil.TempDir("", "fissile-tar-walker")
+	require.NoError(t, err)
+	defer os.RemoveAll(compiledDir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(compiledDir, "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(compiledDir, "bin", "tor"), []byte("tor"), 0755))
+	require.NoError(t, os.Symlink("bin/tor", filepath.Join(compiledDir, "tor")))
+	manifest, err := util.NewDirManifest(compiledDir)
+	require.NoError(t, err)
+
+	walk := func() (*bytes.Buffer, error) {
+		tarFile := &bytes.Buffer{}
+		walker := &tarWalker{
+			stream:   tar.NewWriter(tarFile),
+			root:     compiledDir,
+			prefix:   "packages-src/tor",
+			manifest: manifest,
+		}
+		if err := filepath.Walk(walker.root, walker.walk); err != nil {
+			return nil, err
+		}
+		return tarFile, walker.verify()
+	}
+
+	tarFile, err := walk()
+	assert.NoError(err)
+	if tarFile != nil {
+		var names []string
+		tarReader := tar.NewReader(tarFile)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF || !assert.NoError(err) {
+				break
+			}
+			names = append(names, header.Name)
+		}
+		assert.Equal([]string{"packages-src/tor", "packages-src/tor/bin", "packages-src/tor/bin/tor", "packages-src/tor/tor"}, names)
+	}
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(compiledDir, "bin", "tor"), []byte("rot"), 0755))
+	_, err = walk()
+	if assert.Error(err) {
+		assert.Equal("bin/tor: contents changed", err.Error())
+	}
+}
+
 func setHash(hash map[string]interface{}, value interface{}, keys ...string) {
 	var child map[interface{}]interface{}
 	for i, k := range keys {
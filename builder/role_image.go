@@ -3,6 +3,7 @@ package builder
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -31,13 +32,17 @@ const (
 var (
 	// newDockerImageBuilder is a stub to be replaced by the unit test
 	newDockerImageBuilder = func() (dockerImageBuilder, error) { return docker.NewImageManager() }
+
+	// errRoleBuildAborted is the result of role builds not started because
+	// another one failed
+	errRoleBuildAborted = fmt.Errorf("Role build aborted")
 )
 
 // dockerImageBuilder is the interface to shim around docker.RoleImageBuilder for the unit test
 type dockerImageBuilder interface {
 	HasImage(imageName string) (bool, error)
-	BuildImage(dockerfileDirPath, name string, stdoutProcessor io.WriteCloser) error
-	BuildImageFromCallback(name string, stdoutWriter io.Writer, callback func(*tar.Writer) error) error
+	BuildImage(ctx context.Context, dockerfileDirPath, name string, stdoutProcessor io.WriteCloser) error
+	BuildImageFromCallback(ctx context.Context, name string, stdoutWriter io.Writer, callback func(*tar.Writer) error) error
 }
 
 // RoleImageBuilder represents a builder of docker role images
@@ -50,12 +55,13 @@ type RoleImageBuilder struct {
 	fissileVersion       string
 	lightOpinionsPath    string
 	darkOpinionsPath     string
+	opinionsVarsPath     string
 	ui                   *termui.UI
 	grapher              util.ModelGrapher
 }
 
 // NewRoleImageBuilder creates a new RoleImageBuilder
-func NewRoleImageBuilder(repository, compiledPackagesPath, targetPath, lightOpinionsPath, darkOpinionsPath, metricsPath, tagExtra, fissileVersion string, ui *termui.UI, grapher util.ModelGrapher) (*RoleImageBuilder, error) {
+func NewRoleImageBuilder(repository, compiledPackagesPath, targetPath, lightOpinionsPath, darkOpinionsPath, opinionsVarsPath, metricsPath, tagExtra, fissileVersion string, ui *termui.UI, grapher util.ModelGrapher) (*RoleImageBuilder, error) {
 	if err := os.MkdirAll(targetPath, 0755); err != nil {
 		return nil, err
 	}
@@ -69,6 +75,7 @@ func NewRoleImageBuilder(repository, compiledPackagesPath, targetPath, lightOpin
 		tagExtra:             tagExtra,
 		lightOpinionsPath:    lightOpinionsPath,
 		darkOpinionsPath:     darkOpinionsPath,
+		opinionsVarsPath:     opinionsVarsPath,
 		ui:                   ui,
 		grapher:              grapher,
 	}, nil
@@ -162,7 +169,7 @@ func (r *RoleImageBuilder) NewDockerPopulator(role *model.Role, baseImageName st
 			})
 
 			// Write spec into <ROOT_DIR>/var/vcap/job-src/<JOB>/config_spec.json
-			configJSON, err := roleJob.WriteConfigs(role, r.lightOpinionsPath, r.darkOpinionsPath)
+			configJSON, err := roleJob.WriteConfigs(role, r.lightOpinionsPath, r.darkOpinionsPath, r.opinionsVarsPath)
 			if err != nil {
 				return err
 			}
@@ -337,6 +344,7 @@ func (r *RoleImageBuilder) generateDockerfile(role *model.Role, baseImageName st
 }
 
 type roleBuildJob struct {
+	ctx             context.Context
 	role            *model.Role
 	builder         *RoleImageBuilder
 	ui              *termui.UI
@@ -354,15 +362,20 @@ type roleBuildJob struct {
 }
 
 func (j roleBuildJob) Run() {
+	if err := j.ctx.Err(); err != nil {
+		j.resultsCh <- err
+		return
+	}
+
 	select {
 	case <-j.abort:
-		j.resultsCh <- nil
+		j.resultsCh <- errRoleBuildAborted
 		return
 	default:
 	}
 
 	j.resultsCh <- func() error {
-		opinions, err := model.NewOpinions(j.builder.lightOpinionsPath, j.builder.darkOpinionsPath)
+		opinions, err := model.NewOpinions(j.builder.lightOpinionsPath, j.builder.darkOpinionsPath, j.builder.opinionsVarsPath)
 		if err != nil {
 			return err
 		}
@@ -434,7 +447,10 @@ func (j roleBuildJob) Run() {
 				docker.ColoredBuildStringFunc(roleImageName),
 			)
 
-			err := j.dockerManager.BuildImageFromCallback(roleImageName, stdoutWriter, dockerPopulator)
+			err := j.dockerManager.BuildImageFromCallback(j.ctx, roleImageName, stdoutWriter, dockerPopulator)
+			if j.ctx.Err() != nil {
+				return j.ctx.Err()
+			}
 			if err != nil {
 				log.WriteTo(j.ui)
 				return fmt.Errorf("Error building image: %s", err.Error())
@@ -457,13 +473,22 @@ func (j roleBuildJob) Run() {
 			if err != nil {
 				return fmt.Errorf("Failed to close tar file %s: %s", outputPath, err)
 			}
+
+			if j.ctx.Err() != nil {
+				// Do not leave a tarball behind which a later run would skip
+				tarFile.Close()
+				os.Remove(outputPath)
+				return j.ctx.Err()
+			}
 		}
 		return nil
 	}()
 }
 
-// BuildRoleImages triggers the building of the role docker images in parallel
-func (r *RoleImageBuilder) BuildRoleImages(roles model.Roles, registry, organization, repository, baseImageName, outputDirectory string, force, noBuild bool, workerCount int) error {
+// BuildRoleImages triggers the building of the role docker images in parallel.
+// Cancelling the context stops the builds in progress, and no others are
+// started.
+func (r *RoleImageBuilder) BuildRoleImages(ctx context.Context, roles model.Roles, registry, organization, repository, baseImageName, outputDirectory string, force, noBuild bool, workerCount int) error {
 	if workerCount < 1 {
 		return fmt.Errorf("Invalid worker count %d", workerCount)
 	}
@@ -486,6 +511,7 @@ func (r *RoleImageBuilder) BuildRoleImages(roles model.Roles, registry, organiza
 	abort := make(chan struct{})
 	for _, role := range roles {
 		worker.Add(roleBuildJob{
+			ctx:             ctx,
 			role:            role,
 			builder:         r,
 			ui:              r.ui,
@@ -506,15 +532,25 @@ func (r *RoleImageBuilder) BuildRoleImages(roles model.Roles, registry, organiza
 	go worker.RunUntilDone()
 
 	aborted := false
+	notBuilt := 0
 	for i := 0; i < len(roles); i++ {
 		result := <-resultsCh
-		if result != nil {
-			if !aborted {
-				close(abort)
-				aborted = true
-			}
-			err = result
+		if result == nil {
+			continue
+		}
+		notBuilt++
+		if result == errRoleBuildAborted {
+			continue
 		}
+		if !aborted {
+			close(abort)
+			aborted = true
+		}
+		err = result
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("Building role images was interrupted, %d of %d roles were not built", notBuilt, len(roles))
 	}
 
 	return err
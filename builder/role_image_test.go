@@ -3,6 +3,7 @@ package builder
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -49,7 +50,7 @@ func TestGenerateRoleImageDockerfile(t *testing.T) {
 	torOpinionsDir := filepath.Join(workDir, "../test-assets/tor-opinions")
 	lightOpinionsPath := filepath.Join(torOpinionsDir, "opinions.yml")
 	darkOpinionsPath := filepath.Join(torOpinionsDir, "dark-opinions.yml")
-	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "deadbeef", "6.28.30", ui, nil)
+	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "", "deadbeef", "6.28.30", ui, nil)
 	assert.NoError(err)
 
 	var dockerfileContents bytes.Buffer
@@ -102,7 +103,7 @@ func TestGenerateRoleImageRunScript(t *testing.T) {
 	lightOpinionsPath := filepath.Join(torOpinionsDir, "opinions.yml")
 	darkOpinionsPath := filepath.Join(torOpinionsDir, "dark-opinions.yml")
 
-	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "deadbeef", "6.28.30", ui, nil)
+	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "", "deadbeef", "6.28.30", ui, nil)
 	assert.NoError(err)
 
 	runScriptContents, err := roleImageBuilder.generateRunScript(roleManifest.Roles[0], "run.sh")
@@ -173,7 +174,7 @@ func TestGenerateRoleImageJobsConfig(t *testing.T) {
 	torOpinionsDir := filepath.Join(workDir, "../test-assets/tor-opinions")
 	lightOpinionsPath := filepath.Join(torOpinionsDir, "opinions.yml")
 	darkOpinionsPath := filepath.Join(torOpinionsDir, "dark-opinions.yml")
-	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "deadbeef", "6.28.30", ui, nil)
+	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "", "deadbeef", "6.28.30", ui, nil)
 	assert.NoError(err)
 
 	jobsConfigContents, err := roleImageBuilder.generateJobsConfig(roleManifest.Roles[0])
@@ -223,7 +224,7 @@ func TestGenerateRoleImageDockerfileDir(t *testing.T) {
 	lightOpinionsPath := filepath.Join(torOpinionsDir, "opinions.yml")
 	darkOpinionsPath := filepath.Join(torOpinionsDir, "dark-opinions.yml")
 
-	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "deadbeef", "6.28.30", ui, nil)
+	roleImageBuilder, err := NewRoleImageBuilder("foo", compiledPackagesDir, targetPath, lightOpinionsPath, darkOpinionsPath, "", "", "deadbeef", "6.28.30", ui, nil)
 	assert.NoError(err)
 
 	torPkg := getPackage(roleManifest.Roles, "myrole", "tor", "tor")
@@ -368,15 +369,17 @@ type buildImageCallback func(name string) error
 type mockDockerImageBuilder struct {
 	callback buildImageCallback
 	hasImage bool
-	tarBytes map[string]*bytes.Buffer
-	mutex    sync.Mutex
+	// hasImageCallback, if set, replaces hasImage
+	hasImageCallback func(name string) (bool, error)
+	tarBytes         map[string]*bytes.Buffer
+	mutex            sync.Mutex
 }
 
-func (m *mockDockerImageBuilder) BuildImage(dockerDirPath, name string, stdoutProcessor io.WriteCloser) error {
+func (m *mockDockerImageBuilder) BuildImage(ctx context.Context, dockerDirPath, name string, stdoutProcessor io.WriteCloser) error {
 	return m.callback(name)
 }
 
-func (m *mockDockerImageBuilder) BuildImageFromCallback(name string, stdoutProcessor io.Writer, populator func(*tar.Writer) error) error {
+func (m *mockDockerImageBuilder) BuildImageFromCallback(ctx context.Context, name string, stdoutProcessor io.Writer, populator func(*tar.Writer) error) error {
 	if err := m.callback(name); err != nil {
 		return err
 	}
@@ -394,6 +397,9 @@ func (m *mockDockerImageBuilder) BuildImageFromCallback(name string, stdoutProce
 }
 
 func (m *mockDockerImageBuilder) HasImage(imageName string) (bool, error) {
+	if m.hasImageCallback != nil {
+		return m.hasImageCallback(imageName)
+	}
 	return m.hasImage, nil
 }
 
@@ -445,6 +451,7 @@ func TestBuildRoleImages(t *testing.T) {
 		lightOpinionsPath,
 		darkOpinionsPath,
 		"",
+		"",
 		"deadbeef",
 		"6.28.30",
 		ui,
@@ -468,6 +475,7 @@ func TestBuildRoleImages(t *testing.T) {
 	}
 
 	err = roleImageBuilder.BuildRoleImages(
+		context.Background(),
 		roleManifest.Roles,
 		"test-registry.com:9000",
 		"test-organization",
@@ -490,6 +498,7 @@ func TestBuildRoleImages(t *testing.T) {
 
 	// Should not allow invalid worker counts
 	err = roleImageBuilder.BuildRoleImages(
+		context.Background(),
 		roleManifest.Roles,
 		"test-registry.com:9000",
 		"test-organization",
@@ -518,6 +527,7 @@ func TestBuildRoleImages(t *testing.T) {
 	}
 
 	err = roleImageBuilder.BuildRoleImages(
+		context.Background(),
 		roleManifest.Roles,
 		"test-registry.com:9000",
 		"test-organization",
@@ -544,6 +554,7 @@ func TestBuildRoleImages(t *testing.T) {
 		return nil
 	}
 	err = roleImageBuilder.BuildRoleImages(
+		context.Background(),
 		roleManifest.Roles,
 		"test-registry.com:9000",
 		"test-organization",
@@ -557,6 +568,56 @@ func TestBuildRoleImages(t *testing.T) {
 	assert.NoError(err)
 	assert.Empty(buildersRan, "should not have ran any builders")
 
+	// Check that cancelling stops the build in progress, and starts no others
+	mockBuilder.hasImage = false
+	ctx, cancel := context.WithCancel(context.Background())
+	mockBuilder.callback = func(name string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		buildersRan = append(buildersRan, name)
+		cancel()
+		return nil
+	}
+	err = roleImageBuilder.BuildRoleImages(
+		ctx,
+		roleManifest.Roles,
+		"test-registry.com:9000",
+		"test-organization",
+		"test-repository",
+		"",
+		"",
+		false,
+		false,
+		1,
+	)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "interrupted, 2 of 2 roles were not built")
+	}
+	assert.Len(buildersRan, 1, "No build should start once cancelled")
+
+	// Roles failing otherwise while interrupted are not built either
+	ctx, cancel = context.WithCancel(context.Background())
+	mockBuilder.hasImageCallback = func(name string) (bool, error) {
+		cancel()
+		return false, fmt.Errorf("Deliberate failure")
+	}
+	err = roleImageBuilder.BuildRoleImages(
+		ctx,
+		roleManifest.Roles,
+		"test-registry.com:9000",
+		"test-organization",
+		"test-repository",
+		"",
+		"",
+		false,
+		false,
+		1,
+	)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "interrupted, 2 of 2 roles were not built")
+	}
+	mockBuilder.hasImageCallback = nil
+
 	// Check that we write timestamps to the metrics file
 	file, err := ioutil.TempFile("", "metrics")
 	assert.NoError(err)
@@ -578,6 +639,7 @@ func TestBuildRoleImages(t *testing.T) {
 		return nil
 	}
 	err = roleImageBuilder.BuildRoleImages(
+		context.Background(),
 		roleManifest.Roles,
 		"test-registry.com:9000",
 		"test-organization",
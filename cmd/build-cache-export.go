@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// buildCacheExportCmd represents the cache export command
+var buildCacheExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports compiled BOSH packages into a bundle.",
+	Long: `
+This command writes the packages of the BOSH releases referenced by your role
+manifest, which are compiled on the given stemcell, into a bundle for
+"build cache import". The bundle is a tar file holding a ` + "`manifest.json`" + ` with
+the stemcell, the fissile version, and the fingerprint and SHA256 digest of each
+package, followed by a tar.gz archive per package.
+
+Packages which are not compiled are skipped.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		flagBuildCacheExportBundle := buildCacheExportViper.GetString("bundle")
+		flagBuildCacheExportRoles := buildCacheExportViper.GetString("roles")
+		flagBuildCacheExportOnlyReleases := buildCacheExportViper.GetString("only-releases")
+		flagBuildCacheExportStemcell := buildCacheExportViper.GetString("stemcell")
+
+		if flagBuildCacheExportBundle == "" {
+			return fmt.Errorf("--bundle is required")
+		}
+
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+			workPathReleasesDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ExportCompilationCache(
+			flagBuildCacheExportBundle,
+			workPathCompilationDir,
+			flagBuildCacheExportStemcell,
+			flagRoleManifest,
+			strings.FieldsFunc(flagBuildCacheExportRoles, func(r rune) bool { return r == ',' }),
+			strings.FieldsFunc(flagBuildCacheExportOnlyReleases, func(r rune) bool { return r == ',' }),
+		)
+	},
+}
+
+var buildCacheExportViper = viper.New()
+
+func init() {
+	initViper(buildCacheExportViper)
+
+	buildCacheCmd.AddCommand(buildCacheExportCmd)
+
+	buildCacheExportCmd.PersistentFlags().StringP(
+		"bundle",
+		"b",
+		"",
+		"Path of the bundle to write",
+	)
+
+	// viper is busted w/ string slice, https://github.com/spf13/viper/issues/200
+	buildCacheExportCmd.PersistentFlags().StringP(
+		"roles",
+		"",
+		"",
+		"Export only packages for the given role names; comma separated.",
+	)
+
+	buildCacheExportCmd.PersistentFlags().StringP(
+		"only-releases",
+		"",
+		"",
+		"Export only packages for the given release names; comma separated.",
+	)
+
+	buildCacheExportCmd.PersistentFlags().StringP(
+		"stemcell",
+		"s",
+		"",
+		"The source stemcell the packages were compiled on",
+	)
+
+	buildCacheExportViper.BindPFlags(buildCacheExportCmd.PersistentFlags())
+}
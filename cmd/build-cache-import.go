@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// buildCacheImportCmd represents the cache import command
+var buildCacheImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports a bundle of compiled BOSH packages.",
+	Long: `
+This command merges a bundle written by "build cache export" into the compilation
+cache, below ` + "`<work-dir>/compilation`" + `, for the stemcell the bundle was exported
+for. The whole bundle is checked against its manifest before anything is merged.
+
+Packages which are compiled already, at the same time as the bundled ones or
+later, are kept. With ` + "`--stemcell`" + `, the bundle must have been exported for that
+stemcell. No releases are needed to import a bundle.
+`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Importing does not need any releases, so only the basic flags
+		// are checked.
+		return validateBasicFlags()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		flagBuildCacheImportBundle := buildCacheImportViper.GetString("bundle")
+		flagBuildCacheImportStemcell := buildCacheImportViper.GetString("stemcell")
+
+		if flagBuildCacheImportBundle == "" {
+			return fmt.Errorf("--bundle is required")
+		}
+
+		return fissile.ImportCompilationCache(
+			flagBuildCacheImportBundle,
+			workPathCompilationDir,
+			flagBuildCacheImportStemcell,
+		)
+	},
+}
+
+var buildCacheImportViper = viper.New()
+
+func init() {
+	initViper(buildCacheImportViper)
+
+	buildCacheCmd.AddCommand(buildCacheImportCmd)
+
+	buildCacheImportCmd.PersistentFlags().StringP(
+		"bundle",
+		"b",
+		"",
+		"Path of the bundle to import",
+	)
+
+	buildCacheImportCmd.PersistentFlags().StringP(
+		"stemcell",
+		"s",
+		"",
+		"The source stemcell the bundle must have been exported for",
+	)
+
+	buildCacheImportViper.BindPFlags(buildCacheImportCmd.PersistentFlags())
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// buildCacheCmd represents the cache command
+var buildCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Has subcommands to move the compilation cache between hosts.",
+	Long: `
+This command has subcommands to export compiled packages from the compilation
+cache populated by "build packages" into a bundle, and to import such bundles on
+other hosts, e.g. build hosts without network access.
+`,
+}
+
+func init() {
+	buildCmd.AddCommand(buildCacheCmd)
+}
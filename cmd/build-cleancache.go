@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/docker/go-units"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // buildCleanCacheCmd represents the cleancache command
@@ -10,23 +14,112 @@ var buildCleanCacheCmd = &cobra.Command{
 	Short: "Removes unused BOSH packages from the compilation cache.",
 	Long: `
 This command will inspect the compilation cache populated by its sibling "packages"
-and remove all which are not required anymore.`,
-	RunE: func(cmd *cobra.Command, args []string) error {
+and remove all which are not required anymore.
 
-		err := fissile.LoadReleases(
-			flagRelease,
-			flagReleaseName,
-			flagReleaseVersion,
-			flagCacheDir,
-		)
-		if err != nil {
+Without retention options, all packages which are not part of the given releases
+are removed. With retention options, releases are optional, and their packages
+are always kept:
+
+- ` + "`--keep-days N`" + ` keeps the packages used within the last N days.
+- ` + "`--keep-versions K`" + ` keeps the K last used packages of each name, per stemcell.
+- ` + "`--max-size SIZE`" + ` (e.g. ` + "`20g`" + `) removes the least recently used packages until the
+  cache is no larger than SIZE.
+
+Packages not kept by ` + "`--keep-days`" + ` or ` + "`--keep-versions`" + `, when given, are removed
+before ` + "`--max-size`" + ` applies. The use of packages by "build packages" is tracked in
+` + "`access.json`" + ` of each stemcell compilation directory; packages compiled by older
+versions of fissile count as used when they were compiled.
+
+With ` + "`--dry-run`" + `, the packages which would be removed are listed, with the space
+they would free, but kept.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateBasicFlags(); err != nil {
 			return err
 		}
 
-		return fissile.CleanCache(workPathCompilationDir)
+		// Releases are only required to know what to keep when no
+		// retention option is given
+		if len(flagRelease) == 0 &&
+			(buildCleanCacheViper.GetInt("keep-days") > 0 ||
+				buildCleanCacheViper.GetInt("keep-versions") > 0 ||
+				buildCleanCacheViper.GetString("max-size") != "") {
+			return nil
+		}
+
+		return validateReleaseArgs()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagBuildCleanCacheKeepDays := buildCleanCacheViper.GetInt("keep-days")
+		flagBuildCleanCacheKeepVersions := buildCleanCacheViper.GetInt("keep-versions")
+		flagBuildCleanCacheMaxSize := buildCleanCacheViper.GetString("max-size")
+		flagBuildCleanCacheDryRun := buildCleanCacheViper.GetBool("dry-run")
+
+		var maxSize int64
+		if flagBuildCleanCacheMaxSize != "" {
+			var err error
+			maxSize, err = units.RAMInBytes(flagBuildCleanCacheMaxSize)
+			if err != nil || maxSize <= 0 {
+				return fmt.Errorf("Invalid --max-size %q, expected a size such as 500m or 20g", flagBuildCleanCacheMaxSize)
+			}
+		}
+
+		if len(flagRelease) > 0 {
+			err := fissile.LoadReleases(
+				flagRelease,
+				flagReleaseName,
+				flagReleaseVersion,
+				flagCacheDir,
+				workPathReleasesDir,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return fissile.CleanCache(
+			workPathCompilationDir,
+			flagBuildCleanCacheKeepDays,
+			flagBuildCleanCacheKeepVersions,
+			maxSize,
+			flagBuildCleanCacheDryRun,
+		)
 	},
 }
 
+var buildCleanCacheViper = viper.New()
+
 func init() {
+	initViper(buildCleanCacheViper)
+
 	buildCmd.AddCommand(buildCleanCacheCmd)
+
+	buildCleanCacheCmd.PersistentFlags().IntP(
+		"keep-days",
+		"",
+		0,
+		"Keep the packages used within this many days",
+	)
+
+	buildCleanCacheCmd.PersistentFlags().IntP(
+		"keep-versions",
+		"",
+		0,
+		"Keep this many last used packages of each name, per stemcell",
+	)
+
+	buildCleanCacheCmd.PersistentFlags().StringP(
+		"max-size",
+		"",
+		"",
+		"Remove the least recently used packages until the cache is no larger than this size (e.g. 20g)",
+	)
+
+	buildCleanCacheCmd.PersistentFlags().BoolP(
+		"dry-run",
+		"",
+		false,
+		"List the packages which would be removed, and the space freed, without removing them",
+	)
+
+	buildCleanCacheViper.BindPFlags(buildCleanCacheCmd.PersistentFlags())
 }
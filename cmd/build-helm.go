@@ -37,6 +37,7 @@ var buildHelmCmd = &cobra.Command{
 			flagReleaseName,
 			flagReleaseVersion,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 		if err != nil {
 			return err
@@ -45,6 +46,7 @@ var buildHelmCmd = &cobra.Command{
 		opinions, err := model.NewOpinions(
 			flagLightOpinions,
 			flagDarkOpinions,
+			flagOpinionsVars,
 		)
 		if err != nil {
 			return err
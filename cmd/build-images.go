@@ -15,10 +15,11 @@ var (
 	flagPatchPropertiesDirective string
 	flagOutputDirectory          string
 
-	flagBuildImagesStemcell   string
-	flagBuildImagesStemcellID string
-	flagBuildImagesTagExtra   string
-	flagLabels                []string
+	flagBuildImagesStemcell        string
+	flagBuildImagesStemcellID      string
+	flagBuildImagesStemcellVersion string
+	flagBuildImagesTagExtra        string
+	flagLabels                     []string
 )
 
 // buildImagesCmd represents the images command
@@ -41,8 +42,18 @@ The images will be tagged: ` + "`<repository>-<role_name>:<SIGNATURE>`" + `.
 The SIGNATURE is based on the hashes of all jobs and packages that are included in
 the image.
 
+The compiled packages are taken from ` + "`<work-dir>/compilation`" + `, from the packages
+compiled on the stemcell image, as identified by its image ID. Packages compiled
+with ` + "`--without-docker`" + ` on a host without the image have no image ID recorded;
+they are used when there are none compiled on the image ID itself. Without docker,
+or without the image, the packages compiled last for the image name, and for the
+BOSH stemcell given by ` + "`--stemcell-version`" + `, are used.
+
 The ` + "`--patch-properties-release`" + ` flag is used to distinguish the patchProperties release/job spec
 from other specs.  At most one is allowed.
+
+Interrupting the command, with Ctrl-C or SIGTERM, stops the image builds in
+progress and starts no others. Interrupt it a second time to exit right away.
 	`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
@@ -53,6 +64,7 @@ from other specs.  At most one is allowed.
 		flagOutputDirectory = buildImagesViper.GetString("output-directory")
 		flagBuildImagesStemcell = buildImagesViper.GetString("stemcell")
 		flagBuildImagesStemcellID = buildImagesViper.GetString("stemcell-id")
+		flagBuildImagesStemcellVersion = buildImagesViper.GetString("stemcell-version")
 		flagBuildImagesTagExtra = buildImagesViper.GetString("tag-extra")
 		flagBuildOutputGraph = buildViper.GetString("output-graph")
 		flagLabels = buildImagesViper.GetStringSlice("add-label")
@@ -62,6 +74,7 @@ from other specs.  At most one is allowed.
 			flagReleaseName,
 			flagReleaseVersion,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 		if err != nil {
 			return err
@@ -91,13 +104,18 @@ from other specs.  At most one is allowed.
 			labels[parts[0]] = parts[1]
 		}
 
+		ctx, stop := interruptibleContext()
+		defer stop()
+
 		return fissile.GenerateRoleImages(
+			ctx,
 			workPathDockerDir,
 			flagDockerRegistry,
 			flagDockerOrganization,
 			flagRepository,
 			flagBuildImagesStemcell,
 			flagBuildImagesStemcellID,
+			flagBuildImagesStemcellVersion,
 			flagMetrics,
 			flagBuildImagesNoBuild,
 			flagBuildImagesForce,
@@ -108,6 +126,7 @@ from other specs.  At most one is allowed.
 			workPathCompilationDir,
 			flagLightOpinions,
 			flagDarkOpinions,
+			flagOpinionsVars,
 			flagOutputDirectory,
 			labels,
 		)
@@ -170,6 +189,13 @@ func init() {
 		"Docker image ID for the stemcell (intended for CI)",
 	)
 
+	buildImagesCmd.PersistentFlags().StringP(
+		"stemcell-version",
+		"",
+		"",
+		"The BOSH stemcell (<os>/<version>) the source stemcell is built from, as given to \"build packages\"",
+	)
+
 	buildImagesCmd.PersistentFlags().StringP(
 		"tag-extra",
 		"",
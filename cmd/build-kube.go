@@ -35,6 +35,7 @@ var buildKubeCmd = &cobra.Command{
 			flagReleaseName,
 			flagReleaseVersion,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 		if err != nil {
 			return err
@@ -43,6 +44,7 @@ var buildKubeCmd = &cobra.Command{
 		opinions, err := model.NewOpinions(
 			flagLightOpinions,
 			flagDarkOpinions,
+			flagOpinionsVars,
 		)
 		if err != nil {
 			return err
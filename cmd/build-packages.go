@@ -1,11 +1,10 @@
 package cmd
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
-	"path/filepath"
 	"strings"
 
+	"github.com/SUSE/fissile/app"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -28,6 +27,79 @@ Compiled packages are stored in ` + "`<work-dir>/compilation`" + `. Fissile uses
 package's fingerprint as part of the directory structure. This means that if the
 same package (with the same version) is used by multiple releases, it will only be
 compiled once.
+
+Next to each compiled package, ` + "`stemcell.json`" + ` records the stemcell it was compiled
+on: the image name, the image ID and the BOSH stemcell given by
+` + "`--stemcell-version`" + `. Packages compiled on each stemcell are kept in a directory of
+their own, keyed by the image ID and BOSH stemcell, so retagging the stemcell
+image compiles packages next to the ones of the old image, instead of replacing
+them. Packages compiled on another image ID or stemcell are compiled again. The
+package cache keys packages by image ID and BOSH stemcell as well.
+
+Packages compiled by older versions of fissile, which did not record the
+stemcell, are compiled again, as they can not be told apart from packages
+compiled on another image of the same name. With ` + "`--reuse-unrecorded-packages`" + `,
+they are used instead, and the stemcell is recorded for them; only set it when
+the stemcell image was not retagged since they were compiled.
+
+Compiled releases (release tarballs with ` + "`compiled_packages`" + `) are used as they
+are when they were compiled for the stemcell given by ` + "`--stemcell-version`" + `.
+
+Packages are queued for the workers after their dependencies, the ones at the
+head of the longest chain of packages still to compile first. The length of a
+chain is estimated from the compile durations recorded by earlier runs in the
+` + "`status.json`" + ` of each package, or from the number of packages in it when there
+are none.
+
+With ` + "`--package-cache`" + `, compiled packages are shared between builds, e.g. on
+different machines. Packages found in the cache for the same fingerprint and
+stemcell are used instead of compiling them, and packages compiled are pushed to
+the cache, unless ` + "`--package-cache-read-only`" + ` is set. The cache is a directory,
+or a ` + "`file://`" + `, ` + "`http(s)://`" + ` or ` + "`s3://<bucket>/<prefix>`" + ` URL. HTTP servers have to
+support GET and PUT requests; credentials in the URL are used for basic
+authentication. S3 URLs accept ` + "`region`" + ` and ` + "`endpoint`" + ` query parameters for S3
+compatible stores, and take their credentials from ` + "`AWS_ACCESS_KEY_ID`" + ` and
+` + "`AWS_SECRET_ACCESS_KEY`" + `. Downloaded packages are verified against their SHA256
+digest before they are used. The digest is stored in the cache next to the
+package, so it detects damaged downloads, but not packages replaced by anyone
+who can write to the cache; only use caches whose writers are trusted.
+
+By default, compilation stops at the first package failing to compile. With
+` + "`--keep-going`" + `, all packages whose dependencies compiled successfully are compiled
+regardless, and the failures are reported at the end. The output of each package
+is written to ` + "`compile.log`" + `, and its outcome to ` + "`status.json`" + `, next to its compiled
+directory; "show compilation" lists them. Compiled packages are kept, so running
+the command again resumes with the packages which failed or were skipped.
+
+With ` + "`--plan`" + `, nothing is compiled. Instead, the command prints the packages which
+are compiled already, and the packages it would compile, in the order they are
+queued in, with the depth of their dependencies. The plan is printed as text, or
+as JSON, YAML or a graphviz DOT graph with ` + "`--output json|yaml|dot`" + `. This shows the
+effect of release updates, ` + "`--roles`" + ` and ` + "`--only-releases`" + ` before compiling.
+
+Each compiled package has a manifest listing its files, with their modes and
+SHA-256 digests, in ` + "`compiled.manifest.json`" + ` next to its compiled directory. Packages
+which do not match their manifest are compiled again, and are not added to
+images by "build images". With ` + "`--verify-cache`" + `, nothing is compiled. Instead, the
+command checks all compiled packages, for all stemcells, against their
+manifests, and fails if any of them does not match.
+
+With ` + "`--stemcell-rootfs`" + `, packages are compiled without docker or root
+privileges. The root filesystem of the stemcell image is unpacked from the
+archive, written by "docker save" or as an OCI image layout tarball, into the
+compilation directory, once for all packages and again only once the archive
+changes. Each stemcell image has its root filesystem of its own, so archives of
+different images with the same name do not replace each other. Each package is
+then compiled in a chroot of it, in new user and mount namespaces, with its
+sources and its compiled directory mounted as with docker.
+The compiling user is root in the chroot, mapped to the invoking user, so
+packages which need other users can not be compiled this way.
+
+Interrupting the command, with Ctrl-C or SIGTERM, stops the compilation: the
+compilation containers are killed and removed with their volumes, partial
+results are discarded, and the command exits with a summary of the packages
+compiled. Running it again resumes from there. Interrupt it a second time to
+exit right away, without cleaning up.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
@@ -35,14 +107,28 @@ compiled once.
 		flagBuildPackagesOnlyReleases := buildPackagesViper.GetString("only-releases")
 		flagBuildPackagesWithoutDocker := buildPackagesViper.GetBool("without-docker")
 		flagBuildPackagesDockerNetworkMode := buildPackagesViper.GetString("docker-network-mode")
+		flagBuildPackagesStemcellRootfs := buildPackagesViper.GetString("stemcell-rootfs")
 		flagBuildPackagesStemcell := buildPackagesViper.GetString("stemcell")
+		flagBuildPackagesStemcellVersion := buildPackagesViper.GetString("stemcell-version")
+		flagBuildPackagesPackageCache := buildPackagesViper.GetString("package-cache")
+		flagBuildPackagesPackageCacheReadOnly := buildPackagesViper.GetBool("package-cache-read-only")
+		flagBuildPackagesKeepGoing := buildPackagesViper.GetBool("keep-going")
+		flagBuildPackagesPlan := buildPackagesViper.GetBool("plan")
+		flagBuildPackagesVerifyCache := buildPackagesViper.GetBool("verify-cache")
+		flagBuildPackagesReuseUnrecordedPackages := buildPackagesViper.GetBool("reuse-unrecorded-packages")
 		flagBuildOutputGraph = buildViper.GetString("output-graph")
 
+		// Verifying the cache does not depend on the releases
+		if flagBuildPackagesVerifyCache {
+			return fissile.VerifyCompilationCache(workPathCompilationDir)
+		}
+
 		err := fissile.LoadReleases(
 			flagRelease,
 			flagReleaseName,
 			flagReleaseVersion,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 		if err != nil {
 			return err
@@ -58,24 +144,40 @@ compiled once.
 			}()
 		}
 
-		hasher := sha1.New()
-		if _, err := hasher.Write([]byte(flagBuildPackagesStemcell)); err != nil {
-			return err
+		if flagBuildPackagesPlan {
+			return fissile.PlanCompilation(
+				flagBuildPackagesStemcell,
+				flagBuildPackagesStemcellVersion,
+				workPathCompilationDir,
+				flagRoleManifest,
+				strings.FieldsFunc(flagBuildPackagesRoles, func(r rune) bool { return r == ',' }),
+				strings.FieldsFunc(flagBuildPackagesOnlyReleases, func(r rune) bool { return r == ',' }),
+				flagWorkers,
+				app.OutputFormat(flagOutputFormat),
+			)
 		}
-		compilationDir := filepath.Join(workPathCompilationDir, hex.EncodeToString(hasher.Sum(nil)))
-
-		return fissile.Compile(
-			flagBuildPackagesStemcell,
-			compilationDir,
-			flagRoleManifest,
-			flagMetrics,
-			strings.FieldsFunc(flagBuildPackagesRoles, func(r rune) bool { return r == ',' }),
-			strings.FieldsFunc(flagBuildPackagesOnlyReleases, func(r rune) bool { return r == ',' }),
-			flagWorkers,
-			flagBuildPackagesDockerNetworkMode,
-			flagBuildPackagesWithoutDocker,
-			flagVerbose,
-		)
+
+		ctx, stop := interruptibleContext()
+		defer stop()
+
+		return fissile.Compile(ctx, app.CompileOptions{
+			StemcellImageName:       flagBuildPackagesStemcell,
+			Stemcell:                flagBuildPackagesStemcellVersion,
+			CompilationDir:          workPathCompilationDir,
+			RoleManifestPath:        flagRoleManifest,
+			MetricsPath:             flagMetrics,
+			RoleNames:               strings.FieldsFunc(flagBuildPackagesRoles, func(r rune) bool { return r == ',' }),
+			ReleaseNames:            strings.FieldsFunc(flagBuildPackagesOnlyReleases, func(r rune) bool { return r == ',' }),
+			WorkerCount:             flagWorkers,
+			DockerNetworkMode:       flagBuildPackagesDockerNetworkMode,
+			StemcellRootfs:          flagBuildPackagesStemcellRootfs,
+			WithoutDocker:           flagBuildPackagesWithoutDocker,
+			Verbose:                 flagVerbose,
+			PackageCacheLocation:    flagBuildPackagesPackageCache,
+			PackageCacheReadOnly:    flagBuildPackagesPackageCacheReadOnly,
+			KeepGoing:               flagBuildPackagesKeepGoing,
+			ReuseUnrecordedPackages: flagBuildPackagesReuseUnrecordedPackages,
+		})
 	},
 }
 
@@ -108,6 +210,13 @@ func init() {
 		"Build without docker; this may adversely affect your system.  Only supported on Linux, and requires CAP_SYS_ADMIN.",
 	)
 
+	buildPackagesCmd.PersistentFlags().StringP(
+		"stemcell-rootfs",
+		"",
+		"",
+		"Build without docker, in a chroot of the root filesystem of the stemcell image from this archive, written by \"docker save\" or as an OCI image layout tarball.  Only supported on Linux, and requires unprivileged user namespaces.",
+	)
+
 	buildPackagesCmd.PersistentFlags().StringP(
 		"docker-network-mode",
 		"",
@@ -122,5 +231,54 @@ func init() {
 		"The source stemcell",
 	)
 
+	buildPackagesCmd.PersistentFlags().StringP(
+		"stemcell-version",
+		"",
+		"",
+		"The BOSH stemcell (<os>/<version>) the source stemcell is built from; packages of compiled releases for it are used instead of compiling them",
+	)
+
+	buildPackagesCmd.PersistentFlags().StringP(
+		"package-cache",
+		"",
+		"",
+		"Share compiled packages through the cache at this directory, or file, http(s) or s3 URL",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"package-cache-read-only",
+		"",
+		false,
+		"Only pull packages from the package cache, never push compiled packages to it",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"keep-going",
+		"k",
+		false,
+		"Compile all packages whose dependencies compiled successfully, instead of stopping at the first failure",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"plan",
+		"",
+		false,
+		"Print the packages which would be compiled, and in which order, without compiling them",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"verify-cache",
+		"",
+		false,
+		"Check all compiled packages against their manifests, without compiling anything",
+	)
+
+	buildPackagesCmd.PersistentFlags().BoolP(
+		"reuse-unrecorded-packages",
+		"",
+		false,
+		"Use packages compiled without recording the stemcell, by older versions of fissile, instead of compiling them again",
+	)
+
 	buildPackagesViper.BindPFlags(buildPackagesCmd.PersistentFlags())
 }
@@ -17,6 +17,7 @@ which added, and which had their default values changed).
 		return fissile.DiffConfigurationBases(
 			flagRelease,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 	},
 }
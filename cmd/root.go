@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 
+	"github.com/SUSE/termui/sigint"
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
@@ -32,12 +37,14 @@ var (
 	flagWorkers            int
 	flagLightOpinions      string
 	flagDarkOpinions       string
+	flagOpinionsVars       string
 	flagOutputFormat       string
 	flagMetrics            string
 	flagVerbose            bool
 
 	// workPath* variables contain paths derived from flagWorkDir
 	workPathCompilationDir string
+	workPathReleasesDir    string
 	workPathConfigDir      string
 	workPathBaseDockerfile string
 	workPathDockerDir      string
@@ -88,7 +95,7 @@ func init() {
 		"role-manifest",
 		"m",
 		"",
-		"Path to a yaml file that details which jobs are used for each role.",
+		"Path to a yaml file that details which jobs are used for each role, optionally followed by comma separated ops files to apply to it.",
 	)
 
 	// We can't use slices here because of https://github.com/spf13/viper/issues/112
@@ -96,7 +103,7 @@ func init() {
 		"release",
 		"r",
 		"",
-		"Path to final or dev BOSH release(s).",
+		"Path to final or dev BOSH release(s), to final release tarballs, or to release source trees without dev releases.",
 	)
 
 	// We can't use slices here because of https://github.com/spf13/viper/issues/112
@@ -175,14 +182,21 @@ func init() {
 		"light-opinions",
 		"l",
 		"",
-		"Path to a BOSH deployment manifest file that contains properties to be used as defaults.",
+		"Path to a BOSH deployment manifest file that contains properties to be used as defaults, optionally followed by comma separated further files to merge and ops files to apply.",
 	)
 
 	RootCmd.PersistentFlags().StringP(
 		"dark-opinions",
 		"d",
 		"",
-		"Path to a BOSH deployment manifest file that contains properties that should not have opinionated defaults.",
+		"Path to a BOSH deployment manifest file that contains properties that should not have opinionated defaults, optionally followed by comma separated further files to merge and ops files to apply.",
+	)
+
+	RootCmd.PersistentFlags().StringP(
+		"opinions-vars",
+		"",
+		"",
+		"Path to a yaml file with values for the ((variables)) used in the light and dark opinions.",
 	)
 
 	RootCmd.PersistentFlags().StringP(
@@ -196,7 +210,7 @@ func init() {
 		"output",
 		"o",
 		app.OutputFormatHuman,
-		"Choose output format, one of human, json, or yaml (currently only for 'show properties')",
+		"Choose output format, one of human, json, or yaml (for 'show' commands), human, json, junit, or sarif (for 'validate'), or human, json, yaml, or dot (for 'build packages --plan')",
 	)
 
 	RootCmd.PersistentFlags().BoolP(
@@ -243,6 +257,7 @@ func extendPathsFromWorkDirectory() {
 
 	// Initialize paths that are always relative to flagWorkDir
 	workPathCompilationDir = filepath.Join(workDir, "compilation")
+	workPathReleasesDir = filepath.Join(workDir, "releases")
 	workPathConfigDir = filepath.Join(workDir, "config")
 	workPathBaseDockerfile = filepath.Join(workDir, "base_dockerfile")
 	workPathDockerDir = filepath.Join(workDir, "dockerfiles")
@@ -278,6 +293,7 @@ func validateBasicFlags() error {
 	flagWorkers = viper.GetInt("workers")
 	flagLightOpinions = viper.GetString("light-opinions")
 	flagDarkOpinions = viper.GetString("dark-opinions")
+	flagOpinionsVars = viper.GetString("opinions-vars")
 	flagOutputFormat = viper.GetString("output")
 	flagMetrics = viper.GetString("metrics")
 	flagVerbose = viper.GetBool("verbose")
@@ -289,13 +305,11 @@ func validateBasicFlags() error {
 	}
 
 	if err = absolutePaths(
-		&flagRoleManifest,
 		&flagCacheDir,
 		&flagWorkDir,
-		&flagLightOpinions,
-		&flagDarkOpinions,
 		&flagMetrics,
 		&workPathCompilationDir,
+		&workPathReleasesDir,
 		&workPathConfigDir,
 		&workPathBaseDockerfile,
 		&workPathDockerDir,
@@ -303,6 +317,21 @@ func validateBasicFlags() error {
 		return err
 	}
 
+	// The role manifest and opinions may be followed by more files to apply
+	for _, paths := range []*string{&flagRoleManifest, &flagLightOpinions, &flagDarkOpinions} {
+		absPaths, err := absolutePathsForArray(splitNonEmpty(*paths, ","))
+		if err != nil {
+			return err
+		}
+		*paths = strings.Join(absPaths, ",")
+	}
+
+	if flagOpinionsVars != "" {
+		if err = absolutePaths(&flagOpinionsVars); err != nil {
+			return err
+		}
+	}
+
 	if flagRelease, err = absolutePathsForArray(flagRelease); err != nil {
 		return err
 	}
@@ -387,3 +416,55 @@ func splitNonEmpty(value string, separator string) []string {
 	}
 	return r
 }
+
+// interruptibleContext returns a context which is cancelled on the first
+// SIGINT or SIGTERM, so that the builds using it stop and clean up after
+// themselves. A second signal exits right away. The returned function stops
+// handling the signals this way: SIGINT goes to the exit handler of termui
+// again, and SIGTERM gets its default handling.
+func interruptibleContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Take over from the exit handler of termui, which would exit on the
+	// first SIGINT. Its subscription can not be restored later, see
+	// restoreInterruptHandler.
+	signal.Reset(os.Interrupt)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fissile.UI.Println(color.YellowString("Interrupted, stopping and cleaning up. Interrupt again to exit right away."))
+		cancel()
+
+		select {
+		case <-signals:
+			sigint.DefaultHandler.Exit(sigint.SigInt)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+		restoreInterruptHandler()
+	}
+}
+
+// restoreInterruptHandler hands SIGINT to the exit handler of termui again,
+// after interruptibleContext took it over, so that it runs its callbacks and
+// exits with its exit code
+func restoreInterruptHandler() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		sigint.DefaultHandler.Exit(sigint.SigInt)
+	}()
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"github.com/SUSE/fissile/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// showCompilationCmd represents the compilation command
+var showCompilationCmd = &cobra.Command{
+	Use:   "compilation",
+	Short: "Displays the status of the packages compiled by build packages.",
+	Long: `
+Displays the outcome of the last attempt to compile each package in the
+compilation cache below ` + "`<work-dir>/compilation`" + `: whether it failed, was skipped
+as a dependency failed, was pulled from the package cache, or compiled. Failed
+and skipped packages are listed first, together with the path of their
+compilation log.
+
+Only the packages of the given stemcell are listed if ` + "`--stemcell`" + ` is set. No
+releases are needed to show the compilation status.
+`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Showing the status does not need any releases, so only the
+		// basic flags are checked.
+		return validateBasicFlags()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flagShowCompilationStemcell := showCompilationViper.GetString("stemcell")
+
+		return fissile.ShowCompilation(
+			workPathCompilationDir,
+			flagShowCompilationStemcell,
+			app.OutputFormat(flagOutputFormat),
+		)
+	},
+}
+
+var showCompilationViper = viper.New()
+
+func init() {
+	initViper(showCompilationViper)
+
+	showCmd.AddCommand(showCompilationCmd)
+
+	showCompilationCmd.PersistentFlags().StringP(
+		"stemcell",
+		"s",
+		"",
+		"Show only the packages compiled on this source stemcell",
+	)
+
+	showCompilationViper.BindPFlags(showCompilationCmd.PersistentFlags())
+}
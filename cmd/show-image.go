@@ -32,6 +32,7 @@ This command is useful in conjunction with docker (e.g. ` + "`docker rmi $(fissi
 			flagReleaseName,
 			flagReleaseVersion,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 		if err != nil {
 			return err
@@ -44,6 +45,7 @@ This command is useful in conjunction with docker (e.g. ` + "`docker rmi $(fissi
 			flagRoleManifest,
 			flagLightOpinions,
 			flagDarkOpinions,
+			flagOpinionsVars,
 			flagShowImageDockerOnly,
 			flagShowImageWithSizes,
 			flagShowImageTagExtra,
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"github.com/SUSE/fissile/app"
+
+	"github.com/spf13/cobra"
+)
+
+// showLinksCmd represents the links command
+var showLinksCmd = &cobra.Command{
+	Use:   "links",
+	Short: "Displays the resolved BOSH links of the role manifest.",
+	Long: `
+Displays every BOSH link consumed by the jobs in the role manifest, together
+with the role and job providing it and the service through which the provider
+is reached. Providers get a service when they are clustered or indexed, or have
+exposed ports; the service is left empty otherwise.
+
+Consumers are matched to providers by name, or by type when the deployment has
+only one provider of the type. When there are several, the provider to use is
+taken from ` + "`configuration.links.preferred`" + ` of the consuming role, or
+of the role manifest.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+			workPathReleasesDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.ListLinks(flagRoleManifest, app.OutputFormat(flagOutputFormat))
+	},
+}
+
+func init() {
+	showCmd.AddCommand(showLinksCmd)
+}
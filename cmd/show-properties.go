@@ -4,6 +4,7 @@ import (
 	"github.com/SUSE/fissile/app"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // showPropertiesCmd represents the properties command
@@ -12,7 +13,12 @@ var showPropertiesCmd = &cobra.Command{
 	Short: "Displays information about BOSH properties, per jobs.",
 	Long: `
 Displays a report of all properties of all the jobs in the referenced releases.
-The report lists the properties per job per release, with their default value.
+The report lists the properties per job per release, with their default value,
+and with their example value and type where the job spec has them.
+
+With ` + "`--output json|yaml`" + `, the report maps each property to its default value.
+With ` + "`--with-examples`" + `, it maps each property to its ` + "`default`" + `, ` + "`example`" + ` and
+` + "`type`" + ` instead.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Show property information
@@ -22,15 +28,32 @@ The report lists the properties per job per release, with their default value.
 			flagReleaseName,
 			flagReleaseVersion,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 		if err != nil {
 			return err
 		}
 
-		return fissile.ListProperties(app.OutputFormat(flagOutputFormat))
+		return fissile.ListProperties(
+			app.OutputFormat(flagOutputFormat),
+			showPropertiesViper.GetBool("with-examples"),
+		)
 	},
 }
 
+var showPropertiesViper = viper.New()
+
 func init() {
+	initViper(showPropertiesViper)
+
 	showCmd.AddCommand(showPropertiesCmd)
+
+	showPropertiesCmd.PersistentFlags().BoolP(
+		"with-examples",
+		"",
+		false,
+		"Map properties to their default, example and type in JSON and YAML output, instead of to their default",
+	)
+
+	showPropertiesViper.BindPFlags(showPropertiesCmd.PersistentFlags())
 }
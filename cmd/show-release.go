@@ -17,6 +17,7 @@ var showReleaseCmd = &cobra.Command{
 	Long: `
 Displays a report of all jobs and packages in all referenced releases.
 The report contains the name, version, description and counts of jobs and packages.
+Packages of compiled releases are marked with the stemcell they were compiled for.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Show job information
@@ -26,6 +27,7 @@ The report contains the name, version, description and counts of jobs and packag
 			flagReleaseName,
 			flagReleaseVersion,
 			flagCacheDir,
+			workPathReleasesDir,
 		)
 		if err != nil {
 			return err
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"github.com/SUSE/fissile/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagValidateDefaultEnvFiles []string
+	flagValidateScriptsDir      string
+	flagValidateStrict          bool
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks the role manifest and opinions for consistency.",
+	Long: `
+Runs all checks of the role manifest validator against the releases, the role
+manifest, the light and dark opinions and the env files with the defaults for
+the variables, without compiling packages or building any images. Docker is not
+needed.
+
+The command fails if any of the checks report an error, so it can be used to
+cheaply gate changes to the configuration. Warnings and infos are only printed,
+unless --strict is given, which fails on warnings too. Roles and variables of
+the role manifest can suppress some rules by their ID with suppress-rules, see
+docs/validator-description.md; other and unknown rule IDs are reported as
+errors. Errors name the file, line and column they were found at. With --output json, junit or sarif, they are written to stdout
+as a report for CI systems and code review tools instead.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+
+		flagValidateDefaultEnvFiles = splitNonEmpty(validateViper.GetString("defaults-file"), ",")
+		flagValidateScriptsDir = validateViper.GetString("scripts-dir")
+		flagValidateStrict = validateViper.GetBool("strict")
+
+		if flagValidateDefaultEnvFiles, err = absolutePathsForArray(flagValidateDefaultEnvFiles); err != nil {
+			return err
+		}
+		if flagValidateScriptsDir != "" {
+			if err = absolutePaths(&flagValidateScriptsDir); err != nil {
+				return err
+			}
+		}
+
+		err = fissile.LoadReleases(
+			flagRelease,
+			flagReleaseName,
+			flagReleaseVersion,
+			flagCacheDir,
+			workPathReleasesDir,
+		)
+		if err != nil {
+			return err
+		}
+
+		return fissile.Validate(
+			flagRoleManifest,
+			flagLightOpinions,
+			flagDarkOpinions,
+			flagOpinionsVars,
+			flagValidateScriptsDir,
+			flagValidateDefaultEnvFiles,
+			flagValidateStrict,
+			app.OutputFormat(flagOutputFormat),
+		)
+	},
+}
+var validateViper = viper.New()
+
+func init() {
+	initViper(validateViper)
+
+	RootCmd.AddCommand(validateCmd)
+
+	validateCmd.PersistentFlags().StringP(
+		"defaults-file",
+		"D",
+		"",
+		"Env files that contain defaults for the variables of the role manifest",
+	)
+
+	validateCmd.PersistentFlags().StringP(
+		"scripts-dir",
+		"",
+		"",
+		"Directory whose scripts must all be used by the roles; defaults to the scripts directory next to the role manifest",
+	)
+
+	validateCmd.PersistentFlags().BoolP(
+		"strict",
+		"",
+		false,
+		"Fail on warnings as well as on errors",
+	)
+
+	validateViper.BindPFlags(validateCmd.PersistentFlags())
+}
@@ -0,0 +1,104 @@
+package compilator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/SUSE/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+const accessIndexFile = "access.json"
+
+// PackageAccess is when a compiled package was last used by Compile
+type PackageAccess struct {
+	Release  string    `json:"release"`
+	Package  string    `json:"package"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// AccessIndexPath returns the path of the index of package accesses of the
+// compilation directory of a stemcell
+func AccessIndexPath(workDir string) string {
+	return filepath.Join(workDir, accessIndexFile)
+}
+
+// ReadAccessIndex returns the accesses recorded in the compilation directory
+// of a stemcell, by package fingerprint. It is empty if nothing is recorded.
+func ReadAccessIndex(workDir string) (map[string]PackageAccess, error) {
+	index := map[string]PackageAccess{}
+
+	indexPath := AccessIndexPath(workDir)
+	buf, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(buf, &index); err != nil {
+		return nil, fmt.Errorf("Error reading the access index %s: %s", indexPath, err.Error())
+	}
+	return index, nil
+}
+
+// WriteAccessIndex replaces the accesses recorded in the compilation
+// directory of a stemcell
+func WriteAccessIndex(workDir string, index map[string]PackageAccess) error {
+	buf, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that readers never see a
+	// partial index
+	tempFile, err := ioutil.TempFile(workDir, accessIndexFile)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(append(buf, '\n')); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), AccessIndexPath(workDir))
+}
+
+// recordAccess records the compiled packages among the packages as used
+// now. Failing to do so is reported, but does not fail compilation.
+func (c *Compilator) recordAccess(packages model.Packages) {
+	var used model.Packages
+	for _, pkg := range packages {
+		if compiled, err := isPackageCompiledHarness(c, pkg); err == nil && compiled {
+			used = append(used, pkg)
+		}
+	}
+	if len(used) == 0 {
+		return
+	}
+
+	index, err := ReadAccessIndex(c.hostWorkDir)
+	if err == nil {
+		now := time.Now().UTC()
+		for _, pkg := range used {
+			index[pkg.Fingerprint] = PackageAccess{
+				Release:  pkg.Release.Name,
+				Package:  pkg.Name,
+				LastUsed: now,
+			}
+		}
+		err = WriteAccessIndex(c.hostWorkDir, index)
+	}
+	if err != nil {
+		c.ui.Printf("%s: Could not record the use of compiled packages: %s\n",
+			color.YellowString("Warning"), err.Error())
+	}
+}
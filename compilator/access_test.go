@@ -0,0 +1,46 @@
+package compilator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-access-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	c, err := NewDockerCompilator(nil, workDir, "", "", "", "", "", "", false, ui, nil)
+	require.NoError(t, err)
+
+	packages := genTestCase("ruby-2.5", "go-1.4")[0].Packages
+	compiledDir := packages[0].GetPackageCompiledDir(workDir)
+	require.NoError(t, os.MkdirAll(compiledDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(compiledDir, "ruby"), []byte{}, 0755))
+	c.recordStemcell(packages[0])
+
+	earlier := time.Now().Add(-time.Hour).UTC()
+	require.NoError(t, WriteAccessIndex(workDir, map[string]PackageAccess{
+		"removed":  {Release: "test-release", Package: "removed", LastUsed: earlier},
+		"ruby-2.5": {Release: "test-release", Package: "ruby-2.5", LastUsed: earlier},
+	}))
+
+	c.recordAccess(packages)
+
+	index, err := ReadAccessIndex(workDir)
+	require.NoError(t, err)
+	assert.Len(index, 2, "Only compiled packages are recorded")
+	assert.Equal(earlier, index["removed"].LastUsed)
+	if assert.Contains(index, "ruby-2.5") {
+		assert.Equal("ruby-2.5", index["ruby-2.5"].Package)
+		assert.True(index["ruby-2.5"].LastUsed.After(earlier))
+	}
+}
@@ -3,6 +3,7 @@ package compilator
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,10 +11,12 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/SUSE/fissile/docker"
 	"github.com/SUSE/fissile/model"
+	"github.com/SUSE/fissile/pkgcache"
 	"github.com/SUSE/fissile/scripts/compilation"
 	"github.com/SUSE/fissile/util"
 	"github.com/SUSE/stampy"
@@ -45,10 +48,13 @@ type Compilator struct {
 	hostWorkDir       string
 	metricsPath       string
 	stemcellImageName string
+	stemcellImageID   string
+	stemcell          string
 	baseType          string
 	fissileVersion    string
 	dockerNetworkMode string
-	compilePackage    func(*Compilator, *model.Package) error
+	stemcellRootfsDir string
+	compilePackage    func(*Compilator, context.Context, *model.Package) error
 
 	// signalDependencies is a map of
 	//    (package fingerprint) -> (channel to close when done)
@@ -63,12 +69,36 @@ type Compilator struct {
 	// dependencies and resulting files.
 
 	signalDependencies map[string]chan struct{}
+
+	// failedDependencies holds the fingerprints of the packages which
+	// failed, or were skipped, when keepGoing is set. They are recorded
+	// before their channel in signalDependencies is closed.
+	failedDependencies map[string]bool
+	failedMutex        sync.Mutex
+	keepGoing          bool
 	keepContainer      bool
 	ui                 *termui.UI
 	grapher            util.ModelGrapher
+
+	// verifiedPackages holds the outcome of checking compiled packages
+	// against their manifest, by fingerprint
+	verifiedPackages map[string]error
+	verifiedMutex    sync.Mutex
+
+	// packageCache shares compiled packages with other builds; packages
+	// are pulled from it before compiling, and pushed to it once compiled
+	// unless packageCacheReadOnly is set
+	packageCache         *pkgcache.Cache
+	packageCacheReadOnly bool
+
+	// unrecordedDir is the directory of packages compiled by older
+	// versions of fissile, if packages without a recorded stemcell are
+	// reused, see ReuseUnrecordedPackages
+	unrecordedDir string
 }
 
 type compileJob struct {
+	ctx           context.Context
 	workerPackage *workerLib.Package
 	pkg           *model.Package
 	compilator    *Compilator
@@ -76,12 +106,15 @@ type compileJob struct {
 	killCh        <-chan struct{}
 }
 
-// NewDockerCompilator will create an instance of the Compilator using docker
+// NewDockerCompilator will create an instance of the Compilator using docker.
+// The stemcell is the BOSH stemcell (<os>/<version>) matching the stemcell
+// image; packages of compiled releases built for it are used as they are.
 func NewDockerCompilator(
 	dockerManager *docker.ImageManager,
 	hostWorkDir string,
 	metricsPath string,
 	stemcellImageName string,
+	stemcell string,
 	baseType string,
 	fissileVersion string,
 	dockerNetworkMode string,
@@ -95,6 +128,7 @@ func NewDockerCompilator(
 		hostWorkDir:       hostWorkDir,
 		metricsPath:       metricsPath,
 		stemcellImageName: stemcellImageName,
+		stemcell:          stemcell,
 		baseType:          baseType,
 		fissileVersion:    fissileVersion,
 		compilePackage:    (*Compilator).compilePackageInDocker,
@@ -104,6 +138,8 @@ func NewDockerCompilator(
 		grapher:           grapher,
 
 		signalDependencies: make(map[string]chan struct{}),
+		failedDependencies: make(map[string]bool),
+		verifiedPackages:   make(map[string]error),
 	}
 
 	return compilator, nil
@@ -115,6 +151,7 @@ func NewMountNSCompilator(
 	hostWorkDir string,
 	metricsPath string,
 	stemcellImageName string,
+	stemcell string,
 	baseType string,
 	fissileVersion string,
 	ui *termui.UI,
@@ -125,6 +162,7 @@ func NewMountNSCompilator(
 		hostWorkDir:       hostWorkDir,
 		metricsPath:       metricsPath,
 		stemcellImageName: stemcellImageName,
+		stemcell:          stemcell,
 		baseType:          baseType,
 		fissileVersion:    fissileVersion,
 		compilePackage:    (*Compilator).compilePackageInMountNS,
@@ -132,16 +170,97 @@ func NewMountNSCompilator(
 		grapher:           grapher,
 
 		signalDependencies: make(map[string]chan struct{}),
+		failedDependencies: make(map[string]bool),
+		verifiedPackages:   make(map[string]error),
+	}
+
+	return compilator, nil
+}
+
+// NewChrootCompilator will create an instance of the Compilator compiling in
+// a chroot of the stemcell root filesystem, in user and mount namespaces
+// (Linux only). The root filesystem must be unpacked into rootfsDir first,
+// see PrepareStemcellRootfs.
+func NewChrootCompilator(
+	hostWorkDir string,
+	rootfsDir string,
+	metricsPath string,
+	stemcellImageName string,
+	stemcell string,
+	baseType string,
+	fissileVersion string,
+	ui *termui.UI,
+	grapher util.ModelGrapher,
+) (*Compilator, error) {
+
+	compilator := &Compilator{
+		hostWorkDir:       hostWorkDir,
+		metricsPath:       metricsPath,
+		stemcellImageName: stemcellImageName,
+		stemcell:          stemcell,
+		baseType:          baseType,
+		fissileVersion:    fissileVersion,
+		stemcellRootfsDir: rootfsDir,
+		compilePackage:    (*Compilator).compilePackageInChroot,
+		ui:                ui,
+		grapher:           grapher,
+
+		signalDependencies: make(map[string]chan struct{}),
+		failedDependencies: make(map[string]bool),
+		verifiedPackages:   make(map[string]error),
 	}
 
 	return compilator, nil
 }
 
+// UsePackageCache makes the compilator use the cache of compiled packages.
+// With readOnly set, packages compiled are not pushed to the cache.
+func (c *Compilator) UsePackageCache(cache *pkgcache.Cache, readOnly bool) {
+	c.packageCache = cache
+	c.packageCacheReadOnly = readOnly
+}
+
+// SetKeepGoing makes Compile go on when packages fail to compile, compiling
+// all packages whose dependencies compiled successfully
+func (c *Compilator) SetKeepGoing(keepGoing bool) {
+	c.keepGoing = keepGoing
+}
+
 var errWorkerAbort = errors.New("worker aborted")
 
+// errCompileInterrupted is the result of packages whose compilation was
+// stopped as the context was cancelled
+var errCompileInterrupted = errors.New("compilation interrupted")
+
+// dependencyFailedError is the result of packages which are skipped, as a
+// dependency failed to compile
+type dependencyFailedError struct {
+	dependency *model.Package
+}
+
+func (e dependencyFailedError) Error() string {
+	return fmt.Sprintf("dependency %s/%s failed", e.dependency.Release.Name, e.dependency.Name)
+}
+
+// markFailed records that the package failed, for the packages depending on it
+func (c *Compilator) markFailed(pkg *model.Package) {
+	c.failedMutex.Lock()
+	defer c.failedMutex.Unlock()
+	c.failedDependencies[pkg.Fingerprint] = true
+}
+
+// hasFailed returns whether the package failed; it must only be called once
+// the package is done
+func (c *Compilator) hasFailed(pkg *model.Package) bool {
+	c.failedMutex.Lock()
+	defer c.failedMutex.Unlock()
+	return c.failedDependencies[pkg.Fingerprint]
+}
+
 type compileResult struct {
-	pkg *model.Package
-	err error
+	pkg      *model.Package
+	err      error
+	duration time.Duration
 }
 
 // Compile concurrency works like this:
@@ -150,7 +269,8 @@ type compileResult struct {
 // 1 synchronizer consuming EXACTLY 1 <-doneCh for every <-todoCh  <=> Compile() again.
 //
 // Dependencies:
-// - Packages with the least dependencies are queued first.
+// - Packages are queued after their dependencies, the ones heading the
+//   longest chain of compilation still to do first, see createDepBuckets.
 // - Workers wait for their dependencies by waiting on a map of
 //   broadcasting channels that are closed by the synchronizer when
 //   something is done compiling successfully
@@ -169,8 +289,38 @@ type compileResult struct {
 // - synchronizer will greedily drain the <-todoCh to starve the
 //   workers out and won't wait for the <-doneCh for the N packages it
 //   drained.
-func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles model.Roles, verbose bool) error {
-	packages, err := c.removeCompiledPackages(c.gatherPackages(releases, roles), verbose)
+//
+// Cancelling the context stops everything, keepGoing or not: the running
+// compilations are killed and their compiled-temp directories removed, the
+// remaining jobs abort without compiling, and the error returned summarizes
+// what was compiled. Compiling again picks up from there.
+//
+// With keepGoing set, errors do not activate the killCh. Instead, the
+// synchronizer records the package as failed and closes its channel in
+// c.signalDependencies all the same; the workers waiting for it then skip
+// their package, which in turn counts as failed for its dependents. All
+// other packages are compiled, and the error returned lists the failures.
+//
+// The status of each package handled, with the path of its log, is recorded
+// next to its compilation directory, see ReadPackageStatuses. The compiled
+// packages used are recorded in the access index, see ReadAccessIndex.
+func (c *Compilator) Compile(ctx context.Context, workerCount int, releases []*model.Release, roles model.Roles, verbose bool) error {
+	packages := c.gatherPackages(releases, roles)
+	defer c.recordAccess(packages)
+	if err := RecordStemcellCompilationDir(c.hostWorkDir, c.StemcellIdentity()); err != nil {
+		return fmt.Errorf("failed to record the stemcell: %v", err)
+	}
+	if err := c.adoptUnrecordedPackages(packages); err != nil {
+		return fmt.Errorf("failed to reuse packages without a recorded stemcell: %v", err)
+	}
+	if err := c.importPrecompiledPackages(packages); err != nil {
+		return fmt.Errorf("failed to import precompiled packages: %v", err)
+	}
+	if err := c.pullCachedPackages(packages, workerCount); err != nil {
+		return fmt.Errorf("failed to pull cached packages: %v", err)
+	}
+
+	packages, err := c.removeCompiledPackages(packages, verbose)
 
 	if err != nil {
 		return fmt.Errorf("failed to remove compiled packages: %v", err)
@@ -188,11 +338,16 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles m
 	workerLib.MaxJobs = workerCount
 
 	worker := workerLib.NewWorker()
-	buckets := createDepBuckets(packages)
+	durations, err := c.compileDurations()
+	if err != nil {
+		return fmt.Errorf("failed to read the compile durations: %v", err)
+	}
+	buckets := createDepBuckets(packages, durations, workerCount)
 
 	// ... load it with the jobs to run ...
 	for _, pkg := range buckets {
 		worker.Add(compileJob{
+			ctx:        ctx,
 			pkg:        pkg,
 			compilator: c,
 			killCh:     killCh,
@@ -206,6 +361,23 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles m
 		close(doneCh)
 	}()
 
+	// Compiled packages are pushed to the package cache in the background,
+	// so that neither their dependents nor the workers wait for the uploads
+	pushCh := make(chan *model.Package, len(packages))
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for pkg := range pushCh {
+			if ctx.Err() == nil {
+				c.pushCachedPackage(pkg)
+			}
+		}
+	}()
+	defer func() {
+		close(pushCh)
+		<-pushed
+	}()
+
 	// (**) All jobs push their results into the single doneCh.
 	// The code below is a synchronizer which pulls the results
 	// from the channel as fast as it can and reports them to the
@@ -217,9 +389,15 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles m
 	// may still run to regular completion.
 
 	killed := false
+	compiled := 0
+	var failed, skipped []string
 	for result := range doneCh {
 		if result.err == nil {
+			compiled++
+			c.recordStemcell(result.pkg)
+			c.recordStatus(result.pkg, StatusCompiled, nil, result.duration)
 			close(c.signalDependencies[result.pkg.Fingerprint])
+			pushCh <- result.pkg
 			c.ui.Printf("%s   > success: %s/%s\n",
 				color.YellowString("result"),
 				color.GreenString(result.pkg.Release.Name),
@@ -227,14 +405,40 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles m
 			continue
 		}
 
+		status, outcome := StatusFailed, "failure"
+		switch result.err.(type) {
+		case dependencyFailedError:
+			status, outcome = StatusSkipped, "skipped"
+		default:
+			if result.err == errWorkerAbort {
+				status = StatusSkipped
+			} else if result.err == errCompileInterrupted {
+				status, outcome = StatusSkipped, "interrupted"
+			}
+		}
+		c.recordStatus(result.pkg, status, result.err, 0)
+
 		c.ui.Printf(
-			"%s   > failure: %s/%s - %s\n",
+			"%s   > %s: %s/%s - %s\n",
 			color.YellowString("result"),
+			outcome,
 			color.RedString(result.pkg.Release.Name),
 			color.RedString(result.pkg.Name),
 			color.RedString(result.err.Error()),
 		)
 
+		if c.keepGoing {
+			name := fmt.Sprintf("%s/%s", result.pkg.Release.Name, result.pkg.Name)
+			if status == StatusSkipped {
+				skipped = append(skipped, name)
+			} else {
+				failed = append(failed, name)
+			}
+			c.markFailed(result.pkg)
+			close(c.signalDependencies[result.pkg.Fingerprint])
+			continue
+		}
+
 		err = result.err
 		if !killed {
 			close(killCh)
@@ -242,6 +446,18 @@ func (c *Compilator) Compile(workerCount int, releases []*model.Release, roles m
 		}
 	}
 
+	if ctx.Err() != nil {
+		return fmt.Errorf("Compilation was interrupted: %d of %d packages compiled, %d not compiled; run the compilation again to compile the rest",
+			compiled, len(packages), len(packages)-compiled)
+	}
+
+	if len(failed) > 0 || len(skipped) > 0 {
+		sort.Strings(failed)
+		sort.Strings(skipped)
+		return fmt.Errorf("%d packages failed to compile (%s), %d packages were skipped (%s)",
+			len(failed), strings.Join(failed, ", "), len(skipped), strings.Join(skipped, ", "))
+	}
+
 	return err
 }
 
@@ -275,6 +491,12 @@ func (c *Compilator) gatherPackages(releases []*model.Release, roles model.Roles
 func (j compileJob) Run() {
 	c := j.compilator
 
+	// Once cancelled, nothing is compiled anymore, dependencies or not
+	if j.ctx.Err() != nil {
+		j.doneCh <- compileResult{pkg: j.pkg, err: errWorkerAbort}
+		return
+	}
+
 	// Metrics: Overall time for the specific job
 	var waitSeriesName string
 	var runSeriesName string
@@ -299,14 +521,10 @@ func (j compileJob) Run() {
 		for !done {
 			select {
 			case <-j.killCh:
-				c.ui.Printf("killed:  %s/%s\n",
-					color.MagentaString(j.pkg.Release.Name),
-					color.MagentaString(j.pkg.Name))
-				j.doneCh <- compileResult{pkg: j.pkg, err: errWorkerAbort}
-
-				if c.metricsPath != "" {
-					stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
-				}
+				j.abortWaiting(waitSeriesName)
+				return
+			case <-j.ctx.Done():
+				j.abortWaiting(waitSeriesName)
 				return
 			case <-time.After(5 * time.Second):
 				c.ui.Printf("waiting: %s/%s - %s\n",
@@ -314,6 +532,18 @@ func (j compileJob) Run() {
 					color.MagentaString(j.pkg.Name),
 					color.MagentaString(dep.Name))
 			case <-c.signalDependencies[dep.Fingerprint]:
+				if c.hasFailed(dep) {
+					c.ui.Printf("skipped: %s/%s - %s\n",
+						color.MagentaString(j.pkg.Release.Name),
+						color.MagentaString(j.pkg.Name),
+						color.MagentaString(dep.Name))
+					j.doneCh <- compileResult{pkg: j.pkg, err: dependencyFailedError{dependency: dep}}
+
+					if c.metricsPath != "" {
+						stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
+					}
+					return
+				}
 				c.ui.Printf("depdone: %s/%s - %s\n",
 					color.MagentaString(j.pkg.Release.Name),
 					color.MagentaString(j.pkg.Name),
@@ -336,7 +566,15 @@ func (j compileJob) Run() {
 		stampy.Stamp(c.metricsPath, "fissile", runSeriesName, "start")
 	}
 
-	workerErr := c.compilePackage(c, j.pkg)
+	started := time.Now()
+	workerErr := c.compilePackage(c, j.ctx, j.pkg)
+	duration := time.Since(started)
+
+	if workerErr != nil && j.ctx.Err() != nil {
+		// Drop the partial results of the interrupted compilation
+		os.RemoveAll(j.pkg.GetPackageCompiledTempDir(c.hostWorkDir))
+		workerErr = errCompileInterrupted
+	}
 
 	if c.metricsPath != "" {
 		stampy.Stamp(c.metricsPath, "fissile", runSeriesName, "done")
@@ -346,15 +584,34 @@ func (j compileJob) Run() {
 		color.MagentaString(j.pkg.Release.Name),
 		color.MagentaString(j.pkg.Name))
 
-	j.doneCh <- compileResult{pkg: j.pkg, err: workerErr}
+	j.doneCh <- compileResult{pkg: j.pkg, err: workerErr, duration: duration}
 }
 
-func createDepBuckets(packages []*model.Package) []*model.Package {
-	var buckets []*model.Package
+// abortWaiting reports the job as aborted while waiting for its dependencies
+func (j compileJob) abortWaiting(waitSeriesName string) {
+	c := j.compilator
+	c.ui.Printf("killed:  %s/%s\n",
+		color.MagentaString(j.pkg.Release.Name),
+		color.MagentaString(j.pkg.Name))
+	j.doneCh <- compileResult{pkg: j.pkg, err: errWorkerAbort}
 
-	// ruby takes forever and has no deps,
-	// so optimize by moving ruby packages to the front
-	var rubies []*model.Package
+	if c.metricsPath != "" {
+		stampy.Stamp(c.metricsPath, "fissile", waitSeriesName, "done")
+	}
+}
+
+// createDepBuckets returns the packages in the order to queue them for the
+// workers, each package after all of its dependencies. The order follows an
+// estimated schedule of the workers: whenever a worker is estimated to be
+// free, it is given the package heading the longest chain of compilation
+// still to do among the packages it could start right away, or else the
+// package which could start the earliest. The length of a chain is the sum
+// of the compile durations of its packages, as recorded by earlier runs and
+// keyed by release and package name, see packageDurationKey; packages
+// without a recorded duration count as the average recorded duration, or all
+// the same if nothing was recorded. Ties keep the order of the input.
+func createDepBuckets(packages []*model.Package, durations map[string]time.Duration, workerCount int) []*model.Package {
+	var buckets []*model.Package
 
 	// topological sort, ensuring that each package X is queued
 	// only after all of its dependencies.
@@ -415,56 +672,122 @@ func createDepBuckets(packages []*model.Package) []*model.Package {
 		}
 	}
 
-	// Iterate until we have handled all packages.  We expect each
-	// iteration to handle at least one package, because the input
-	// is a DAG, i.e. has no cycles. Therefore each iteration will
-	// have at least one package with no dependencies, and being
-	// handled.
+	// The priority of a package is the length of the longest chain of
+	// packages starting with it, i.e. its own cost plus the highest
+	// priority of the packages using it.
 
-	keepRunning := true
-	for keepRunning {
-		keepRunning = false
+	defaultCost := averageDuration(durations)
+	cost := func(pkg *model.Package) time.Duration {
+		if duration, ok := durations[packageDurationKey(pkg)]; ok {
+			return duration
+		}
+		return defaultCost
+	}
+	priorities := make(map[string]time.Duration)
+	var priority func(pkg *model.Package) time.Duration
+	priority = func(pkg *model.Package) time.Duration {
+		if p, ok := priorities[pkg.Fingerprint]; ok {
+			return p
+		}
+		var longest time.Duration
+		for _, usr := range revDeps[pkg.Fingerprint] {
+			if p := priority(usr); p > longest {
+				longest = p
+			}
+		}
+		priorities[pkg.Fingerprint] = cost(pkg) + longest
+		return cost(pkg) + longest
+	}
 
-		for _, pkg := range packages {
+	// Each iteration queues one package for the worker estimated to be
+	// free first, among the packages whose dependencies are all queued
+	// (depCount == 0). We expect there to always be one, because the
+	// input is a DAG, i.e. has no cycles.
+
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	free := make([]time.Duration, workerCount)
+	finished := make(map[string]time.Duration)
+
+	for len(buckets) < len(packages) {
+		worker := 0
+		for i := range free {
+			if free[i] < free[worker] {
+				worker = i
+			}
+		}
 
+		var next *model.Package
+		var nextStart time.Duration
+		for _, pkg := range packages {
 			// The package either still has dependencies waiting (depCount > 0),
 			// or is enqueued and processed ((**) depCount == -1 < 0)
 			if depCount[pkg.Fingerprint] != 0 {
 				continue
 			}
 
-			// depCount == 0, time to
-			// - queue the package
-			// - notify the outer loop to keep running, and
-			// - force the following iterations to ignore
-			//   the package (See (**)).
-			depCount[pkg.Fingerprint]--
-			keepRunning = true
-
-			// notify the users of the queued that another
-			// of their dependencies is handled
-			for _, usr := range revDeps[pkg.Fingerprint] {
-				depCount[usr.Fingerprint]--
+			// The package starts once the worker is free and its
+			// dependencies are compiled; packages compiled already
+			// are not in finished, and do not hold it up
+			start := free[worker]
+			for _, dep := range pkg.Dependencies {
+				if finished[dep.Fingerprint] > start {
+					start = finished[dep.Fingerprint]
+				}
 			}
 
-			// rubies are special, see notes at top of function
-			if strings.HasPrefix(pkg.Name, "ruby-2.") {
-				rubies = append(rubies, pkg)
+			switch {
+			case next == nil:
+			case start <= free[worker] && nextStart <= free[worker]:
+				if priority(pkg) <= priority(next) {
+					continue
+				}
+			case start < nextStart:
+			case start == nextStart && priority(pkg) > priority(next):
+			default:
 				continue
 			}
+			next, nextStart = pkg, start
+		}
+		if next == nil {
+			// Only possible with a dependency cycle
+			break
+		}
 
-			// queue regular
-			buckets = append(buckets, pkg)
+		// depCount == 0, time to
+		// - queue the package, and
+		// - force the following iterations to ignore
+		//   the package (See (**)).
+		depCount[next.Fingerprint]--
+		buckets = append(buckets, next)
+		finished[next.Fingerprint] = nextStart + cost(next)
+		free[worker] = finished[next.Fingerprint]
+
+		// notify the users of the queued that another
+		// of their dependencies is handled
+		for _, usr := range revDeps[next.Fingerprint] {
+			depCount[usr.Fingerprint]--
 		}
 	}
 
-	// prepend rubies to get them out of the way first
-	buckets = append(rubies, buckets...)
-
 	return buckets
 }
 
-func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
+// averageDuration returns the average of the durations, or one second if
+// there are none
+func averageDuration(durations map[string]time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return time.Second
+	}
+	var total time.Duration
+	for _, duration := range durations {
+		total += duration
+	}
+	return total / time.Duration(len(durations))
+}
+
+func (c *Compilator) compilePackageInDocker(ctx context.Context, pkg *model.Package) (err error) {
 	// Prepare input dir (package plus deps)
 	if err := c.createCompilationDirStructure(pkg); err != nil {
 		return err
@@ -491,17 +814,25 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 	// Run compilation in container
 	containerName := c.getPackageContainerName(pkg)
 
-	// in-memory buffer of the log
+	// in-memory buffer of the log, and the log file kept on disk
 	log := new(bytes.Buffer)
 	logWriter := util.NewSyncedWriter(log)
+	logFile, err := c.createPackageLog(pkg)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	logFileWriter := util.NewSyncedWriter(logFile)
 
-	stdoutWriter := docker.NewFormattingWriter(
+	stdoutWriter := newPackageLogWriter(
+		logFileWriter,
 		logWriter,
 		func(line string) string {
 			return color.GreenString("compilation-%s > %s", color.MagentaString("%s", pkg.Name), color.WhiteString("%s", line))
 		},
 	)
-	stderrWriter := docker.NewFormattingWriter(
+	stderrWriter := newPackageLogWriter(
+		logFileWriter,
 		logWriter,
 		func(line string) string {
 			return color.GreenString("compilation-%s > %s", color.MagentaString("%s", pkg.Name), color.RedString("%s", line))
@@ -517,7 +848,7 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		// from, so it will be in some docker-maintained storage.
 		sourceMountName: ContainerSourceDir,
 	}
-	exitCode, container, err := c.dockerManager.RunInContainer(docker.RunInContainerOpts{
+	exitCode, container, err := c.dockerManager.RunInContainer(ctx, docker.RunInContainerOpts{
 		ContainerName: containerName,
 		ImageName:     c.stemcellImageName,
 		EntryPoint:    []string{},
@@ -530,7 +861,7 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		StderrWriter:  stderrWriter,
 	})
 
-	if container != nil && (!c.keepContainer || err == nil || exitCode == 0) {
+	if container != nil && (!c.keepContainer || err == nil || exitCode == 0 || ctx.Err() != nil) {
 		// Attention. While the assignments to 'err' in the
 		// deferal below take effect after the 'return'
 		// statements coming later they are visible to the
@@ -557,6 +888,10 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		}()
 	}
 
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
 	if err != nil {
 		log.WriteTo(c.ui)
 		return fmt.Errorf("Error compiling package %s: %s", pkg.Name, err.Error())
@@ -567,9 +902,7 @@ func (c *Compilator) compilePackageInDocker(pkg *model.Package) (err error) {
 		return fmt.Errorf("Error - compilation for package %s exited with code %d", pkg.Name, exitCode)
 	}
 
-	return os.Rename(
-		pkg.GetPackageCompiledTempDir(c.hostWorkDir),
-		pkg.GetPackageCompiledDir(c.hostWorkDir))
+	return c.moveCompiledPackage(pkg, pkg.GetPackageCompiledTempDir(c.hostWorkDir))
 }
 
 func (c *Compilator) isPackageCompiled(pkg *model.Package) (bool, error) {
@@ -585,11 +918,17 @@ func (c *Compilator) isPackageCompiled(pkg *model.Package) (bool, error) {
 	}
 
 	compiledDirEmpty, err := isDirEmpty(compiledPackagePath)
-	if err != nil {
+	if err != nil || compiledDirEmpty {
 		return false, err
 	}
 
-	return !compiledDirEmpty, nil
+	// Packages compiled on another stemcell have to be compiled again
+	if matches, err := c.matchesStemcell(pkg, c.hostWorkDir); err != nil || !matches {
+		return false, err
+	}
+
+	// So do packages which changed since they were compiled
+	return c.verifyCompiledPackage(pkg) == nil, nil
 }
 
 func isDirEmpty(path string) (bool, error) {
@@ -698,6 +1037,145 @@ func (c *Compilator) getPackageContainerName(pkg *model.Package) string {
 	return util.SanitizeDockerName(fmt.Sprintf("%s-%s-%s-pkg-%s-gkp", c.baseCompilationContainerName(), pkg.Release.Name, pkg.Release.Version, pkg.Name))
 }
 
+// importPrecompiledPackages places the packages of compiled releases into the
+// compilation cache, so that they are treated as already compiled. Packages
+// compiled for a different stemcell cannot be used, as compiled releases do
+// not carry the package sources.
+func (c *Compilator) importPrecompiledPackages(packages model.Packages) error {
+	for _, pkg := range packages {
+		if !pkg.IsCompiled() {
+			continue
+		}
+
+		if pkg.Stemcell != c.stemcell {
+			return fmt.Errorf("package %s/%s was compiled for stemcell %s, but the stemcell is %q", pkg.Release.Name, pkg.Name, pkg.Stemcell, c.stemcell)
+		}
+
+		compiled, err := isPackageCompiledHarness(c, pkg)
+		if err != nil {
+			return err
+		}
+		if compiled {
+			continue
+		}
+
+		if err := pkg.ValidateSHA1(); err != nil {
+			return err
+		}
+
+		// The archive holds the contents of the package install directory
+		compiledTempDir := pkg.GetPackageCompiledTempDir(c.hostWorkDir)
+		if err := os.RemoveAll(compiledTempDir); err != nil {
+			return err
+		}
+		extractedDir, err := pkg.Extract(compiledTempDir)
+		if err != nil {
+			return fmt.Errorf("Error extracting compiled package %s: %s", pkg.Name, err.Error())
+		}
+		if err := c.moveCompiledPackage(pkg, extractedDir); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(compiledTempDir); err != nil {
+			return err
+		}
+		c.recordStemcell(pkg)
+
+		c.ui.Printf("%s   > precompiled: %s/%s\n",
+			color.YellowString("result"),
+			color.GreenString(pkg.Release.Name),
+			color.GreenString(pkg.Name))
+	}
+
+	return nil
+}
+
+// pullCachedPackages places the packages found in the package cache into the
+// compilation directory, so that they are treated as already compiled. The
+// packages are pulled in parallel, by as many workers as compile. Packages
+// which cannot be pulled are reported, and compiled.
+func (c *Compilator) pullCachedPackages(packages model.Packages, workerCount int) error {
+	if c.packageCache == nil {
+		return nil
+	}
+
+	var missing model.Packages
+	for _, pkg := range packages {
+		compiled, err := isPackageCompiledHarness(c, pkg)
+		if err != nil {
+			return err
+		}
+		if !compiled {
+			missing = append(missing, pkg)
+		}
+	}
+
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	slots := make(chan struct{}, workerCount)
+	var wg sync.WaitGroup
+	for _, pkg := range missing {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(pkg *model.Package) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			found, err := c.pullCachedPackage(pkg)
+			if err != nil {
+				c.ui.Printf("%s: Could not pull %s/%s from package cache %s: %s\n",
+					color.YellowString("Warning"),
+					pkg.Release.Name, pkg.Name, c.packageCache, err.Error())
+				return
+			}
+			if found {
+				c.recordStemcell(pkg)
+				c.recordStatus(pkg, StatusCached, nil, 0)
+				c.ui.Printf("%s   > cached: %s/%s\n",
+					color.YellowString("result"),
+					color.GreenString(pkg.Release.Name),
+					color.GreenString(pkg.Name))
+			}
+		}(pkg)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// pullCachedPackage pulls the compiled package from the package cache into
+// the temporary compilation directory, and moves it into place once complete
+func (c *Compilator) pullCachedPackage(pkg *model.Package) (bool, error) {
+	compiledTempDir := pkg.GetPackageCompiledTempDir(c.hostWorkDir)
+	if err := os.RemoveAll(compiledTempDir); err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(compiledTempDir)
+
+	found, err := c.packageCache.Pull(pkg.Fingerprint, compiledTempDir)
+	if err != nil || !found {
+		return false, err
+	}
+
+	return true, c.moveCompiledPackage(pkg, compiledTempDir)
+}
+
+// pushCachedPackage pushes the freshly compiled package to the package
+// cache. Failures are reported, but do not fail the compilation.
+func (c *Compilator) pushCachedPackage(pkg *model.Package) {
+	if c.packageCache == nil || c.packageCacheReadOnly {
+		return
+	}
+
+	if err := c.packageCache.Push(pkg.Fingerprint, pkg.GetPackageCompiledDir(c.hostWorkDir)); err != nil {
+		c.ui.Printf("%s: Could not push %s/%s to package cache %s: %s\n",
+			color.YellowString("Warning"),
+			pkg.Release.Name, pkg.Name, c.packageCache, err.Error())
+	}
+}
+
 // removeCompiledPackages must be called after initPackageMaps as it closes
 // the broadcast channels of anything already compiled.
 func (c *Compilator) removeCompiledPackages(packages model.Packages, verbose bool) (model.Packages, error) {
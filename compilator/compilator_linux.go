@@ -2,73 +2,203 @@ package compilator
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"syscall"
 
-	"github.com/SUSE/fissile/docker"
 	"github.com/SUSE/fissile/model"
 	"github.com/SUSE/fissile/scripts/compilation"
+	"github.com/SUSE/fissile/util"
 	"github.com/fatih/color"
 )
 
-func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
+func (c *Compilator) compilePackageInMountNS(ctx context.Context, pkg *model.Package) (err error) {
+	hostScriptPath, err := c.prepareCompilationSources(pkg)
+	if err != nil {
+		return err
+	}
+
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		return fmt.Errorf("Failed to find bash: %s", err)
+	}
+	cmd := &exec.Cmd{
+		Path: bashPath,
+		Args: []string{"bash", hostScriptPath, pkg.Name, pkg.Version, c.hostWorkDir},
+		Env:  append(os.Environ(), "HOST_USERID=1000", "HOST_USERGID=1000"),
+		Dir:  c.hostWorkDir,
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWNS,
+			Setpgid:    true,
+		},
+	}
+	if err := c.runCompilation(ctx, pkg, cmd); err != nil {
+		return err
+	}
+
+	return c.moveCompiledPackage(pkg, pkg.GetPackageCompiledTempDir(c.hostWorkDir))
+}
+
+// compilePackageInChroot compiles the package in a chroot of the stemcell
+// root filesystem, see PrepareStemcellRootfs. The chroot script mounts the
+// package sources and its compiled-temp directory into it, as docker does,
+// in new user and mount namespaces, so that no privileges are needed. The
+// user is root in the chroot, and owns all of its files.
+func (c *Compilator) compilePackageInChroot(ctx context.Context, pkg *model.Package) (err error) {
+	if _, err := c.prepareCompilationSources(pkg); err != nil {
+		return err
+	}
+
+	sourcesDir := pkg.GetTargetPackageSourcesDir(c.hostWorkDir)
+	chrootScriptPath := filepath.Join(sourcesDir, "chroot.sh")
+	if err := compilation.SaveScript(c.baseType, compilation.ChrootScript, chrootScriptPath); err != nil {
+		return fmt.Errorf("failed to copy chroot script: %s", err)
+	}
+
+	compiledTempDir := pkg.GetPackageCompiledTempDir(c.hostWorkDir)
+	if err := os.RemoveAll(compiledTempDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(compiledTempDir, 0755); err != nil {
+		return err
+	}
+
+	// The chroot is shared by all packages; each of them gets its own /tmp
+	// and /var/vcap
+	scratchDir := filepath.Join(c.hostWorkDir, pkg.Fingerprint, "chroot")
+	if err := os.RemoveAll(scratchDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratchDir)
+	if err := os.MkdirAll(filepath.Join(scratchDir, "vcap"), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(scratchDir, "tmp"), 0755); err != nil {
+		return err
+	}
+	if err := os.Chmod(filepath.Join(scratchDir, "tmp"), 0777|os.ModeSticky); err != nil {
+		return err
+	}
+
+	bashPath, err := exec.LookPath("bash")
+	if err != nil {
+		return fmt.Errorf("Failed to find bash: %s", err)
+	}
+	cmd := &exec.Cmd{
+		Path: bashPath,
+		Args: []string{"bash", chrootScriptPath,
+			c.stemcellRootfsDir, sourcesDir, compiledTempDir, scratchDir,
+			pkg.Name, pkg.Version},
+		Env: []string{
+			"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+			"HOME=/root",
+			"HOST_USERID=0",
+			"HOST_USERGID=0",
+		},
+		Dir: c.hostWorkDir,
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+			UidMappings: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+			},
+			GidMappings: []syscall.SysProcIDMap{
+				{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+			},
+			Setpgid: true,
+		},
+	}
+	for _, name := range []string{"http_proxy", "https_proxy", "no_proxy", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		if val, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", name, val))
+		}
+	}
+	if err := c.runCompilation(ctx, pkg, cmd); err != nil {
+		return err
+	}
+
+	return c.moveCompiledPackage(pkg, compiledTempDir)
+}
+
+// prepareCompilationSources prepares the sources of the package and of its
+// dependencies, and returns the path of the compilation script
+func (c *Compilator) prepareCompilationSources(pkg *model.Package) (string, error) {
 	// Prepare input dir (package plus deps)
 	if err := c.createCompilationDirStructure(pkg); err != nil {
-		return fmt.Errorf("failed to create directory: %s", err)
+		return "", fmt.Errorf("failed to create directory: %s", err)
 	}
 
 	if err := c.copyDependencies(pkg); err != nil {
-		return fmt.Errorf("failed to copy dependencies: %s", err)
+		return "", fmt.Errorf("failed to copy dependencies: %s", err)
 	}
 
 	// Generate a compilation script
 	targetScriptName := "compile.sh"
 	hostScriptPath := filepath.Join(pkg.GetTargetPackageSourcesDir(c.hostWorkDir), targetScriptName)
 	if err := compilation.SaveScript(c.baseType, compilation.CompilationScript, hostScriptPath); err != nil {
-		return fmt.Errorf("failed to copy compilation script: %s", err)
+		return "", fmt.Errorf("failed to copy compilation script: %s", err)
 	}
 
 	// Extract package
 	extractDir := c.getSourcePackageDir(pkg)
 	if _, err := pkg.Extract(extractDir); err != nil {
-		return fmt.Errorf("faile to extract package: %s", err)
+		return "", fmt.Errorf("faile to extract package: %s", err)
 	}
 
-	// in-memory buffer of the log
+	return hostScriptPath, nil
+}
+
+// runCompilation runs the compilation command, logging its output. The
+// command must run in its own process group, which is killed if the context
+// is cancelled.
+func (c *Compilator) runCompilation(ctx context.Context, pkg *model.Package, cmd *exec.Cmd) error {
+	// in-memory buffer of the log, and the log file kept on disk
 	log := new(bytes.Buffer)
+	logWriter := util.NewSyncedWriter(log)
+	logFile, err := c.createPackageLog(pkg)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %s", err)
+	}
+	defer logFile.Close()
+	logFileWriter := util.NewSyncedWriter(logFile)
 
-	stdoutWriter := docker.NewFormattingWriter(
-		log,
+	stdoutWriter := newPackageLogWriter(
+		logFileWriter,
+		logWriter,
 		func(line string) string {
 			return color.GreenString("compilation-%s > %s", color.MagentaString("%s", pkg.Name), color.WhiteString("%s", line))
 		},
 	)
-	stderrWriter := docker.NewFormattingWriter(
-		log,
+	stderrWriter := newPackageLogWriter(
+		logFileWriter,
+		logWriter,
 		func(line string) string {
 			return color.GreenString("compilation-%s > %s", color.MagentaString("%s", pkg.Name), color.RedString("%s", line))
 		},
 	)
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
 
-	bashPath, err := exec.LookPath("bash")
-	if err != nil {
-		return fmt.Errorf("Failed to find bash: %s", err)
+	if err = cmd.Start(); err == nil {
+		stop := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				// Kill the whole process group, the build runs sub-processes
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			case <-stop:
+			}
+		}()
+		err = cmd.Wait()
+		close(stop)
 	}
-	cmd := &exec.Cmd{
-		Path:   bashPath,
-		Args:   []string{"bash", hostScriptPath, pkg.Name, pkg.Version, c.hostWorkDir},
-		Env:    append(os.Environ(), "HOST_USERID=1000", "HOST_USERGID=1000"),
-		Dir:    c.hostWorkDir,
-		Stdout: stdoutWriter,
-		Stderr: stderrWriter,
-		SysProcAttr: &syscall.SysProcAttr{
-			Cloneflags: syscall.CLONE_NEWNS,
-		},
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-	err = cmd.Run()
 	if err != nil {
 		log.WriteTo(c.ui)
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -79,7 +209,5 @@ func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
 		return fmt.Errorf("Error compiling package %s: %s", pkg.Name, err)
 	}
 
-	return os.Rename(
-		pkg.GetPackageCompiledTempDir(c.hostWorkDir),
-		pkg.GetPackageCompiledDir(c.hostWorkDir))
+	return nil
 }
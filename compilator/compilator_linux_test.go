@@ -2,15 +2,20 @@ package compilator
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/SUSE/fissile/model"
 	"github.com/SUSE/termui"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type LockedBuffer struct {
@@ -56,9 +61,107 @@ func TestCompilePackageInMountNS(t *testing.T) {
 	}
 	defer os.RemoveAll(tempDir)
 
-	c, err := NewMountNSCompilator(tempDir, "", "repo", "linux", "0", ui, nil)
+	c, err := NewMountNSCompilator(tempDir, "", "repo", "", "linux", "0", ui, nil)
 	assert.NoError(err)
 
-	err = c.Compile(2, []*model.Release{release}, nil, false)
+	err = c.Compile(context.Background(), 2, []*model.Release{release}, nil, false)
 	assert.NoError(err, stderr.String())
 }
+
+// writeChrootTestArchive writes an archive of a minimal stemcell image, with
+// the commands of the compilation script copied from the host, with the
+// libraries they need
+func writeChrootTestArchive(t *testing.T, archivePath string) {
+	files := map[string]string{}
+	addFile := func(name, hostPath string) {
+		contents, err := ioutil.ReadFile(hostPath)
+		require.NoError(t, err)
+		files[strings.TrimPrefix(name, "/")] = string(contents)
+	}
+
+	lddPath, err := exec.LookPath("ldd")
+	if err != nil {
+		t.Skip("generating the stemcell image requires ldd")
+	}
+	for _, command := range []string{"bash", "chown", "cp", "ln", "mkdir", "readlink"} {
+		commandPath, err := exec.LookPath(command)
+		require.NoError(t, err)
+		addFile(filepath.Join("bin", command), commandPath)
+
+		libraries, err := exec.Command(lddPath, commandPath).Output()
+		require.NoError(t, err)
+		for _, line := range strings.Split(string(libraries), "\n") {
+			// Either "name => /path (address)" or "/path (address)"
+			if index := strings.Index(line, "=>"); index >= 0 {
+				line = line[index+len("=>"):]
+			}
+			if fields := strings.Fields(line); len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+				addFile(fields[0], fields[0])
+			}
+		}
+	}
+
+	writeStemcellArchive(t, archivePath, "c0ffee", files)
+}
+
+func TestCompilePackageInChroot(t *testing.T) {
+	assert := assert.New(t)
+
+	// The chroot needs unprivileged user namespaces
+	probe := exec.Command("true")
+	probe.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	if err := probe.Run(); err != nil {
+		t.Skipf("compiling in a chroot requires unprivileged user namespaces: %s", err)
+	}
+
+	stderr := &LockedBuffer{}
+	ui := termui.New(&bytes.Buffer{}, stderr, nil)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	releasePath := filepath.Join(workDir, "../test-assets/no-license")
+	releasePathBoshCache := filepath.Join(releasePath, "bosh-cache")
+	release, err := model.NewDevRelease(releasePath, "", "", releasePathBoshCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	tempDir, err := ioutil.TempDir("", "fissile-test-compile-chroot")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	// An archive of a real stemcell image can be given instead
+	archivePath := os.Getenv("FISSILE_TEST_STEMCELL_ARCHIVE")
+	if archivePath == "" {
+		archivePath = filepath.Join(tempDir, "stemcell.tar")
+		writeChrootTestArchive(t, archivePath)
+	}
+
+	rootfs, err := PrepareStemcellRootfs(archivePath, "", tempDir, ui)
+	if !assert.NoError(err) {
+		return
+	}
+
+	c, err := NewChrootCompilator(tempDir, StemcellRootfsDir(tempDir, rootfs.ImageID), "", "repo", "", "linux", "0", ui, nil)
+	assert.NoError(err)
+	c.SetStemcellImageID(rootfs.ImageID)
+
+	err = c.Compile(context.Background(), 2, []*model.Release{release}, nil, false)
+	assert.NoError(err, stderr.String())
+
+	for _, pkg := range release.Packages {
+		log, err := ioutil.ReadFile(PackageLogPath(pkg, tempDir))
+		if assert.NoError(err) {
+			assert.Contains(string(log), "Compiling to /var/vcap/packages/"+pkg.Name)
+		}
+		_, err = os.Stat(pkg.GetPackageCompiledDir(tempDir))
+		assert.NoError(err, "%s should be compiled", pkg.Name)
+	}
+}
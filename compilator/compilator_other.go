@@ -3,11 +3,16 @@
 package compilator
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/SUSE/fissile/model"
 )
 
-func (c *Compilator) compilePackageInMountNS(pkg *model.Package) (err error) {
+func (c *Compilator) compilePackageInMountNS(ctx context.Context, pkg *model.Package) (err error) {
+	return fmt.Errorf("Compilation without docker is not supported outside Linux")
+}
+
+func (c *Compilator) compilePackageInChroot(ctx context.Context, pkg *model.Package) (err error) {
 	return fmt.Errorf("Compilation without docker is not supported outside Linux")
 }
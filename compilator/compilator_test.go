@@ -2,10 +2,13 @@ package compilator
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/SUSE/fissile/docker"
 	"github.com/SUSE/fissile/model"
+	"github.com/SUSE/fissile/pkgcache"
 	"github.com/SUSE/fissile/scripts/compilation"
 	"github.com/SUSE/fissile/util"
 	"github.com/SUSE/termui"
@@ -48,12 +52,16 @@ func TestMain(m *testing.M) {
 func TestCompilationEmpty(t *testing.T) {
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil)
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", "", "", false, ui, nil)
 	assert.NoError(err)
 
 	waitCh := make(chan struct{})
 	go func() {
-		err := c.Compile(1, genTestCase(), nil, false)
+		err := c.Compile(context.Background(), 1, genTestCase(), nil, false)
 		close(waitCh)
 		assert.NoError(err)
 	}()
@@ -70,11 +78,15 @@ func TestCompilationBasic(t *testing.T) {
 	metrics := file.Name()
 	defer os.Remove(metrics)
 
-	c, err := NewDockerCompilator(nil, "", metrics, "", "", "", "", false, ui, nil)
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, metrics, "", "", "", "", "", false, ui, nil)
 	assert.NoError(err)
 
 	compileChan := make(chan string)
-	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
 		compileChan <- pkg.Name
 		return nil
 	}
@@ -83,11 +95,11 @@ func TestCompilationBasic(t *testing.T) {
 
 	waitCh := make(chan struct{})
 	go func() {
-		c.Compile(1, release, nil, false)
+		c.Compile(context.Background(), 1, release, nil, false)
 		close(waitCh)
 	}()
 
-	for _, expectedName := range []string{"ruby-2.5", "go-1.4", "consul"} {
+	for _, expectedName := range []string{"go-1.4", "consul", "ruby-2.5"} {
 		select {
 		case pkgName := <-compileChan:
 			assert.Equal(pkgName, expectedName)
@@ -102,12 +114,6 @@ func TestCompilationBasic(t *testing.T) {
 	}
 
 	expected := []string{
-		",compile-packages::test-release/ruby-2.5,start",
-		",compile-packages::wait::test-release/ruby-2.5,start",
-		",compile-packages::wait::test-release/ruby-2.5,done",
-		",compile-packages::run::test-release/ruby-2.5,start",
-		",compile-packages::run::test-release/ruby-2.5,done",
-		",compile-packages::test-release/ruby-2.5,done",
 		",compile-packages::test-release/go-1.4,start",
 		",compile-packages::wait::test-release/go-1.4,start",
 		",compile-packages::wait::test-release/go-1.4,done",
@@ -120,6 +126,12 @@ func TestCompilationBasic(t *testing.T) {
 		",compile-packages::run::test-release/consul,start",
 		",compile-packages::run::test-release/consul,done",
 		",compile-packages::test-release/consul,done",
+		",compile-packages::test-release/ruby-2.5,start",
+		",compile-packages::wait::test-release/ruby-2.5,start",
+		",compile-packages::wait::test-release/ruby-2.5,done",
+		",compile-packages::run::test-release/ruby-2.5,start",
+		",compile-packages::run::test-release/ruby-2.5,done",
+		",compile-packages::test-release/ruby-2.5,done",
 	}
 
 	contents, err := ioutil.ReadFile(metrics)
@@ -149,11 +161,15 @@ func TestCompilationSkipCompiled(t *testing.T) {
 
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil)
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", "", "", false, ui, nil)
 	assert.NoError(err)
 
 	compileChan := make(chan string)
-	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
 		compileChan <- pkg.Name
 		return nil
 	}
@@ -162,7 +178,7 @@ func TestCompilationSkipCompiled(t *testing.T) {
 
 	waitCh := make(chan struct{})
 	go func() {
-		c.Compile(1, release, nil, false)
+		c.Compile(context.Background(), 1, release, nil, false)
 		close(waitCh)
 	}()
 
@@ -171,14 +187,208 @@ func TestCompilationSkipCompiled(t *testing.T) {
 	<-waitCh
 }
 
+func TestCompilationPrecompiledRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	release, err := model.NewFinalRelease(filepath.Join(workDir, "../test-assets/test-compiled-release"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "ubuntu-trusty/3421.11", "", "", "", false, ui, nil)
+	assert.NoError(err)
+
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
+		assert.Fail("Precompiled package should not be compiled", pkg.Name)
+		return nil
+	}
+
+	if !assert.NoError(c.Compile(context.Background(), 1, []*model.Release{release}, nil, false)) {
+		return
+	}
+
+	for _, pkg := range release.Packages {
+		compiledFile := filepath.Join(pkg.GetPackageCompiledDir(compilationWorkDir), "bin", pkg.Name)
+		assert.NoError(util.ValidatePath(compiledFile, false, "compiled package file"))
+	}
+}
+
+func TestCompilationPrecompiledReleaseWrongStemcell(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	release, err := model.NewFinalRelease(filepath.Join(workDir, "../test-assets/test-compiled-release"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "opensuse-42.3/28.g837c5b3-30.79", "", "", "", false, ui, nil)
+	assert.NoError(err)
+
+	err = c.Compile(context.Background(), 1, []*model.Release{release}, nil, false)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "was compiled for stemcell ubuntu-trusty/3421.11")
+	}
+}
+
+func TestCompilationPackageCache(t *testing.T) {
+	assert := assert.New(t)
+
+	cacheDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(cacheDir)
+	backend, err := pkgcache.NewBackend(cacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+	cache := pkgcache.New(backend, "stemcell:latest")
+
+	// The first build compiles all packages, and pushes them to the cache
+	firstWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(firstWorkDir)
+
+	c, err := NewDockerCompilator(nil, firstWorkDir, "", "stemcell:latest", "", "", "", "", false, ui, nil)
+	assert.NoError(err)
+	c.UsePackageCache(cache, false)
+
+	var compiled []string
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
+		compiled = append(compiled, pkg.Name)
+		compiledDir := filepath.Join(pkg.GetPackageCompiledDir(c.hostWorkDir), "bin")
+		if err := os.MkdirAll(compiledDir, 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(compiledDir, pkg.Name), []byte(pkg.Fingerprint), 0755)
+	}
+
+	if !assert.NoError(c.Compile(context.Background(), 1, genTestCase("ruby-2.5", "consul>go-1.4", "go-1.4"), nil, false)) {
+		return
+	}
+	assert.Len(compiled, 3)
+
+	// Another build, with a fresh compilation directory, pulls them instead
+	secondWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(secondWorkDir)
+
+	c, err = NewDockerCompilator(nil, secondWorkDir, "", "stemcell:latest", "", "", "", "", false, ui, nil)
+	assert.NoError(err)
+	c.UsePackageCache(cache, true)
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
+		assert.Fail("Cached package should not be compiled", pkg.Name)
+		return nil
+	}
+
+	release := genTestCase("ruby-2.5", "consul>go-1.4", "go-1.4")
+	if !assert.NoError(c.Compile(context.Background(), 2, release, nil, false)) {
+		return
+	}
+	for _, pkg := range release[0].Packages {
+		contents, err := ioutil.ReadFile(filepath.Join(pkg.GetPackageCompiledDir(secondWorkDir), "bin", pkg.Name))
+		assert.NoError(err)
+		assert.Equal(pkg.Fingerprint, string(contents))
+		_, err = os.Stat(pkg.GetPackageCompiledTempDir(secondWorkDir))
+		assert.True(os.IsNotExist(err), "Temporary directory of %s is removed", pkg.Name)
+	}
+}
+
+// blockingBackend is a package cache backend whose uploads wait for a channel
+type blockingBackend struct {
+	pkgcache.Backend
+	unblock <-chan struct{}
+	puts    chan string
+}
+
+func (b *blockingBackend) Put(key string, contents io.Reader, size int64) error {
+	select {
+	case <-b.unblock:
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("Upload of %s was not unblocked", key)
+	}
+	b.puts <- key
+	return b.Backend.Put(key, contents, size)
+}
+
+func TestCompilationPackageCachePushInBackground(t *testing.T) {
+	assert := assert.New(t)
+
+	cacheDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(cacheDir)
+	local, err := pkgcache.NewBackend(cacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Uploads only proceed once the dependent package was compiled
+	dependentCompiled := make(chan struct{})
+	backend := &blockingBackend{Backend: local, unblock: dependentCompiled, puts: make(chan string, 10)}
+
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "stemcell:latest", "", "", "", "", false, ui, nil)
+	assert.NoError(err)
+	c.UsePackageCache(pkgcache.New(backend, "stemcell:latest"), false)
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
+		if pkg.Name == "consul" {
+			close(dependentCompiled)
+		}
+		return os.MkdirAll(pkg.GetPackageCompiledDir(c.hostWorkDir), 0755)
+	}
+
+	assert.NoError(c.Compile(context.Background(), 1, genTestCase("consul>go-1.4", "go-1.4"), nil, false))
+
+	// All uploads are done once the compilation returns
+	close(backend.puts)
+	var puts []string
+	for key := range backend.puts {
+		puts = append(puts, key)
+	}
+	assert.Len(puts, 4, "Both packages are pushed with their digests")
+}
+
 func TestCompilationRoleManifest(t *testing.T) {
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil)
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", "", "", false, ui, nil)
 	assert.NoError(err)
 
 	compileChan := make(chan string, 2)
-	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
 		compileChan <- pkg.Name
 		return nil
 	}
@@ -203,7 +413,7 @@ func TestCompilationRoleManifest(t *testing.T) {
 	waitCh := make(chan struct{})
 	errCh := make(chan error)
 	go func() {
-		errCh <- c.Compile(1, []*model.Release{release}, roleManifest.Roles, false)
+		errCh <- c.Compile(context.Background(), 1, []*model.Release{release}, roleManifest.Roles, false)
 	}()
 	go func() {
 		// `libevent` is a dependency of `tor` and will be compiled first
@@ -268,14 +478,14 @@ func doTestContainerKeptAfterCompilationWithErrors(t *testing.T, keepContainer b
 
 	imageName := "splatform/fissile-stemcell-opensuse:42.2"
 
-	comp, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", imageName, compilation.FakeBase, "3.14.15", "", keepContainer, ui, nil)
+	comp, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", imageName, "", compilation.FakeBase, "3.14.15", "", keepContainer, ui, nil)
 	assert.NoError(err)
 
 	beforeCompileContainers, err := getContainerIDs(imageName)
 	assert.NoError(err)
 
 	comp.baseType = compilation.FailBase
-	err = comp.compilePackageInDocker(release.Packages[0])
+	err = comp.compilePackageInDocker(context.Background(), release.Packages[0])
 	// We expect the package to fail this time.
 	assert.Error(err)
 	afterCompileContainers, err := getContainerIDs(imageName)
@@ -356,19 +566,128 @@ func TestCompilationMultipleErrors(t *testing.T) {
 
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil)
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", "", "", false, ui, nil)
 	assert.NoError(err)
 
-	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
 		return fmt.Errorf("Intentional error compiling %s", pkg.Name)
 	}
 
 	release := genTestCase("ruby-2.5", "consul>go-1.4", "go-1.4")
 
-	err = c.Compile(1, release, nil, false)
+	err = c.Compile(context.Background(), 1, release, nil, false)
 	assert.NotNil(err)
 }
 
+func TestCompilationInterrupted(t *testing.T) {
+	assert := assert.New(t)
+
+	compilationDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compilationDir)
+	workDir := StemcellCompilationDir(compilationDir, StemcellIdentity{Image: "stemcell:latest"})
+
+	c, err := NewDockerCompilator(nil, workDir, "", "stemcell:latest", "", "", "", "", false, ui, nil)
+	assert.NoError(err)
+	c.SetKeepGoing(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var compiled []string
+	var interruptedTempDir string
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
+		if pkg.Name != "consul" {
+			compiled = append(compiled, pkg.Name)
+			return nil
+		}
+		interruptedTempDir = pkg.GetPackageCompiledTempDir(c.hostWorkDir)
+		if err := os.MkdirAll(interruptedTempDir, 0755); err != nil {
+			return err
+		}
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	release := genTestCase("ruby-2.5", "consul>go-1.4", "go-1.4", "nginx>consul")
+	err = c.Compile(ctx, 1, release, nil, false)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Compilation was interrupted: 1 of 4 packages compiled, 3 not compiled")
+	}
+	assert.Equal([]string{"go-1.4"}, compiled, "Nothing is compiled once interrupted")
+
+	if assert.NotEmpty(interruptedTempDir) {
+		_, err = os.Stat(interruptedTempDir)
+		assert.True(os.IsNotExist(err), "The compiled-temp directory of the interrupted package must be removed")
+	}
+}
+
+func TestCompilationKeepGoing(t *testing.T) {
+	assert := assert.New(t)
+
+	compilationDir, err := ioutil.TempDir("", "fissile-tests")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(compilationDir)
+	workDir := StemcellCompilationDir(compilationDir, StemcellIdentity{Image: "stemcell:latest"})
+
+	c, err := NewDockerCompilator(nil, workDir, "", "stemcell:latest", "", "", "", "", false, ui, nil)
+	assert.NoError(err)
+	c.SetKeepGoing(true)
+
+	var mutex sync.Mutex
+	var compiled []string
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
+		if pkg.Name == "go-1.4" {
+			return fmt.Errorf("Intentional error compiling %s", pkg.Name)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		compiled = append(compiled, pkg.Name)
+		return nil
+	}
+
+	release := genTestCase("ruby-2.5", "consul>go-1.4", "go-1.4", "nginx>consul", "libevent")
+	err = c.Compile(context.Background(), 2, release, nil, false)
+	if assert.Error(err) {
+		assert.Equal("1 packages failed to compile (test-release/go-1.4), "+
+			"2 packages were skipped (test-release/consul, test-release/nginx)", err.Error())
+	}
+	sort.Strings(compiled)
+	assert.Equal([]string{"libevent", "ruby-2.5"}, compiled)
+
+	for _, stemcell := range []string{"", "stemcell:latest"} {
+		statuses, err := ReadPackageStatuses(compilationDir, stemcell)
+		if !assert.NoError(err) {
+			continue
+		}
+		var actual []string
+		for _, status := range statuses {
+			assert.Equal("stemcell:latest", status.Stemcell)
+			actual = append(actual, fmt.Sprintf("%s %s %s", status.Package, status.Status, status.Error))
+		}
+		assert.Equal([]string{
+			"consul skipped dependency test-release/go-1.4 failed",
+			"go-1.4 failed Intentional error compiling go-1.4",
+			"libevent compiled ",
+			"nginx skipped dependency test-release/consul failed",
+			"ruby-2.5 compiled ",
+		}, actual)
+	}
+
+	statuses, err := ReadPackageStatuses(compilationDir, "stemcell:other")
+	assert.NoError(err)
+	assert.Empty(statuses)
+}
+
 func TestGetPackageStatusCompiled(t *testing.T) {
 	assert := assert.New(t)
 
@@ -386,7 +705,7 @@ func TestGetPackageStatusCompiled(t *testing.T) {
 	// For this test we assume that the release does not have multiple packages with a single fingerprint
 	assert.NoError(err)
 
-	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", compilation.FakeBase, "3.14.15", "", false, ui, nil)
+	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", "", compilation.FakeBase, "3.14.15", "", false, ui, nil)
 	assert.NoError(err)
 
 	compiledPackagePath := filepath.Join(compilationWorkDir, release.Packages[0].Fingerprint, "compiled")
@@ -396,6 +715,7 @@ func TestGetPackageStatusCompiled(t *testing.T) {
 
 	err = ioutil.WriteFile(filepath.Join(compiledPackagePath, "foo"), []byte{}, 0700)
 	assert.NoError(err)
+	compilator.recordStemcell(release.Packages[0])
 
 	status, err := compilator.isPackageCompiled(release.Packages[0])
 
@@ -445,9 +765,13 @@ func TestCompilationParallel(t *testing.T) {
 
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil)
+	compilationWorkDir, err := ioutil.TempDir("", "fissile-tests")
 	assert.NoError(err)
-	c.compilePackage = func(c *Compilator, pkg *model.Package) error {
+	defer os.RemoveAll(compilationWorkDir)
+
+	c, err := NewDockerCompilator(nil, compilationWorkDir, "", "", "", "", "", "", false, ui, nil)
+	assert.NoError(err)
+	c.compilePackage = func(c *Compilator, ctx context.Context, pkg *model.Package) error {
 		mutex.Lock()
 		defer mutex.Unlock()
 		compiledPackages[pkg.Name] = true
@@ -468,7 +792,7 @@ func TestCompilationParallel(t *testing.T) {
 
 	testDoneCh := make(chan struct{})
 	go func() {
-		err = c.Compile(2, releases, nil, false)
+		err = c.Compile(context.Background(), 2, releases, nil, false)
 		assert.NoError(err)
 		close(testDoneCh)
 	}()
@@ -500,7 +824,7 @@ func TestGetPackageStatusNone(t *testing.T) {
 	// For this test we assume that the release does not have multiple packages with a single fingerprint
 	assert.NoError(err)
 
-	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", compilation.FakeBase, "3.14.15", "", false, ui, nil)
+	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", "", compilation.FakeBase, "3.14.15", "", false, ui, nil)
 	assert.NoError(err)
 
 	status, err := compilator.isPackageCompiled(release.Packages[0])
@@ -525,7 +849,7 @@ func TestPackageFolderStructure(t *testing.T) {
 	release, err := model.NewDevRelease(ntpReleasePath, "", "", ntpReleasePathBoshCache)
 	assert.NoError(err)
 
-	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", compilation.FakeBase, "3.14.15", "", false, ui, nil)
+	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", "", compilation.FakeBase, "3.14.15", "", false, ui, nil)
 	assert.NoError(err)
 
 	err = compilator.createCompilationDirStructure(release.Packages[0])
@@ -556,7 +880,7 @@ func TestPackageDependenciesPreparation(t *testing.T) {
 	release, err := model.NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
 	assert.NoError(err)
 
-	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", compilation.FakeBase, "3.14.15", "", false, ui, nil)
+	compilator, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", "fissile-test-compilator", "", compilation.FakeBase, "3.14.15", "", false, ui, nil)
 	assert.NoError(err)
 
 	pkg, err := release.LookupPackage("tor")
@@ -606,7 +930,7 @@ func doTestCompilePackageInDocker(t *testing.T, keepInContainer bool) {
 
 	imageName := "splatform/fissile-stemcell-opensuse:42.2"
 
-	comp, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", imageName, compilation.FakeBase, "3.14.15", "", keepInContainer, ui, nil)
+	comp, err := NewDockerCompilator(dockerManager, compilationWorkDir, "", imageName, "", compilation.FakeBase, "3.14.15", "", keepInContainer, ui, nil)
 	assert.NoError(err)
 
 	containerName := comp.getPackageContainerName(release.Packages[0])
@@ -623,7 +947,7 @@ func doTestCompilePackageInDocker(t *testing.T, keepInContainer bool) {
 	beforeCompileContainers, err := getContainerIDs(imageName)
 	assert.NoError(err)
 
-	err = comp.compilePackageInDocker(release.Packages[0])
+	err = comp.compilePackageInDocker(context.Background(), release.Packages[0])
 	assert.NoError(err)
 	afterCompileContainers, err := getContainerIDs(imageName)
 	assert.NoError(err)
@@ -668,9 +992,21 @@ func TestCreateDepBuckets(t *testing.T) {
 		},
 	}
 
-	buckets := createDepBuckets(packages)
+	// Without recorded durations, the longest chains come first
+	buckets := createDepBuckets(packages, nil, 1)
+	assert.Equal(t, len(buckets), 4)
+	assert.Equal(t, buckets[0].Name, "go-1.4")
+	assert.Equal(t, buckets[1].Name, "ruby-2.5")
+	assert.Equal(t, buckets[2].Name, "consul")
+	assert.Equal(t, buckets[3].Name, "cloud_controller_go")
+
+	// Slow packages are queued early
+	buckets = createDepBuckets(packages, map[string]time.Duration{
+		packageDurationKey(packages[3]): 10 * time.Minute,
+		packageDurationKey(packages[1]): time.Minute,
+	}, 1)
 	assert.Equal(t, len(buckets), 4)
-	assert.Equal(t, buckets[0].Name, "ruby-2.5") // Ruby should be first
+	assert.Equal(t, buckets[0].Name, "ruby-2.5")
 	assert.Equal(t, buckets[1].Name, "go-1.4")
 	assert.Equal(t, buckets[2].Name, "consul")
 	assert.Equal(t, buckets[3].Name, "cloud_controller_go")
@@ -703,17 +1039,77 @@ func TestCreateDepBucketsOnChain(t *testing.T) {
 		},
 	}
 
-	buckets := createDepBuckets(packages)
+	buckets := createDepBuckets(packages, nil, 1)
 	assert.Equal(t, len(buckets), 3)
 	assert.Equal(t, buckets[0].Name, "A")
 	assert.Equal(t, buckets[1].Name, "C")
 	assert.Equal(t, buckets[2].Name, "B")
 }
 
+func TestCreateDepBucketsMakespan(t *testing.T) {
+	t.Parallel()
+
+	// Three independent packages, and a chain of three packages, all
+	// taking a minute, compiled by two workers
+	chain := []*model.Package{{Name: "a", Fingerprint: "a"}}
+	chain = append(chain, &model.Package{Name: "b", Fingerprint: "b", Dependencies: chain[0:1]})
+	chain = append(chain, &model.Package{Name: "c", Fingerprint: "c", Dependencies: chain[1:2]})
+	packages := []*model.Package{
+		{Name: "x", Fingerprint: "x"},
+		{Name: "y", Fingerprint: "y"},
+		{Name: "z", Fingerprint: "z"},
+	}
+	packages = append(packages, chain...)
+	durations := map[string]time.Duration{}
+	for _, pkg := range packages {
+		durations[packageDurationKey(pkg)] = time.Minute
+	}
+
+	// Queueing the packages by number of dependencies leaves the chain
+	// to a single worker at the end
+	byDependencies := simulateCompilation(packages, durations, 2)
+	assert.Equal(t, 4*time.Minute, byDependencies)
+
+	buckets := createDepBuckets(packages, durations, 2)
+	if assert.Len(t, buckets, 6) {
+		assert.Equal(t, "a", buckets[0].Name)
+	}
+	assert.Equal(t, 3*time.Minute, simulateCompilation(buckets, durations, 2))
+}
+
+// simulateCompilation returns how long the workers take to compile the
+// packages, in queue order. Like the real workers, a worker takes the next
+// package from the queue when free, and waits for its dependencies.
+func simulateCompilation(queue []*model.Package, durations map[string]time.Duration, workerCount int) time.Duration {
+	free := make([]time.Duration, workerCount)
+	done := map[string]time.Duration{}
+	var makespan time.Duration
+	for _, pkg := range queue {
+		worker := 0
+		for i := range free {
+			if free[i] < free[worker] {
+				worker = i
+			}
+		}
+		start := free[worker]
+		for _, dep := range pkg.Dependencies {
+			if done[dep.Fingerprint] > start {
+				start = done[dep.Fingerprint]
+			}
+		}
+		done[pkg.Fingerprint] = start + durations[packageDurationKey(pkg)]
+		free[worker] = done[pkg.Fingerprint]
+		if free[worker] > makespan {
+			makespan = free[worker]
+		}
+	}
+	return makespan
+}
+
 func TestGatherPackages(t *testing.T) {
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil)
+	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", "", false, ui, nil)
 	assert.NoError(err)
 
 	releases := genTestCase("ruby-2.5", "go-1.4.1:G", "go-1.4:G")
@@ -736,7 +1132,7 @@ func TestRemoveCompiledPackages(t *testing.T) {
 
 	assert := assert.New(t)
 
-	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", false, ui, nil)
+	c, err := NewDockerCompilator(nil, "", "", "", "", "", "", "", false, ui, nil)
 	assert.NoError(err)
 
 	releases := genTestCase("ruby-2.5", "consul>go-1.4", "go-1.4")
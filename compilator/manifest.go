@@ -0,0 +1,79 @@
+package compilator
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/SUSE/fissile/model"
+	"github.com/SUSE/fissile/util"
+
+	"github.com/fatih/color"
+)
+
+// InstallCompiledPackage moves the compiled package from the source
+// directory into its place below the compilation directory, replacing the
+// package compiled earlier, if any. The manifest of the package is written
+// before it is moved, so that an interrupted installation leaves no compiled
+// package without its manifest.
+func InstallCompiledPackage(pkg *model.Package, sourceDir, workDir string) error {
+	manifest, err := util.NewDirManifest(sourceDir)
+	if err != nil {
+		return fmt.Errorf("Error creating the manifest of compiled package %s: %s", pkg.Name, err.Error())
+	}
+	if err := manifest.Write(pkg.GetPackageCompiledManifestPath(workDir)); err != nil {
+		return fmt.Errorf("Error writing the manifest of compiled package %s: %s", pkg.Name, err.Error())
+	}
+
+	compiledDir := pkg.GetPackageCompiledDir(workDir)
+	if err := os.RemoveAll(compiledDir); err != nil {
+		return err
+	}
+	return os.Rename(sourceDir, compiledDir)
+}
+
+// VerifyCompiledPackage checks the compiled package below the compilation
+// directory against its manifest. It returns false if the package has no
+// manifest, as for packages compiled by older versions of fissile, and an
+// error describing the differences if the package does not match it.
+func VerifyCompiledPackage(pkg *model.Package, workDir string) (bool, error) {
+	manifest, err := util.ReadDirManifest(pkg.GetPackageCompiledManifestPath(workDir))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, manifest.Verify(pkg.GetPackageCompiledDir(workDir))
+}
+
+// moveCompiledPackage installs the package compiled into the source
+// directory, see InstallCompiledPackage
+func (c *Compilator) moveCompiledPackage(pkg *model.Package, sourceDir string) error {
+	if err := InstallCompiledPackage(pkg, sourceDir, c.hostWorkDir); err != nil {
+		return err
+	}
+
+	c.verifiedMutex.Lock()
+	defer c.verifiedMutex.Unlock()
+	c.verifiedPackages[pkg.Fingerprint] = nil
+	return nil
+}
+
+// verifyCompiledPackage checks the compiled package against its manifest,
+// once per compilator. Packages which do not match are reported once, and
+// stay unverified until they are compiled again.
+func (c *Compilator) verifyCompiledPackage(pkg *model.Package) error {
+	c.verifiedMutex.Lock()
+	defer c.verifiedMutex.Unlock()
+
+	if err, ok := c.verifiedPackages[pkg.Fingerprint]; ok {
+		return err
+	}
+
+	_, err := VerifyCompiledPackage(pkg, c.hostWorkDir)
+	if err != nil {
+		c.ui.Printf("%s: Compiled package %s/%s does not match its manifest, compiling it again: %s\n",
+			color.YellowString("Warning"), pkg.Release.Name, pkg.Name, err.Error())
+	}
+	c.verifiedPackages[pkg.Fingerprint] = err
+	return err
+}
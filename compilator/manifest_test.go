@@ -0,0 +1,72 @@
+package compilator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledPackageManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-manifest-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	output := &bytes.Buffer{}
+	c, err := NewDockerCompilator(nil, workDir, "", "", "", "", "", "", false, termui.New(&bytes.Buffer{}, output, nil), nil)
+	require.NoError(t, err)
+
+	pkg := genTestCase("ruby-2.5")[0].Packages[0]
+	tempDir := pkg.GetPackageCompiledTempDir(workDir)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "bin", "ruby"), []byte("ruby"), 0755))
+	require.NoError(t, c.moveCompiledPackage(pkg, tempDir))
+	c.recordStemcell(pkg)
+
+	hasManifest, err := VerifyCompiledPackage(pkg, workDir)
+	assert.NoError(err)
+	assert.True(hasManifest)
+
+	compiled, err := c.isPackageCompiled(pkg)
+	assert.NoError(err)
+	assert.True(compiled)
+
+	// A fresh compilator notices the corruption, and warns once
+	require.NoError(t, ioutil.WriteFile(filepath.Join(pkg.GetPackageCompiledDir(workDir), "bin", "ruby"), []byte("ruby!"), 0755))
+	hasManifest, err = VerifyCompiledPackage(pkg, workDir)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "bin/ruby: size 5 instead of 4")
+	}
+	assert.True(hasManifest)
+
+	c, err = NewDockerCompilator(nil, workDir, "", "", "", "", "", "", false, termui.New(&bytes.Buffer{}, output, nil), nil)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		compiled, err = c.isPackageCompiled(pkg)
+		assert.NoError(err)
+		assert.False(compiled, "Corrupt packages must be compiled again")
+	}
+	assert.Equal(1, bytes.Count(output.Bytes(), []byte("does not match its manifest")))
+
+	// Compiling again replaces the package and its manifest
+	require.NoError(t, os.MkdirAll(tempDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "ruby"), []byte("ruby"), 0755))
+	require.NoError(t, c.moveCompiledPackage(pkg, tempDir))
+	c.recordStemcell(pkg)
+	compiled, err = c.isPackageCompiled(pkg)
+	assert.NoError(err)
+	assert.True(compiled)
+
+	// Packages compiled without a manifest are used as they are
+	require.NoError(t, os.Remove(pkg.GetPackageCompiledManifestPath(workDir)))
+	hasManifest, err = VerifyCompiledPackage(pkg, workDir)
+	assert.NoError(err)
+	assert.False(hasManifest)
+}
@@ -0,0 +1,132 @@
+package compilator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/SUSE/fissile/model"
+)
+
+// The states of packages in a compilation plan
+const (
+	PlanCached      = "cached"      // compiled already, in the compilation directory
+	PlanPrecompiled = "precompiled" // taken from a compiled release
+	PlanCompile     = "compile"     // to be compiled
+)
+
+// Plan is what Compile would do for a set of releases and roles
+type Plan struct {
+	Stemcell string           `json:"stemcell" yaml:"stemcell"`
+	Packages []PlannedPackage `json:"packages" yaml:"packages"`
+}
+
+// PlannedPackage is a package of a compilation plan. Depth is the length of
+// the longest chain of dependencies of the package, 0 for packages without
+// dependencies. Order is the position of packages to compile in the queue of
+// the workers, starting at 1.
+type PlannedPackage struct {
+	Release      string   `json:"release" yaml:"release"`
+	Name         string   `json:"name" yaml:"name"`
+	Fingerprint  string   `json:"fingerprint" yaml:"fingerprint"`
+	State        string   `json:"state" yaml:"state"`
+	Depth        int      `json:"depth" yaml:"depth"`
+	Order        int      `json:"order,omitempty" yaml:"order,omitempty"`
+	Dependencies []string `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+}
+
+// Plan returns what Compile would do for the releases and roles, without
+// compiling, or pulling from the package cache: the packages compiled
+// already, in release and package order, then the packages to compile, in
+// the order they are queued in for the workers, which takes the compile
+// durations recorded by earlier runs into account.
+func (c *Compilator) Plan(workerCount int, releases []*model.Release, roles model.Roles) (*Plan, error) {
+	// Gathering the packages registers them for compilation; planning
+	// must leave the compilator as it was
+	defer func(signalDependencies map[string]chan struct{}) {
+		c.signalDependencies = signalDependencies
+	}(c.signalDependencies)
+	c.signalDependencies = make(map[string]chan struct{})
+
+	packages := c.gatherPackages(releases, roles)
+
+	depths := map[string]int{}
+	var done model.Packages
+	var pending model.Packages
+	states := map[string]string{}
+	for _, pkg := range packages {
+		compiled, err := isPackageCompiledHarness(c, pkg)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case compiled:
+			states[pkg.Fingerprint] = PlanCached
+			done = append(done, pkg)
+		case pkg.IsCompiled():
+			if pkg.Stemcell != c.stemcell {
+				return nil, fmt.Errorf("package %s/%s was compiled for stemcell %s, but the stemcell is %q", pkg.Release.Name, pkg.Name, pkg.Stemcell, c.stemcell)
+			}
+			states[pkg.Fingerprint] = PlanPrecompiled
+			done = append(done, pkg)
+		default:
+			states[pkg.Fingerprint] = PlanCompile
+			pending = append(pending, pkg)
+		}
+	}
+
+	sort.Slice(done, func(i, j int) bool {
+		if done[i].Release.Name != done[j].Release.Name {
+			return done[i].Release.Name < done[j].Release.Name
+		}
+		return done[i].Name < done[j].Name
+	})
+	sort.Sort(pending)
+
+	durations, err := c.compileDurations()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Stemcell: c.stemcellImageName, Packages: []PlannedPackage{}}
+	for _, pkg := range done {
+		plan.Packages = append(plan.Packages, plannedPackage(pkg, states[pkg.Fingerprint], 0, depths))
+	}
+	for index, pkg := range createDepBuckets(pending, durations, workerCount) {
+		plan.Packages = append(plan.Packages, plannedPackage(pkg, PlanCompile, index+1, depths))
+	}
+
+	return plan, nil
+}
+
+func plannedPackage(pkg *model.Package, state string, order int, depths map[string]int) PlannedPackage {
+	planned := PlannedPackage{
+		Release:     pkg.Release.Name,
+		Name:        pkg.Name,
+		Fingerprint: pkg.Fingerprint,
+		State:       state,
+		Depth:       dependencyDepth(pkg, depths),
+		Order:       order,
+	}
+	for _, dep := range pkg.Dependencies {
+		planned.Dependencies = append(planned.Dependencies, fmt.Sprintf("%s/%s", dep.Release.Name, dep.Name))
+	}
+	sort.Strings(planned.Dependencies)
+	return planned
+}
+
+// dependencyDepth returns the length of the longest chain of dependencies of
+// the package, memoized by fingerprint
+func dependencyDepth(pkg *model.Package, depths map[string]int) int {
+	if depth, ok := depths[pkg.Fingerprint]; ok {
+		return depth
+	}
+	depth := 0
+	for _, dep := range pkg.Dependencies {
+		if depDepth := dependencyDepth(dep, depths) + 1; depDepth > depth {
+			depth = depDepth
+		}
+	}
+	depths[pkg.Fingerprint] = depth
+	return depth
+}
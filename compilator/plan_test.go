@@ -0,0 +1,40 @@
+package compilator
+
+import (
+	"testing"
+
+	"github.com/SUSE/fissile/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilationPlan(t *testing.T) {
+	assert := assert.New(t)
+
+	saveIsPackageCompiled := isPackageCompiledHarness
+	defer func() {
+		isPackageCompiledHarness = saveIsPackageCompiled
+	}()
+	isPackageCompiledHarness = func(c *Compilator, pkg *model.Package) (bool, error) {
+		return pkg.Name == "ruby-2.5", nil
+	}
+
+	c, err := NewDockerCompilator(nil, "", "", "stemcell:latest", "", "", "", "", false, ui, nil)
+	require.NoError(t, err)
+
+	plan, err := c.Plan(1, genTestCase("consul>go-1.4", "nginx>consul", "go-1.4", "ruby-2.5"), nil)
+	require.NoError(t, err)
+	assert.Equal("stemcell:latest", plan.Stemcell)
+	assert.Equal([]PlannedPackage{
+		{Release: "test-release", Name: "ruby-2.5", Fingerprint: "ruby-2.5", State: PlanCached},
+		{Release: "test-release", Name: "go-1.4", Fingerprint: "go-1.4", State: PlanCompile, Order: 1},
+		{Release: "test-release", Name: "consul", Fingerprint: "consul", State: PlanCompile, Order: 2, Depth: 1,
+			Dependencies: []string{"test-release/go-1.4"}},
+		{Release: "test-release", Name: "nginx", Fingerprint: "nginx", State: PlanCompile, Order: 3, Depth: 2,
+			Dependencies: []string{"test-release/consul"}},
+	}, plan.Packages)
+
+	// Planning leaves the compilator ready to compile
+	assert.Empty(c.signalDependencies)
+}
@@ -0,0 +1,169 @@
+package compilator
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SUSE/fissile/docker"
+	"github.com/SUSE/fissile/util"
+
+	"github.com/SUSE/termui"
+	"github.com/fatih/color"
+)
+
+// StemcellRootfsName is the name of the directory below the compilation
+// directory which holds the root filesystems of stemcell images, one per
+// image ID, and the records of the archives they were unpacked from
+const StemcellRootfsName = "rootfs"
+
+// chrootMountPoints are the directories of the stemcell root filesystem which
+// compilations mount over, see the chroot compilation script
+var chrootMountPoints = []string{"dev", "proc", "sys", "tmp", "var/vcap", docker.ContainerInPath, docker.ContainerOutPath}
+
+// StemcellRootfs records the archive the root filesystem of the stemcell was
+// unpacked from, and the ID of the stemcell image in it
+type StemcellRootfs struct {
+	Archive string    `json:"archive"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	ImageID string    `json:"image_id"`
+}
+
+// StemcellRootfsDir returns the directory the root filesystem of the stemcell
+// image with the ID is unpacked to, below the compilation directory
+func StemcellRootfsDir(compilationDir, imageID string) string {
+	return filepath.Join(compilationDir, StemcellRootfsName, fmt.Sprintf("%x", sha1.Sum([]byte(imageID))))
+}
+
+// stemcellRootfsRecordPath returns the path of the record of the archive,
+// below the compilation directory
+func stemcellRootfsRecordPath(compilationDir, archivePath string) string {
+	return filepath.Join(compilationDir, StemcellRootfsName, fmt.Sprintf("%x.json", sha1.Sum([]byte(archivePath))))
+}
+
+// PrepareStemcellRootfs unpacks the root filesystem of the stemcell image,
+// from an archive written by `docker save` or an OCI image layout tarball,
+// into the compilation directory, for compiling in it without docker, see
+// StemcellRootfsDir. The stemcell image name selects the image if the archive
+// holds several. The root filesystem is only unpacked again once the archive
+// changes; archives of different images, even if tagged the same, are
+// unpacked side by side.
+func PrepareStemcellRootfs(archivePath, stemcellImageName, compilationDir string, ui *termui.UI) (*StemcellRootfs, error) {
+	archivePath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the stemcell archive: %s", err.Error())
+	}
+	rootfs := &StemcellRootfs{
+		Archive: archivePath,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}
+
+	if recorded, err := ReadStemcellRootfs(compilationDir, archivePath); err == nil && recorded != nil &&
+		recorded.Size == rootfs.Size && recorded.ModTime.Equal(rootfs.ModTime) {
+		if _, err := os.Stat(StemcellRootfsDir(compilationDir, recorded.ImageID)); err == nil {
+			return recorded, nil
+		}
+	}
+
+	ui.Printf("Unpacking the stemcell root filesystem from %s ...\n", color.YellowString(archivePath))
+
+	// The image ID, and so where the root filesystem goes, is only known
+	// once unpacked; unpack next to the root filesystems, and only replace
+	// the one of the image once done
+	recordPath := stemcellRootfsRecordPath(compilationDir, archivePath)
+	if err := os.Remove(recordPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(recordPath), 0755); err != nil {
+		return nil, err
+	}
+	tempDir, err := ioutil.TempDir(filepath.Dir(recordPath), "unpack")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	tempRootfsDir := filepath.Join(tempDir, StemcellRootfsName)
+	rootfs.ImageID, err = util.ExtractImageArchive(archivePath, stemcellImageName, tempRootfsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, mountPoint := range chrootMountPoints {
+		if err := mkdirInRootfs(tempRootfsDir, mountPoint); err != nil {
+			return nil, err
+		}
+	}
+
+	rootfsDir := StemcellRootfsDir(compilationDir, rootfs.ImageID)
+	if err := os.RemoveAll(rootfsDir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tempRootfsDir, rootfsDir); err != nil {
+		return nil, err
+	}
+
+	buf, err := json.MarshalIndent(rootfs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(recordPath, append(buf, '\n'), 0644); err != nil {
+		return nil, err
+	}
+
+	return rootfs, nil
+}
+
+// ReadStemcellRootfs returns the record of the root filesystem of the
+// stemcell last unpacked from the archive into the compilation directory, or
+// nil if there is none
+func ReadStemcellRootfs(compilationDir, archivePath string) (*StemcellRootfs, error) {
+	archivePath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	recordPath := stemcellRootfsRecordPath(compilationDir, archivePath)
+	buf, err := ioutil.ReadFile(recordPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rootfs StemcellRootfs
+	if err := json.Unmarshal(buf, &rootfs); err != nil {
+		return nil, fmt.Errorf("Error reading the stemcell root filesystem record %s: %s", recordPath, err.Error())
+	}
+	return &rootfs, nil
+}
+
+// mkdirInRootfs creates the directory below the root filesystem, refusing to
+// follow symbolic links, which would point out of it
+func mkdirInRootfs(rootfsDir, dir string) error {
+	current := rootfsDir
+	for _, element := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current = filepath.Join(current, element)
+		info, err := os.Lstat(current)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(current, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !info.IsDir():
+			return fmt.Errorf("The stemcell root filesystem has no directory /%s to mount over", dir)
+		}
+	}
+	return nil
+}
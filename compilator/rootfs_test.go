@@ -0,0 +1,124 @@
+package compilator
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SUSE/termui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeStemcellArchive writes a `docker save` archive of the image
+// stemcell:latest, with the config and a single layer holding the files;
+// the image ID is the digest of the config, sha256:<config>
+func writeStemcellArchive(t *testing.T, archivePath, config string, files map[string]string) {
+	tarBytes := func(files map[string]string) []byte {
+		buf := &bytes.Buffer{}
+		tarWriter := tar.NewWriter(buf)
+		for name, contents := range files {
+			header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(contents))}
+			if contents == "->" {
+				header = &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: "/", Mode: 0777}
+			}
+			require.NoError(t, tarWriter.WriteHeader(header))
+			if header.Typeflag == tar.TypeReg {
+				_, err := tarWriter.Write([]byte(contents))
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, tarWriter.Close())
+		return buf.Bytes()
+	}
+
+	require.NoError(t, ioutil.WriteFile(archivePath, tarBytes(map[string]string{
+		"manifest.json":   `[{"Config": "` + config + `.json", "RepoTags": ["stemcell:latest"], "Layers": ["layer/layer.tar"]}]`,
+		config + ".json":  `{}`,
+		"layer/layer.tar": string(tarBytes(files)),
+	}), 0644))
+}
+
+func TestPrepareStemcellRootfs(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-rootfs-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	archivePath := filepath.Join(workDir, "stemcell.tar")
+	writeStemcellArchive(t, archivePath, "c0ffee", map[string]string{"etc/os-release": "leap"})
+
+	output := &bytes.Buffer{}
+	ui := termui.New(&bytes.Buffer{}, output, nil)
+
+	rootfs, err := PrepareStemcellRootfs(archivePath, "stemcell:latest", workDir, ui)
+	require.NoError(t, err)
+	assert.Equal("sha256:c0ffee", rootfs.ImageID)
+	assert.Equal(archivePath, rootfs.Archive)
+
+	contents, err := ioutil.ReadFile(filepath.Join(StemcellRootfsDir(workDir, rootfs.ImageID), "etc", "os-release"))
+	if assert.NoError(err) {
+		assert.Equal("leap", string(contents))
+	}
+	for _, mountPoint := range chrootMountPoints {
+		info, err := os.Stat(filepath.Join(StemcellRootfsDir(workDir, rootfs.ImageID), mountPoint))
+		if assert.NoError(err, mountPoint) {
+			assert.True(info.IsDir(), mountPoint)
+		}
+	}
+
+	recorded, err := ReadStemcellRootfs(workDir, archivePath)
+	if assert.NoError(err) && assert.NotNil(recorded) {
+		assert.Equal(*rootfs, *recorded)
+	}
+
+	// The root filesystem is unpacked once per archive
+	_, err = PrepareStemcellRootfs(archivePath, "stemcell:latest", workDir, ui)
+	assert.NoError(err)
+	assert.Equal(1, bytes.Count(output.Bytes(), []byte("Unpacking")))
+
+	writeStemcellArchive(t, archivePath, "c0ffee", map[string]string{"etc/os-release": "leap 15"})
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(archivePath, later, later))
+	_, err = PrepareStemcellRootfs(archivePath, "stemcell:latest", workDir, ui)
+	assert.NoError(err)
+	assert.Equal(2, bytes.Count(output.Bytes(), []byte("Unpacking")))
+	contents, err = ioutil.ReadFile(filepath.Join(StemcellRootfsDir(workDir, rootfs.ImageID), "etc", "os-release"))
+	if assert.NoError(err) {
+		assert.Equal("leap 15", string(contents))
+	}
+
+	// Archives of another image with the same name are unpacked next to it
+	otherArchivePath := filepath.Join(workDir, "other-stemcell.tar")
+	writeStemcellArchive(t, otherArchivePath, "decaf", map[string]string{"etc/os-release": "sle"})
+	other, err := PrepareStemcellRootfs(otherArchivePath, "stemcell:latest", workDir, ui)
+	if assert.NoError(err) {
+		assert.Equal("sha256:decaf", other.ImageID)
+		contents, err = ioutil.ReadFile(filepath.Join(StemcellRootfsDir(workDir, other.ImageID), "etc", "os-release"))
+		if assert.NoError(err) {
+			assert.Equal("sle", string(contents))
+		}
+	}
+	contents, err = ioutil.ReadFile(filepath.Join(StemcellRootfsDir(workDir, rootfs.ImageID), "etc", "os-release"))
+	if assert.NoError(err) {
+		assert.Equal("leap 15", string(contents))
+	}
+	_, err = PrepareStemcellRootfs(archivePath, "stemcell:latest", workDir, ui)
+	assert.NoError(err)
+	assert.Equal(3, bytes.Count(output.Bytes(), []byte("Unpacking")))
+
+	// Mount points must not lead out of the root filesystem
+	writeStemcellArchive(t, archivePath, "c0ffee", map[string]string{"tmp": "->"})
+	_, err = PrepareStemcellRootfs(archivePath, "stemcell:latest", workDir, ui)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "no directory /tmp to mount over")
+	}
+	recorded, err = ReadStemcellRootfs(workDir, archivePath)
+	assert.NoError(err)
+	assert.Nil(recorded, "A failed unpacking must not be recorded")
+}
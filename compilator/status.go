@@ -0,0 +1,223 @@
+package compilator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/SUSE/fissile/docker"
+	"github.com/SUSE/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+// The statuses recorded for the packages handled by Compile
+const (
+	StatusCompiled = "compiled" // compiled successfully
+	StatusCached   = "cached"   // pulled from the package cache
+	StatusFailed   = "failed"   // failed to compile
+	StatusSkipped  = "skipped"  // not compiled, as a dependency failed or compilation was aborted
+)
+
+const (
+	packageStatusFile = "status.json"
+	packageLogFile    = "compile.log"
+)
+
+// PackageStatus is the outcome of the last attempt to compile a package, as
+// recorded next to its compilation directory. Duration is the time spent
+// compiling, in seconds, for compiled packages.
+type PackageStatus struct {
+	Release     string    `json:"release" yaml:"release"`
+	Package     string    `json:"package" yaml:"package"`
+	Fingerprint string    `json:"fingerprint" yaml:"fingerprint"`
+	Stemcell    string    `json:"stemcell" yaml:"stemcell"`
+	Status      string    `json:"status" yaml:"status"`
+	Error       string    `json:"error,omitempty" yaml:"error,omitempty"`
+	Log         string    `json:"log,omitempty" yaml:"log,omitempty"`
+	Finished    time.Time `json:"finished" yaml:"finished"`
+	Duration    float64   `json:"duration,omitempty" yaml:"duration,omitempty"`
+}
+
+// PackageLogPath returns the path of the log of the last compilation of the
+// package, below the compilation directory
+func PackageLogPath(pkg *model.Package, workDir string) string {
+	return filepath.Join(workDir, pkg.Fingerprint, packageLogFile)
+}
+
+// recordStatus writes the status of the package next to its compilation
+// directory. Failing to do so is reported, but does not fail compilation.
+func (c *Compilator) recordStatus(pkg *model.Package, status string, compileErr error, duration time.Duration) {
+	packageStatus := PackageStatus{
+		Release:     pkg.Release.Name,
+		Package:     pkg.Name,
+		Fingerprint: pkg.Fingerprint,
+		Stemcell:    c.stemcellImageName,
+		Status:      status,
+		Finished:    time.Now().UTC(),
+		Duration:    duration.Seconds(),
+	}
+	if compileErr != nil {
+		packageStatus.Error = compileErr.Error()
+	}
+	if logPath := PackageLogPath(pkg, c.hostWorkDir); fileExists(logPath) {
+		packageStatus.Log = logPath
+	}
+
+	err := writePackageStatus(filepath.Join(c.hostWorkDir, pkg.Fingerprint, packageStatusFile), packageStatus)
+	if err != nil {
+		c.ui.Printf("%s: Could not record the status of %s/%s: %s\n",
+			color.YellowString("Warning"), pkg.Release.Name, pkg.Name, err.Error())
+	}
+}
+
+func writePackageStatus(statusPath string, status PackageStatus) error {
+	buf, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(statusPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statusPath, append(buf, '\n'), 0644)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// ReadPackageStatuses returns the statuses recorded below the compilation
+// directory for the stemcell image, or for all stemcell images if it is
+// empty, sorted by release and package name
+func ReadPackageStatuses(compilationDir, stemcellImageName string) ([]PackageStatus, error) {
+	// Each image may have been compiled on for several stemcells
+	statuses, err := readPackageStatuses(filepath.Join(compilationDir, "*", "*", packageStatusFile))
+	if err != nil {
+		return nil, err
+	}
+	if stemcellImageName != "" {
+		selected := []PackageStatus{}
+		for _, status := range statuses {
+			if status.Stemcell == stemcellImageName {
+				selected = append(selected, status)
+			}
+		}
+		statuses = selected
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Release != statuses[j].Release {
+			return statuses[i].Release < statuses[j].Release
+		}
+		if statuses[i].Package != statuses[j].Package {
+			return statuses[i].Package < statuses[j].Package
+		}
+		return statuses[i].Stemcell < statuses[j].Stemcell
+	})
+	return statuses, nil
+}
+
+// readPackageStatuses reads the status files matching the pattern
+func readPackageStatuses(pattern string) ([]PackageStatus, error) {
+	statusPaths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []PackageStatus{}
+	for _, statusPath := range statusPaths {
+		buf, err := ioutil.ReadFile(statusPath)
+		if err != nil {
+			return nil, err
+		}
+		var status PackageStatus
+		if err := json.Unmarshal(buf, &status); err != nil {
+			return nil, fmt.Errorf("Error reading the package status %s: %s", statusPath, err.Error())
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// compileDurations returns the durations recorded for the packages compiled
+// earlier in the compilation directory, keyed by release and package name,
+// see durationKey. For a package compiled more than once, in other versions,
+// the latest duration counts.
+func (c *Compilator) compileDurations() (map[string]time.Duration, error) {
+	statuses, err := readPackageStatuses(filepath.Join(c.hostWorkDir, "*", packageStatusFile))
+	if err != nil {
+		return nil, err
+	}
+
+	durations := map[string]time.Duration{}
+	finished := map[string]time.Time{}
+	for _, status := range statuses {
+		if status.Status != StatusCompiled || status.Duration <= 0 {
+			continue
+		}
+		key := durationKey(status.Release, status.Package)
+		if last, ok := finished[key]; ok && last.After(status.Finished) {
+			continue
+		}
+		finished[key] = status.Finished
+		durations[key] = time.Duration(status.Duration * float64(time.Second))
+	}
+	return durations, nil
+}
+
+// durationKey returns the key of the compile durations of a package, its
+// release and package name, so that durations carry over to new versions of
+// the package, but not to packages of the same name in other releases
+func durationKey(releaseName, packageName string) string {
+	return fmt.Sprintf("%s/%s", releaseName, packageName)
+}
+
+// packageDurationKey returns the key of the compile durations of the package
+func packageDurationKey(pkg *model.Package) string {
+	if pkg.Release == nil {
+		return durationKey("", pkg.Name)
+	}
+	return durationKey(pkg.Release.Name, pkg.Name)
+}
+
+// createPackageLog creates the log file for compiling the package, replacing
+// the log of any earlier attempt
+func (c *Compilator) createPackageLog(pkg *model.Package) (*os.File, error) {
+	logPath := PackageLogPath(pkg, c.hostWorkDir)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(logPath)
+}
+
+// packageLogWriter writes the output of compiling a package as it is to the
+// log file, and formatted to the log shown to the user. Closing it flushes
+// the formatted output.
+type packageLogWriter struct {
+	file      io.Writer
+	formatted *docker.FormattingWriter
+}
+
+func newPackageLogWriter(file, log io.Writer, formatter docker.StringFormatter) *packageLogWriter {
+	return &packageLogWriter{
+		file:      file,
+		formatted: docker.NewFormattingWriter(log, formatter),
+	}
+}
+
+func (w *packageLogWriter) Write(data []byte) (int, error) {
+	if _, err := w.file.Write(data); err != nil {
+		return 0, err
+	}
+	return w.formatted.Write(data)
+}
+
+func (w *packageLogWriter) Close() error {
+	return w.formatted.Close()
+}
@@ -0,0 +1,61 @@
+package compilator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageLogWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	file := &bytes.Buffer{}
+	log := &bytes.Buffer{}
+	writer := newPackageLogWriter(file, log, func(line string) string {
+		return "pkg > " + line
+	})
+
+	_, err := writer.Write([]byte("configure\nmake"))
+	assert.NoError(err)
+	assert.Equal("configure\nmake", file.String())
+	assert.Equal("pkg > configure\n", log.String())
+
+	assert.NoError(writer.Close())
+	assert.Equal("pkg > configure\npkg > make\n", log.String())
+}
+
+func TestCompileDurations(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-compile-durations")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	finished := time.Now().UTC()
+	for fingerprint, status := range map[string]PackageStatus{
+		"go-old":   {Release: "go", Package: "go", Status: StatusCompiled, Duration: 60, Finished: finished.Add(-time.Hour)},
+		"go-new":   {Release: "go", Package: "go", Status: StatusCompiled, Duration: 90, Finished: finished},
+		"other-go": {Release: "other", Package: "go", Status: StatusCompiled, Duration: 30, Finished: finished.Add(time.Hour)},
+		"ruby":     {Release: "ruby", Package: "ruby", Status: StatusCompiled, Duration: 600, Finished: finished},
+		"nginx":    {Release: "nginx", Package: "nginx", Status: StatusFailed, Duration: 5, Finished: finished},
+		"consul":   {Release: "consul", Package: "consul", Status: StatusCached, Finished: finished},
+		"unknown":  {Release: "unknown", Package: "unknown", Status: StatusCompiled, Finished: finished},
+	} {
+		require.NoError(t, writePackageStatus(filepath.Join(workDir, fingerprint, packageStatusFile), status))
+	}
+
+	c := &Compilator{hostWorkDir: workDir}
+	durations, err := c.compileDurations()
+	assert.NoError(err)
+	assert.Equal(map[string]time.Duration{
+		"go/go":     90 * time.Second,
+		"other/go":  30 * time.Second,
+		"ruby/ruby": 10 * time.Minute,
+	}, durations, "Packages of the same name in other releases are told apart")
+}
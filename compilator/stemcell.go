@@ -0,0 +1,287 @@
+package compilator
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/SUSE/fissile/model"
+
+	"github.com/fatih/color"
+)
+
+const packageStemcellFile = "stemcell.json"
+
+// StemcellIdentity identifies the stemcell packages are compiled on: the
+// stemcell image, its ID, and the BOSH stemcell (<os>/<version>) it is built
+// from. The image name alone does not identify the stemcell, as it may be
+// retagged to a newer image, or to another OS.
+type StemcellIdentity struct {
+	Image   string `json:"image" yaml:"image"`
+	ImageID string `json:"image_id,omitempty" yaml:"image_id,omitempty"`
+	OS      string `json:"os,omitempty" yaml:"os,omitempty"`
+}
+
+// Key returns the key of compiled packages for the stemcell, built from the
+// image ID when it is known, and from the image name otherwise, and the OS
+func (s StemcellIdentity) Key() string {
+	key := s.ImageID
+	if key == "" {
+		key = s.Image
+	}
+	if s.OS == "" {
+		return key
+	}
+	return fmt.Sprintf("%s %s", key, s.OS)
+}
+
+// Matches returns whether packages compiled on the other stemcell can be
+// used on this one, which takes both to be identified the same way. With
+// partial set, only the parts known for both stemcells are compared, for
+// packages whose stemcell was not fully recorded.
+func (s StemcellIdentity) Matches(other StemcellIdentity, partial bool) bool {
+	if !partial {
+		return s.Key() == other.Key()
+	}
+	if s.ImageID != "" && other.ImageID != "" && s.ImageID != other.ImageID {
+		return false
+	}
+	if s.OS != "" && other.OS != "" && s.OS != other.OS {
+		return false
+	}
+	return true
+}
+
+// StemcellCompilationDir returns the directory below the compilation
+// directory which holds the packages compiled on the stemcell. Each stemcell
+// has its own directory, so that packages compiled on several stemcells,
+// e.g. before and after retagging the stemcell image, are kept side by side.
+func StemcellCompilationDir(compilationDir string, stemcell StemcellIdentity) string {
+	return filepath.Join(compilationDir, fmt.Sprintf("%x", sha1.Sum([]byte(stemcell.Key()))))
+}
+
+// FindStemcellCompilationDir returns the directory below the compilation
+// directory which holds the packages compiled on the stemcell, which may be
+// known only in part, e.g. by image name alone. Of the stemcells packages were
+// compiled on matching the known parts, the one compiled on last is taken;
+// without any, the directory of the stemcell as given is returned. Packages
+// compiled without docker, on a host without the image, have no image ID
+// recorded; they are found for any image ID of the image, unless packages
+// were compiled for the image ID itself.
+func FindStemcellCompilationDir(compilationDir string, stemcell StemcellIdentity) (string, error) {
+	recordPaths, err := filepath.Glob(filepath.Join(compilationDir, "*", packageStemcellFile))
+	if err != nil {
+		return "", err
+	}
+
+	// The directories found for the image ID, and for no image ID
+	var found, foundWithoutID string
+	var foundTime, foundWithoutIDTime time.Time
+	for _, recordPath := range recordPaths {
+		recorded, err := readStemcellIdentity(recordPath)
+		if err != nil {
+			return "", err
+		}
+		if (stemcell.Image != "" && stemcell.Image != recorded.Image) ||
+			(stemcell.OS != "" && stemcell.OS != recorded.OS) {
+			continue
+		}
+		withoutID := false
+		if stemcell.ImageID != "" && stemcell.ImageID != recorded.ImageID {
+			if recorded.ImageID != "" {
+				continue
+			}
+			withoutID = true
+		}
+
+		info, err := os.Stat(recordPath)
+		if err != nil {
+			return "", err
+		}
+		if withoutID {
+			if foundWithoutIDTime.IsZero() || info.ModTime().After(foundWithoutIDTime) {
+				foundWithoutID = filepath.Dir(recordPath)
+				foundWithoutIDTime = info.ModTime()
+			}
+		} else if foundTime.IsZero() || info.ModTime().After(foundTime) {
+			found = filepath.Dir(recordPath)
+			foundTime = info.ModTime()
+		}
+	}
+
+	switch {
+	case found != "":
+		return found, nil
+	case foundWithoutID != "":
+		return foundWithoutID, nil
+	default:
+		return StemcellCompilationDir(compilationDir, stemcell), nil
+	}
+}
+
+// ReadStemcellCompilationDir returns the stemcell recorded for the directory
+// of compiled packages, or nil if none is recorded
+func ReadStemcellCompilationDir(workDir string) (*StemcellIdentity, error) {
+	identity, err := readStemcellIdentity(filepath.Join(workDir, packageStemcellFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return identity, err
+}
+
+// SetStemcellImageID sets the ID of the stemcell image; it is recorded for
+// compiled packages, and packages recorded for another image ID are compiled
+// again
+func (c *Compilator) SetStemcellImageID(imageID string) {
+	c.stemcellImageID = imageID
+}
+
+// ReuseUnrecordedPackages makes the compilator use packages for which the
+// stemcell was not recorded, or only in part, as compiled by older versions
+// of fissile, instead of compiling them again. Such packages found in the
+// directory of older versions, keyed by the stemcell image name alone, are
+// moved into the compilation directory, recording the stemcell for them.
+func (c *Compilator) ReuseUnrecordedPackages(unrecordedDir string) {
+	c.unrecordedDir = unrecordedDir
+}
+
+// StemcellIdentity returns the identity of the stemcell the compilator
+// compiles on
+func (c *Compilator) StemcellIdentity() StemcellIdentity {
+	return StemcellIdentity{
+		Image:   c.stemcellImageName,
+		ImageID: c.stemcellImageID,
+		OS:      c.stemcell,
+	}
+}
+
+// PackageStemcellPath returns the path of the stemcell recorded for the
+// compiled package, below the compilation directory
+func PackageStemcellPath(pkg *model.Package, workDir string) string {
+	return filepath.Join(workDir, pkg.Fingerprint, packageStemcellFile)
+}
+
+// ReadPackageStemcell returns the stemcell recorded for the compiled
+// package, or nil if none is recorded, as for packages compiled by older
+// versions of fissile
+func ReadPackageStemcell(pkg *model.Package, workDir string) (*StemcellIdentity, error) {
+	identity, err := readStemcellIdentity(PackageStemcellPath(pkg, workDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return identity, err
+}
+
+func readStemcellIdentity(recordPath string) (*StemcellIdentity, error) {
+	buf, err := ioutil.ReadFile(recordPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var identity StemcellIdentity
+	if err := json.Unmarshal(buf, &identity); err != nil {
+		return nil, fmt.Errorf("Error reading the stemcell record %s: %s", recordPath, err.Error())
+	}
+	return &identity, nil
+}
+
+// writeStemcellIdentity writes the stemcell record at the path
+func writeStemcellIdentity(recordPath string, identity StemcellIdentity) error {
+	buf, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(recordPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(recordPath, append(buf, '\n'), 0644)
+}
+
+// RecordPackageStemcell records the stemcell the package below the
+// compilation directory was compiled on
+func RecordPackageStemcell(pkg *model.Package, workDir string, stemcell StemcellIdentity) error {
+	return writeStemcellIdentity(PackageStemcellPath(pkg, workDir), stemcell)
+}
+
+// recordStemcell writes the stemcell next to the compiled package. Failing
+// to do so is reported, but does not fail compilation.
+func (c *Compilator) recordStemcell(pkg *model.Package) {
+	if err := RecordPackageStemcell(pkg, c.hostWorkDir, c.StemcellIdentity()); err != nil {
+		c.ui.Printf("%s: Could not record the stemcell of %s/%s: %s\n",
+			color.YellowString("Warning"), pkg.Release.Name, pkg.Name, err.Error())
+	}
+}
+
+// RecordStemcellCompilationDir records the stemcell for the directory of
+// packages compiled on it, for FindStemcellCompilationDir; it is recorded
+// again at each compilation, so that the stemcell compiled on last is found
+func RecordStemcellCompilationDir(workDir string, stemcell StemcellIdentity) error {
+	return writeStemcellIdentity(filepath.Join(workDir, packageStemcellFile), stemcell)
+}
+
+// matchesStemcell returns whether the compiled package was compiled on the
+// stemcell of the compilator. Packages without a recorded stemcell, or with
+// a partial one, only match if they are to be reused, see
+// ReuseUnrecordedPackages.
+func (c *Compilator) matchesStemcell(pkg *model.Package, workDir string) (bool, error) {
+	stemcell, err := ReadPackageStemcell(pkg, workDir)
+	if err != nil {
+		return false, err
+	}
+	reuseUnrecorded := c.unrecordedDir != ""
+	if stemcell == nil {
+		return reuseUnrecorded, nil
+	}
+	return c.StemcellIdentity().Matches(*stemcell, reuseUnrecorded), nil
+}
+
+// adoptUnrecordedPackages moves the packages compiled in the directory of
+// older versions of fissile into the compilation directory, see
+// ReuseUnrecordedPackages
+func (c *Compilator) adoptUnrecordedPackages(packages model.Packages) error {
+	if c.unrecordedDir == "" || filepath.Clean(c.unrecordedDir) == filepath.Clean(c.hostWorkDir) {
+		return nil
+	}
+
+	for _, pkg := range packages {
+		compiled, err := isPackageCompiledHarness(c, pkg)
+		if err != nil {
+			return err
+		}
+		if compiled {
+			continue
+		}
+
+		if _, err := os.Stat(pkg.GetPackageCompiledDir(c.unrecordedDir)); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		matches, err := c.matchesStemcell(pkg, c.unrecordedDir)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			continue
+		}
+
+		target := filepath.Join(c.hostWorkDir, pkg.Fingerprint)
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(c.hostWorkDir, 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(c.unrecordedDir, pkg.Fingerprint), target); err != nil {
+			return err
+		}
+		c.recordStemcell(pkg)
+		c.ui.Printf("reused: %s/%s compiled without a recorded stemcell\n",
+			color.YellowString(pkg.Release.Name), color.YellowString(pkg.Name))
+	}
+	return nil
+}
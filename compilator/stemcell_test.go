@@ -0,0 +1,195 @@
+package compilator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStemcellIdentity(t *testing.T) {
+	assert := assert.New(t)
+
+	byName := StemcellIdentity{Image: "stemcell:latest"}
+	leap := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:aaa", OS: "opensuse-42.3/30.1"}
+	trusty := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:bbb", OS: "ubuntu-trusty/3421.11"}
+	rebuilt := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:ccc", OS: "opensuse-42.3/30.1"}
+
+	assert.Equal("stemcell:latest", byName.Key())
+	assert.Equal("sha256:aaa opensuse-42.3/30.1", leap.Key())
+
+	assert.True(leap.Matches(leap, false))
+	assert.False(leap.Matches(byName, false), "Partial identities only match if allowed")
+	assert.True(leap.Matches(byName, true), "Unknown parts are not compared")
+	assert.False(leap.Matches(trusty, true))
+	assert.False(leap.Matches(rebuilt, true))
+	assert.False(leap.Matches(StemcellIdentity{OS: "ubuntu-trusty/3421.11"}, true))
+
+	assert.NotEqual(StemcellCompilationDir("/work", leap), StemcellCompilationDir("/work", rebuilt),
+		"Packages of a retagged image are kept apart")
+}
+
+func TestFindStemcellCompilationDir(t *testing.T) {
+	assert := assert.New(t)
+
+	compilationDir, err := ioutil.TempDir("", "fissile-stemcell-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(compilationDir)
+
+	byName := StemcellIdentity{Image: "stemcell:latest"}
+	leap := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:aaa", OS: "opensuse-42.3/30.1"}
+	rebuilt := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:ccc", OS: "opensuse-42.3/30.1"}
+
+	found, err := FindStemcellCompilationDir(compilationDir, byName)
+	assert.NoError(err)
+	assert.Equal(StemcellCompilationDir(compilationDir, byName), found, "Without records, the directory of the stemcell as given is used")
+
+	earlier := time.Now().Add(-time.Hour)
+	for _, stemcell := range []StemcellIdentity{leap, rebuilt} {
+		require.NoError(t, RecordStemcellCompilationDir(StemcellCompilationDir(compilationDir, stemcell), stemcell))
+	}
+	recordPath := filepath.Join(StemcellCompilationDir(compilationDir, leap), packageStemcellFile)
+	require.NoError(t, os.Chtimes(recordPath, earlier, earlier))
+
+	found, err = FindStemcellCompilationDir(compilationDir, byName)
+	assert.NoError(err)
+	assert.Equal(StemcellCompilationDir(compilationDir, rebuilt), found, "The stemcell compiled on last is found")
+
+	found, err = FindStemcellCompilationDir(compilationDir, StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:aaa"})
+	assert.NoError(err)
+	assert.Equal(StemcellCompilationDir(compilationDir, leap), found)
+
+	recorded, err := ReadStemcellCompilationDir(found)
+	if assert.NoError(err) && assert.NotNil(recorded) {
+		assert.Equal(leap, *recorded)
+	}
+
+	// Packages compiled without docker, on a host without the image, have no
+	// image ID recorded; they are found for the image ID known elsewhere,
+	// unless there are packages compiled for the image ID itself
+	withoutID := StemcellIdentity{Image: "stemcell:latest", OS: "opensuse-42.3/30.1"}
+	require.NoError(t, RecordStemcellCompilationDir(StemcellCompilationDir(compilationDir, withoutID), withoutID))
+	for _, test := range []struct {
+		stemcell StemcellIdentity
+		expected StemcellIdentity
+	}{
+		{StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:ddd", OS: "opensuse-42.3/30.1"}, withoutID},
+		{StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:ddd"}, withoutID},
+		{StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:aaa", OS: "opensuse-42.3/30.1"}, leap},
+	} {
+		found, err = FindStemcellCompilationDir(compilationDir, test.stemcell)
+		assert.NoError(err)
+		assert.Equal(StemcellCompilationDir(compilationDir, test.expected), found, "Looking up %s", test.stemcell.Key())
+	}
+
+	other := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:ddd", OS: "ubuntu-trusty/3421.11"}
+	found, err = FindStemcellCompilationDir(compilationDir, other)
+	assert.NoError(err)
+	assert.Equal(StemcellCompilationDir(compilationDir, other), found, "Packages of another stemcell are not found")
+}
+
+func TestIsPackageCompiledStemcell(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := ioutil.TempDir("", "fissile-stemcell-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(workDir)
+
+	pkg := genTestCase("ruby-2.5")[0].Packages[0]
+	require.NoError(t, os.MkdirAll(pkg.GetPackageCompiledDir(workDir), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(pkg.GetPackageCompiledDir(workDir), "ruby"), []byte{}, 0755))
+
+	leap, err := NewDockerCompilator(nil, workDir, "", "stemcell:latest", "opensuse-42.3/30.1", "", "", "", false, ui, nil)
+	require.NoError(t, err)
+	leap.SetStemcellImageID("sha256:aaa")
+	trusty, err := NewDockerCompilator(nil, workDir, "", "stemcell:latest", "ubuntu-trusty/3421.11", "", "", "", false, ui, nil)
+	require.NoError(t, err)
+	trusty.SetStemcellImageID("sha256:bbb")
+
+	// Packages compiled without a recorded stemcell are compiled again,
+	// unless they are to be reused
+	compiled, err := trusty.isPackageCompiled(pkg)
+	assert.NoError(err)
+	assert.False(compiled)
+
+	trusty.ReuseUnrecordedPackages(workDir)
+	compiled, err = trusty.isPackageCompiled(pkg)
+	assert.NoError(err)
+	assert.True(compiled)
+	trusty.ReuseUnrecordedPackages("")
+
+	leap.recordStemcell(pkg)
+	stemcell, err := ReadPackageStemcell(pkg, workDir)
+	if assert.NoError(err) && assert.NotNil(stemcell) {
+		assert.Equal(leap.StemcellIdentity(), *stemcell)
+	}
+
+	compiled, err = leap.isPackageCompiled(pkg)
+	assert.NoError(err)
+	assert.True(compiled)
+
+	compiled, err = trusty.isPackageCompiled(pkg)
+	assert.NoError(err)
+	assert.False(compiled, "Packages compiled on another stemcell must be compiled again")
+
+	// Compiling again replaces the package
+	require.NoError(t, os.MkdirAll(pkg.GetPackageCompiledTempDir(workDir), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(pkg.GetPackageCompiledTempDir(workDir), "ruby-trusty"), []byte{}, 0755))
+	assert.NoError(trusty.moveCompiledPackage(pkg, pkg.GetPackageCompiledTempDir(workDir)))
+	trusty.recordStemcell(pkg)
+
+	entries, err := ioutil.ReadDir(pkg.GetPackageCompiledDir(workDir))
+	if assert.NoError(err) && assert.Len(entries, 1) {
+		assert.Equal("ruby-trusty", entries[0].Name())
+	}
+	compiled, err = trusty.isPackageCompiled(pkg)
+	assert.NoError(err)
+	assert.True(compiled)
+}
+
+func TestAdoptUnrecordedPackages(t *testing.T) {
+	assert := assert.New(t)
+
+	compilationDir, err := ioutil.TempDir("", "fissile-stemcell-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(compilationDir)
+
+	unrecordedDir := StemcellCompilationDir(compilationDir, StemcellIdentity{Image: "stemcell:latest"})
+	packages := genTestCase("ruby-2.5", "go-1.4")[0].Packages
+	for _, pkg := range packages {
+		require.NoError(t, os.MkdirAll(pkg.GetPackageCompiledDir(unrecordedDir), 0755))
+	}
+
+	leap := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:aaa", OS: "opensuse-42.3/30.1"}
+	workDir := StemcellCompilationDir(compilationDir, leap)
+	c, err := NewDockerCompilator(nil, workDir, "", leap.Image, leap.OS, "", "", "", false, ui, nil)
+	require.NoError(t, err)
+	c.SetStemcellImageID(leap.ImageID)
+
+	// Nothing is taken over unless asked to
+	assert.NoError(c.adoptUnrecordedPackages(packages))
+	_, err = os.Stat(packages[0].GetPackageCompiledDir(workDir))
+	assert.True(os.IsNotExist(err))
+
+	// Packages recorded for another stemcell are left alone
+	other := StemcellIdentity{Image: "stemcell:latest", ImageID: "sha256:bbb"}
+	require.NoError(t, RecordPackageStemcell(packages[1], unrecordedDir, other))
+
+	c.ReuseUnrecordedPackages(unrecordedDir)
+	assert.NoError(c.adoptUnrecordedPackages(packages))
+
+	_, err = os.Stat(packages[0].GetPackageCompiledDir(workDir))
+	assert.NoError(err, "Unrecorded packages are moved into the compilation directory")
+	stemcell, err := ReadPackageStemcell(packages[0], workDir)
+	if assert.NoError(err) && assert.NotNil(stemcell) {
+		assert.Equal(leap, *stemcell)
+	}
+	_, err = os.Stat(packages[1].GetPackageCompiledDir(workDir))
+	assert.True(os.IsNotExist(err), "Packages of another image ID must not be adopted")
+	_, err = os.Stat(packages[1].GetPackageCompiledDir(unrecordedDir))
+	assert.NoError(err)
+}
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/url"
@@ -44,6 +45,7 @@ type dockerClient interface {
 	ImageHistory(string) ([]dockerclient.ImageHistory, error)
 	InspectImage(string) (*dockerclient.Image, error)
 	ListImages(dockerclient.ListImagesOptions) ([]dockerclient.APIImages, error)
+	KillContainer(dockerclient.KillContainerOptions) error
 	ListVolumes(dockerclient.ListVolumesOptions) ([]dockerclient.Volume, error)
 	RemoveContainer(dockerclient.RemoveContainerOptions) error
 	RemoveImage(string) error
@@ -143,8 +145,9 @@ func (w *FormattingWriter) color(s string) string {
 	return s
 }
 
-// BuildImage builds a docker image using a directory that contains a Dockerfile
-func (d *ImageManager) BuildImage(dockerfileDirPath, name string, stdoutWriter io.WriteCloser) error {
+// BuildImage builds a docker image using a directory that contains a Dockerfile.
+// It returns early if the context is cancelled.
+func (d *ImageManager) BuildImage(ctx context.Context, dockerfileDirPath, name string, stdoutWriter io.WriteCloser) error {
 
 	bio := dockerclient.BuildImageOptions{
 		Name:         name,
@@ -170,14 +173,15 @@ func (d *ImageManager) BuildImage(dockerfileDirPath, name string, stdoutWriter i
 		}()
 	}
 
-	return d.client.BuildImage(bio)
+	return d.buildImage(ctx, bio)
 }
 
 // BuildImageFromCallback builds a docker image by letting a callback populate
 // a tar.Writer; the callback must write a Dockerfile into the tar stream (as
 // well as any additional build context).  If stdoutWriter implements io.Closer,
-// it will be closed when done.
-func (d *ImageManager) BuildImageFromCallback(name string, stdoutWriter io.Writer, callback func(*tar.Writer) error) error {
+// it will be closed when done. If the context is cancelled, the build context
+// stream is cut off and the build returns early.
+func (d *ImageManager) BuildImageFromCallback(ctx context.Context, name string, stdoutWriter io.Writer, callback func(*tar.Writer) error) error {
 	pipeReader, pipeWriter, err := os.Pipe()
 	if err != nil {
 		return err
@@ -219,7 +223,13 @@ func (d *ImageManager) BuildImageFromCallback(name string, stdoutWriter io.Write
 		writerErrorChan <- err
 	}()
 
-	err = d.client.BuildImage(bio)
+	err = d.buildImage(ctx, bio)
+	if ctx.Err() != nil {
+		// Stop the callback, which is blocked on the pipe otherwise
+		pipeReader.Close()
+		<-writerErrorChan
+		return ctx.Err()
+	}
 
 	// Prefer returning the error from the tar writer; that normally
 	// has more useful details.
@@ -230,6 +240,29 @@ func (d *ImageManager) BuildImageFromCallback(name string, stdoutWriter io.Write
 	return err
 }
 
+// buildImage runs a docker build, returning early if the context is
+// cancelled. The vendored docker client can not cancel the request, so the
+// build is abandoned then, rather than stopped: if the build context is still
+// being sent, closing its pipe fails the request, but once it is sent the
+// daemon finishes the build in the background.
+func (d *ImageManager) buildImage(ctx context.Context, bio dockerclient.BuildImageOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.client.BuildImage(bio)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // FindImage will lookup an image in Docker
 func (d *ImageManager) FindImage(imageName string) (*dockerclient.Image, error) {
 	image, err := d.client.InspectImage(imageName)
@@ -449,8 +482,13 @@ type RunInContainerOpts struct {
 	StderrWriter  io.Writer
 }
 
-// RunInContainer will execute a set of commands within a running Docker container
-func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (exitCode int, container *dockerclient.Container, err error) {
+// RunInContainer will execute a set of commands within a running Docker container.
+// If the context is cancelled, the container is killed and the context's
+// error is returned; removing the container stays up to the caller.
+func (d *ImageManager) RunInContainer(ctx context.Context, opts RunInContainerOpts) (exitCode int, container *dockerclient.Container, err error) {
+	if err := ctx.Err(); err != nil {
+		return -1, nil, err
+	}
 
 	// Get current user info to map to container
 	// os/user.Current() isn't supported when cross-compiling hence this code
@@ -581,7 +619,11 @@ func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (exitCode int, co
 	}
 
 	if !opts.KeepContainer {
-		exitCode, err = d.client.WaitContainer(container.ID)
+		exitCode, err = d.waitContainer(ctx, container.ID)
+		if ctx.Err() != nil {
+			closeFiles()
+			return -1, container, err
+		}
 		attachCloseWaiter.Wait()
 		closeFiles()
 		if err != nil {
@@ -595,7 +637,7 @@ func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (exitCode int, co
 	cmdArgs := append([]string{"exec", "-i", container.ID}, actualCmd...)
 
 	// Couldn't get this to work with dockerclient.Exec, so do it this way
-	execCmd := exec.Command("docker", cmdArgs...)
+	execCmd := exec.CommandContext(ctx, "docker", cmdArgs...)
 	execCmd.Stdout = opts.StdoutWriter
 	execCmd.Stderr = opts.StderrWriter
 	err = execCmd.Run()
@@ -606,9 +648,39 @@ func (d *ImageManager) RunInContainer(opts RunInContainerOpts) (exitCode int, co
 		exitCode = -1
 	}
 	closeFiles()
+	if ctx.Err() != nil {
+		return exitCode, container, ctx.Err()
+	}
 	return exitCode, container, err
 }
 
+// waitContainer waits for a container to exit, and returns its exit code. If
+// the context is cancelled first, the container is killed instead.
+func (d *ImageManager) waitContainer(ctx context.Context, containerID string) (int, error) {
+	type waitResult struct {
+		exitCode int
+		err      error
+	}
+	resultCh := make(chan waitResult, 1)
+	go func() {
+		exitCode, err := d.client.WaitContainer(containerID)
+		resultCh <- waitResult{exitCode: exitCode, err: err}
+	}()
+
+	select {
+	case result := <-resultCh:
+		return result.exitCode, result.err
+	case <-ctx.Done():
+	}
+
+	err := d.client.KillContainer(dockerclient.KillContainerOptions{ID: containerID})
+	if err != nil {
+		return -1, fmt.Errorf("Error killing container %s: %s", containerID, err.Error())
+	}
+	<-resultCh
+	return -1, ctx.Err()
+}
+
 // RemoveVolumes removes any temporary volumes assoicated with a container
 func (d *ImageManager) RemoveVolumes(container *dockerclient.Container) error {
 	volumes, err := d.client.ListVolumes(dockerclient.ListVolumesOptions{})
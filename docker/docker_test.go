@@ -3,6 +3,7 @@ package docker
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -94,7 +95,7 @@ func TestRunInContainer(t *testing.T) {
 	stdoutWriter := &bytes.Buffer{}
 	stderrWriter := &bytes.Buffer{}
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"hostname"},
@@ -113,6 +114,56 @@ func TestRunInContainer(t *testing.T) {
 	assert.NoError(err)
 }
 
+func TestRunInContainerCancel(t *testing.T) {
+	assert := assert.New(t)
+	mockCtl := gomock.NewController(t)
+	defer mockCtl.Finish()
+
+	mockDockerClient := NewMockdockerClient(mockCtl)
+	dockerManager := &ImageManager{
+		client: mockDockerClient,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	killed := make(chan struct{})
+	container := &dockerclient.Container{ID: "compiling", HostConfig: &dockerclient.HostConfig{}}
+	closeWaiter := &mockCloseWaiter{}
+
+	mockDockerClient.EXPECT().CreateContainer(gomock.Any()).Return(container, nil)
+	mockDockerClient.EXPECT().AttachToContainerNonBlocking(gomock.Any()).Do(
+		func(opts dockerclient.AttachToContainerOptions) {
+			go func() {
+				opts.Success <- struct{}{}
+				<-opts.Success
+			}()
+		}).Return(closeWaiter, nil)
+	mockDockerClient.EXPECT().StartContainer(container.ID, container.HostConfig).Do(
+		func(string, *dockerclient.HostConfig) { cancel() }).Return(nil)
+	mockDockerClient.EXPECT().WaitContainer(container.ID).Do(
+		func(string) { <-killed }).Return(137, nil)
+	mockDockerClient.EXPECT().KillContainer(dockerclient.KillContainerOptions{ID: container.ID}).Do(
+		func(dockerclient.KillContainerOptions) { close(killed) }).Return(nil)
+
+	exitCode, runContainer, err := dockerManager.RunInContainer(ctx, RunInContainerOpts{
+		ContainerName: "compiling",
+		ImageName:     "stemcell",
+		Cmd:           []string{"make"},
+	})
+	assert.Equal(context.Canceled, err)
+	assert.Equal(-1, exitCode)
+	assert.Equal(container, runContainer, "The container is returned for removal")
+
+	// Nothing is started once the context is cancelled
+	_, runContainer, err = dockerManager.RunInContainer(ctx, RunInContainerOpts{ContainerName: "late"})
+	assert.Equal(context.Canceled, err)
+	assert.Nil(runContainer)
+}
+
+type mockCloseWaiter struct{}
+
+func (*mockCloseWaiter) Wait() error  { return nil }
+func (*mockCloseWaiter) Close() error { return nil }
+
 func TestRunInContainerStderr(t *testing.T) {
 	assert := assert.New(t)
 
@@ -125,7 +176,7 @@ func TestRunInContainerStderr(t *testing.T) {
 	buf := new(bytes.Buffer)
 	stderrWriter := NewFormattingWriter(buf, nil)
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"ping", "-foo"},
@@ -155,7 +206,7 @@ func TestRunInContainerWithInFiles(t *testing.T) {
 	stdoutWriter := NewFormattingWriter(buf, nil)
 	stderrWriter := new(bytes.Buffer)
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"ls", ContainerInPath},
@@ -182,7 +233,7 @@ func TestRunInContainerWithReadOnlyInFiles(t *testing.T) {
 
 	assert.NoError(err)
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"touch", filepath.Join(ContainerInPath, "fissile-test.txt")},
@@ -208,7 +259,7 @@ func TestRunInContainerWithOutFiles(t *testing.T) {
 	buf := new(bytes.Buffer)
 	stdoutWriter := NewFormattingWriter(buf, nil)
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"ls", ContainerOutPath},
@@ -233,7 +284,7 @@ func TestRunInContainerWithWritableOutFiles(t *testing.T) {
 
 	assert.NoError(err)
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"touch", filepath.Join(ContainerOutPath, "fissile-test.txt")},
@@ -258,7 +309,7 @@ func TestRunInContainerVolumeRemoved(t *testing.T) {
 	volumeName := uuid.New()
 	stdoutWriter := &bytes.Buffer{}
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"cat", "/proc/self/mounts"},
@@ -299,7 +350,7 @@ func TestCreateImageOk(t *testing.T) {
 
 	assert.NoError(err)
 
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: getTestName(),
 		ImageName:     dockerImageName,
 		Cmd:           []string{"ping", "127.0.0.1", "-c", "1"},
@@ -351,7 +402,7 @@ func verifyDebugContainerStays(t *testing.T, cmdShouldSucceed bool) {
 	testName := getTestName()
 
 	// Run /bin/true to succeed, /bin/false to fail
-	exitCode, container, err := dockerManager.RunInContainer(RunInContainerOpts{
+	exitCode, container, err := dockerManager.RunInContainer(context.Background(), RunInContainerOpts{
 		ContainerName: testName,
 		ImageName:     dockerImageName,
 		Cmd:           []string{fmt.Sprintf("/bin/%t", cmdShouldSucceed)},
@@ -454,7 +505,7 @@ func doTestBuildImageFromCallback(t *testing.T, callback func(*tar.Writer) error
 		assert.False(hasImage, "Failed to get an unused image name")
 	}
 
-	err = dockerManager.BuildImageFromCallback(imageName, ioutil.Discard, callback)
+	err = dockerManager.BuildImageFromCallback(context.Background(), imageName, ioutil.Discard, callback)
 	postRun(err, dockerManager, imageName)
 }
 
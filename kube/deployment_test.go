@@ -69,6 +69,52 @@ func TestNewDeploymentKube(t *testing.T) {
 	assert.Equal(deployment.Get("metadata", "name").String(), "role")
 }
 
+func TestNewDeploymentDockerRole(t *testing.T) {
+	assert := assert.New(t)
+
+	role := deploymentTestLoadRole(assert, "proxy", "docker-roles.yml")
+	if role == nil {
+		return
+	}
+
+	deployment, svc, err := NewDeployment(role, ExportSettings{Opinions: model.NewEmptyOpinions()}, FakeGrapher{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NotNil(svc)
+
+	actual, err := testhelpers.RoundtripNode(deployment, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	testhelpers.IsYAMLSubsetString(assert, `---
+		kind: Deployment
+		metadata:
+			name: proxy
+		spec:
+			template:
+				spec:
+					containers:
+					-
+						name: proxy
+						image: docker.io/library/nginx:1.13
+						env:
+						-
+							name: CACHE_SIZE
+							value: "64"
+						-
+							name: KUBERNETES_NAMESPACE
+						ports:
+						-
+							containerPort: 8080
+							name: http
+							protocol: TCP
+	`, actual)
+
+	container := deployment.Get("spec", "template", "spec", "containers").Values()[0]
+	assert.Nil(container.Get("lifecycle"), "Docker roles have no pre-stop script")
+}
+
 func TestNewDeploymentHelm(t *testing.T) {
 	assert := assert.New(t)
 
@@ -111,11 +111,14 @@ func NewPodTemplate(role *model.Role, settings ExportSettings, grapher util.Mode
 	container.Add("securityContext", securityContext)
 	container.Add("livenessProbe", livenessProbe)
 	container.Add("readinessProbe", readinessProbe)
-	container.Add("lifecycle",
-		helm.NewMapping("preStop",
-			helm.NewMapping("exec",
-				helm.NewMapping("command",
-					[]string{"/opt/fissile/pre-stop.sh"}))))
+	if role.Type != model.RoleTypeDocker {
+		// Docker roles run images not built by fissile, without the pre-stop script
+		container.Add("lifecycle",
+			helm.NewMapping("preStop",
+				helm.NewMapping("exec",
+					helm.NewMapping("command",
+						[]string{"/opt/fissile/pre-stop.sh"}))))
+	}
 	container.Sort()
 
 	imagePullSecrets := helm.NewMapping("name", "registry-credentials")
@@ -175,6 +178,10 @@ func NewPod(role *model.Role, settings ExportSettings, grapher util.ModelGrapher
 
 // getContainerImageName returns the name of the docker image to use for a role
 func getContainerImageName(role *model.Role, settings ExportSettings, grapher util.ModelGrapher) (string, error) {
+	if role.Type == model.RoleTypeDocker {
+		return role.Image, nil
+	}
+
 	devVersion, err := role.GetRoleDevVersion(settings.Opinions, settings.TagExtra, settings.FissileVersion, grapher)
 	if err != nil {
 		return "", err
@@ -338,3 +338,69 @@ func TestStatefulSetVolumesHelm(t *testing.T) {
 	}
 	assert.Empty(volumes, "Hostpath volumes should not be available")
 }
+
+func TestStatefulSetDockerRole(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, _ := statefulSetTestLoadManifest(assert, "docker-roles.yml")
+	if manifest == nil {
+		return
+	}
+	role := manifest.LookupRole("cache")
+	if !assert.NotNil(role) {
+		return
+	}
+
+	statefulSet, deps, err := NewStatefulSet(role, ExportSettings{
+		Opinions:     model.NewEmptyOpinions(),
+		RoleManifest: manifest,
+	}, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NotNil(deps)
+
+	actual, err := testhelpers.RoundtripNode(statefulSet, nil)
+	if !assert.NoError(err) {
+		return
+	}
+	testhelpers.IsYAMLSubsetString(assert, `---
+		kind: StatefulSet
+		metadata:
+			name: cache
+		spec:
+			serviceName: cache-set
+			template:
+				spec:
+					containers:
+					-
+						name: cache
+						image: docker.io/library/redis:4.0
+						env:
+						-
+							name: CACHE_PASSWORD
+							valueFrom:
+								secretKeyRef:
+									key: cache-password
+									name: secrets
+						-
+							name: CACHE_SIZE
+							value: "64"
+						-
+							name: KUBERNETES_NAMESPACE
+						livenessProbe:
+							exec:
+								command: [redis-cli, ping]
+						readinessProbe:
+							tcpSocket:
+								port: 6379
+						volumeMounts:
+						-
+							mountPath: /data
+							name: cache-data
+			volumeClaimTemplates:
+			-
+				metadata:
+					name: cache-data
+	`, actual)
+}
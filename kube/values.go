@@ -6,6 +6,8 @@ import (
 
 	"github.com/SUSE/fissile/helm"
 	"github.com/SUSE/fissile/model"
+
+	"gopkg.in/yaml.v2"
 )
 
 // MakeValues returns a Mapping with all default values for the Helm chart
@@ -14,6 +16,8 @@ func MakeValues(settings ExportSettings) (helm.Node, error) {
 	secrets := helm.NewMapping()
 	generated := helm.NewMapping()
 
+	propertyExamples := model.MakeMapOfPropertyExamples(settings.RoleManifest)
+
 	for name, cv := range model.MakeMapOfVariables(settings.RoleManifest) {
 		if strings.HasPrefix(name, "KUBE_SIZING_") || cv.Type == model.CVTypeEnv {
 			continue
@@ -34,6 +38,10 @@ func MakeValues(settings ExportSettings) (helm.Node, error) {
 		comment := cv.Description
 		if cv.Example != "" && cv.Example != value {
 			comment += fmt.Sprintf("\nExample: %s", cv.Example)
+		} else if example, ok := propertyExamples[name]; ok && cv.Example == "" {
+			// Fall back to the example from the job spec of the property the
+			// variable is used for
+			comment += formatPropertyExample(example)
 		}
 
 		if cv.Secret {
@@ -199,3 +207,23 @@ func MakeValues(settings ExportSettings) (helm.Node, error) {
 
 	return values, nil
 }
+
+// formatPropertyExample renders the example of a job property for a comment
+// in values.yaml; structured examples are rendered as YAML on separate lines
+func formatPropertyExample(example interface{}) string {
+	text, ok := example.(string)
+	if !ok {
+		buf, err := yaml.Marshal(example)
+		if err != nil {
+			return ""
+		}
+		text = string(buf)
+	}
+
+	text = strings.TrimRight(text, "\n")
+	if strings.Contains(text, "\n") {
+		return "\nExample:\n" + text
+	}
+
+	return fmt.Sprintf("\nExample: %s", text)
+}
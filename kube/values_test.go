@@ -100,3 +100,56 @@ func TestMakeValues(t *testing.T) {
 		assert.Equal(auth.String(), authString)
 	})
 }
+
+func TestMakeValuesPropertyExamples(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	job := &model.Job{Name: "job"}
+	job.Properties = []*model.JobProperty{
+		&model.JobProperty{Name: "cert", Example: "some-cert", Job: job},
+		&model.JobProperty{Name: "peers", Example: []interface{}{"a", "b"}, Job: job},
+		&model.JobProperty{Name: "own", Example: "from the job", Job: job},
+		&model.JobProperty{Name: "combined", Example: "from the job", Job: job},
+	}
+
+	settings := ExportSettings{
+		RoleManifest: &model.RoleManifest{
+			Roles: model.Roles{
+				&model.Role{
+					Name:     "role",
+					Run:      &model.RoleRun{FlightStage: model.FlightStageManual},
+					RoleJobs: []*model.RoleJob{&model.RoleJob{Job: job, Name: "job"}},
+					Configuration: &model.Configuration{
+						Templates: map[string]string{
+							"properties.cert":     "((CERT))",
+							"properties.peers":    `"((PEERS))"`,
+							"properties.own":      "((OWN))",
+							"properties.combined": "((HOST)):((PORT))",
+						},
+					},
+				},
+			},
+			Configuration: &model.Configuration{
+				Variables: model.ConfigurationVariableSlice{
+					&model.ConfigurationVariable{Name: "CERT", Description: "The cert."},
+					&model.ConfigurationVariable{Name: "PEERS", Description: "The peers."},
+					&model.ConfigurationVariable{Name: "OWN", Description: "Own.", Example: "from the manifest"},
+					&model.ConfigurationVariable{Name: "HOST", Description: "The host."},
+				},
+			},
+		},
+	}
+
+	node, err := MakeValues(settings)
+	if !assert.NoError(err) {
+		return
+	}
+
+	env := node.Get("env")
+	assert.Equal("The cert.\nExample: some-cert", env.Get("CERT").Comment())
+	assert.Equal("The peers.\nExample:\n- a\n- b", env.Get("PEERS").Comment())
+	assert.Equal("Own.\nExample: from the manifest", env.Get("OWN").Comment())
+	assert.Equal("The host.", env.Get("HOST").Comment())
+}
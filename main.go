@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"runtime"
 
@@ -43,7 +44,12 @@ func main() {
 	f := app.NewFissileApplication(version, ui)
 
 	if err := cmd.Execute(f, version); err != nil {
-		ui.Println(color.RedString("%v", err))
+		if _, ok := err.(app.ValidationFailedError); ok {
+			// Keep the validation report on stdout parseable
+			fmt.Fprintln(os.Stderr, color.RedString("%v", err))
+		} else {
+			ui.Println(color.RedString("%v", err))
+		}
 		sigint.DefaultHandler.Exit(1)
 	}
 }
@@ -13,12 +13,19 @@ import (
 
 // NewDevRelease will create an instance of a BOSH development release
 func NewDevRelease(path, releaseName, version, boshCacheDir string) (*Release, error) {
+	return NewDevReleaseWithSpecCache(path, releaseName, version, boshCacheDir, nil)
+}
+
+// NewDevReleaseWithSpecCache will create an instance of a BOSH development
+// release, reading job specs from specCache where possible
+func NewDevReleaseWithSpecCache(path, releaseName, version, boshCacheDir string, specCache *JobSpecCache) (*Release, error) {
 	release := &Release{
 		Path:            path,
 		Name:            releaseName,
 		Version:         version,
 		DevBOSHCacheDir: boshCacheDir,
 		FinalRelease:    false,
+		specCache:       specCache,
 	}
 
 	if err := release.validateDevPathStructure(); err != nil {
@@ -38,6 +45,8 @@ func NewDevRelease(path, releaseName, version, boshCacheDir string) (*Release, e
 		return nil, err
 	}
 
+	release.openBlobstore()
+
 	if version == "" {
 		version, err := release.getLatestDevVersion()
 		if err != nil {
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/SUSE/fissile/blobstore"
+
+	"gopkg.in/yaml.v2"
+)
+
+// finalBuildsDir is where the BOSH CLI records the blobstore IDs of the final
+// job and package archives of a release
+const finalBuildsDir = ".final_builds"
+
+// finalBuildsIndex describes the contents of the index.yml files of the
+// final builds of a job or package
+type finalBuildsIndex struct {
+	Builds map[string]struct {
+		Version     string `yaml:"version"`
+		BlobstoreID string `yaml:"blobstore_id"`
+		SHA1        string `yaml:"sha1"`
+	} `yaml:"builds"`
+}
+
+// openBlobstore creates the blobstore configured for the release. Releases
+// without a blobstore configuration have none. A broken configuration is only
+// reported once something actually needs to be fetched.
+func (r *Release) openBlobstore() {
+	config, err := blobstore.LoadReleaseConfig(r.Path)
+	if err != nil || config == nil {
+		r.blobstoreErr = err
+		return
+	}
+
+	r.blobstore, r.blobstoreErr = blobstore.New(config, r.Path)
+}
+
+// fetchMissingArchive downloads the archive of a job or package of a dev
+// release into the BOSH cache if it is not there yet. The archive can only be
+// fetched if its fingerprint was recorded as a final build of the release;
+// otherwise it stays missing, and extracting it will report that.
+func (r *Release) fetchMissingArchive(kind, name, fingerprint, digest, path string) error {
+	if r.FinalRelease {
+		return nil
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+
+	blobstoreID, err := r.finalBuildBlobstoreID(kind, name, fingerprint)
+	if err != nil || blobstoreID == "" {
+		return err
+	}
+
+	if err := r.fetchBlob(blobstoreID, digest, path); err != nil {
+		return fmt.Errorf("Error fetching archive of %s %s (%s) of release %s: %s", kind, name, fingerprint, r.Name, err.Error())
+	}
+
+	return nil
+}
+
+// fetchBlob downloads a blob from the release's blobstore to path, verifying
+// it against digest
+func (r *Release) fetchBlob(blobstoreID, digest, path string) error {
+	if r.blobstoreErr != nil {
+		return fmt.Errorf("Error loading blobstore configuration: %s", r.blobstoreErr.Error())
+	}
+	if r.blobstore == nil {
+		return fmt.Errorf("No blobstore configured in %s", r.getDevReleaseFinalConfigFile())
+	}
+
+	expected, err := ParseMultiDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	return blobstore.Fetch(r.blobstore, blobstoreID, path, expected.Verify)
+}
+
+// finalBuildBlobstoreID looks up the blobstore ID of the final build of a job
+// or package with the given fingerprint. It returns the empty string if there
+// is no such build.
+func (r *Release) finalBuildBlobstoreID(kind, name, fingerprint string) (string, error) {
+	indexPath := filepath.Join(r.Path, finalBuildsDir, kind+"s", name, "index.yml")
+
+	contents, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var index finalBuildsIndex
+	if err := yaml.Unmarshal(contents, &index); err != nil {
+		return "", fmt.Errorf("Error loading final builds index %s: %s", indexPath, err.Error())
+	}
+
+	return index.Builds[fingerprint].BlobstoreID, nil
+}
+
+// missingArchiveError describes an archive of a job or package that is
+// neither in the BOSH cache nor could be fetched from the blobstore
+func missingArchiveError(kind string, release *Release, name, path string) error {
+	if release == nil || release.FinalRelease {
+		return fmt.Errorf("Archive of %s %s is missing: %s does not exist", kind, name, path)
+	}
+
+	return fmt.Errorf("Archive of %s %s of release %s is missing from the BOSH cache %s, and it is not a final build that could be fetched from the blobstore",
+		kind, name, release.Name, release.DevBOSHCacheDir)
+}
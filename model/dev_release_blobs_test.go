@@ -0,0 +1,181 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFinalBuilds records all jobs and packages of the release that have
+// archives as final builds, with the archive SHA1 as their blobstore ID. It
+// returns the number of archives recorded.
+func writeFinalBuilds(releasePath string, release *Release) (int, error) {
+	indexes := map[string]string{}
+	for _, pkg := range release.Packages {
+		if _, err := os.Stat(pkg.Path); err != nil {
+			continue
+		}
+		indexes[filepath.Join("packages", pkg.Name)] = fmt.Sprintf("  %s:\n    version: %s\n    blobstore_id: %s\n    sha1: %s\n",
+			pkg.Fingerprint, pkg.Version, pkg.SHA1, pkg.SHA1)
+	}
+	for _, job := range release.Jobs {
+		if _, err := os.Stat(job.Path); err != nil {
+			continue
+		}
+		indexes[filepath.Join("jobs", job.Name)] = fmt.Sprintf("  %s:\n    version: %s\n    blobstore_id: %s\n    sha1: %s\n",
+			job.Fingerprint, job.Version, job.SHA1, job.SHA1)
+	}
+
+	for dir, build := range indexes {
+		indexDir := filepath.Join(releasePath, finalBuildsDir, dir)
+		if err := os.MkdirAll(indexDir, 0755); err != nil {
+			return 0, err
+		}
+		index := "---\nbuilds:\n" + build + "format-version: '2'\n"
+		if err := ioutil.WriteFile(filepath.Join(indexDir, "index.yml"), []byte(index), 0644); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(indexes), nil
+}
+
+func TestDevReleaseFetchesMissingArchives(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	sourcePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	sourceCachePath := filepath.Join(sourcePath, "bosh-cache")
+	source, err := NewDevRelease(sourcePath, "", "", sourceCachePath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Jobs are loaded concurrently
+	var requestsLock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsLock.Lock()
+		requests = append(requests, r.URL.Path)
+		requestsLock.Unlock()
+		http.ServeFile(w, r, filepath.Join(sourceCachePath, filepath.Base(r.URL.Path)))
+	}))
+	defer server.Close()
+
+	tempDir := copySourceTree(t, sourcePath)
+	defer os.RemoveAll(tempDir)
+	releasePath := filepath.Join(tempDir, "release")
+	cacheDir := filepath.Join(tempDir, "cache")
+
+	finalConfig := fmt.Sprintf("---\nfinal_name: ntp\nblobstore:\n  provider: http\n  options:\n    endpoint: %s/blobs\n", server.URL)
+	assert.NoError(ioutil.WriteFile(filepath.Join(releasePath, "config", "final.yml"), []byte(finalConfig), 0644))
+	archives, err := writeFinalBuilds(releasePath, source)
+	assert.NoError(err)
+
+	release, err := NewDevRelease(releasePath, "", "", cacheDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Len(requests, archives)
+	for _, pkg := range release.Packages {
+		assert.Equal(filepath.Join(cacheDir, pkg.SHA1), pkg.Path)
+		if _, err := os.Stat(filepath.Join(sourceCachePath, pkg.SHA1)); err == nil {
+			assert.NoError(pkg.ValidateSHA1())
+		}
+	}
+	for _, job := range release.Jobs {
+		assert.NoError(job.ValidateSHA1())
+	}
+
+	// The fetched archives are cached
+	requests = nil
+	_, err = NewDevRelease(releasePath, "", "", cacheDir)
+	assert.NoError(err)
+	assert.Empty(requests)
+}
+
+func TestDevReleaseFetchVerifiesArchives(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	sourcePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	source, err := NewDevRelease(sourcePath, "", "", filepath.Join(sourcePath, "bosh-cache"))
+	if !assert.NoError(err) {
+		return
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not the archive you are looking for"))
+	}))
+	defer server.Close()
+
+	tempDir := copySourceTree(t, sourcePath)
+	defer os.RemoveAll(tempDir)
+	releasePath := filepath.Join(tempDir, "release")
+	cacheDir := filepath.Join(tempDir, "cache")
+
+	finalConfig := fmt.Sprintf("---\nfinal_name: ntp\nblobstore:\n  provider: http\n  options:\n    endpoint: %s\n", server.URL)
+	assert.NoError(ioutil.WriteFile(filepath.Join(releasePath, "config", "final.yml"), []byte(finalConfig), 0644))
+	_, err = writeFinalBuilds(releasePath, source)
+	assert.NoError(err)
+
+	_, err = NewDevRelease(releasePath, "", "", cacheDir)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Error fetching archive of")
+		assert.Contains(err.Error(), "Error verifying blob")
+	}
+
+	// Nothing that failed verification ends up in the cache
+	cached, err := ioutil.ReadDir(cacheDir)
+	assert.NoError(err)
+	assert.Empty(cached)
+}
+
+func TestDevReleaseMissingArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	tempDir, err := ioutil.TempDir("", "fissile-tests")
+	assert.NoError(err)
+	defer os.RemoveAll(tempDir)
+
+	// Only copy the job archives into the cache, and leave out the packages
+	sourcePath := filepath.Join(workDir, "../test-assets/ntp-release")
+	source, err := NewDevRelease(sourcePath, "", "", filepath.Join(sourcePath, "bosh-cache"))
+	if !assert.NoError(err) {
+		return
+	}
+	for _, job := range source.Jobs {
+		contents, err := ioutil.ReadFile(job.Path)
+		assert.NoError(err)
+		assert.NoError(ioutil.WriteFile(filepath.Join(tempDir, job.SHA1), contents, 0644))
+	}
+
+	release, err := NewDevRelease(sourcePath, "", "", tempDir)
+	if !assert.NoError(err) {
+		return
+	}
+
+	pkg, err := release.LookupPackage("ntp-4.2.8p2")
+	if assert.NoError(err) {
+		_, err = pkg.Extract(filepath.Join(tempDir, "extracted"))
+		if assert.Error(err) {
+			assert.True(strings.HasPrefix(err.Error(), "Archive of package ntp-4.2.8p2 of release ntp is missing from the BOSH cache"), err.Error())
+		}
+	}
+}
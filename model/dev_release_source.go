@@ -0,0 +1,509 @@
+package model
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SUSE/fissile/util"
+	"gopkg.in/yaml.v2"
+)
+
+// devBuildsDir is where the BOSH CLI records the archives it built for each
+// job and package fingerprint
+const devBuildsDir = ".dev_builds"
+
+// IsDevReleaseSourceTree returns true if the path points to a BOSH release
+// source tree (e.g. a git checkout) for which no dev release has been created
+func IsDevReleaseSourceTree(path string) bool {
+	release := &Release{Path: path}
+
+	if util.ValidatePath(release.getDevReleaseFinalConfigFile(), false, "release final config file") != nil {
+		return false
+	}
+
+	if util.ValidatePath(release.getDevReleasesDir(), true, "release 'dev_releases' directory") == nil {
+		return false
+	}
+
+	return util.ValidatePath(release.jobsDirPath(), true, "jobs directory") == nil ||
+		util.ValidatePath(release.packagesDirPath(), true, "packages directory") == nil
+}
+
+// NewDevReleaseFromSource will create an instance of a BOSH development release
+// directly from a release source tree, without `bosh create-release`. The job
+// and package archives are built and written into boshCacheDir the way the
+// BOSH CLI does, and reused as long as their fingerprints do not change. If no
+// version is given, one is derived from the fingerprints.
+func NewDevReleaseFromSource(path, releaseName, version, boshCacheDir string, specCache *JobSpecCache) (*Release, error) {
+	release := &Release{
+		Path:            path,
+		Name:            releaseName,
+		Version:         version,
+		DevBOSHCacheDir: boshCacheDir,
+		FinalRelease:    false,
+		specCache:       specCache,
+	}
+
+	if err := util.ValidatePath(path, true, "release directory"); err != nil {
+		return nil, err
+	}
+
+	if boshCacheDir == "" {
+		return nil, fmt.Errorf("No BOSH cache directory to write the archives of release %s into", path)
+	}
+
+	if releaseName == "" {
+		releaseName, err := release.getDefaultDevReleaseName()
+		if err != nil {
+			return nil, err
+		}
+
+		release.Name = releaseName
+	}
+
+	release.openBlobstore()
+
+	if err := release.validateSourceBlobs(); err != nil {
+		return nil, err
+	}
+
+	packages, err := release.createSourcePackages()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := release.createSourceJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	if release.Version == "" {
+		release.Version = sourceReleaseVersion(packages, jobs)
+	}
+
+	release.CommitHash = release.sourceCommitHash()
+	release.manifest = map[interface{}]interface{}{
+		"name":                release.Name,
+		"version":             release.Version,
+		"commit_hash":         release.CommitHash,
+		"uncommitted_changes": false,
+		"packages":            packages,
+		"jobs":                jobs,
+	}
+
+	if err := release.loadPackages(); err != nil {
+		return nil, err
+	}
+
+	if err := release.loadDependenciesForPackages(); err != nil {
+		return nil, err
+	}
+
+	if err := release.loadJobs(); err != nil {
+		return nil, err
+	}
+
+	if err := release.loadLicense(); err != nil {
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// sourceBlob is an entry of config/blobs.yml
+type sourceBlob struct {
+	Size     int64  `yaml:"size"`
+	ObjectID string `yaml:"object_id"`
+	SHA      string `yaml:"sha"`
+}
+
+// validateSourceBlobs reads config/blobs.yml and makes sure that all blobs
+// listed there are present in the blobs directory with the expected contents
+func (r *Release) validateSourceBlobs() error {
+	blobs := map[string]sourceBlob{}
+
+	contents, err := ioutil.ReadFile(filepath.Join(r.getDevReleaseConfigDir(), "blobs.yml"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := yaml.Unmarshal(contents, &blobs); err != nil {
+		return fmt.Errorf("Error loading blobs of release %s: %s", r.Name, err.Error())
+	}
+
+	for name, blob := range blobs {
+		blobPath := filepath.Join(r.sourceBlobsDir(), name)
+		if err := util.ValidatePath(blobPath, false, "blob"); err != nil {
+			if blob.ObjectID == "" {
+				return fmt.Errorf("Blob %s of release %s is missing from %s, and it has not been uploaded to the blobstore", name, r.Name, r.sourceBlobsDir())
+			}
+			if err := r.fetchBlob(blob.ObjectID, blob.SHA, blobPath); err != nil {
+				return fmt.Errorf("Blob %s of release %s is missing from %s, and fetching it failed: %s", name, r.Name, r.sourceBlobsDir(), err.Error())
+			}
+			continue
+		}
+
+		digest, err := ParseMultiDigest(blob.SHA)
+		if err != nil {
+			return fmt.Errorf("Error loading blob %s of release %s: %s", name, r.Name, err.Error())
+		}
+		if err := digest.Verify(blobPath); err != nil {
+			return fmt.Errorf("%s for blob %s of release %s", err.Error(), name, r.Name)
+		}
+	}
+
+	return nil
+}
+
+// packageSourceSpec describes the contents of the "spec" file of a package
+type packageSourceSpec struct {
+	Name          string   `yaml:"name"`
+	Dependencies  []string `yaml:"dependencies"`
+	Files         []string `yaml:"files"`
+	ExcludedFiles []string `yaml:"excluded_files"`
+}
+
+// createSourcePackages builds the archives of all packages of the release and
+// returns their release manifest entries
+func (r *Release) createSourcePackages() ([]interface{}, error) {
+	specPaths, err := filepath.Glob(filepath.Join(r.packagesDirPath(), "*", "spec"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(specPaths)
+
+	packages := make([]interface{}, 0, len(specPaths))
+	for _, specPath := range specPaths {
+		packageDir := filepath.Dir(specPath)
+
+		specContents, err := ioutil.ReadFile(specPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var spec packageSourceSpec
+		if err := yaml.Unmarshal(specContents, &spec); err != nil {
+			return nil, fmt.Errorf("Error loading package spec %s: %s", specPath, err.Error())
+		}
+		if spec.Name == "" {
+			spec.Name = filepath.Base(packageDir)
+		}
+
+		files, err := r.packageSourceFiles(packageDir, &spec)
+		if err != nil {
+			return nil, fmt.Errorf("Error collecting files of package %s: %s", spec.Name, err.Error())
+		}
+
+		dependencies := append([]string{}, spec.Dependencies...)
+		sort.Strings(dependencies)
+
+		fingerprint, err := sourceFingerprint(files, dependencies)
+		if err != nil {
+			return nil, fmt.Errorf("Error computing fingerprint of package %s: %s", spec.Name, err.Error())
+		}
+
+		digest, err := r.buildSourceArchive(packagesDir, spec.Name, fingerprint, files)
+		if err != nil {
+			return nil, fmt.Errorf("Error building archive of package %s: %s", spec.Name, err.Error())
+		}
+
+		manifestDependencies := make([]interface{}, 0, len(spec.Dependencies))
+		for _, dependency := range spec.Dependencies {
+			manifestDependencies = append(manifestDependencies, dependency)
+		}
+
+		packages = append(packages, map[interface{}]interface{}{
+			"name":         spec.Name,
+			"version":      fingerprint,
+			"fingerprint":  fingerprint,
+			"sha1":         digest,
+			"dependencies": manifestDependencies,
+		})
+	}
+
+	return packages, nil
+}
+
+// packageSourceFiles returns the files making up a package, in the order the
+// BOSH CLI fingerprints them: the files matched by the spec, sorted by their
+// path, followed by the packaging scripts. The blobs directory is matched
+// first and the src directory second, so that files in src take precedence
+// over blobs of the same path.
+func (r *Release) packageSourceFiles(packageDir string, spec *packageSourceSpec) ([]sourceFile, error) {
+	found := map[string]string{}
+	for _, pattern := range spec.Files {
+		matchedAny := false
+		for _, root := range []string{r.sourceBlobsDir(), filepath.Join(r.Path, "src")} {
+			matched, err := globFiles(root, []string{pattern})
+			if err != nil {
+				return nil, err
+			}
+			excluded, err := globFiles(root, spec.ExcludedFiles)
+			if err != nil {
+				return nil, err
+			}
+			excludedSet := map[string]bool{}
+			for _, name := range excluded {
+				excludedSet[name] = true
+			}
+
+			for _, name := range matched {
+				if !excludedSet[name] {
+					// Files in src take precedence over blobs
+					found[name] = filepath.Join(root, filepath.FromSlash(name))
+					matchedAny = true
+				}
+			}
+		}
+
+		if !matchedAny {
+			return nil, fmt.Errorf("%s does not match any file", pattern)
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]sourceFile, 0, len(names)+2)
+	for _, name := range names {
+		files = append(files, sourceFile{
+			Path:            found[name],
+			ArchivePath:     name,
+			FingerprintName: name,
+		})
+	}
+
+	for _, hook := range []string{"packaging", "pre_packaging"} {
+		hookPath := filepath.Join(packageDir, hook)
+		if _, err := os.Stat(hookPath); err != nil {
+			if hook == "packaging" {
+				return nil, fmt.Errorf("Package %s has no packaging script", spec.Name)
+			}
+			continue
+		}
+		files = append(files, sourceFile{
+			Path:            hookPath,
+			ArchivePath:     hook,
+			FingerprintName: hook,
+			ExcludeMode:     true,
+		})
+	}
+
+	return files, nil
+}
+
+// createSourceJobs builds the archives of all jobs of the release and returns
+// their release manifest entries
+func (r *Release) createSourceJobs() ([]interface{}, error) {
+	specPaths, err := filepath.Glob(filepath.Join(r.jobsDirPath(), "*", "spec"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(specPaths)
+
+	jobs := make([]interface{}, 0, len(specPaths))
+	for _, specPath := range specPaths {
+		name, files, err := jobSourceFiles(filepath.Dir(specPath))
+		if err != nil {
+			return nil, err
+		}
+
+		fingerprint, err := sourceFingerprint(files, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error computing fingerprint of job %s: %s", name, err.Error())
+		}
+
+		digest, err := r.buildSourceArchive(jobsDir, name, fingerprint, files)
+		if err != nil {
+			return nil, fmt.Errorf("Error building archive of job %s: %s", name, err.Error())
+		}
+
+		jobs = append(jobs, map[interface{}]interface{}{
+			"name":        name,
+			"version":     fingerprint,
+			"fingerprint": fingerprint,
+			"sha1":        digest,
+		})
+	}
+
+	return jobs, nil
+}
+
+// jobSourceFiles returns the name of the job in jobDir and the files making it
+// up, in the order the BOSH CLI fingerprints them: the templates in the order
+// of the spec, named by their base name, followed by the monit file and the
+// spec. This is not sorted by path; TestDevReleaseFromSourceBoshFingerprints
+// checks it against the fingerprints of a release the BOSH CLI created.
+func jobSourceFiles(jobDir string) (string, []sourceFile, error) {
+	specPath := filepath.Join(jobDir, "spec")
+	specContents, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The templates are read as a MapSlice, as the fingerprint depends on
+	// their order in the spec
+	var spec struct {
+		Name      string        `yaml:"name"`
+		Templates yaml.MapSlice `yaml:"templates"`
+	}
+	if err := yaml.Unmarshal(specContents, &spec); err != nil {
+		return "", nil, fmt.Errorf("Error loading job spec %s: %s", specPath, err.Error())
+	}
+	if spec.Name == "" {
+		spec.Name = filepath.Base(jobDir)
+	}
+
+	var files []sourceFile
+	for _, template := range spec.Templates {
+		source, ok := template.Key.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("Job %s has an invalid template %v", spec.Name, template.Key)
+		}
+		files = append(files, sourceFile{
+			Path:            filepath.Join(jobDir, "templates", source),
+			ArchivePath:     filepath.Join("templates", source),
+			FingerprintName: filepath.Base(source),
+		})
+	}
+
+	return spec.Name, append(files,
+		sourceFile{
+			Path:            filepath.Join(jobDir, "monit"),
+			ArchivePath:     "monit",
+			FingerprintName: "monit",
+		},
+		sourceFile{
+			Path:            specPath,
+			ArchivePath:     "job.MF",
+			FingerprintName: "spec",
+		},
+	), nil
+}
+
+// devBuildsIndex is the format of the .dev_builds/<kind>/<name>/index.yml files
+type devBuildsIndex struct {
+	Builds        map[string]devBuildsIndexEntry `yaml:"builds"`
+	FormatVersion string                         `yaml:"format-version"`
+}
+
+type devBuildsIndexEntry struct {
+	Version string `yaml:"version"`
+	SHA1    string `yaml:"sha1"`
+}
+
+// buildSourceArchive returns the SHA1 of the archive for the given job or
+// package fingerprint, building it into the BOSH cache unless an archive for
+// the fingerprint is recorded in the dev builds index and still cached
+func (r *Release) buildSourceArchive(kind, name, fingerprint string, files []sourceFile) (string, error) {
+	indexPath := filepath.Join(r.Path, devBuildsDir, kind, name, "index.yml")
+
+	index := devBuildsIndex{
+		Builds:        map[string]devBuildsIndexEntry{},
+		FormatVersion: "2",
+	}
+	if contents, err := ioutil.ReadFile(indexPath); err == nil {
+		if err := yaml.Unmarshal(contents, &index); err != nil {
+			return "", fmt.Errorf("Error loading dev builds index %s: %s", indexPath, err.Error())
+		}
+		if index.Builds == nil {
+			index.Builds = map[string]devBuildsIndexEntry{}
+		}
+	}
+
+	if entry, ok := index.Builds[fingerprint]; ok {
+		if util.ValidatePath(filepath.Join(r.DevBOSHCacheDir, entry.SHA1), false, "cached archive") == nil {
+			return entry.SHA1, nil
+		}
+	}
+
+	archivePath, digest, err := writeSourceArchive(r.DevBOSHCacheDir, files)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(archivePath, filepath.Join(r.DevBOSHCacheDir, digest)); err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+
+	index.Builds[fingerprint] = devBuildsIndexEntry{
+		Version: fingerprint,
+		SHA1:    digest,
+	}
+	contents, err := yaml.Marshal(&index)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(indexPath, contents, 0644); err != nil {
+		return "", err
+	}
+
+	return digest, nil
+}
+
+// sourceReleaseVersion derives a dev release version from the fingerprints of
+// all jobs and packages, so that unchanged source trees keep their version
+func sourceReleaseVersion(packages, jobs []interface{}) string {
+	hasher := sha1.New()
+	for _, entries := range [][]interface{}{packages, jobs} {
+		for _, entry := range entries {
+			info := entry.(map[interface{}]interface{})
+			hasher.Write([]byte(fmt.Sprintf("%s:%s\n", info["name"], info["fingerprint"])))
+		}
+	}
+
+	return fmt.Sprintf("0+dev.%x", hasher.Sum(nil)[:4])
+}
+
+// sourceCommitHash returns the abbreviated git commit the source tree is at,
+// or "non-git" when it is not a git checkout
+func (r *Release) sourceCommitHash() string {
+	gitDir := filepath.Join(r.Path, ".git")
+
+	head, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "non-git"
+	}
+
+	commit := strings.TrimSpace(string(head))
+	if strings.HasPrefix(commit, "ref: ") {
+		ref := strings.TrimPrefix(commit, "ref: ")
+		if contents, err := ioutil.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+			commit = strings.TrimSpace(string(contents))
+		} else if packed, err := ioutil.ReadFile(filepath.Join(gitDir, "packed-refs")); err == nil {
+			commit = ""
+			for _, line := range strings.Split(string(packed), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) == 2 && fields[1] == ref {
+					commit = fields[0]
+				}
+			}
+		} else {
+			commit = ""
+		}
+	}
+
+	if len(commit) < 8 {
+		return "non-git"
+	}
+
+	return commit[:8]
+}
+
+func (r *Release) sourceBlobsDir() string {
+	return filepath.Join(r.Path, "blobs")
+}
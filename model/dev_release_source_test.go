@@ -0,0 +1,284 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+// copySourceTree copies a release source tree into a temporary directory, so
+// that tests can write into it
+func copySourceTree(t *testing.T, sourcePath string) string {
+	tempDir, err := ioutil.TempDir("", "fissile-dev-release-source")
+	require.NoError(t, err)
+
+	releasePath := filepath.Join(tempDir, "release")
+	err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(releasePath, relPath), 0755)
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(releasePath, relPath), contents, info.Mode())
+	})
+	require.NoError(t, err)
+
+	return tempDir
+}
+
+func TestDevReleaseFromSourceOk(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	tempDir := copySourceTree(t, filepath.Join(workDir, "../test-assets/test-dev-release-source"))
+	defer os.RemoveAll(tempDir)
+	releasePath := filepath.Join(tempDir, "release")
+	cacheDir := filepath.Join(tempDir, "cache")
+
+	assert.True(IsDevReleaseSourceTree(releasePath))
+
+	release, err := NewDevReleaseFromSource(releasePath, "", "", cacheDir, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Equal("test-source", release.Name)
+	assert.Regexp(`^0\+dev\.[0-9a-f]{8}$`, release.Version)
+	assert.Equal("non-git", release.CommitHash)
+	assert.False(release.FinalRelease)
+	assert.NotEmpty(release.License.Files)
+
+	if assert.Len(release.Packages, 2) {
+		for _, pkg := range release.Packages {
+			assert.NoError(pkg.ValidateSHA1(), "Archive of package %s", pkg.Name)
+			assert.Equal(filepath.Join(cacheDir, pkg.SHA1), pkg.Path)
+		}
+
+		bar, err := release.LookupPackage("bar")
+		if assert.NoError(err) && assert.Len(bar.Dependencies, 1) {
+			assert.Equal("foo", bar.Dependencies[0].Name)
+		}
+
+		foo, err := release.LookupPackage("foo")
+		if assert.NoError(err) {
+			extractDir := filepath.Join(tempDir, "extracted")
+			fooDir, err := foo.Extract(extractDir)
+			if assert.NoError(err) {
+				assert.NoError(util.ValidatePath(filepath.Join(fooDir, "packaging"), false, "packaging script"))
+				assert.NoError(util.ValidatePath(filepath.Join(fooDir, "foo", "foo.c"), false, "source file"))
+				assert.NoError(util.ValidatePath(filepath.Join(fooDir, "foo", "lib", "util.c"), false, "source file"))
+				assert.Error(util.ValidatePath(filepath.Join(fooDir, "foo", ".hidden"), false, "hidden file"))
+			}
+		}
+	}
+
+	if assert.Len(release.Jobs, 1) {
+		job := release.Jobs[0]
+		assert.Equal("app", job.Name)
+		assert.Equal("A job built from source", job.Description)
+		assert.Len(job.Templates, 2)
+		if assert.Len(job.Packages, 1) {
+			assert.Equal("bar", job.Packages[0].Name)
+		}
+		assert.NoError(job.ValidateSHA1())
+
+		// Examples and types of properties are kept
+		if assert.Len(job.Properties, 3) {
+			assert.Equal("app.peers", job.Properties[0].Name)
+			assert.Equal([]interface{}{"10.0.0.1", "10.0.0.2"}, job.Properties[0].Example)
+			assert.Empty(job.Properties[0].Type)
+
+			assert.Equal("app.port", job.Properties[1].Name)
+			assert.Nil(job.Properties[1].Example)
+
+			assert.Equal("app.tls.certificate", job.Properties[2].Name)
+			assert.Equal("certificate", job.Properties[2].Type)
+			assert.Contains(job.Properties[2].Example, "BEGIN CERTIFICATE")
+		}
+	}
+
+	// The archives are recorded in the dev builds index and reused
+	index, err := filepath.Glob(filepath.Join(releasePath, ".dev_builds", "*", "*", "index.yml"))
+	assert.NoError(err)
+	assert.Len(index, 3)
+
+	again, err := NewDevReleaseFromSource(releasePath, "", "", cacheDir, nil)
+	if assert.NoError(err) {
+		assert.Equal(release.Version, again.Version)
+		assert.Equal(release.Jobs[0].SHA1, again.Jobs[0].SHA1)
+	}
+
+	// Changing a source file changes the fingerprint of the package
+	err = ioutil.WriteFile(filepath.Join(releasePath, "src", "foo", "foo.c"), []byte("changed\n"), 0644)
+	assert.NoError(err)
+	changed, err := NewDevReleaseFromSource(releasePath, "", "", cacheDir, nil)
+	if assert.NoError(err) {
+		assert.NotEqual(release.Version, changed.Version)
+		oldFoo, _ := release.LookupPackage("foo")
+		newFoo, _ := changed.LookupPackage("foo")
+		assert.NotEqual(oldFoo.Fingerprint, newFoo.Fingerprint)
+		oldBar, _ := release.LookupPackage("bar")
+		newBar, _ := changed.LookupPackage("bar")
+		assert.Equal(oldBar.Fingerprint, newBar.Fingerprint)
+	}
+}
+
+func TestDevReleaseFromSourceMissingBlob(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	tempDir := copySourceTree(t, filepath.Join(workDir, "../test-assets/test-dev-release-source"))
+	defer os.RemoveAll(tempDir)
+	releasePath := filepath.Join(tempDir, "release")
+
+	assert.NoError(os.Remove(filepath.Join(releasePath, "blobs", "bar", "bar-1.0.txt")))
+
+	_, err = NewDevReleaseFromSource(releasePath, "", "", filepath.Join(tempDir, "cache"), nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Blob bar/bar-1.0.txt of release test-source is missing")
+	}
+}
+
+func TestDevReleaseFromSourceFetchesBlob(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	tempDir := copySourceTree(t, filepath.Join(workDir, "../test-assets/test-dev-release-source"))
+	defer os.RemoveAll(tempDir)
+	releasePath := filepath.Join(tempDir, "release")
+
+	// Move the blob into a local blobstore, under its object ID
+	storeDir := filepath.Join(tempDir, "store")
+	assert.NoError(os.MkdirAll(storeDir, 0755))
+	assert.NoError(os.Rename(
+		filepath.Join(releasePath, "blobs", "bar", "bar-1.0.txt"),
+		filepath.Join(storeDir, "3f1e5b8e-6a3c-4d9b-9b1e-6c2f0e1d2a3b")))
+
+	finalConfig := "---\nfinal_name: test-source\nblobstore:\n  provider: local\n  options:\n    blobstore_path: " + storeDir + "\n"
+	assert.NoError(ioutil.WriteFile(filepath.Join(releasePath, "config", "final.yml"), []byte(finalConfig), 0644))
+
+	release, err := NewDevReleaseFromSource(releasePath, "", "", filepath.Join(tempDir, "cache"), nil)
+	if assert.NoError(err) {
+		assert.Len(release.Packages, 2)
+	}
+	assert.NoError(util.ValidatePath(filepath.Join(releasePath, "blobs", "bar", "bar-1.0.txt"), false, "fetched blob"))
+}
+
+func TestDevReleaseFromSourceBoshFingerprints(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	tempDir := copySourceTree(t, filepath.Join(workDir, "../test-assets/tor-boshrelease"))
+	defer os.RemoveAll(tempDir)
+	releasePath := filepath.Join(tempDir, "release")
+
+	// The blobs are not part of the source tree; take them from the package
+	// archives the BOSH CLI built, which are cached by another test asset
+	blobsDir := filepath.Join(releasePath, "blobs")
+	for _, archive := range []string{
+		"6555a7d32c13f38be9b6017ec4f0e65f60e7b4e0", // tor
+		"d95b7a054e0c98d52134a27158b988e375b07bf0", // libevent
+	} {
+		file, err := os.Open(filepath.Join(workDir, "../test-assets/ntp-release/bosh-cache", archive))
+		require.NoError(t, err)
+		err = util.ExtractTargz(archive, file, blobsDir)
+		file.Close()
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(filepath.Join(blobsDir, "packaging")))
+	}
+
+	release, err := NewDevReleaseFromSource(releasePath, "", "", filepath.Join(tempDir, "cache"), nil)
+	require.NoError(t, err)
+
+	// The fingerprints computed by the BOSH CLI, when it created the last dev
+	// release from the same sources
+	manifestContents, err := ioutil.ReadFile(filepath.Join(releasePath, "dev_releases", "tor", "tor-0.3.5+dev.5.yml"))
+	require.NoError(t, err)
+	var manifest struct {
+		Packages []struct {
+			Name        string `yaml:"name"`
+			Fingerprint string `yaml:"fingerprint"`
+		} `yaml:"packages"`
+		Jobs []struct {
+			Name        string `yaml:"name"`
+			Fingerprint string `yaml:"fingerprint"`
+		} `yaml:"jobs"`
+	}
+	require.NoError(t, yaml.Unmarshal(manifestContents, &manifest))
+
+	assert.Len(release.Packages, len(manifest.Packages))
+	for _, expected := range manifest.Packages {
+		pkg, err := release.LookupPackage(expected.Name)
+		if assert.NoError(err) {
+			assert.Equal(expected.Fingerprint, pkg.Fingerprint, "Fingerprint of package %s", expected.Name)
+		}
+	}
+	assert.Len(release.Jobs, len(manifest.Jobs))
+	for _, expected := range manifest.Jobs {
+		job, err := release.LookupJob(expected.Name)
+		if assert.NoError(err) {
+			assert.Equal(expected.Fingerprint, job.Fingerprint, "Fingerprint of job %s", expected.Name)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	assert := assert.New(t)
+
+	for pattern, cases := range map[string]map[string]bool{
+		"foo/*.tar.gz": {
+			"foo/foo-1.tar.gz":     true,
+			"foo/bar/foo.tar.gz":   false,
+			"foo/.hidden.tar.gz":   false,
+			"bar/foo-1.tar.gz":     false,
+			"foo/foo-1.tar.gz.sig": false,
+		},
+		"foo/**/*": {
+			"foo/a":        true,
+			"foo/a/b/c":    true,
+			"foo/.hidden":  false,
+			"foo/a/.b/c":   false,
+			"foobar/a":     false,
+			"other/foo/a":  false,
+			"foo/a/b/.git": false,
+		},
+		"{a,b}/file?.txt": {
+			"a/file1.txt":  true,
+			"b/file2.txt":  true,
+			"c/file1.txt":  false,
+			"a/file12.txt": false,
+		},
+	} {
+		expression, err := globToRegexp(pattern)
+		if !assert.NoError(err, pattern) {
+			continue
+		}
+		for path, expected := range cases {
+			assert.Equal(expected, expression.MatchString(path), "%s matching %s", pattern, path)
+		}
+	}
+}
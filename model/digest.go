@@ -0,0 +1,125 @@
+package model
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Digest algorithms supported in release manifests, weakest first
+const (
+	DigestAlgorithmSHA1   = "sha1"
+	DigestAlgorithmSHA256 = "sha256"
+	DigestAlgorithmSHA512 = "sha512"
+)
+
+var digestAlgorithms = []string{
+	DigestAlgorithmSHA1,
+	DigestAlgorithmSHA256,
+	DigestAlgorithmSHA512,
+}
+
+// MultiDigest holds the checksums of an archive, keyed by algorithm
+type MultiDigest map[string]string
+
+// ParseMultiDigest parses the value of a `sha1` entry of a release manifest.
+// BOSH writes either a plain SHA-1 hex string, a single prefixed digest such
+// as `sha256:<hex>`, or several of those separated by semicolons.
+func ParseMultiDigest(value string) (MultiDigest, error) {
+	result := MultiDigest{}
+
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		algorithm := DigestAlgorithmSHA1
+		digest := part
+		if index := strings.Index(part, ":"); index >= 0 {
+			algorithm = strings.ToLower(part[:index])
+			digest = part[index+1:]
+		}
+
+		if newDigestHash(algorithm) == nil {
+			return nil, fmt.Errorf("Unsupported digest algorithm %s in %s", algorithm, value)
+		}
+		if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+			return nil, fmt.Errorf("Invalid %s digest %s", algorithm, digest)
+		}
+
+		result[algorithm] = strings.ToLower(digest)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("No digest found in %s", value)
+	}
+
+	return result, nil
+}
+
+// Strongest returns the algorithm and value of the strongest digest available
+func (m MultiDigest) Strongest() (string, string) {
+	for i := len(digestAlgorithms) - 1; i >= 0; i-- {
+		if value, ok := m[digestAlgorithms[i]]; ok {
+			return digestAlgorithms[i], value
+		}
+	}
+
+	return "", ""
+}
+
+// Verify checks the file at path against the strongest digest available
+func (m MultiDigest) Verify(path string) error {
+	algorithm, expected := m.Strongest()
+	computed, err := ComputeDigest(algorithm, path)
+	if err != nil {
+		return err
+	}
+
+	if computed != expected {
+		return fmt.Errorf("Computed %s (%s) is different than manifest %s (%s)", algorithm, computed, algorithm, expected)
+	}
+
+	return nil
+}
+
+// ComputeDigest returns the hex encoded checksum of the file at path, using
+// the given algorithm
+func ComputeDigest(algorithm, path string) (string, error) {
+	h := newDigestHash(algorithm)
+	if h == nil {
+		return "", fmt.Errorf("Unsupported digest algorithm %s", algorithm)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Error opening %s for %s calculation", path, algorithm)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("Error reading %s for %s calculation", path, algorithm)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newDigestHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case DigestAlgorithmSHA1:
+		return sha1.New()
+	case DigestAlgorithmSHA256:
+		return sha256.New()
+	case DigestAlgorithmSHA512:
+		return sha512.New()
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMultiDigest(t *testing.T) {
+	assert := assert.New(t)
+
+	digest, err := ParseMultiDigest("A6B2C7C03EB0E6F2C5D1A3B0C3F7E4A9B1C2D3E4")
+	if assert.NoError(err) {
+		assert.Equal(MultiDigest{"sha1": "a6b2c7c03eb0e6f2c5d1a3b0c3f7e4a9b1c2d3e4"}, digest)
+	}
+
+	digest, err = ParseMultiDigest("sha1:a6b2;sha256:0011;sha512:ff00")
+	if assert.NoError(err) {
+		assert.Len(digest, 3)
+		algorithm, value := digest.Strongest()
+		assert.Equal("sha512", algorithm)
+		assert.Equal("ff00", value)
+	}
+
+	digest, err = ParseMultiDigest("sha256:0011")
+	if assert.NoError(err) {
+		algorithm, value := digest.Strongest()
+		assert.Equal("sha256", algorithm)
+		assert.Equal("0011", value)
+	}
+
+	_, err = ParseMultiDigest("md5:0011")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Unsupported digest algorithm md5")
+	}
+
+	_, err = ParseMultiDigest("sha256:not-hex")
+	assert.Error(err)
+
+	_, err = ParseMultiDigest("")
+	assert.Error(err)
+}
+
+func TestMultiDigestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	tempDir, err := ioutil.TempDir("", "fissile-digest")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "archive")
+	assert.NoError(ioutil.WriteFile(path, []byte("hello\n"), 0644))
+
+	const (
+		helloSHA1   = "f572d396fae9206628714fb2ce00f72e94f2258f"
+		helloSHA256 = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	)
+
+	digest, err := ParseMultiDigest("sha256:" + helloSHA256)
+	if assert.NoError(err) {
+		assert.NoError(digest.Verify(path))
+	}
+
+	// Only the strongest digest is checked
+	digest, err = ParseMultiDigest("sha1:0000;sha256:" + helloSHA256)
+	if assert.NoError(err) {
+		assert.NoError(digest.Verify(path))
+	}
+
+	digest, err = ParseMultiDigest(helloSHA1 + ";sha256:0000")
+	if assert.NoError(err) {
+		err = digest.Verify(path)
+		if assert.Error(err) {
+			assert.Contains(err.Error(), "Computed sha256")
+		}
+	}
+
+	digest, err = ParseMultiDigest(helloSHA1)
+	if assert.NoError(err) {
+		assert.NoError(digest.Verify(path))
+	}
+}
@@ -10,11 +10,18 @@ import (
 
 // NewFinalRelease will create an instance of a BOSH final release
 func NewFinalRelease(path string) (release *Release, err error) {
+	return NewFinalReleaseWithSpecCache(path, nil)
+}
+
+// NewFinalReleaseWithSpecCache will create an instance of a BOSH final release,
+// reading job specs from specCache where possible
+func NewFinalReleaseWithSpecCache(path string, specCache *JobSpecCache) (release *Release, err error) {
 	release = &Release{
 		Path:         path,
 		Name:         "",
 		Version:      "",
 		FinalRelease: true,
+		specCache:    specCache,
 	}
 
 	release.Name, err = release.getFinalReleaseName()
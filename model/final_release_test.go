@@ -169,3 +169,35 @@ func TestFinalReleaseJobExtractOk(t *testing.T) {
 
 	assert.Nil(util.ValidatePath(extractedPath, true, "extracted job dir"))
 }
+
+func TestFinalReleaseCompiledPackagesOk(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	compiledReleasePath := filepath.Join(workDir, "../test-assets/test-compiled-release")
+
+	release, err := NewFinalRelease(compiledReleasePath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	assert.Len(release.Packages, 2)
+	for _, pkg := range release.Packages {
+		assert.True(pkg.IsCompiled())
+		assert.Equal("ubuntu-trusty/3421.11", pkg.Stemcell)
+		assert.Equal(filepath.Join(compiledReleasePath, "compiled_packages", pkg.Name+".tgz"), pkg.Path)
+		assert.NoError(pkg.ValidateSHA1())
+	}
+
+	barPkg, err := release.LookupPackage("bar")
+	if assert.NoError(err) && assert.Len(barPkg.Dependencies, 1) {
+		assert.Equal("foo", barPkg.Dependencies[0].Name)
+	}
+
+	fooJob, err := release.LookupJob("foo")
+	if assert.NoError(err) && assert.NotEmpty(fooJob.Packages) {
+		assert.True(fooJob.Packages[0].IsCompiled())
+	}
+}
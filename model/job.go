@@ -1,9 +1,7 @@
 package model
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -71,6 +69,10 @@ func newJob(release *Release, jobReleaseInfo map[interface{}]interface{}) (*Job,
 		return nil, err
 	}
 
+	if err := release.fetchMissingArchive("job", job.Name, job.Fingerprint, job.SHA1, job.Path); err != nil {
+		return nil, err
+	}
+
 	if err := job.loadJobSpec(); err != nil {
 		return nil, err
 	}
@@ -88,27 +90,17 @@ func (j *Job) getProperty(name string) (*JobProperty, error) {
 	return nil, fmt.Errorf("Property %s not found in job %s", name, j.Name)
 }
 
-// ValidateSHA1 validates that the SHA1 of the actual job archive is the same
-// as the one from the release manifest
+// ValidateSHA1 validates that the checksum of the actual job archive is the
+// same as the one from the release manifest. The manifest may list several
+// digests; the strongest algorithm available is used.
 func (j *Job) ValidateSHA1() error {
-	file, err := os.Open(j.Path)
-	if err != nil {
-		return fmt.Errorf("Error opening the job archive %s for sha1 calculation", j.Path)
-	}
-
-	defer file.Close()
-
-	h := sha1.New()
-
-	_, err = io.Copy(h, file)
+	digest, err := ParseMultiDigest(j.SHA1)
 	if err != nil {
-		return fmt.Errorf("Error copying job archive %s for sha1 calculation", j.Path)
+		return fmt.Errorf("Error parsing checksum of job archive %s: %s", j.Path, err.Error())
 	}
 
-	computedSha1 := fmt.Sprintf("%x", h.Sum(nil))
-
-	if computedSha1 != j.SHA1 {
-		return fmt.Errorf("Computed sha1 (%s) is different than manifest sha1 (%s) for job archive %s", computedSha1, j.SHA1, j.Path)
+	if err := digest.Verify(j.Path); err != nil {
+		return fmt.Errorf("%s for job archive %s", err.Error(), j.Path)
 	}
 
 	return nil
@@ -118,6 +110,10 @@ func (j *Job) ValidateSHA1() error {
 // It creates a directory with the name of the job
 // Returns the full path of the extracted archive
 func (j *Job) Extract(destination string) (string, error) {
+	if _, err := os.Stat(j.Path); os.IsNotExist(err) {
+		return "", missingArchiveError("job", j.Release, j.Name, j.Path)
+	}
+
 	targetDir := filepath.Join(destination, j.Name)
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
 		return "", err
@@ -143,6 +139,10 @@ func (j *Job) loadJobInfo() (err error) {
 	j.SHA1 = j.jobReleaseInfo["sha1"].(string)
 	j.Path = j.jobArchivePath()
 
+	if _, err := ParseMultiDigest(j.SHA1); err != nil {
+		return fmt.Errorf("Error loading job %s: %s", j.Name, err.Error())
+	}
+
 	return nil
 }
 
@@ -153,28 +153,16 @@ func (j *Job) loadJobSpec() (err error) {
 		}
 	}()
 
-	tempJobDir, err := ioutil.TempDir("", "fissile-job-dir")
-	defer func() {
-		if cleanupErr := os.RemoveAll(tempJobDir); cleanupErr != nil && err != nil {
-			err = fmt.Errorf("Error loading job spec: %v,  cleanup error: %v", err, cleanupErr)
-		} else if cleanupErr != nil {
-			err = fmt.Errorf("Error cleaning up after load job spec: %v", cleanupErr)
+	entry, ok := j.Release.specCache.load(j)
+	if !ok {
+		entry, err = j.readJobArchive()
+		if err != nil {
+			return err
 		}
-	}()
-	if err != nil {
-		return err
-	}
-
-	jobDir, err := j.Extract(tempJobDir)
-	if err != nil {
-		return fmt.Errorf("Error extracting archive (%s) for job %s: %s", j.Path, j.Name, err.Error())
-	}
-
-	specFile := filepath.Join(jobDir, "job.MF")
 
-	specContents, err := ioutil.ReadFile(specFile)
-	if err != nil {
-		return err
+		if err := j.Release.specCache.store(j, entry); err != nil {
+			return err
+		}
 	}
 
 	// jobSpec describes the contents of "job.MF" files
@@ -187,6 +175,7 @@ func (j *Job) loadJobSpec() (err error) {
 			Description string
 			Default     interface{}
 			Example     interface{}
+			Type        string
 		}
 		Consumes []struct {
 			Name     string
@@ -200,7 +189,7 @@ func (j *Job) loadJobSpec() (err error) {
 		}
 	}
 
-	if err := yaml.Unmarshal([]byte(specContents), &jobSpec); err != nil {
+	if err := yaml.Unmarshal([]byte(entry.Spec), &jobSpec); err != nil {
 		return err
 	}
 
@@ -216,18 +205,16 @@ func (j *Job) loadJobSpec() (err error) {
 	}
 
 	for source, destination := range jobSpec.Templates {
-		templateFile := filepath.Join(jobDir, "templates", source)
-
-		templateContent, err := ioutil.ReadFile(templateFile)
-		if err != nil {
-			return err
+		templateContent, ok := entry.Templates[source]
+		if !ok {
+			return fmt.Errorf("Template %s of job %s is missing from the job archive", source, j.Name)
 		}
 
 		template := &JobTemplate{
 			SourcePath:      source,
 			DestinationPath: destination,
 			Job:             j,
-			Content:         string(templateContent),
+			Content:         templateContent,
 		}
 
 		j.Templates = append(j.Templates, template)
@@ -247,6 +234,8 @@ func (j *Job) loadJobSpec() (err error) {
 			Job:         j,
 			Description: jobSpec.Properties[propertyName].Description,
 			Default:     jobSpec.Properties[propertyName].Default,
+			Example:     jobSpec.Properties[propertyName].Example,
+			Type:        jobSpec.Properties[propertyName].Type,
 		}
 
 		j.Properties = append(j.Properties, property)
@@ -284,6 +273,55 @@ func (j *Job) loadJobSpec() (err error) {
 	return nil
 }
 
+// readJobArchive extracts the job archive and reads the job.MF and the
+// templates it references
+func (j *Job) readJobArchive() (entry *jobSpecCacheEntry, err error) {
+	tempJobDir, err := ioutil.TempDir("", "fissile-job-dir")
+	defer func() {
+		if cleanupErr := os.RemoveAll(tempJobDir); cleanupErr != nil && err != nil {
+			err = fmt.Errorf("Error loading job spec: %v,  cleanup error: %v", err, cleanupErr)
+		} else if cleanupErr != nil {
+			err = fmt.Errorf("Error cleaning up after load job spec: %v", cleanupErr)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	jobDir, err := j.Extract(tempJobDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error extracting archive (%s) for job %s: %s", j.Path, j.Name, err.Error())
+	}
+
+	specContents, err := ioutil.ReadFile(filepath.Join(jobDir, "job.MF"))
+	if err != nil {
+		return nil, err
+	}
+
+	var jobSpec struct {
+		Templates map[string]string
+	}
+	if err := yaml.Unmarshal(specContents, &jobSpec); err != nil {
+		return nil, err
+	}
+
+	entry = &jobSpecCacheEntry{
+		Spec:      string(specContents),
+		Templates: make(map[string]string, len(jobSpec.Templates)),
+	}
+
+	for source := range jobSpec.Templates {
+		templateContent, err := ioutil.ReadFile(filepath.Join(jobDir, "templates", source))
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Templates[source] = string(templateContent)
+	}
+
+	return entry, nil
+}
+
 // MergeSpec is used to merge temporary spec patches into each job. otherJob should only be
 // the fissile-compat/patch-properties job.  The code assumes package and property objects are immutable,
 // as they're now being shared across jobs. Also, when specified packages or properties are
@@ -250,7 +250,7 @@ func testJobsProperties(fakeRelease *Release) func(*testing.T) {
 
 		lightOpinionsPath := filepath.Join(workDir, "../test-assets/ntp-opinions/opinions.yml")
 		darkOpinionsPath := filepath.Join(workDir, "../test-assets/ntp-opinions/dark-opinions.yml")
-		opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath)
+		opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath, "")
 		assert.NoError(err)
 
 		properties, err := fakeRelease.Jobs[0].GetPropertiesForJob(opinions)
@@ -280,7 +280,7 @@ func testFinalJobsProperties(fakeRelease *Release) func(*testing.T) {
 
 		lightOpinionsPath := filepath.Join(workDir, "../test-assets/ntp-opinions/opinions.yml")
 		darkOpinionsPath := filepath.Join(workDir, "../test-assets/ntp-opinions/dark-opinions.yml")
-		opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath)
+		opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath, "")
 		assert.NoError(err)
 
 		properties, err := fakeRelease.Jobs[0].GetPropertiesForJob(opinions)
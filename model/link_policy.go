@@ -0,0 +1,161 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SUSE/fissile/validation"
+)
+
+// LinkPolicy describes how link consumers are wired to providers when more
+// than one job provides a link of the type they consume. It can be set for
+// the whole role manifest, and for single roles; the role settings take
+// precedence.
+type LinkPolicy struct {
+	// Preferred maps link types to the provider to use for them
+	Preferred map[string]LinkProviderRef `yaml:"preferred,omitempty"`
+}
+
+// LinkProviderRef names a job providing a link. The job may be left out if
+// only one job of the role provides links of the type.
+type LinkProviderRef struct {
+	Role string `yaml:"role"`
+	Job  string `yaml:"job,omitempty"`
+}
+
+// String returns the provider as <role>/<job>, or just the role
+func (ref LinkProviderRef) String() string {
+	if ref.Job == "" {
+		return ref.Role
+	}
+	return fmt.Sprintf("%s/%s", ref.Role, ref.Job)
+}
+
+// ResolvedLink describes a BOSH link consumed by a job, and the job that
+// provides it
+type ResolvedLink struct {
+	Role         string `json:"role" yaml:"role"`
+	Job          string `json:"job" yaml:"job"`
+	Name         string `json:"name" yaml:"name"`
+	Type         string `json:"type" yaml:"type"`
+	ProviderRole string `json:"provider_role" yaml:"provider_role"`
+	ProviderJob  string `json:"provider_job" yaml:"provider_job"`
+	// Service is the kubernetes service through which the provider is reached
+	Service string `json:"service" yaml:"service"`
+}
+
+// ResolvedLinks returns the links consumed by all jobs in the role manifest,
+// sorted by consuming role, job and link name
+func (m *RoleManifest) ResolvedLinks() []ResolvedLink {
+	links := make([]ResolvedLink, 0)
+
+	for _, role := range m.Roles {
+		for _, roleJob := range role.RoleJobs {
+			for name, consumer := range roleJob.ResolvedConsumers {
+				var service string
+				if provider := m.LookupRole(consumer.RoleName); provider != nil {
+					service = provider.ServiceName()
+				}
+				links = append(links, ResolvedLink{
+					Role:         role.Name,
+					Job:          roleJob.Name,
+					Name:         name,
+					Type:         consumer.Type,
+					ProviderRole: consumer.RoleName,
+					ProviderJob:  consumer.JobName,
+					Service:      service,
+				})
+			}
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Role != links[j].Role {
+			return links[i].Role < links[j].Role
+		}
+		if links[i].Job != links[j].Job {
+			return links[i].Job < links[j].Job
+		}
+		return links[i].Name < links[j].Name
+	})
+
+	return links
+}
+
+// describeLinkProviders lists the role and job of each provider, for use in
+// error messages
+func describeLinkProviders(providers []jobProvidesInfo) string {
+	if len(providers) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		names = append(names, fmt.Sprintf("%s/%s", provider.RoleName, provider.JobName))
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// matchLinkProviders returns the providers matching the reference
+func matchLinkProviders(ref LinkProviderRef, providers []jobProvidesInfo) []jobProvidesInfo {
+	var matches []jobProvidesInfo
+	for _, provider := range providers {
+		if provider.RoleName == ref.Role && (ref.Job == "" || provider.JobName == ref.Job) {
+			matches = append(matches, provider)
+		}
+	}
+	return matches
+}
+
+// preferredLinkProvider returns the preferred provider for links of the given
+// type consumed in role, if the role or the role manifest has a preference
+func (m *RoleManifest) preferredLinkProvider(role *Role, linkType string) (LinkProviderRef, bool) {
+	if role.Configuration != nil {
+		if ref, ok := role.Configuration.Links.Preferred[linkType]; ok {
+			return ref, true
+		}
+	}
+	if m.Configuration != nil {
+		if ref, ok := m.Configuration.Links.Preferred[linkType]; ok {
+			return ref, true
+		}
+	}
+	return LinkProviderRef{}, false
+}
+
+// validateLinkPolicy checks that the link preferences of a role manifest or
+// role name exactly one provider of the link type each
+func validateLinkPolicy(field string, policy LinkPolicy, providersByType map[string][]jobProvidesInfo) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	linkTypes := make([]string, 0, len(policy.Preferred))
+	for linkType := range policy.Preferred {
+		linkTypes = append(linkTypes, linkType)
+	}
+	sort.Strings(linkTypes)
+
+	for _, linkType := range linkTypes {
+		ref := policy.Preferred[linkType]
+		candidates := providersByType[linkType]
+		switch len(matchLinkProviders(ref, candidates)) {
+		case 1:
+			// Nothing to do.
+		case 0:
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("%s.links.preferred[%s]", field, linkType),
+				ref.String(),
+				fmt.Sprintf("Does not provide links of type %s; candidates: %s", linkType, describeLinkProviders(candidates))))
+		default:
+			allErrs = append(allErrs, validation.Invalid(
+				fmt.Sprintf("%s.links.preferred[%s]", field, linkType),
+				ref.String(),
+				fmt.Sprintf("Several jobs provide links of type %s, a job must be given; candidates: %s",
+					linkType, describeLinkProviders(matchLinkProviders(ref, candidates)))))
+		}
+	}
+
+	return allErrs
+}
@@ -15,7 +15,12 @@ type JobProperty struct {
 	Name        string
 	Description string
 	Default     interface{}
-	Job         *Job
+	// Example is the example value from the job spec, if there is one
+	Example interface{}
+	// Type is the type of the property as given in the job spec (e.g.
+	// "certificate"); most properties have none
+	Type string
+	Job  *Job
 }
 
 // MarshalJSON implements the encoding/json.Marshaler interface
@@ -34,10 +39,18 @@ func (p *JobProperty) MarshalYAML() (interface{}, error) {
 		jobName = p.Job.Name
 	}
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"name":        p.Name,
 		"description": p.Description,
 		"default":     p.Default,
 		"job":         jobName,
-	}, nil
+	}
+	if p.Example != nil {
+		result["example"] = p.Example
+	}
+	if p.Type != "" {
+		result["type"] = p.Type
+	}
+
+	return result, nil
 }
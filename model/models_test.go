@@ -42,6 +42,24 @@ var jobPropertyMarshalTestCases = []struct {
 			"job":         "",
 		},
 	},
+	{
+		name: "example",
+		property: &JobProperty{
+			Name:        "typed-property",
+			Description: "A property with an example",
+			Example:     []interface{}{"a", "b"},
+			Type:        "certificate",
+			Job:         &Job{Name: "job-name"},
+		},
+		expected: map[string]interface{}{
+			"name":        "typed-property",
+			"description": "A property with an example",
+			"default":     nil,
+			"example":     []interface{}{"a", "b"},
+			"type":        "certificate",
+			"job":         "job-name",
+		},
+	},
 }
 
 func TestJobPropertyMarshalYAML(t *testing.T) {
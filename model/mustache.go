@@ -24,14 +24,71 @@ func MakeMapOfVariables(roleManifest *RoleManifest) CVMap {
 	return configsDictionary
 }
 
+// MakeMapOfPropertyExamples returns the examples from the job specs of the
+// properties that are set directly from a configuration variable (i.e. whose
+// template is just "((VARIABLE))", optionally quoted), keyed by variable name. A variable used
+// for several such properties gets the first example found, going through the
+// roles, jobs and properties in order.
+func MakeMapOfPropertyExamples(roleManifest *RoleManifest) map[string]interface{} {
+	examples := map[string]interface{}{}
+
+	for _, role := range roleManifest.Roles {
+		if role.Configuration == nil {
+			continue
+		}
+		for _, roleJob := range role.RoleJobs {
+			for _, property := range roleJob.Properties {
+				if property.Example == nil {
+					continue
+				}
+
+				template, ok := role.Configuration.Templates[fmt.Sprintf("properties.%s", property.Name)]
+				if !ok {
+					continue
+				}
+				varsInTemplate, err := parseTemplate(template)
+				if err != nil || len(varsInTemplate) != 1 {
+					continue
+				}
+				name := varsInTemplate[0]
+				template = strings.TrimSpace(template)
+				if strings.HasPrefix(template, `"`) && strings.HasSuffix(template, `"`) && len(template) > 1 {
+					template = template[1 : len(template)-1]
+				}
+				if template != fmt.Sprintf("((%s))", name) {
+					continue
+				}
+
+				if _, ok := examples[name]; !ok {
+					examples[name] = property.Example
+				}
+			}
+		}
+	}
+
+	return examples
+}
+
 // GetVariablesForRole returns all the environment variables required for
-// calculating all the templates for the role
+// calculating all the templates for the role. Docker roles have no templates,
+// and get the variables listed in their run.env instead.
 func (r *Role) GetVariablesForRole() (ConfigurationVariableSlice, error) {
 
 	configsDictionary := MakeMapOfVariables(r.roleManifest)
 
 	configs := CVMap{}
 
+	if r.Type == RoleTypeDocker {
+		result := make(ConfigurationVariableSlice, 0, len(r.Run.Environment))
+		for _, envVar := range r.Run.Environment {
+			if confVar, ok := configsDictionary[envVar]; ok {
+				result = append(result, confVar)
+			}
+		}
+		sort.Sort(result)
+		return result, nil
+	}
+
 	// First, render all referenced variables of type user.
 
 	for _, roleJob := range r.RoleJobs {
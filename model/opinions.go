@@ -4,6 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/SUSE/fissile/patch"
 
 	"gopkg.in/yaml.v2"
 )
@@ -12,6 +17,15 @@ import (
 type Opinions struct {
 	Light map[string]interface{}
 	Dark  map[string]interface{}
+
+	lightSources []opinionsSource
+	darkSources  []opinionsSource
+}
+
+// opinionsSource is one of the files the opinions were merged from
+type opinionsSource struct {
+	file      string
+	positions *yamlPosition
 }
 
 // NewEmptyOpinions returns an empty opinions object, used for testing and
@@ -30,11 +44,29 @@ func NewEmptyOpinions() *Opinions {
 	return result
 }
 
-// NewOpinions returns the json opinions for the light and dark opinion files
-func NewOpinions(lightFile, darkFile string) (*Opinions, error) {
+// NewOpinions returns the json opinions for the light and dark opinion files.
+// Each of them can be a comma separated list of files, which are merged in
+// order; files holding a list of operations instead are BOSH ops files, and
+// are applied to the opinions merged before them. If a vars file is given,
+// ((name)) placeholders in the opinions are replaced by its values.
+func NewOpinions(lightFile, darkFile, varsFile string) (*Opinions, error) {
 	result := &Opinions{}
 
-	manifestContents, err := ioutil.ReadFile(lightFile)
+	var vars map[interface{}]interface{}
+	if varsFile != "" {
+		varsContents, err := ioutil.ReadFile(varsFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(varsContents, &vars); err != nil {
+			return nil, fmt.Errorf("Error loading opinions vars file %s: %s", varsFile, err.Error())
+		}
+		if vars == nil {
+			vars = map[interface{}]interface{}{}
+		}
+	}
+
+	manifestContents, sources, err := composeOpinions(lightFile, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -43,8 +75,9 @@ func NewOpinions(lightFile, darkFile string) (*Opinions, error) {
 	if err != nil {
 		return nil, err
 	}
+	result.lightSources = sources
 
-	manifestContents, err = ioutil.ReadFile(darkFile)
+	manifestContents, sources, err = composeOpinions(darkFile, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -53,10 +86,192 @@ func NewOpinions(lightFile, darkFile string) (*Opinions, error) {
 	if err != nil {
 		return nil, err
 	}
+	result.darkSources = sources
 
 	return result, nil
 }
 
+// composeOpinions reads the comma separated opinion files, and returns the
+// YAML document resulting from merging them, applying the ops files among
+// them and interpolating the vars. A single opinions file without vars is
+// returned as it is. The files of opinions are returned as well, to find the
+// opinions in them.
+func composeOpinions(opinionsFiles string, vars map[interface{}]interface{}) ([]byte, []opinionsSource, error) {
+	var paths []string
+	for _, path := range strings.Split(opinionsFiles, ",") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("No opinions file given")
+	}
+
+	var sources []opinionsSource
+	var doc interface{}
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(paths) == 1 && vars == nil {
+			sources = append(sources, opinionsSource{file: path, positions: indexBlockYAML(contents)})
+			return contents, sources, nil
+		}
+
+		var fileDoc interface{}
+		if err := yaml.Unmarshal(contents, &fileDoc); err != nil {
+			return nil, nil, fmt.Errorf("Error loading opinions file %s: %s", path, err.Error())
+		}
+
+		switch fileDoc.(type) {
+		case []interface{}:
+			var ops patch.Ops
+			if ops, err = patch.LoadOps(path); err != nil {
+				return nil, nil, err
+			}
+			if doc, err = ops.Apply(doc); err != nil {
+				return nil, nil, fmt.Errorf("Error applying ops file %s: %s", path, err.Error())
+			}
+		case map[interface{}]interface{}, nil:
+			doc = mergeOpinionDocs(doc, fileDoc)
+			sources = append(sources, opinionsSource{file: path, positions: indexBlockYAML(contents)})
+		default:
+			return nil, nil, fmt.Errorf("Opinions file %s is neither a map of opinions nor a list of operations", path)
+		}
+	}
+
+	if vars != nil {
+		var missing []string
+		doc = interpolateOpinions(doc, vars, &missing)
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return nil, nil, fmt.Errorf("Error interpolating opinions %s: no values for variables: %s",
+				opinionsFiles, strings.Join(uniqueStrings(missing), ", "))
+		}
+	}
+
+	if doc == nil {
+		return []byte{}, sources, nil
+	}
+
+	contents, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return contents, sources, nil
+}
+
+// mergeOpinionDocs merges the overlay opinions into the base opinions. Maps
+// are merged key by key; all other values, lists included, are replaced by
+// those of the overlay.
+func mergeOpinionDocs(base, overlay interface{}) interface{} {
+	typedOverlay, ok := overlay.(map[interface{}]interface{})
+	if !ok {
+		if overlay == nil {
+			return base
+		}
+		return overlay
+	}
+	typedBase, ok := base.(map[interface{}]interface{})
+	if !ok {
+		return overlay
+	}
+
+	result := make(map[interface{}]interface{}, len(typedBase)+len(typedOverlay))
+	for key, value := range typedBase {
+		result[key] = value
+	}
+	for key, value := range typedOverlay {
+		if baseValue, ok := result[key]; ok {
+			result[key] = mergeOpinionDocs(baseValue, value)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// opinionVarPattern matches ((name)) placeholders, where the name may be a
+// dotted path into the vars (((name.key)))
+var opinionVarPattern = regexp.MustCompile(`\(\(([-\w./]+)\)\)`)
+
+// interpolateOpinions replaces the ((name)) placeholders in the string values
+// of doc with the values of the vars. A string consisting of a single
+// placeholder is replaced by the value as it is; otherwise the value is
+// inserted as text. The names of variables without a value are appended to
+// missing.
+func interpolateOpinions(doc interface{}, vars map[interface{}]interface{}, missing *[]string) interface{} {
+	switch typed := doc.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(typed))
+		for key, value := range typed {
+			result[key] = interpolateOpinions(value, vars, missing)
+		}
+		return result
+
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for index, value := range typed {
+			result[index] = interpolateOpinions(value, vars, missing)
+		}
+		return result
+
+	case string:
+		if match := opinionVarPattern.FindStringSubmatch(typed); match != nil && match[0] == typed {
+			value, ok := lookupOpinionVar(vars, match[1])
+			if !ok {
+				*missing = append(*missing, match[1])
+				return typed
+			}
+			return value
+		}
+		return opinionVarPattern.ReplaceAllStringFunc(typed, func(placeholder string) string {
+			name := opinionVarPattern.FindStringSubmatch(placeholder)[1]
+			value, ok := lookupOpinionVar(vars, name)
+			if !ok {
+				*missing = append(*missing, name)
+				return placeholder
+			}
+			return fmt.Sprintf("%v", value)
+		})
+	}
+
+	return doc
+}
+
+// lookupOpinionVar returns the value of a variable, following dotted names
+// into nested maps
+func lookupOpinionVar(vars map[interface{}]interface{}, name string) (interface{}, bool) {
+	if value, ok := vars[name]; ok {
+		return value, true
+	}
+
+	var current interface{} = vars
+	for _, piece := range strings.Split(name, ".") {
+		typed, ok := current.(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = typed[piece]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// uniqueStrings returns the sorted strings without consecutive duplicates
+func uniqueStrings(sorted []string) []string {
+	var result []string
+	for index, value := range sorted {
+		if index == 0 || value != sorted[index-1] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
 // FlattenOpinions converts the incoming nested map of opinions into a
 // flat map of properties to values (strings). When 'total' is set (to
 // true) array values are recursed into and flattened as well.
@@ -114,6 +329,31 @@ func flattenOpinionsRecurse(result map[string]string, prefix string, value inter
 	result[prefix] = fmt.Sprintf("%v", value)
 }
 
+// LightPosition returns the file, line and column of a light opinion, such as
+// properties.tor.hostname. The file is empty if the opinion was not found,
+// for example because it was set by an ops file.
+func (o *Opinions) LightPosition(property string) (string, int, int) {
+	return opinionPosition(o.lightSources, property)
+}
+
+// DarkPosition returns the file, line and column of a dark opinion, like
+// LightPosition
+func (o *Opinions) DarkPosition(property string) (string, int, int) {
+	return opinionPosition(o.darkSources, property)
+}
+
+// opinionPosition finds an opinion in the files the opinions were merged
+// from, starting with the last one, as it takes precedence
+func opinionPosition(sources []opinionsSource, property string) (string, int, int) {
+	path := strings.Split(property, ".")
+	for index := len(sources) - 1; index >= 0; index-- {
+		if node, found := sources[index].positions.lookup(path); found {
+			return sources[index].file, node.line, node.column
+		}
+	}
+	return "", 0, 0
+}
+
 // GetOpinionForKey pulls an opinion out of the holding container.
 func (o *Opinions) GetOpinionForKey(opinions map[string]interface{}, keyPieces []string) (result interface{}) {
 	return getDeepValueFromManifest(opinions, keyPieces)
@@ -3,9 +3,11 @@ package model
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestOpinionsLoad(t *testing.T) {
@@ -17,7 +19,7 @@ func TestOpinionsLoad(t *testing.T) {
 	opinionsFile := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
 	opinionsFileDark := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
 
-	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark)
+	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark, "")
 	assert.Nil(err)
 	assert.NotNil(confOpinions)
 }
@@ -32,7 +34,7 @@ func TestGetOpinionForKey(t *testing.T) {
 	opinionsFile := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
 	opinionsFileDark := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
 
-	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark)
+	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark, "")
 	assert.Nil(err)
 	assert.NotNil(confOpinions)
 
@@ -52,7 +54,7 @@ func TestGetOpinionWithDarkKey(t *testing.T) {
 	opinionsFile := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
 	opinionsFileDark := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
 
-	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark)
+	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark, "")
 	assert.Nil(err)
 	assert.NotNil(confOpinions)
 
@@ -70,7 +72,7 @@ func TestGetOpinionForKeyInvalid(t *testing.T) {
 	opinionsFile := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
 	opinionsFileDark := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
 
-	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark)
+	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark, "")
 	assert.Nil(err)
 	assert.NotNil(confOpinions)
 
@@ -88,7 +90,7 @@ func TestOpinionsFlattenOpinions(t *testing.T) {
 
 	opinionsFile := filepath.Join(workDir, "../test-assets/test-opinions/opinions.yml")
 	opinionsFileDark := filepath.Join(workDir, "../test-assets/test-opinions/dark-opinions.yml")
-	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark)
+	confOpinions, err := NewOpinions(opinionsFile, opinionsFileDark, "")
 	assert.Nil(err)
 	assert.NotNil(confOpinions)
 
@@ -105,3 +107,117 @@ func TestOpinionsFlattenOpinions(t *testing.T) {
 		assert.Contains(light, property)
 	}
 }
+
+func TestOpinionsCompose(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	opinionsDir := filepath.Join(workDir, "../test-assets/test-opinions")
+	composeDir := filepath.Join(opinionsDir, "compose")
+
+	expected, err := NewOpinions(
+		filepath.Join(opinionsDir, "opinions.yml"),
+		filepath.Join(opinionsDir, "dark-opinions.yml"),
+		"")
+	require.NoError(t, err)
+
+	composed, err := NewOpinions(
+		strings.Join([]string{
+			filepath.Join(composeDir, "opinions.yml"),
+			filepath.Join(composeDir, "opinions-overlay.yml"),
+			filepath.Join(composeDir, "opinions-ops.yml"),
+		}, ","),
+		strings.Join([]string{
+			filepath.Join(composeDir, "dark-opinions.yml"),
+			filepath.Join(composeDir, "dark-opinions-ops.yml"),
+		}, ","),
+		filepath.Join(composeDir, "vars.yml"))
+	require.NoError(t, err)
+
+	assert.Equal(expected.Light, composed.Light)
+	assert.Equal(expected.Dark, composed.Dark)
+
+	// Opinions are located in the last file setting them
+	file, line, column := composed.LightPosition("properties.tor.int_opinion")
+	assert.Equal(filepath.Join(composeDir, "opinions-overlay.yml"), file)
+	assert.Equal(3, line)
+	assert.Equal(5, column)
+	file, line, _ = composed.LightPosition("properties.tor.opinion")
+	assert.Equal(filepath.Join(composeDir, "opinions.yml"), file)
+	assert.Equal(3, line)
+	file, _, _ = composed.LightPosition("properties.tor.no_such_opinion")
+	assert.Empty(file)
+
+	// Role images built from either must be the same
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	release, err := NewDevRelease(torReleasePath, "", "", filepath.Join(torReleasePath, "bosh-cache"))
+	require.NoError(t, err)
+	roleManifest, err := LoadRoleManifest(filepath.Join(workDir, "../test-assets/role-manifests/tor-good.yml"), []*Release{release}, nil)
+	require.NoError(t, err)
+	role := roleManifest.LookupRole("myrole")
+	require.NotNil(t, role)
+
+	expectedVersion, err := role.GetRoleDevVersion(expected, "", "", nil)
+	require.NoError(t, err)
+	composedVersion, err := role.GetRoleDevVersion(composed, "", "", nil)
+	require.NoError(t, err)
+	assert.Equal(expectedVersion, composedVersion)
+}
+
+func TestOpinionsComposeErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	composeDir := filepath.Join(workDir, "../test-assets/test-opinions/compose")
+	lightFile := filepath.Join(composeDir, "opinions.yml")
+	darkFile := filepath.Join(composeDir, "dark-opinions.yml")
+
+	// Without vars, placeholders are left alone
+	opinions, err := NewOpinions(lightFile, darkFile, "")
+	if assert.NoError(err) {
+		assert.Equal("((hostname))", opinions.GetOpinionForKey(opinions.Light, []string{"tor", "hostname"}))
+	}
+
+	_, err = NewOpinions(lightFile, darkFile, filepath.Join(composeDir, "missing-vars.yml"))
+	if assert.Error(err) {
+		assert.Equal("Error interpolating opinions "+darkFile+": no values for variables: darkness", err.Error())
+	}
+
+	opsFile := filepath.Join(composeDir, "opinions-ops.yml")
+	_, err = NewOpinions(lightFile, darkFile+","+opsFile, "")
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Error applying ops file "+opsFile)
+	}
+}
+
+func TestInterpolateOpinions(t *testing.T) {
+	assert := assert.New(t)
+
+	vars := map[interface{}]interface{}{
+		"port": 8080,
+		"host": "example.com",
+		"tls": map[interface{}]interface{}{
+			"ca": "CA",
+		},
+	}
+	doc := map[interface{}]interface{}{
+		"port":  "((port))",
+		"url":   "https://((host)):((port))/",
+		"list":  []interface{}{"((tls.ca))", 1},
+		"other": "((unknown))",
+	}
+
+	var missing []string
+	result := interpolateOpinions(doc, vars, &missing)
+	assert.Equal(map[interface{}]interface{}{
+		"port":  8080,
+		"url":   "https://example.com:8080/",
+		"list":  []interface{}{"CA", 1},
+		"other": "((unknown))",
+	}, result)
+	assert.Equal([]string{"unknown"}, missing)
+}
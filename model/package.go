@@ -1,9 +1,7 @@
 package model
 
 import (
-	"crypto/sha1"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 
@@ -16,6 +14,7 @@ type Package struct {
 	Version      string
 	Fingerprint  string
 	SHA1         string
+	Stemcell     string
 	Release      *Release
 	Path         string
 	Dependencies Packages
@@ -26,6 +25,32 @@ type Package struct {
 // Packages is an array of *Package
 type Packages []*Package
 
+// newCompiledPackage creates a package from a `compiled_packages` entry of a
+// compiled release; its archive holds the compilation result for the stemcell
+// named in the entry instead of the package sources.
+func newCompiledPackage(release *Release, packageReleaseInfo map[interface{}]interface{}) (*Package, error) {
+	pkg, err := newPackage(release, packageReleaseInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	stemcell, ok := packageReleaseInfo["stemcell"].(string)
+	if !ok || stemcell == "" {
+		return nil, fmt.Errorf("Compiled package %s has no stemcell", pkg.Name)
+	}
+
+	pkg.Stemcell = stemcell
+	pkg.Path = filepath.Join(release.Path, compiledPackagesDir, pkg.Name+".tgz")
+
+	return pkg, nil
+}
+
+// IsCompiled returns true if the package archive holds a package compiled
+// for Stemcell instead of the package sources
+func (p *Package) IsCompiled() bool {
+	return p.Stemcell != ""
+}
+
 func newPackage(release *Release, packageReleaseInfo map[interface{}]interface{}) (*Package, error) {
 	pkg := &Package{
 		Release: release,
@@ -37,39 +62,50 @@ func newPackage(release *Release, packageReleaseInfo map[interface{}]interface{}
 		return nil, err
 	}
 
+	if err := release.fetchMissingArchive("package", pkg.Name, pkg.Fingerprint, pkg.SHA1, pkg.Path); err != nil {
+		return nil, err
+	}
+
 	return pkg, nil
 }
 
-// ValidateSHA1 validates that the SHA1 of the actual package archive is the same
-// as the one from the release manifest
+// ValidateSHA1 validates that the checksum of the actual package archive is
+// the same as the one from the release manifest. The manifest may list several
+// digests; the strongest algorithm available is used.
 func (p *Package) ValidateSHA1() error {
-	file, err := os.Open(p.Path)
+	digest, err := ParseMultiDigest(p.SHA1)
 	if err != nil {
-		return fmt.Errorf("Error opening the package archive %s for SHA1 calculation", p.Path)
+		return fmt.Errorf("Error parsing checksum of package archive %s: %s", p.Path, err.Error())
 	}
 
-	defer file.Close()
-
-	h := sha1.New()
-
-	_, err = io.Copy(h, file)
-	if err != nil {
-		return fmt.Errorf("Error copying package archive %s for SHA1 calculation", p.Path)
+	if err := digest.Verify(p.Path); err != nil {
+		return fmt.Errorf("%s for package archive %s", err.Error(), p.Path)
 	}
 
-	computedSHA1 := fmt.Sprintf("%x", h.Sum(nil))
+	return nil
+}
 
-	if computedSHA1 != p.SHA1 {
-		return fmt.Errorf("Computed SHA1 (%s) is different than manifest SHA1 (%s) for package archive %s", computedSHA1, p.SHA1, p.Path)
+// StrongestDigest returns the value of the strongest digest of the package
+// archive listed in the release manifest. For plain SHA-1 checksums this is the
+// same as SHA1.
+func (p *Package) StrongestDigest() string {
+	digest, err := ParseMultiDigest(p.SHA1)
+	if err != nil {
+		return p.SHA1
 	}
 
-	return nil
+	_, value := digest.Strongest()
+	return value
 }
 
 // Extract will extract the contents of the package archive to destination
 // It creates a directory with the name of the package
 // Returns the full path of the extracted archive
 func (p *Package) Extract(destination string) (string, error) {
+	if _, err := os.Stat(p.Path); os.IsNotExist(err) {
+		return "", missingArchiveError("package", p.Release, p.Name, p.Path)
+	}
+
 	targetDir := filepath.Join(destination, p.Name)
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
 		return "", err
@@ -120,6 +156,10 @@ func (p *Package) loadPackageInfo() (err error) {
 	p.SHA1 = p.packageReleaseInfo["sha1"].(string)
 	p.Path = p.packageArchivePath()
 
+	if _, err := ParseMultiDigest(p.SHA1); err != nil {
+		return fmt.Errorf("Error loading package %s: %s", p.Name, err.Error())
+	}
+
 	return nil
 }
 
@@ -169,6 +209,12 @@ func (p *Package) GetPackageCompiledDir(workDir string) string {
 	return filepath.Join(workDir, p.Fingerprint, "compiled")
 }
 
+// GetPackageCompiledManifestPath returns the path to the manifest of the
+// build result directory of the package, underneath the main cache directory
+func (p *Package) GetPackageCompiledManifestPath(workDir string) string {
+	return filepath.Join(workDir, p.Fingerprint, "compiled.manifest.json")
+}
+
 // Marshal implements the util.Marshaler interface
 func (p *Package) Marshal() (interface{}, error) {
 	var releaseName string
@@ -180,7 +226,7 @@ func (p *Package) Marshal() (interface{}, error) {
 		dependencies = append(dependencies, dependency.Fingerprint)
 	}
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"name":         p.Name,
 		"version":      p.Version,
 		"fingerprint":  p.Fingerprint,
@@ -188,5 +234,10 @@ func (p *Package) Marshal() (interface{}, error) {
 		"release":      releaseName,
 		"path":         p.Path,
 		"dependencies": dependencies,
-	}, nil
+	}
+	if p.IsCompiled() {
+		result["stemcell"] = p.Stemcell
+	}
+
+	return result, nil
 }
@@ -6,7 +6,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sync"
 
+	"github.com/SUSE/fissile/blobstore"
 	"github.com/SUSE/fissile/util"
 	"gopkg.in/yaml.v2"
 )
@@ -24,13 +27,17 @@ type Release struct {
 	DevBOSHCacheDir    string
 	FinalRelease       bool
 
-	manifest map[interface{}]interface{}
+	manifest     map[interface{}]interface{}
+	specCache    *JobSpecCache
+	blobstore    blobstore.Blobstore
+	blobstoreErr error
 }
 
 const (
-	jobsDir      = "jobs"
-	packagesDir  = "packages"
-	manifestFile = "release.MF"
+	jobsDir             = "jobs"
+	packagesDir         = "packages"
+	compiledPackagesDir = "compiled_packages"
+	manifestFile        = "release.MF"
 )
 
 // yamlBinaryRegexp is the regexp used to look for the "!binary" YAML tag; see
@@ -124,15 +131,36 @@ func (r *Release) loadJobs() (err error) {
 	}()
 
 	jobs := r.manifest["jobs"].([]interface{})
-	for _, job := range jobs {
-		j, err := newJob(r, job.(map[interface{}]interface{}))
+	jobInfos := make([]map[interface{}]interface{}, len(jobs))
+	for idx, job := range jobs {
+		jobInfos[idx] = job.(map[interface{}]interface{})
+	}
+
+	// Extracting the job archives is the slow part of loading a release, so
+	// the jobs are loaded concurrently; the results keep the manifest order
+	loaded := make(Jobs, len(jobInfos))
+	errs := make([]error, len(jobInfos))
+	limit := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for idx, jobInfo := range jobInfos {
+		wg.Add(1)
+		go func(idx int, jobInfo map[interface{}]interface{}) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			loaded[idx], errs[idx] = newJob(r, jobInfo)
+		}(idx, jobInfo)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
 		if err != nil {
 			return err
 		}
-
-		r.Jobs = append(r.Jobs, j)
 	}
 
+	r.Jobs = append(r.Jobs, loaded...)
+
 	return nil
 }
 
@@ -152,6 +180,16 @@ func (r *Release) loadPackages() (err error) {
 			r.Packages = append(r.Packages, p)
 		}
 	}
+	if packages, ok := r.manifest["compiled_packages"].([]interface{}); ok {
+		for _, pkg := range packages {
+			p, err := newCompiledPackage(r, pkg.(map[interface{}]interface{}))
+			if err != nil {
+				return err
+			}
+
+			r.Packages = append(r.Packages, p)
+		}
+	}
 
 	return nil
 }
@@ -171,8 +209,8 @@ func (r *Release) loadLicense() error {
 
 	licenseFile, err := os.Open(r.licensePath())
 	if os.IsNotExist(err) {
-		// There were never licenses to load.
-		return nil
+		// Release tarballs only carry the archived license
+		return r.loadLicenseArchive()
 	}
 	if err != nil {
 		return err
@@ -194,6 +232,35 @@ func (r *Release) loadLicense() error {
 	return nil
 }
 
+// loadLicenseArchive loads the license files from the license.tgz of a final
+// release, if there is one
+func (r *Release) loadLicenseArchive() error {
+	if !r.FinalRelease {
+		return nil
+	}
+
+	archive, err := os.Open(r.licenseArchivePath())
+	if os.IsNotExist(err) {
+		// There were never licenses to load.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	files, err := util.LoadLicenseFiles(r.licenseArchivePath(), archive, util.DefaultLicensePrefixFilters...)
+	if err != nil {
+		return err
+	}
+
+	for name, contents := range files {
+		r.License.Files[filepath.Clean(name)] = contents
+	}
+
+	return nil
+}
+
 func (r *Release) validatePathStructure() error {
 	if err := util.ValidatePath(r.Path, true, "release directory"); err != nil {
 		return err
@@ -214,6 +281,10 @@ func (r *Release) licensePath() string {
 	return filepath.Join(r.Path, "LICENSE")
 }
 
+func (r *Release) licenseArchivePath() string {
+	return filepath.Join(r.Path, "license.tgz")
+}
+
 func (r *Release) packagesDirPath() string {
 	return filepath.Join(r.Path, packagesDir)
 }
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/SUSE/fissile/util"
+)
+
+// IsReleaseTarball returns true if the path points to a (gzipped) BOSH
+// release tarball, as downloaded from bosh.io, instead of a release directory
+func IsReleaseTarball(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	return strings.HasSuffix(path, ".tgz") || strings.HasSuffix(path, ".tar.gz")
+}
+
+// NewFinalReleaseFromTarball will create an instance of a BOSH final release
+// from a release tarball. The tarball is extracted into a directory below
+// extractionRoot named after the SHA1 of the tarball, so that unchanged
+// tarballs are only extracted once. Freshly extracted releases have the
+// checksums of all of their job and package archives verified. Job specs are
+// read from specCache where possible; it may be nil.
+func NewFinalReleaseFromTarball(tarballPath, extractionRoot string, specCache *JobSpecCache) (*Release, error) {
+	if extractionRoot == "" {
+		return nil, fmt.Errorf("No work directory to extract release tarball %s into", tarballPath)
+	}
+
+	digest, err := tarballSHA1(tarballPath)
+	if err != nil {
+		return nil, err
+	}
+
+	releasePath := filepath.Join(extractionRoot, digest)
+	if err := util.ValidatePath(filepath.Join(releasePath, manifestFile), false, "release manifest file"); err == nil {
+		// Already extracted by an earlier run
+		return NewFinalReleaseWithSpecCache(releasePath, specCache)
+	}
+
+	if err := extractReleaseTarball(tarballPath, digest, extractionRoot, releasePath); err != nil {
+		return nil, err
+	}
+
+	release, err := NewFinalReleaseWithSpecCache(releasePath, specCache)
+	if err != nil {
+		os.RemoveAll(releasePath)
+		return nil, err
+	}
+
+	if err := release.validateArchives(); err != nil {
+		os.RemoveAll(releasePath)
+		return nil, fmt.Errorf("Release tarball %s is corrupt: %s", tarballPath, err.Error())
+	}
+
+	return release, nil
+}
+
+// extractReleaseTarball streams the tarball into a temporary directory and
+// moves it into place once complete, so that an interrupted extraction never
+// leaves a partial release behind at releasePath.
+func extractReleaseTarball(tarballPath, expectedSHA1, extractionRoot, releasePath string) error {
+	if err := os.MkdirAll(extractionRoot, 0755); err != nil {
+		return err
+	}
+
+	tempDir, err := ioutil.TempDir(extractionRoot, ".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	file, err := os.Open(tarballPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha1.New()
+	if err := util.ExtractTargz(tarballPath, io.TeeReader(file, hasher), tempDir); err != nil {
+		return fmt.Errorf("Error extracting release tarball: %s", err.Error())
+	}
+
+	// Drain any trailing padding so the digest covers the whole file
+	if _, err := io.Copy(hasher, file); err != nil {
+		return err
+	}
+
+	if actualSHA1 := hex.EncodeToString(hasher.Sum(nil)); actualSHA1 != expectedSHA1 {
+		return fmt.Errorf("Release tarball %s changed during extraction (SHA1 %s, expected %s)", tarballPath, actualSHA1, expectedSHA1)
+	}
+
+	// Final releases are expected to have both directories, even if empty
+	for _, dir := range []string{jobsDir, packagesDir} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tempDir, releasePath); err != nil {
+		// A concurrent load may have extracted the same tarball meanwhile
+		if util.ValidatePath(filepath.Join(releasePath, manifestFile), false, "release manifest file") == nil {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// validateArchives checks the checksums of all job and package archives
+// against the ones recorded in the release manifest
+func (r *Release) validateArchives() error {
+	for _, pkg := range r.Packages {
+		if err := pkg.ValidateSHA1(); err != nil {
+			return err
+		}
+	}
+
+	for _, job := range r.Jobs {
+		if err := job.ValidateSHA1(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func tarballSHA1(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Error opening release tarball %s: %s", path, err.Error())
+	}
+	defer file.Close()
+
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("Error reading release tarball %s: %s", path, err.Error())
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
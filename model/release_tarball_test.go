@@ -0,0 +1,136 @@
+package model
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/fissile/util"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeReleaseTarball packs the release directory at releasePath into a
+// tarball the way `bosh create-release --tarball` lays it out
+func writeReleaseTarball(t *testing.T, releasePath, tarballPath string) {
+	file, err := os.Create(tarballPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err = filepath.Walk(releasePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(releasePath, path)
+		if err != nil {
+			return err
+		}
+		return util.CopyFileToTarStream(tarWriter, path, &tar.Header{
+			Name: "./" + filepath.ToSlash(relPath),
+			Mode: 0644,
+		})
+	})
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+}
+
+func TestFinalReleaseFromTarballOk(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	tempDir, err := ioutil.TempDir("", "fissile-release-tarball")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	tarballPath := filepath.Join(tempDir, "test-final-1.tgz")
+	writeReleaseTarball(t, filepath.Join(workDir, "../test-assets/test-final-release"), tarballPath)
+	assert.True(IsReleaseTarball(tarballPath))
+
+	extractionRoot := filepath.Join(tempDir, "releases")
+	release, err := NewFinalReleaseFromTarball(tarballPath, extractionRoot, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	digest, err := tarballSHA1(tarballPath)
+	assert.NoError(err)
+	assert.Equal(filepath.Join(extractionRoot, digest), release.Path)
+	assert.Equal("test-final", release.Name)
+	assert.Equal("1", release.Version)
+	assert.Len(release.Jobs, 2)
+	assert.Len(release.Packages, 2)
+	assert.NotEmpty(release.License.Files)
+
+	// A second load reuses the extracted release
+	release, err = NewFinalReleaseFromTarball(tarballPath, extractionRoot, nil)
+	if assert.NoError(err) {
+		assert.Equal(filepath.Join(extractionRoot, digest), release.Path)
+	}
+	entries, err := ioutil.ReadDir(extractionRoot)
+	assert.NoError(err)
+	assert.Len(entries, 1, "Expected only the extracted release in the work dir")
+}
+
+func TestFinalReleaseFromTarballCorrupt(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	tempDir, err := ioutil.TempDir("", "fissile-release-tarball")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// Copy the release and damage one of the package archives
+	releasePath := filepath.Join(tempDir, "release")
+	sourcePath := filepath.Join(workDir, "../test-assets/test-final-release")
+	err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(sourcePath, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(releasePath, relPath), 0755)
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(releasePath, relPath), contents, 0644)
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(releasePath, "packages", "foo.tgz"), []byte("garbage"), 0644))
+
+	tarballPath := filepath.Join(tempDir, "corrupt.tgz")
+	writeReleaseTarball(t, releasePath, tarballPath)
+
+	extractionRoot := filepath.Join(tempDir, "releases")
+	_, err = NewFinalReleaseFromTarball(tarballPath, extractionRoot, nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "is corrupt")
+	}
+
+	entries, err := ioutil.ReadDir(extractionRoot)
+	assert.NoError(err)
+	assert.Empty(entries, "Corrupt release should not be kept in the work dir")
+}
+
+func TestFinalReleaseFromTarballNotTarball(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	assert.False(IsReleaseTarball(filepath.Join(workDir, "../test-assets/test-final-release")))
+	assert.False(IsReleaseTarball(filepath.Join(workDir, "../test-assets/test-final-release/release.MF")))
+}
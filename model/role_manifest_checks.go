@@ -0,0 +1,282 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/SUSE/fissile/validation"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The IDs of the rules checked for role manifests. Roles and variables can
+// suppress some of them with suppress-rules, see RoleRules and VariableRules.
+const (
+	RuleConstantTemplate      = "constant-template"
+	RuleTemplateSorting       = "template-sorting"
+	RuleUndeclaredVariable    = "undeclared-variable"
+	RuleUnusedScript          = "unused-script"
+	RuleUnusedVariable        = "unused-variable"
+	RuleVariablePreviousNames = "variable-previous-names"
+	RuleVariableSorting       = "variable-sorting"
+)
+
+// Rules lists the IDs of the rules checked for role manifests
+var Rules = []string{
+	RuleConstantTemplate,
+	RuleTemplateSorting,
+	RuleUndeclaredVariable,
+	RuleUnusedScript,
+	RuleUnusedVariable,
+	RuleVariablePreviousNames,
+	RuleVariableSorting,
+}
+
+// RuleDescriptions describes the rules checked for role manifests, by their
+// IDs, as in the validator description
+var RuleDescriptions = map[string]string{
+	RuleConstantTemplate:      "Global templates use variables",
+	RuleTemplateSorting:       "Templates are sorted",
+	RuleUndeclaredVariable:    "Variables used by templates are declared",
+	RuleUnusedScript:          "Scripts are used by roles",
+	RuleUnusedVariable:        "Variables are used by templates",
+	RuleVariablePreviousNames: "Previous names of variables are unique",
+	RuleVariableSorting:       "Variables are sorted",
+}
+
+// RoleRules lists the IDs of the rules which roles can suppress. The other
+// rules are not checked for a single role.
+var RoleRules = []string{
+	RuleTemplateSorting,
+	RuleUndeclaredVariable,
+}
+
+// VariableRules lists the IDs of the rules which variables can suppress. The
+// other rules are not checked for a single variable.
+var VariableRules = []string{
+	RuleUnusedVariable,
+}
+
+// suppresses returns whether the role suppresses the rule
+func (r *Role) suppresses(rule string) bool {
+	return r != nil && stringInSlice(rule, r.SuppressRules)
+}
+
+// suppresses returns whether the variable suppresses the rule
+func (config *ConfigurationVariable) suppresses(rule string) bool {
+	return config != nil && stringInSlice(rule, config.SuppressRules)
+}
+
+// stringInSlice returns whether the slice contains the string
+func stringInSlice(value string, slice []string) bool {
+	for _, item := range slice {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Suppresses returns whether the error violates a rule which is suppressed
+// by the role or variable its field belongs to, such as roles[api].run or
+// configuration.variables[FOO]
+func (m *RoleManifest) Suppresses(err *validation.Error) bool {
+	if err.Rule == "" {
+		return false
+	}
+	path := splitValidationField(err.Field)
+	switch {
+	case len(path) > 1 && path[0] == "roles":
+		return m.LookupRole(path[1]).suppresses(err.Rule)
+	case len(path) > 2 && path[0] == "configuration" && path[1] == "variables" && m.Configuration != nil:
+		for _, cv := range m.Configuration.Variables {
+			if cv.Name == path[2] {
+				return cv.suppresses(err.Rule)
+			}
+		}
+	}
+	return false
+}
+
+// WithoutSuppressed returns the errors which are not suppressed by the roles
+// and variables of the role manifest
+func (m *RoleManifest) WithoutSuppressed(errs validation.ErrorList) validation.ErrorList {
+	result := validation.ErrorList{}
+	for _, err := range errs {
+		if !m.Suppresses(err) {
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
+// ValidateSuppressedRules checks that the rules suppressed by the roles and
+// variables are known, either rules of the role manifest or one of the other
+// rules given, so that misspelt rule IDs are not ignored silently. Known rules
+// must be in RoleRules or VariableRules, since suppressing the others would
+// have no effect.
+func (m *RoleManifest) ValidateSuppressedRules(otherRules ...string) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	known := append(append([]string{}, Rules...), otherRules...)
+	check := func(field string, suppressed []string, suppressible []string, scope string) {
+		for _, rule := range suppressed {
+			var err *validation.Error
+			switch {
+			case stringInSlice(rule, suppressible):
+				continue
+			case stringInSlice(rule, known):
+				err = validation.Invalid(field, rule, fmt.Sprintf("Can not be suppressed by a %s", scope))
+			default:
+				err = validation.Invalid(field, rule, "Not a known validation rule")
+			}
+			if file, line, column := m.Position(field); line != 0 {
+				err.Locate(file, line, column)
+			}
+			allErrs = append(allErrs, err)
+		}
+	}
+
+	for _, role := range m.Roles {
+		check(fmt.Sprintf("roles[%s].suppress-rules", role.Name), role.SuppressRules, RoleRules, "role")
+	}
+	if m.Configuration != nil {
+		for _, cv := range m.Configuration.Variables {
+			check(fmt.Sprintf("configuration.variables[%s].suppress-rules", cv.Name), cv.SuppressRules, VariableRules, "variable")
+		}
+	}
+
+	return allErrs
+}
+
+// roleManifestTemplateOrder holds the templates of a role manifest file in
+// the order they are written in
+type roleManifestTemplateOrder struct {
+	Roles []struct {
+		Name          string `yaml:"name"`
+		Configuration struct {
+			Templates yaml.MapSlice `yaml:"templates"`
+		} `yaml:"configuration"`
+	} `yaml:"roles"`
+	Configuration struct {
+		Templates yaml.MapSlice `yaml:"templates"`
+	} `yaml:"configuration"`
+}
+
+// ValidateTemplateSorting checks that the templates of the role manifest,
+// both global and per role, are listed in lexicographical order. It checks
+// each file the role manifest was composed from as written, since the order
+// is lost once the role manifest is loaded.
+func (m *RoleManifest) ValidateTemplateSorting() validation.ErrorList {
+	allErrs := validation.ErrorList{}
+	if m.sources == nil {
+		return allErrs
+	}
+
+	for _, layer := range m.sources.layers {
+		var order roleManifestTemplateOrder
+		if err := yaml.Unmarshal(layer.contents, &order); err != nil {
+			allErrs = append(allErrs, validation.InternalError("configuration.templates", err))
+			continue
+		}
+
+		var layerErrs validation.ErrorList
+		layerErrs = append(layerErrs, validateTemplateSorting("configuration.templates", order.Configuration.Templates)...)
+		for _, role := range order.Roles {
+			layerErrs = append(layerErrs, validateTemplateSorting(
+				fmt.Sprintf("roles[%s].configuration.templates", role.Name),
+				role.Configuration.Templates)...)
+		}
+
+		positions := m.sources.positions(layer.file)
+		for _, err := range layerErrs {
+			if len(m.sources.files) > 1 {
+				err.File = layer.file
+			}
+			path := append(splitValidationField(err.Field), fmt.Sprintf("%v", err.BadValue))
+			if node, found := positions.lookup(path); found {
+				err.Line = node.line
+				err.Column = node.column
+			}
+		}
+		allErrs = append(allErrs, layerErrs...)
+	}
+
+	return m.WithoutSuppressed(allErrs)
+}
+
+// validateTemplateSorting reports all templates which are out of order
+func validateTemplateSorting(field string, templates yaml.MapSlice) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	previousName := ""
+	for _, item := range templates {
+		name := fmt.Sprintf("%v", item.Key)
+		if name < previousName {
+			allErrs = append(allErrs, validation.Invalid(field,
+				previousName,
+				fmt.Sprintf("Does not sort before '%s'", name)).WithRule(RuleTemplateSorting, validation.SeverityError))
+		}
+		previousName = name
+	}
+
+	return allErrs
+}
+
+// ValidateScriptUsage checks that all scripts found in the scripts directory
+// are used by at least one role, as environment, pre-start or post-config
+// script. By default, the scripts directory is the scripts directory next to
+// the role manifest; there is nothing to check if it does not exist. The
+// errors name the unused scripts as their file.
+func (m *RoleManifest) ValidateScriptUsage(scriptsDir string) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	manifestDir := filepath.Dir(m.manifestFilePath)
+	if scriptsDir == "" {
+		scriptsDir = filepath.Join(manifestDir, "scripts")
+	}
+
+	if _, err := os.Stat(scriptsDir); os.IsNotExist(err) {
+		return allErrs
+	}
+
+	used := map[string]struct{}{}
+	for _, role := range m.Roles {
+		for _, path := range role.GetScriptPaths() {
+			used[filepath.Clean(path)] = struct{}{}
+		}
+	}
+
+	var unused []string
+	err := filepath.Walk(scriptsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if _, ok := used[filepath.Clean(path)]; ok {
+			return nil
+		}
+		relPath, err := filepath.Rel(manifestDir, path)
+		if err != nil {
+			relPath = path
+		}
+		unused = append(unused, relPath)
+		return nil
+	})
+	if err != nil {
+		return append(allErrs, validation.InternalError("scripts", err))
+	}
+
+	sort.Strings(unused)
+	for _, script := range unused {
+		err := validation.Invalid("scripts", script, "Not used by any role").WithRule(RuleUnusedScript, validation.SeverityError)
+		err.File = filepath.Join(manifestDir, script)
+		allErrs = append(allErrs, err)
+	}
+
+	return allErrs
+}
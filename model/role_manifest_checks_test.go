@@ -0,0 +1,143 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SUSE/fissile/validation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTemplateSorting(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	release, err := NewDevRelease(torReleasePath, "", "", filepath.Join(torReleasePath, "bosh-cache"))
+	require.NoError(t, err)
+
+	roleManifest, err := LoadRoleManifest(filepath.Join(workDir, "../test-assets/validate/good/role-manifest.yml"), []*Release{release}, nil)
+	require.NoError(t, err)
+	assert.Empty(roleManifest.ValidateTemplateSorting())
+
+	roleManifest, err = LoadRoleManifest(filepath.Join(workDir, "../test-assets/validate/bad/role-manifest.yml"), []*Release{release}, nil)
+	require.NoError(t, err)
+	errs := roleManifest.ValidateTemplateSorting()
+	assert.Equal([]string{
+		`configuration.templates: Invalid value: "properties.tor.hostname": Does not sort before 'properties.tor.hashed_control_password'`,
+		`roles[myrole].configuration.templates: Invalid value: "properties.tor.private_key": Does not sort before 'properties.tor.client_keys'`,
+	}, strings.Split(errs.Errors(), "\n"))
+	if assert.Len(errs, 2) {
+		// The single file is not named, but the keys are located
+		assert.Equal(21, errs[0].Line)
+		assert.Equal(5, errs[0].Column)
+		assert.Equal(10, errs[1].Line)
+		assert.Equal(7, errs[1].Column)
+	}
+}
+
+func TestValidateScriptUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	release, err := NewDevRelease(torReleasePath, "", "", filepath.Join(torReleasePath, "bosh-cache"))
+	require.NoError(t, err)
+
+	validateDir := filepath.Join(workDir, "../test-assets/validate")
+	roleManifest, err := LoadRoleManifest(filepath.Join(validateDir, "good/role-manifest.yml"), []*Release{release}, nil)
+	require.NoError(t, err)
+	assert.Empty(roleManifest.ValidateScriptUsage(""))
+	assert.Empty(roleManifest.ValidateScriptUsage(filepath.Join(validateDir, "good/no-such-dir")))
+
+	roleManifest, err = LoadRoleManifest(filepath.Join(validateDir, "bad/role-manifest.yml"), []*Release{release}, nil)
+	require.NoError(t, err)
+	errs := roleManifest.ValidateScriptUsage("")
+	assert.Equal([]string{
+		filepath.Join(validateDir, "bad/scripts/lib/helper.sh") + `: scripts: Invalid value: "scripts/lib/helper.sh": Not used by any role`,
+		filepath.Join(validateDir, "bad/scripts/unused.sh") + `: scripts: Invalid value: "scripts/unused.sh": Not used by any role`,
+	}, strings.Split(errs.Errors(), "\n"))
+}
+
+func TestRoleManifestSuppresses(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	release, err := NewDevRelease(torReleasePath, "", "", filepath.Join(torReleasePath, "bosh-cache"))
+	require.NoError(t, err)
+
+	// The unused variable, and the unsorted role templates and undeclared
+	// variable are suppressed
+	roleManifest, err := LoadRoleManifest(filepath.Join(workDir, "../test-assets/validate/suppressed/role-manifest.yml"), []*Release{release}, nil)
+	require.NoError(t, err)
+	assert.Empty(roleManifest.ValidateTemplateSorting())
+
+	tests := []struct {
+		err        *validation.Error
+		suppressed bool
+	}{
+		{validation.Invalid("roles[myrole].configuration.templates", "a", "b").WithRule(RuleTemplateSorting, validation.SeverityError), true},
+		{validation.Invalid("roles[myrole].run", "a", "b").WithRule(RuleUnusedScript, validation.SeverityError), false},
+		{validation.Invalid("roles[myrole].run", "a", "b"), false},
+		{validation.Invalid("roles[nosuchrole]", "a", "b").WithRule(RuleTemplateSorting, validation.SeverityError), false},
+		{validation.Invalid("configuration.variables[UNUSED].type", "a", "b").WithRule(RuleUnusedVariable, validation.SeverityWarning), true},
+		{validation.Invalid("configuration.variables[FOO]", "a", "b").WithRule(RuleUnusedVariable, validation.SeverityError), false},
+		{validation.Invalid("configuration.templates", "a", "b").WithRule(RuleTemplateSorting, validation.SeverityError), false},
+	}
+	for _, test := range tests {
+		assert.Equal(test.suppressed, roleManifest.Suppresses(test.err), test.err.Error())
+	}
+}
+
+func TestValidateSuppressedRules(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	release, err := NewDevRelease(torReleasePath, "", "", filepath.Join(torReleasePath, "bosh-cache"))
+	require.NoError(t, err)
+
+	roleManifest, err := LoadRoleManifest(filepath.Join(workDir, "../test-assets/validate/suppressed/role-manifest.yml"), []*Release{release}, nil)
+	require.NoError(t, err)
+	assert.Empty(roleManifest.ValidateSuppressedRules())
+
+	// Misspelt rules are reported where they are suppressed, rules checked
+	// elsewhere are known
+	roleManifest.Roles[0].SuppressRules = append(roleManifest.Roles[0].SuppressRules, "template-sortng")
+	errs := roleManifest.ValidateSuppressedRules("undeclared-env-variable")
+	if assert.Len(errs, 1) {
+		assert.Equal("roles[myrole].suppress-rules", errs[0].Field)
+		assert.Equal(`Invalid value: "template-sortng": Not a known validation rule`, errs[0].ErrorBody())
+		assert.Equal(4, errs[0].Line)
+		assert.Equal(3, errs[0].Column)
+	}
+
+	// Known rules which are not checked for single roles or variables can
+	// not be suppressed by them
+	roleManifest.Roles[0].SuppressRules = []string{RuleTemplateSorting, RuleUnusedScript, "undeclared-env-variable"}
+	roleManifest.Configuration.Variables[3].SuppressRules = []string{RuleUnusedVariable, RuleVariableSorting}
+	errs = roleManifest.ValidateSuppressedRules("undeclared-env-variable")
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.Field, err.ErrorBody()))
+	}
+	assert.Equal([]string{
+		`roles[myrole].suppress-rules: Invalid value: "unused-script": Can not be suppressed by a role`,
+		`roles[myrole].suppress-rules: Invalid value: "undeclared-env-variable": Can not be suppressed by a role`,
+		`configuration.variables[UNUSED].suppress-rules: Invalid value: "variable-sorting": Can not be suppressed by a variable`,
+	}, messages)
+}
@@ -0,0 +1,416 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/SUSE/fissile/patch"
+	"github.com/SUSE/fissile/validation"
+
+	"gopkg.in/yaml.v2"
+)
+
+// roleManifestIncludesKey is the key of the list of files a role manifest
+// includes
+const roleManifestIncludesKey = "includes"
+
+// manifestSource records which file introduced a part of a YAML document
+// composed from several files. The children of maps are keyed by their map
+// key; the children of lists by their index and, where the items have one,
+// by their name or tag, as used in the fields of validation errors.
+type manifestSource struct {
+	file     string
+	children map[string]*manifestSource
+}
+
+// manifestSources describes the files a role manifest was composed from
+type manifestSources struct {
+	files  []string
+	root   *manifestSource
+	layers []manifestLayer // The role manifest files, without the ops files
+}
+
+// manifestLayer is the contents of one file of a role manifest
+type manifestLayer struct {
+	file      string
+	contents  []byte
+	doc       interface{}
+	positions *yamlPosition // Indexed on demand, see manifestSources.positions
+}
+
+// splitRoleManifestPath splits the role manifest path into the base role
+// manifest, and the ops files to apply to it, in order
+func splitRoleManifestPath(manifestFilePath string) (string, []string) {
+	paths := strings.Split(manifestFilePath, ",")
+	var opsFiles []string
+	for _, path := range paths[1:] {
+		if path != "" {
+			opsFiles = append(opsFiles, path)
+		}
+	}
+	return paths[0], opsFiles
+}
+
+// composeRoleManifest reads the role manifest with the files it includes, and
+// applies the ops files to it. It returns the resulting YAML document, and
+// where each part of it came from.
+func composeRoleManifest(manifestFilePath string, opsFiles []string) ([]byte, *manifestSources, error) {
+	layers, err := loadManifestLayers(manifestFilePath, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sources := &manifestSources{layers: layers}
+	if len(layers) == 1 && len(opsFiles) == 0 {
+		// A plain role manifest is used as it is
+		sources.track(nil, layers[0].doc, layers[0].file)
+		return layers[0].contents, sources, nil
+	}
+
+	var doc interface{}
+	for _, layer := range layers {
+		merged := mergeManifestDocs(doc, layer.doc)
+		sources.track(doc, merged, layer.file)
+		doc = merged
+	}
+
+	for _, opsFile := range opsFiles {
+		ops, err := patch.LoadOps(opsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		patched, err := ops.Apply(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error applying ops file %s: %s", opsFile, err.Error())
+		}
+		sources.track(doc, patched, opsFile)
+		doc = patched
+	}
+
+	if doc == nil {
+		return []byte{}, sources, nil
+	}
+
+	contents, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return contents, sources, nil
+}
+
+// loadManifestLayers reads a role manifest file, and returns the contents of
+// the files it includes (recursively), followed by its own contents
+func loadManifestLayers(path string, including []string) ([]manifestLayer, error) {
+	for _, other := range including {
+		if other == path {
+			return nil, fmt.Errorf("Role manifest %s includes itself via %s", path, strings.Join(including, " -> "))
+		}
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return nil, fmt.Errorf("Error loading role manifest %s: %s", path, err.Error())
+	}
+
+	typed, ok := doc.(map[interface{}]interface{})
+	if !ok {
+		if doc == nil {
+			return []manifestLayer{{file: path, contents: contents}}, nil
+		}
+		return nil, fmt.Errorf("Role manifest %s is not a map", path)
+	}
+
+	includesValue, ok := typed[roleManifestIncludesKey]
+	if !ok {
+		return []manifestLayer{{file: path, contents: contents, doc: doc}}, nil
+	}
+	delete(typed, roleManifestIncludesKey)
+
+	includes, ok := includesValue.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("The includes of role manifest %s must be a list of files", path)
+	}
+
+	var layers []manifestLayer
+	for _, include := range includes {
+		includePath, ok := include.(string)
+		if !ok {
+			return nil, fmt.Errorf("The includes of role manifest %s must be a list of files, found %v", path, include)
+		}
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		included, err := loadManifestLayers(includePath, append(including, path))
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, included...)
+	}
+
+	return append(layers, manifestLayer{file: path, contents: contents, doc: doc}), nil
+}
+
+// mergeManifestDocs merges the overlay document into the base document. Maps
+// are merged key by key; lists of named items are merged by name, and other
+// values are replaced by those of the overlay.
+func mergeManifestDocs(base, overlay interface{}) interface{} {
+	switch typedOverlay := overlay.(type) {
+	case map[interface{}]interface{}:
+		typedBase, ok := base.(map[interface{}]interface{})
+		if !ok {
+			return overlay
+		}
+		result := make(map[interface{}]interface{}, len(typedBase)+len(typedOverlay))
+		for key, value := range typedBase {
+			result[key] = value
+		}
+		for key, value := range typedOverlay {
+			if baseValue, ok := result[key]; ok {
+				result[key] = mergeManifestDocs(baseValue, value)
+			} else {
+				result[key] = value
+			}
+		}
+		return result
+
+	case []interface{}:
+		typedBase, ok := base.([]interface{})
+		if !ok {
+			return overlay
+		}
+		result := append([]interface{}{}, typedBase...)
+		for _, item := range typedOverlay {
+			name := manifestItemName(item, "name")
+			if name == "" {
+				// Lists of unnamed items replace each other
+				return overlay
+			}
+			merged := false
+			for index, baseItem := range result {
+				if manifestItemName(baseItem, "name") == name {
+					result[index] = mergeManifestDocs(baseItem, item)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+
+	return overlay
+}
+
+// manifestItemName returns the value of the given key of a list item, if it
+// is a map with a scalar value for the key
+func manifestItemName(item interface{}, key string) string {
+	typed, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return ""
+	}
+	switch value := typed[key].(type) {
+	case string:
+		return value
+	case int, bool, float64:
+		return fmt.Sprintf("%v", value)
+	}
+	return ""
+}
+
+// manifestItemID returns the name by which validation errors refer to a list
+// item, if it has one
+func manifestItemID(item interface{}) string {
+	if name := manifestItemName(item, "name"); name != "" {
+		return name
+	}
+	return manifestItemName(item, "tag")
+}
+
+// track records the file that changed the document from before to after
+func (s *manifestSources) track(before, after interface{}, file string) {
+	s.files = append(s.files, file)
+	s.root = trackManifestSource(before, after, s.root, file)
+}
+
+// trackManifestSource returns the sources for the after document, attributing
+// everything that is not the same as in the before document to file
+func trackManifestSource(before, after interface{}, previous *manifestSource, file string) *manifestSource {
+	if previous != nil && reflect.DeepEqual(before, after) {
+		return previous
+	}
+
+	node := &manifestSource{file: file, children: map[string]*manifestSource{}}
+	childSource := func(key string) *manifestSource {
+		if previous == nil {
+			return nil
+		}
+		return previous.children[key]
+	}
+
+	switch typedAfter := after.(type) {
+	case map[interface{}]interface{}:
+		typedBefore, ok := before.(map[interface{}]interface{})
+		if ok && previous != nil {
+			// The map itself was there already
+			node.file = previous.file
+		}
+		for key, value := range typedAfter {
+			name := fmt.Sprintf("%v", key)
+			var beforeValue interface{}
+			var beforeSource *manifestSource
+			if found := false; typedBefore != nil {
+				if beforeValue, found = typedBefore[key]; found {
+					beforeSource = childSource(name)
+				}
+			}
+			node.children[name] = trackManifestSource(beforeValue, value, beforeSource, file)
+		}
+
+	case []interface{}:
+		typedBefore, ok := before.([]interface{})
+		if ok && previous != nil {
+			node.file = previous.file
+		}
+		for index, item := range typedAfter {
+			id := manifestItemID(item)
+			var beforeItem interface{}
+			var beforeSource *manifestSource
+			if id != "" {
+				for _, candidate := range typedBefore {
+					if manifestItemID(candidate) == id {
+						beforeItem = candidate
+						beforeSource = childSource(id)
+						break
+					}
+				}
+			} else if index < len(typedBefore) {
+				beforeItem = typedBefore[index]
+				beforeSource = childSource(strconv.Itoa(index))
+			}
+			child := trackManifestSource(beforeItem, item, beforeSource, file)
+			node.children[strconv.Itoa(index)] = child
+			if id != "" {
+				node.children[id] = child
+			}
+		}
+	}
+
+	return node
+}
+
+// fileOf returns the file that introduced the field of a validation error,
+// such as roles[api].run.scaling.max. For fields that are not in the
+// document, it is the file that introduced the closest field containing it.
+func (s *manifestSources) fileOf(field string) string {
+	node := s.root
+	if node == nil {
+		return ""
+	}
+	file := node.file
+	for _, name := range splitValidationField(field) {
+		node = node.children[name]
+		if node == nil {
+			break
+		}
+		file = node.file
+	}
+	return file
+}
+
+// positions returns the positions of the nodes of the role manifest file, if
+// it is one of the files the role manifest was composed from. Ops files have
+// none.
+func (s *manifestSources) positions(file string) *yamlPosition {
+	for index := range s.layers {
+		layer := &s.layers[index]
+		if layer.file != file {
+			continue
+		}
+		if layer.positions == nil {
+			layer.positions = indexBlockYAML(layer.contents)
+		}
+		return layer.positions
+	}
+	return nil
+}
+
+// annotate records in the validation errors where their fields are. The
+// file which introduced them is only recorded for role manifests composed of
+// several files; the line and column always, where they are known.
+func (s *manifestSources) annotate(errs validation.ErrorList) {
+	if s == nil {
+		return
+	}
+	for _, err := range errs {
+		file := s.fileOf(err.Field)
+		if len(s.files) > 1 {
+			err.File = file
+		}
+		positions := s.positions(file)
+		if positions == nil {
+			continue
+		}
+		if node, _ := positions.lookup(splitValidationField(err.Field)); node != positions {
+			err.Line = node.line
+			err.Column = node.column
+		}
+	}
+}
+
+// Position returns where the field of the role manifest, such as
+// roles[api].run.scaling.max, is. The line and column are zero if the field
+// is not in the role manifest, or was introduced by an ops file.
+func (m *RoleManifest) Position(field string) (string, int, int) {
+	if m.sources == nil {
+		return m.manifestFilePath, 0, 0
+	}
+	file := m.sources.fileOf(field)
+	if file == "" {
+		file = m.manifestFilePath
+	}
+	if positions := m.sources.positions(file); positions != nil {
+		if node, found := positions.lookup(splitValidationField(field)); found {
+			return file, node.line, node.column
+		}
+	}
+	return file, 0, 0
+}
+
+// splitValidationField splits the field of a validation error into its
+// parts: roles[api].run.scaling.max becomes roles, api, run, scaling, max
+func splitValidationField(field string) []string {
+	var parts []string
+	for len(field) > 0 {
+		switch field[0] {
+		case '.':
+			field = field[1:]
+		case '[':
+			end := strings.Index(field, "]")
+			if end < 0 {
+				return append(parts, field[1:])
+			}
+			parts = append(parts, field[1:end])
+			field = field[end+1:]
+		default:
+			end := strings.IndexAny(field, ".[")
+			if end < 0 {
+				return append(parts, field)
+			}
+			parts = append(parts, field[:end])
+			field = field[end:]
+		}
+	}
+	return parts
+}
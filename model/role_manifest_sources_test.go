@@ -0,0 +1,181 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadComposeTestRelease(t *testing.T) (string, *Release) {
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	require.NoError(t, err)
+
+	return filepath.Join(workDir, "../test-assets/role-manifests/compose"), release
+}
+
+func TestLoadRoleManifestIncludes(t *testing.T) {
+	assert := assert.New(t)
+
+	composeDir, release := loadComposeTestRelease(t)
+
+	roleManifestPath := filepath.Join(composeDir, "base.yml")
+	roleManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(roleManifestPath, roleManifest.manifestFilePath)
+	require.Len(t, roleManifest.Roles, 2)
+
+	myrole := roleManifest.LookupRole("myrole")
+	require.NotNil(t, myrole)
+	// The including file wins over the included one
+	assert.Equal(int64(256), *myrole.Run.MemRequest)
+	assert.Equal(3, myrole.Run.Scaling.Max)
+	require.Len(t, myrole.RoleJobs, 1)
+	assert.Equal("tor", myrole.RoleJobs[0].Name)
+
+	assert.NotNil(roleManifest.LookupRole("foorole"))
+}
+
+func TestLoadRoleManifestOpsFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	composeDir, release := loadComposeTestRelease(t)
+
+	roleManifestPath := filepath.Join(composeDir, "base.yml")
+	opsPath := filepath.Join(composeDir, "scale.yml")
+	roleManifest, err := LoadRoleManifest(roleManifestPath+","+opsPath, []*Release{release}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(roleManifestPath, roleManifest.manifestFilePath)
+	require.Len(t, roleManifest.Roles, 1)
+
+	myrole := roleManifest.Roles[0]
+	assert.Equal("myrole", myrole.Name)
+	assert.Equal(5, myrole.Run.Scaling.Max)
+	assert.Equal(1, myrole.Run.Scaling.Min)
+	assert.Equal(2.0, *myrole.Run.VirtualCPUs)
+}
+
+func TestLoadRoleManifestComposeErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	composeDir, release := loadComposeTestRelease(t)
+	basePath := filepath.Join(composeDir, "base.yml")
+
+	opsPath := filepath.Join(composeDir, "missing-role.yml")
+	_, err := LoadRoleManifest(basePath+","+opsPath, []*Release{release}, nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Error applying ops file "+opsPath)
+		assert.Contains(err.Error(), "/roles/name=nosuchrole")
+	}
+
+	cyclePath := filepath.Join(composeDir, "cycle.yml")
+	_, err = LoadRoleManifest(cyclePath, []*Release{release}, nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "includes itself")
+	}
+
+	_, err = LoadRoleManifest(basePath+","+filepath.Join(composeDir, "no-such-ops.yml"), []*Release{release}, nil)
+	assert.Error(err)
+}
+
+func TestLoadRoleManifestComposeValidationSource(t *testing.T) {
+	assert := assert.New(t)
+
+	composeDir, release := loadComposeTestRelease(t)
+
+	opsPath := filepath.Join(composeDir, "bad-flight-stage.yml")
+	roleManifestPath := filepath.Join(composeDir, "base.yml") + "," + opsPath
+	_, err := LoadRoleManifest(roleManifestPath, []*Release{release}, nil)
+	if assert.Error(err) {
+		assert.Equal([]string{
+			opsPath + `: roles[myrole].run.flight-stage: Invalid value: "sideways": Expected one of flight, manual, post-flight, or pre-flight`,
+		}, strings.Split(err.Error(), "\n"))
+	}
+}
+
+func TestRoleManifestPosition(t *testing.T) {
+	assert := assert.New(t)
+
+	composeDir, release := loadComposeTestRelease(t)
+
+	basePath := filepath.Join(composeDir, "base.yml")
+	rolesPath := filepath.Join(composeDir, "roles.yml")
+	roleManifest, err := LoadRoleManifest(basePath, []*Release{release}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		field  string
+		file   string
+		line   int
+		column int
+	}{
+		{"roles[myrole].run.memory", basePath, 7, 5},
+		{"roles[myrole].run.scaling.max", rolesPath, 8, 7},
+		{"roles[foorole].type", rolesPath, 13, 3},
+		{"configuration.templates[properties.tor.hostname]", basePath, 12, 5},
+	}
+	for _, test := range tests {
+		file, line, column := roleManifest.Position(test.field)
+		assert.Equal(test.file, file, test.field)
+		assert.Equal(test.line, line, test.field)
+		assert.Equal(test.column, column, test.field)
+	}
+
+	// Fields set by ops files have no position
+	scalePath := filepath.Join(composeDir, "scale.yml")
+	roleManifest, err = LoadRoleManifest(basePath+","+scalePath, []*Release{release}, nil)
+	require.NoError(t, err)
+	file, line, _ := roleManifest.Position("roles[myrole].run.scaling.max")
+	assert.Equal(scalePath, file)
+	assert.Equal(0, line)
+}
+
+func TestManifestSourcesFileOf(t *testing.T) {
+	assert := assert.New(t)
+
+	base := map[interface{}]interface{}{
+		"roles": []interface{}{
+			map[interface{}]interface{}{
+				"name": "api",
+				"run":  map[interface{}]interface{}{"memory": 128},
+			},
+		},
+	}
+	overlay := map[interface{}]interface{}{
+		"roles": []interface{}{
+			map[interface{}]interface{}{
+				"name": "api",
+				"run":  map[interface{}]interface{}{"scaling": map[interface{}]interface{}{"max": 3}},
+			},
+			map[interface{}]interface{}{"name": "worker"},
+		},
+	}
+
+	sources := &manifestSources{}
+	sources.track(nil, base, "base.yml")
+	sources.track(base, mergeManifestDocs(base, overlay), "overlay.yml")
+
+	assert.Equal("base.yml", sources.fileOf("roles[api].run.memory"))
+	assert.Equal("overlay.yml", sources.fileOf("roles[api].run.scaling.max"))
+	assert.Equal("overlay.yml", sources.fileOf("roles[api].run.scaling.min"))
+	assert.Equal("overlay.yml", sources.fileOf("roles[worker].jobs"))
+	assert.Equal("base.yml", sources.fileOf("configuration.variables"))
+}
+
+func TestSplitValidationField(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"roles", "api", "run", "scaling", "max"}, splitValidationField("roles[api].run.scaling.max"))
+	assert.Equal([]string{"configuration", "templates"}, splitValidationField("configuration.templates"))
+	assert.Equal([]string{"roles", "api", "run", "volumes", "data"}, splitValidationField("roles[api].run.volumes[data]"))
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -58,6 +57,7 @@ type RoleManifest struct {
 	Configuration *Configuration `yaml:"configuration"`
 
 	manifestFilePath string
+	sources          *manifestSources
 }
 
 // RoleJob represents a job in the context of a role
@@ -77,10 +77,12 @@ type Role struct {
 	Scripts           []string       `yaml:"scripts"`
 	PostConfigScripts []string       `yaml:"post_config_scripts"`
 	Type              RoleType       `yaml:"type,omitempty"`
+	Image             string         `yaml:"image,omitempty"` // The image run by docker roles
 	RoleJobs          []*RoleJob     `yaml:"jobs"`
 	Configuration     *Configuration `yaml:"configuration"`
 	Run               *RoleRun       `yaml:"run"`
 	Tags              []string       `yaml:"tags"`
+	SuppressRules     []string       `yaml:"suppress-rules,omitempty"` // Validation rules not checked for the role
 
 	roleManifest *RoleManifest
 }
@@ -213,6 +215,7 @@ type Configuration struct {
 	} `yaml:"auth,omitempty"`
 	Templates map[string]string          `yaml:"templates"`
 	Variables ConfigurationVariableSlice `yaml:"variables"`
+	Links     LinkPolicy                 `yaml:"links,omitempty"`
 }
 
 // ConfigurationVariable is a configuration to be exposed to the IaaS
@@ -243,6 +246,7 @@ type ConfigurationVariable struct {
 	Secret        bool                            `yaml:"secret,omitempty"`
 	Required      bool                            `yaml:"required,omitempty"`
 	Immutable     bool                            `yaml:"immutable,omitempty"`
+	SuppressRules []string                        `yaml:"suppress-rules,omitempty"` // Validation rules not checked for the variable
 }
 
 // Value fetches the value of config variable
@@ -328,9 +332,12 @@ func (roles Roles) Swap(i, j int) {
 	roles[i], roles[j] = roles[j], roles[i]
 }
 
-// LoadRoleManifest loads a yaml manifest that details how jobs get grouped into roles
+// LoadRoleManifest loads a yaml manifest that details how jobs get grouped into roles.
+// The path can be followed by a comma separated list of ops files, which are
+// applied to the manifest in order.
 func LoadRoleManifest(manifestFilePath string, releases []*Release, grapher util.ModelGrapher) (*RoleManifest, error) {
-	manifestContents, err := ioutil.ReadFile(manifestFilePath)
+	manifestFilePath, opsFiles := splitRoleManifestPath(manifestFilePath)
+	manifestContents, sources, err := composeRoleManifest(manifestFilePath, opsFiles)
 	if err != nil {
 		return nil, err
 	}
@@ -351,6 +358,7 @@ func LoadRoleManifest(manifestFilePath string, releases []*Release, grapher util
 
 	roleManifest := RoleManifest{}
 	roleManifest.manifestFilePath = manifestFilePath
+	roleManifest.sources = sources
 	if err := yaml.Unmarshal(manifestContents, &roleManifest); err != nil {
 		return nil, err
 	}
@@ -366,19 +374,17 @@ func LoadRoleManifest(manifestFilePath string, releases []*Release, grapher util
 
 	allErrs := validation.ErrorList{}
 
-	for i := len(roleManifest.Roles) - 1; i >= 0; i-- {
-		role := roleManifest.Roles[i]
-
-		// Remove all roles that are not of the "bosh" or "bosh-task" type
+	for _, role := range roleManifest.Roles {
 		// Default type is considered to be "bosh".
-		// The kept roles are validated.
+		// Docker roles run an existing image instead of BOSH jobs.
 		switch role.Type {
 		case "":
 			role.Type = RoleTypeBosh
+			allErrs = append(allErrs, validateBoshRole(role)...)
 		case RoleTypeBosh, RoleTypeBoshTask:
-			// Nothing to do.
+			allErrs = append(allErrs, validateBoshRole(role)...)
 		case RoleTypeDocker:
-			roleManifest.Roles = append(roleManifest.Roles[:i], roleManifest.Roles[i+1:]...)
+			allErrs = append(allErrs, validateDockerRole(role)...)
 		default:
 			allErrs = append(allErrs, validation.Invalid(
 				fmt.Sprintf("roles[%s].type", role.Name),
@@ -443,8 +449,10 @@ func LoadRoleManifest(manifestFilePath string, releases []*Release, grapher util
 		allErrs = append(allErrs, validateServiceAccounts(&roleManifest)...)
 	}
 
+	allErrs = roleManifest.WithoutSuppressed(allErrs)
 	if len(allErrs) != 0 {
-		return nil, fmt.Errorf(allErrs.Errors())
+		sources.annotate(allErrs)
+		return nil, allErrs
 	}
 
 	return &roleManifest, nil
@@ -510,6 +518,17 @@ func (m *RoleManifest) resolveLinks() validation.ErrorList {
 		}
 	}
 
+	// Check the link preferences before using them
+	if m.Configuration != nil {
+		errors = append(errors, validateLinkPolicy("configuration", m.Configuration.Links, providersByType)...)
+	}
+	for _, role := range m.Roles {
+		if role.Configuration != nil {
+			errors = append(errors, validateLinkPolicy(
+				fmt.Sprintf("roles[%s].configuration", role.Name), role.Configuration.Links, providersByType)...)
+		}
+	}
+
 	// Resolve the consumers
 	for _, role := range m.Roles {
 		for _, roleJob := range role.RoleJobs {
@@ -550,17 +569,31 @@ func (m *RoleManifest) resolveLinks() validation.ErrorList {
 			// Handle any consumers not overridden in the role manifest
 			for _, consumerInfo := range expectedConsumers {
 				// Consumers don't _have_ to be listed; they can be automatically
-				// matched to a published name, or to the only provider of the
-				// same type in the whole deployment
+				// matched to a published name, to the only provider of the
+				// same type in the whole deployment, or to the provider
+				// preferred for the type by the role or the role manifest
 				var provider jobProvidesInfo
 				var ok bool
 				if consumerInfo.Name != "" {
 					provider, ok = providersByName[consumerInfo.Name]
 				}
-				if !ok && len(providersByType[consumerInfo.Type]) == 1 {
-					provider = providersByType[consumerInfo.Type][0]
+				candidates := providersByType[consumerInfo.Type]
+				if !ok && len(candidates) == 1 {
+					provider = candidates[0]
 					ok = true
 				}
+				if !ok && len(candidates) > 1 {
+					if ref, found := m.preferredLinkProvider(role, consumerInfo.Type); found {
+						// Preferences not matching exactly one candidate have
+						// already been reported above
+						if matches := matchLinkProviders(ref, candidates); len(matches) == 1 {
+							provider = matches[0]
+							ok = true
+						} else {
+							continue
+						}
+					}
+				}
 				if ok {
 					name := consumerInfo.Name
 					if name == "" {
@@ -573,9 +606,15 @@ func (m *RoleManifest) resolveLinks() validation.ErrorList {
 					info.JobName = provider.JobName
 					roleJob.ResolvedConsumers[name] = info
 				} else if !consumerInfo.Optional {
+					detail := fmt.Sprintf(`failed to resolve provider %s (type %s): no provider of type %s`,
+						consumerInfo.Name, consumerInfo.Type, consumerInfo.Type)
+					if len(candidates) > 1 {
+						detail = fmt.Sprintf(`failed to resolve provider %s (type %s): %d candidates: %s; set configuration.links.preferred[%s]`,
+							consumerInfo.Name, consumerInfo.Type, len(candidates), describeLinkProviders(candidates), consumerInfo.Type)
+					}
 					errors = append(errors, validation.Required(
 						fmt.Sprintf(`role[%s].job[%s].consumes[%s]`, role.Name, roleJob.Name, consumerInfo.Name),
-						fmt.Sprintf(`failed to resolve provider %s (type %s)`, consumerInfo.Name, consumerInfo.Type)))
+						detail))
 				}
 			}
 		}
@@ -771,6 +810,33 @@ func (r *Role) GetRoleDevVersion(opinions *Opinions, tagExtra, fissileVersion st
 				fmt.Sprintf("properties/%s:", roleJob.Name),
 				hex.EncodeToString(propertyHasher.Sum(nil))})
 		}
+
+		// The addresses of the roles providing the consumed links are
+		// written into the configuration as well, see WriteConfigs
+		var links []string
+		for name := range roleJob.ResolvedConsumers {
+			links = append(links, name)
+		}
+		sort.Strings(links)
+		linkHasher := sha1.New()
+		for _, name := range links {
+			spec, err := json.Marshal(roleJob.linkSpec(r, roleJob.ResolvedConsumers[name]))
+			if err != nil {
+				return "", err
+			}
+			signatures = append(signatures, name, string(spec))
+			if grapher != nil {
+				linkHasher.Write([]byte(name))
+				linkHasher.Write([]byte{0x1F})
+				linkHasher.Write(spec)
+				linkHasher.Write([]byte{0x1E})
+			}
+		}
+		if grapher != nil && len(links) > 0 {
+			extraGraphEdges = append(extraGraphEdges, []string{
+				fmt.Sprintf("links/%s:", roleJob.Name),
+				hex.EncodeToString(linkHasher.Sum(nil))})
+		}
 	}
 	devVersion := AggregateSignatures(signatures)
 	if grapher != nil {
@@ -857,6 +923,28 @@ func (r *Role) HasTag(tag string) bool {
 	return false
 }
 
+// IsStateful returns true if the role is deployed as a StatefulSet, i.e. if
+// its instances are individually addressable
+func (r *Role) IsStateful() bool {
+	return r.HasTag("clustered") || r.HasTag("indexed")
+}
+
+// ServiceName returns the name of the kubernetes service through which the
+// instances of the role can be reached from other roles, or "" if there is
+// none. For stateful roles this is the headless service, which resolves to
+// the individual pods; other roles only have a service for their exposed
+// ports.
+func (r *Role) ServiceName() string {
+	if r.IsStateful() {
+		return fmt.Sprintf("%s-set", r.Name)
+	}
+	if r.Run != nil && len(r.Run.ExposedPorts) > 0 {
+		return r.Name
+	}
+
+	return ""
+}
+
 func (r *Role) calculateRoleConfigurationTemplates() {
 	if r.Configuration == nil {
 		r.Configuration = &Configuration{}
@@ -877,8 +965,75 @@ func (r *Role) calculateRoleConfigurationTemplates() {
 	r.Configuration.Templates = roleConfigs
 }
 
+// jobLinkPortSpec describes a port of the role providing a link
+type jobLinkPortSpec struct {
+	Name         string `json:"name"`
+	Protocol     string `json:"protocol"`
+	Port         int    `json:"port"`
+	InternalPort int    `json:"internal_port"`
+	Count        int    `json:"count"`
+}
+
+// jobLinkSpec is the information about a resolved link written into the
+// configuration of the consuming job, so that link addresses can be rendered
+// without guessing at DNS names at runtime
+type jobLinkSpec struct {
+	jobLinkInfo
+	// ServiceName is the kubernetes service in front of the providing role
+	ServiceName string `json:"service_name,omitempty"`
+	// Stateful is set if the instances of the providing role are addressable
+	// individually, as <role>-<index>.<service name>
+	Stateful   bool              `json:"stateful"`
+	Ports      []jobLinkPortSpec `json:"ports"`
+	Properties []string          `json:"properties"`
+}
+
+// linkSpec resolves the address information of the role and job providing
+// a link consumed by the role job
+func (roleJob *RoleJob) linkSpec(role *Role, consumer jobConsumesInfo) jobLinkSpec {
+	spec := jobLinkSpec{
+		jobLinkInfo: consumer.jobLinkInfo,
+		Ports:       make([]jobLinkPortSpec, 0),
+		Properties:  make([]string, 0),
+	}
+
+	if role.roleManifest == nil {
+		return spec
+	}
+	providerRole := role.roleManifest.LookupRole(consumer.RoleName)
+	if providerRole == nil {
+		return spec
+	}
+
+	spec.ServiceName = providerRole.ServiceName()
+	spec.Stateful = providerRole.IsStateful()
+
+	if providerRole.Run != nil {
+		for _, port := range providerRole.Run.ExposedPorts {
+			spec.Ports = append(spec.Ports, jobLinkPortSpec{
+				Name:         port.Name,
+				Protocol:     port.Protocol,
+				Port:         port.ExternalPort,
+				InternalPort: port.InternalPort,
+				Count:        port.Count,
+			})
+		}
+	}
+
+	for _, providerJob := range providerRole.RoleJobs {
+		if providerJob.Name != consumer.JobName || providerJob.Job == nil {
+			continue
+		}
+		if provider, ok := providerJob.Job.AvailableProviders[consumer.Name]; ok {
+			spec.Properties = append(spec.Properties, provider.Properties...)
+		}
+	}
+
+	return spec
+}
+
 // WriteConfigs merges the job's spec with the opinions and returns the result as JSON.
-func (roleJob *RoleJob) WriteConfigs(role *Role, lightOpinionsPath, darkOpinionsPath string) ([]byte, error) {
+func (roleJob *RoleJob) WriteConfigs(role *Role, lightOpinionsPath, darkOpinionsPath, opinionsVarsPath string) ([]byte, error) {
 	var config struct {
 		Job struct {
 			Name string `json:"name"`
@@ -889,22 +1044,22 @@ func (roleJob *RoleJob) WriteConfigs(role *Role, lightOpinionsPath, darkOpinions
 			Default map[string]string `json:"default"`
 		} `json:"networks"`
 		ExportedProperties []string               `json:"exported_properties"`
-		Consumes           map[string]jobLinkInfo `json:"consumes"`
+		Consumes           map[string]jobLinkSpec `json:"consumes"`
 	}
 
 	config.Parameters = make(map[string]string)
 	config.Properties = make(map[string]interface{})
 	config.Networks.Default = make(map[string]string)
 	config.ExportedProperties = make([]string, 0)
-	config.Consumes = make(map[string]jobLinkInfo)
+	config.Consumes = make(map[string]jobLinkSpec)
 
 	config.Job.Name = role.Name
 
 	for _, consumer := range roleJob.ResolvedConsumers {
-		config.Consumes[consumer.Name] = consumer.jobLinkInfo
+		config.Consumes[consumer.Name] = roleJob.linkSpec(role, consumer)
 	}
 
-	opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath)
+	opinions, err := NewOpinions(lightOpinionsPath, darkOpinionsPath, opinionsVarsPath)
 	if err != nil {
 		return nil, err
 	}
@@ -956,10 +1111,10 @@ func validateVariableSorting(variables ConfigurationVariableSlice) validation.Er
 
 	previousName := ""
 	for _, cv := range variables {
-		if cv.Name < previousName {
+		if cv.Name < previousName && !cv.suppresses(RuleVariableSorting) {
 			allErrs = append(allErrs, validation.Invalid("configuration.variables",
 				previousName,
-				fmt.Sprintf("Does not sort before '%s'", cv.Name)))
+				fmt.Sprintf("Does not sort before '%s'", cv.Name)).WithRule(RuleVariableSorting, validation.SeverityError))
 		} else if cv.Name == previousName {
 			allErrs = append(allErrs, validation.Invalid("configuration.variables",
 				previousName, "Appears more than once"))
@@ -976,18 +1131,21 @@ func validateVariablePreviousNames(variables ConfigurationVariableSlice) validat
 	allErrs := validation.ErrorList{}
 
 	for _, cvOuter := range variables {
+		if cvOuter.suppresses(RuleVariablePreviousNames) {
+			continue
+		}
 		for _, previousOuter := range cvOuter.PreviousNames {
 			for _, cvInner := range variables {
 				if previousOuter == cvInner.Name {
 					allErrs = append(allErrs, validation.Invalid("configuration.variables",
 						cvOuter.Name,
-						fmt.Sprintf("Previous name '%s' also exist as a new variable", cvInner.Name)))
+						fmt.Sprintf("Previous name '%s' also exist as a new variable", cvInner.Name)).WithRule(RuleVariablePreviousNames, validation.SeverityError))
 				}
 				for _, previousInner := range cvInner.PreviousNames {
 					if cvOuter.Name != cvInner.Name && previousOuter == previousInner {
 						allErrs = append(allErrs, validation.Invalid("configuration.variables",
 							cvOuter.Name,
-							fmt.Sprintf("Previous name '%s' also claimed by '%s'", previousOuter, cvInner.Name)))
+							fmt.Sprintf("Previous name '%s' also claimed by '%s'", previousOuter, cvInner.Name)).WithRule(RuleVariablePreviousNames, validation.SeverityError))
 					}
 				}
 			}
@@ -1018,6 +1176,16 @@ func validateVariableUsage(roleManifest *RoleManifest) validation.ErrorList {
 	// configs.
 
 	for _, role := range roleManifest.Roles {
+		// Docker roles get the variables of their environment
+		if role.Type == RoleTypeDocker && role.Run != nil {
+			for _, envVar := range role.Run.Environment {
+				delete(unusedConfigs, envVar)
+			}
+			if len(unusedConfigs) == 0 {
+				return allErrs
+			}
+		}
+
 		for _, roleJob := range role.RoleJobs {
 			for _, property := range roleJob.Properties {
 				propertyName := fmt.Sprintf("properties.%s", property.Name)
@@ -1070,12 +1238,12 @@ func validateVariableUsage(roleManifest *RoleManifest) validation.ErrorList {
 	// those which are not internal.
 
 	for cv, cvar := range unusedConfigs {
-		if cvar.Internal {
+		if cvar.Internal || cvar.suppresses(RuleUnusedVariable) {
 			continue
 		}
 
 		allErrs = append(allErrs, validation.NotFound("configuration.variables",
-			fmt.Sprintf("No templates using '%s'", cv)))
+			fmt.Sprintf("No templates using '%s'", cv)).WithRule(RuleUnusedVariable, validation.SeverityError))
 	}
 
 	return allErrs
@@ -1093,6 +1261,9 @@ func validateTemplateUsage(roleManifest *RoleManifest) validation.ErrorList {
 	// variables. Report all without a declaration.
 
 	for _, role := range roleManifest.Roles {
+		if role.suppresses(RuleUndeclaredVariable) {
+			continue
+		}
 
 		// Note, we cannot use GetVariablesForRole here
 		// because it will abort on bad templates. Here we
@@ -1114,7 +1285,7 @@ func validateTemplateUsage(roleManifest *RoleManifest) validation.ErrorList {
 						}
 
 						allErrs = append(allErrs, validation.NotFound("configuration.variables",
-							fmt.Sprintf("No declaration of '%s'", envVar)))
+							fmt.Sprintf("No declaration of '%s'", envVar)).WithRule(RuleUndeclaredVariable, validation.SeverityError))
 
 						// Add a placeholder so that this variable is not reported again.
 						// One report is good enough.
@@ -1141,7 +1312,7 @@ func validateTemplateUsage(roleManifest *RoleManifest) validation.ErrorList {
 			}
 
 			allErrs = append(allErrs, validation.NotFound("configuration.templates",
-				fmt.Sprintf("No variable declaration of '%s'", envVar)))
+				fmt.Sprintf("No variable declaration of '%s'", envVar)).WithRule(RuleUndeclaredVariable, validation.SeverityError))
 
 			// Add a placeholder so that this variable is
 			// not reported again.  One report is good
@@ -1153,6 +1324,57 @@ func validateTemplateUsage(roleManifest *RoleManifest) validation.ErrorList {
 	return allErrs
 }
 
+// validateBoshRole tests that a role built from BOSH jobs does not use
+// settings of docker roles
+func validateBoshRole(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if role.Image != "" {
+		allErrs = append(allErrs, validation.Forbidden(
+			fmt.Sprintf("roles[%s].image", role.Name),
+			"Only docker roles can run an existing image"))
+	}
+
+	return allErrs
+}
+
+// validateDockerRole tests that a docker role names its image, and has none
+// of the BOSH jobs and scripts that would need an image built by fissile
+func validateDockerRole(role *Role) validation.ErrorList {
+	allErrs := validation.ErrorList{}
+
+	if role.Image == "" {
+		allErrs = append(allErrs, validation.Required(
+			fmt.Sprintf("roles[%s].image", role.Name),
+			"Docker roles must name the image to run"))
+	} else if strings.ContainsAny(role.Image, " \t\n") {
+		allErrs = append(allErrs, validation.Invalid(
+			fmt.Sprintf("roles[%s].image", role.Name),
+			role.Image, "Image references must not contain whitespace"))
+	}
+
+	if len(role.RoleJobs) > 0 {
+		allErrs = append(allErrs, validation.Forbidden(
+			fmt.Sprintf("roles[%s].jobs", role.Name),
+			"Docker roles cannot have BOSH jobs"))
+	}
+
+	scripts := map[string][]string{
+		"environment_scripts": role.EnvironScripts,
+		"scripts":             role.Scripts,
+		"post_config_scripts": role.PostConfigScripts,
+	}
+	for _, field := range []string{"environment_scripts", "scripts", "post_config_scripts"} {
+		if len(scripts[field]) > 0 {
+			allErrs = append(allErrs, validation.Forbidden(
+				fmt.Sprintf("roles[%s].%s", role.Name, field),
+				"Docker roles cannot have scripts"))
+		}
+	}
+
+	return allErrs
+}
+
 // validateRoleRun tests whether required fields in the RoleRun are
 // set. Note, some of the fields have type-dependent checks. Some
 // issues are fixed silently.
@@ -1225,7 +1447,7 @@ func validateRoleRun(role *Role, roleManifest *RoleManifest, declared CVMap) val
 
 			allErrs = append(allErrs, validation.NotFound(
 				fmt.Sprintf("roles[%s].run.env", role.Name),
-				fmt.Sprintf("No variable declaration of '%s'", envVar)))
+				fmt.Sprintf("No variable declaration of '%s'", envVar)).WithRule(RuleUndeclaredVariable, validation.SeverityError))
 		}
 	} else {
 		// Bosh roles must not provide environment variables.
@@ -1470,7 +1692,7 @@ func validateNonTemplates(roleManifest *RoleManifest) validation.ErrorList {
 		if len(varsInTemplate) == 0 {
 			allErrs = append(allErrs, validation.Invalid("configuration.templates",
 				template,
-				fmt.Sprintf("Using '%s' as a constant", property)))
+				fmt.Sprintf("Using '%s' as a constant", property)).WithRule(RuleConstantTemplate, validation.SeverityError))
 		}
 	}
 
@@ -1491,6 +1713,18 @@ func validateServiceAccounts(roleManifest *RoleManifest) validation.ErrorList {
 	return allErrs
 }
 
+// WithoutDockerRoles returns the roles fissile builds images for, leaving out
+// the docker roles which run an existing image
+func (roles Roles) WithoutDockerRoles() Roles {
+	result := make(Roles, 0, len(roles))
+	for _, role := range roles {
+		if role.Type != RoleTypeDocker {
+			result = append(result, role)
+		}
+	}
+	return result
+}
+
 // LookupJob will find the given job in this role, or nil if not found
 func (r *Role) LookupJob(name string) *RoleJob {
 	for _, roleJob := range r.RoleJobs {
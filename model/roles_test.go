@@ -1,6 +1,8 @@
 package model
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -161,7 +163,7 @@ func TestLoadRoleManifestMultipleReleasesNotOk(t *testing.T) {
 		`roles[foorole].jobs[ntpd]: Invalid value: "foo": Referenced release is not loaded`)
 }
 
-func TestNonBoshRolesAreIgnoredOK(t *testing.T) {
+func TestNonBoshRolesOK(t *testing.T) {
 	assert := assert.New(t)
 
 	workDir, err := os.Getwd()
@@ -178,7 +180,77 @@ func TestNonBoshRolesAreIgnoredOK(t *testing.T) {
 	require.NotNil(t, roleManifest)
 
 	assert.Equal(roleManifestPath, roleManifest.manifestFilePath)
-	assert.Len(roleManifest.Roles, 2)
+	assert.Len(roleManifest.Roles, 3)
+
+	dockerRole := roleManifest.LookupRole("dockerrole")
+	if assert.NotNil(dockerRole) {
+		assert.Equal(RoleTypeDocker, dockerRole.Type)
+		assert.Equal("docker.io/library/busybox:1.28", dockerRole.Image)
+	}
+}
+
+func TestLoadRoleManifestDockerRoles(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/docker-roles.yml")
+	roleManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release}, nil)
+	if !assert.NoError(err) {
+		return
+	}
+
+	cache := roleManifest.LookupRole("cache")
+	if assert.NotNil(cache) {
+		assert.Equal("docker.io/library/redis:4.0", cache.Image)
+		assert.Empty(cache.RoleJobs)
+
+		// Docker roles get the variables of their environment
+		variables, err := cache.GetVariablesForRole()
+		if assert.NoError(err) {
+			var names []string
+			for _, variable := range variables {
+				names = append(names, variable.Name)
+			}
+			assert.Equal([]string{"CACHE_PASSWORD", "CACHE_SIZE"}, names)
+		}
+	}
+
+	buildable := roleManifest.Roles.WithoutDockerRoles()
+	if assert.Len(buildable, 1) {
+		assert.Equal("myrole", buildable[0].Name)
+	}
+}
+
+func TestLoadRoleManifestDockerRolesBad(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	torReleasePath := filepath.Join(workDir, "../test-assets/tor-boshrelease")
+	torReleasePathBoshCache := filepath.Join(torReleasePath, "bosh-cache")
+	release, err := NewDevRelease(torReleasePath, "", "", torReleasePathBoshCache)
+	assert.NoError(err)
+
+	roleManifestPath := filepath.Join(workDir, "../test-assets/role-manifests/docker-bad.yml")
+	roleManifest, err := LoadRoleManifest(roleManifestPath, []*Release{release}, nil)
+	assert.Nil(roleManifest)
+	if assert.Error(err) {
+		errors := strings.Split(err.Error(), "\n")
+		assert.Equal([]string{
+			`roles[myrole].image: Forbidden: Only docker roles can run an existing image`,
+			`roles[noimage].image: Required value: Docker roles must name the image to run`,
+			`roles[withjobs].jobs: Forbidden: Docker roles cannot have BOSH jobs`,
+			`roles[withjobs].scripts: Forbidden: Docker roles cannot have scripts`,
+		}, errors)
+	}
 }
 
 func TestRolesSort(t *testing.T) {
@@ -818,6 +890,82 @@ func TestRoleResolveLinksMultipleProvider(t *testing.T) {
 	}
 }
 
+func TestRoleResolveLinksPreferredProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	db := &Job{
+		Name: "db",
+		AvailableProviders: map[string]jobProvidesInfo{
+			"database": {jobLinkInfo: jobLinkInfo{Name: "database", Type: "database"}},
+		},
+	}
+	app := &Job{
+		Name: "app",
+		DesiredConsumers: []jobConsumesInfo{
+			{jobLinkInfo: jobLinkInfo{Type: "database"}},
+		},
+	}
+
+	newRoleManifest := func() *RoleManifest {
+		roleManifest := &RoleManifest{
+			Configuration: &Configuration{},
+			Roles: Roles{
+				&Role{Name: "primary", RoleJobs: []*RoleJob{{Job: db}}},
+				&Role{Name: "secondary", RoleJobs: []*RoleJob{{Job: db}}},
+				&Role{Name: "frontend", RoleJobs: []*RoleJob{{Job: app}}},
+			},
+		}
+		for _, r := range roleManifest.Roles {
+			for _, roleJob := range r.RoleJobs {
+				roleJob.Name = roleJob.Job.Name
+				roleJob.ResolvedConsumers = make(map[string]jobConsumesInfo)
+			}
+		}
+		return roleManifest
+	}
+	resolvedProvider := func(roleManifest *RoleManifest) string {
+		consumes := roleManifest.LookupRole("frontend").LookupJob("app").ResolvedConsumers
+		return fmt.Sprintf("%s/%s", consumes["database"].RoleName, consumes["database"].JobName)
+	}
+
+	// Without a preference, the error lists all candidates
+	roleManifest := newRoleManifest()
+	errors := roleManifest.resolveLinks()
+	if assert.Len(errors, 1) {
+		assert.Contains(errors[0].Error(), "failed to resolve provider  (type database): 2 candidates: primary/db, secondary/db")
+		assert.Contains(errors[0].Error(), "set configuration.links.preferred[database]")
+	}
+
+	// The role manifest can prefer a provider
+	roleManifest = newRoleManifest()
+	roleManifest.Configuration.Links.Preferred = map[string]LinkProviderRef{
+		"database": {Role: "secondary"},
+	}
+	assert.Empty(roleManifest.resolveLinks())
+	assert.Equal("secondary/db", resolvedProvider(roleManifest))
+
+	// Roles can override the preference of the role manifest
+	roleManifest.LookupRole("frontend").LookupJob("app").ResolvedConsumers = make(map[string]jobConsumesInfo)
+	roleManifest.LookupRole("frontend").Configuration = &Configuration{
+		Links: LinkPolicy{Preferred: map[string]LinkProviderRef{
+			"database": {Role: "primary", Job: "db"},
+		}},
+	}
+	assert.Empty(roleManifest.resolveLinks())
+	assert.Equal("primary/db", resolvedProvider(roleManifest))
+
+	// Preferences must name a provider of the type
+	roleManifest = newRoleManifest()
+	roleManifest.Configuration.Links.Preferred = map[string]LinkProviderRef{
+		"database": {Role: "frontend", Job: "app"},
+	}
+	errors = roleManifest.resolveLinks()
+	if assert.Len(errors, 1) {
+		assert.Contains(errors[0].Error(), `configuration.links.preferred[database]: Invalid value: "frontend/app"`)
+		assert.Contains(errors[0].Error(), "candidates: primary/db, secondary/db")
+	}
+}
+
 func TestWriteConfigs(t *testing.T) {
 	assert := assert.New(t)
 
@@ -878,7 +1026,7 @@ func TestWriteConfigs(t *testing.T) {
 	assert.NoError(err)
 	assert.NoError(tempFile.Close())
 
-	json, err := role.RoleJobs[0].WriteConfigs(role, tempFile.Name(), tempFile.Name())
+	json, err := role.RoleJobs[0].WriteConfigs(role, tempFile.Name(), tempFile.Name(), "")
 	assert.NoError(err)
 
 	assert.JSONEq(`
@@ -899,7 +1047,10 @@ func TestWriteConfigs(t *testing.T) {
 		"consumes": {
 			"serious": {
 				"role": "dummy role",
-				"job": "silly job"
+				"job": "silly job",
+				"stateful": false,
+				"ports": [],
+				"properties": []
 			}
 		},
 		"exported_properties": [
@@ -907,3 +1058,175 @@ func TestWriteConfigs(t *testing.T) {
 		]
 	}`, string(json))
 }
+
+func TestWriteConfigsLinkAddresses(t *testing.T) {
+	assert := assert.New(t)
+
+	providerJob := &Job{
+		Name: "provider-job",
+		AvailableProviders: map[string]jobProvidesInfo{
+			"database": jobProvidesInfo{
+				jobLinkInfo: jobLinkInfo{Name: "database", Type: "db"},
+				Properties:  []string{"db.user", "db.port"},
+			},
+		},
+	}
+	consumerJob := &Job{
+		Name: "consumer-job",
+		DesiredConsumers: []jobConsumesInfo{
+			jobConsumesInfo{jobLinkInfo: jobLinkInfo{Name: "database", Type: "db"}},
+		},
+	}
+
+	roleManifest := &RoleManifest{}
+	makeRole := func(name string, tags []string, job *Job, ports []*RoleRunExposedPort) *Role {
+		return &Role{
+			Name:         name,
+			Tags:         tags,
+			RoleJobs:     []*RoleJob{&RoleJob{Job: job, Name: job.Name}},
+			Run:          &RoleRun{ExposedPorts: ports},
+			roleManifest: roleManifest,
+		}
+	}
+	statefulProvider := makeRole("stateful", []string{"clustered"}, providerJob, []*RoleRunExposedPort{
+		&RoleRunExposedPort{Name: "db", Protocol: "TCP", InternalPort: 5432, ExternalPort: 15432, Count: 1},
+	})
+	plainProvider := makeRole("plain", nil, providerJob, nil)
+	consumer := makeRole("consumer", nil, consumerJob, nil)
+	roleManifest.Roles = Roles{statefulProvider, plainProvider, consumer}
+
+	opinionsFile, err := ioutil.TempFile("", "fissile-opinions")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.Remove(opinionsFile.Name())
+	_, err = opinionsFile.WriteString("---\nproperties: {}\n")
+	assert.NoError(err)
+	assert.NoError(opinionsFile.Close())
+
+	for _, provider := range []*Role{statefulProvider, plainProvider} {
+		consumer.RoleJobs[0].ResolvedConsumers = map[string]jobConsumesInfo{
+			"database": jobConsumesInfo{
+				jobLinkInfo: jobLinkInfo{
+					Name:     "database",
+					Type:     "db",
+					RoleName: provider.Name,
+					JobName:  "provider-job",
+				},
+			},
+		}
+
+		output, err := consumer.RoleJobs[0].WriteConfigs(consumer, opinionsFile.Name(), opinionsFile.Name(), "")
+		if !assert.NoError(err) {
+			continue
+		}
+
+		var config struct {
+			Consumes map[string]map[string]interface{} `json:"consumes"`
+		}
+		if !assert.NoError(json.Unmarshal(output, &config)) {
+			continue
+		}
+
+		link := config.Consumes["database"]
+		assert.Equal(provider.Name, link["role"])
+		assert.Equal("provider-job", link["job"])
+		assert.Equal([]interface{}{"db.user", "db.port"}, link["properties"])
+		if provider == statefulProvider {
+			assert.Equal("stateful-set", link["service_name"])
+			assert.Equal(true, link["stateful"])
+			assert.Equal([]interface{}{
+				map[string]interface{}{
+					"name":          "db",
+					"protocol":      "TCP",
+					"port":          15432.0,
+					"internal_port": 5432.0,
+					"count":         1.0,
+				},
+			}, link["ports"])
+		} else {
+			assert.NotContains(link, "service_name", "Roles without exposed ports have no service")
+			assert.Equal(false, link["stateful"])
+			assert.Equal([]interface{}{}, link["ports"])
+		}
+	}
+}
+
+func TestGetRoleDevVersionLinkAddresses(t *testing.T) {
+	assert := assert.New(t)
+
+	providerJob := &Job{
+		Name: "provider-job",
+		AvailableProviders: map[string]jobProvidesInfo{
+			"database": jobProvidesInfo{
+				jobLinkInfo: jobLinkInfo{Name: "database", Type: "db"},
+			},
+		},
+	}
+	roleManifest := &RoleManifest{}
+	provider := &Role{
+		Name:         "provider",
+		RoleJobs:     []*RoleJob{&RoleJob{Job: providerJob, Name: providerJob.Name}},
+		Run:          &RoleRun{},
+		roleManifest: roleManifest,
+	}
+	consumer := &Role{
+		Name: "consumer",
+		RoleJobs: []*RoleJob{&RoleJob{
+			Job:  &Job{Name: "consumer-job"},
+			Name: "consumer-job",
+			ResolvedConsumers: map[string]jobConsumesInfo{
+				"database": jobConsumesInfo{
+					jobLinkInfo: jobLinkInfo{
+						Name:     "database",
+						Type:     "db",
+						RoleName: provider.Name,
+						JobName:  providerJob.Name,
+					},
+				},
+			},
+		}},
+		Run:          &RoleRun{},
+		roleManifest: roleManifest,
+	}
+	roleManifest.Roles = Roles{provider, consumer}
+	opinions := &Opinions{
+		Light: map[string]interface{}{"properties": map[interface{}]interface{}{}},
+		Dark:  map[string]interface{}{"properties": map[interface{}]interface{}{}},
+	}
+
+	versions := map[string]string{}
+	for _, change := range []struct {
+		name  string
+		apply func()
+	}{
+		{"initial", func() {}},
+		{"exposed port", func() {
+			provider.Run.ExposedPorts = []*RoleRunExposedPort{
+				&RoleRunExposedPort{Name: "db", Protocol: "TCP", InternalPort: 5432, ExternalPort: 5432, Count: 1},
+			}
+		}},
+		{"port number", func() { provider.Run.ExposedPorts[0].ExternalPort = 15432 }},
+		{"clustered", func() { provider.Tags = []string{"clustered"} }},
+		{"link properties", func() {
+			providerJob.AvailableProviders["database"] = jobProvidesInfo{
+				jobLinkInfo: jobLinkInfo{Name: "database", Type: "db"},
+				Properties:  []string{"db.user"},
+			}
+		}},
+	} {
+		change.apply()
+		version, err := consumer.GetRoleDevVersion(opinions, "", "", nil)
+		if !assert.NoError(err, change.name) {
+			continue
+		}
+		for name, other := range versions {
+			assert.NotEqual(other, version, "The dev version after %s should differ from the one after %s", change.name, name)
+		}
+		versions[change.name] = version
+
+		again, err := consumer.GetRoleDevVersion(opinions, "", "", nil)
+		assert.NoError(err)
+		assert.Equal(version, again, "The dev version should be stable after %s", change.name)
+	}
+}
@@ -0,0 +1,303 @@
+package model
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SUSE/fissile/util"
+)
+
+// sourceFile is a file that goes into a job or package archive built from a
+// release source tree
+type sourceFile struct {
+	// Path is the location of the file on disk
+	Path string
+	// ArchivePath is the name of the file inside the archive
+	ArchivePath string
+	// FingerprintName is the name used for the file in the fingerprint
+	FingerprintName string
+	// ExcludeMode is set for files whose mode does not affect the fingerprint
+	ExcludeMode bool
+}
+
+// sourceFingerprint computes the fingerprint of a job or package the way the
+// BOSH CLI does: the SHA1 of "v2", followed by the name, SHA1 and (unless
+// excluded) the mode of each file in order, followed by the comma separated
+// additional chunks (the dependencies, for packages). The files are not
+// sorted here, as the fingerprint depends on the order the BOSH CLI lists
+// them in, see packageSourceFiles and jobSourceFiles.
+func sourceFingerprint(files []sourceFile, additionalChunks []string) (string, error) {
+	chunks := []string{"v2"}
+
+	for _, file := range files {
+		info, err := os.Lstat(file.Path)
+		if err != nil {
+			return "", err
+		}
+
+		var digest string
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(file.Path)
+			if err != nil {
+				return "", err
+			}
+			digest = fmt.Sprintf("%x", sha1.Sum([]byte(target)))
+		} else {
+			digest, err = ComputeDigest(DigestAlgorithmSHA1, file.Path)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		chunk := file.FingerprintName + digest
+		if !file.ExcludeMode {
+			if info.Mode()&0111 != 0 {
+				chunk += "100755"
+			} else {
+				chunk += "100644"
+			}
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	chunks = append(chunks, strings.Join(additionalChunks, ","))
+
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(chunks, "")))), nil
+}
+
+// writeSourceArchive packs the files into a gzipped tarball inside dir and
+// returns the path and SHA1 of the new archive. Entries have a fixed owner and
+// timestamp so that the same files always give the same archive.
+func writeSourceArchive(dir string, files []sourceFile) (path, digest string, err error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+
+	archive, err := ioutil.TempFile(dir, ".archive-")
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		archive.Close()
+		if err != nil {
+			os.Remove(archive.Name())
+		}
+	}()
+
+	hasher := sha1.New()
+	gzipWriter := gzip.NewWriter(io.MultiWriter(archive, hasher))
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	sorted := make([]sourceFile, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ArchivePath < sorted[j].ArchivePath })
+
+	writtenDirs := map[string]bool{".": true}
+	for _, file := range sorted {
+		if err := writeSourceArchiveDirs(tarWriter, filepath.Dir(file.ArchivePath), writtenDirs); err != nil {
+			return "", "", err
+		}
+
+		info, err := os.Lstat(file.Path)
+		if err != nil {
+			return "", "", err
+		}
+
+		header := &tar.Header{
+			Name:    "./" + filepath.ToSlash(file.ArchivePath),
+			Mode:    0644,
+			ModTime: time.Unix(0, 0),
+		}
+		if info.Mode()&0111 != 0 {
+			header.Mode = 0755
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(file.Path)
+			if err != nil {
+				return "", "", err
+			}
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = target
+			header.Mode = 0777
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return "", "", err
+			}
+			continue
+		}
+
+		if err := util.CopyFileToTarStream(tarWriter, file.Path, header); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return "", "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", "", err
+	}
+
+	return archive.Name(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// writeSourceArchiveDirs writes the entries for dir and its parents, unless
+// they have been written already
+func writeSourceArchiveDirs(tarWriter *tar.Writer, dir string, written map[string]bool) error {
+	if written[dir] {
+		return nil
+	}
+	if err := writeSourceArchiveDirs(tarWriter, filepath.Dir(dir), written); err != nil {
+		return err
+	}
+	written[dir] = true
+
+	return tarWriter.WriteHeader(&tar.Header{
+		Name:     "./" + filepath.ToSlash(dir) + "/",
+		Mode:     0755,
+		ModTime:  time.Unix(0, 0),
+		Typeflag: tar.TypeDir,
+	})
+}
+
+// globFiles returns the paths (relative to root) of all files below root
+// matching any of the patterns. Patterns use the syntax of package specs:
+// `*` and `?` do not match path separators, `**` matches any number of
+// directories, and `{a,b}` matches either alternative.
+func globFiles(root string, patterns []string) ([]string, error) {
+	var expressions []*regexp.Regexp
+	for _, pattern := range patterns {
+		expression, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid file pattern %s: %s", pattern, err.Error())
+		}
+		expressions = append(expressions, expression)
+	}
+
+	var result []string
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return result, nil
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		for _, expression := range expressions {
+			if expression.MatchString(relPath) {
+				result = append(result, relPath)
+				break
+			}
+		}
+
+		return nil
+	})
+
+	sort.Strings(result)
+
+	return result, err
+}
+
+// globToRegexp converts a file pattern into an anchored regular expression.
+// Like shell globs, wildcards do not match a leading dot of a file name.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var expression bytes.Buffer
+	expression.WriteString("^")
+
+	inAlternatives := false
+	atSegmentStart := true
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		noDot := ""
+		if atSegmentStart {
+			noDot = `(?:[^./][^/]*)?`
+		}
+		atSegmentStart = false
+
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// `**/` matches zero or more directories
+					i++
+					expression.WriteString(`(?:[^./][^/]*/)*`)
+					atSegmentStart = true
+				} else {
+					expression.WriteString(`(?:[^./][^/]*)?(?:/[^./][^/]*)*`)
+				}
+			} else if noDot != "" {
+				expression.WriteString(noDot)
+			} else {
+				expression.WriteString(`[^/]*`)
+			}
+		case '?':
+			if noDot != "" {
+				expression.WriteString(`[^./]`)
+			} else {
+				expression.WriteString(`[^/]`)
+			}
+		case '{':
+			inAlternatives = true
+			expression.WriteString(`(?:`)
+		case '}':
+			if !inAlternatives {
+				return nil, fmt.Errorf("unexpected }")
+			}
+			inAlternatives = false
+			expression.WriteString(`)`)
+		case ',':
+			if inAlternatives {
+				expression.WriteString(`|`)
+			} else {
+				expression.WriteString(`,`)
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + class + "]")
+			i += end
+		case '/':
+			expression.WriteByte('/')
+			atSegmentStart = true
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	if inAlternatives {
+		return nil, fmt.Errorf("unterminated {")
+	}
+
+	expression.WriteString("$")
+
+	return regexp.Compile(expression.String())
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// jobSpecCacheVersion is bumped whenever the layout of cached entries changes,
+// so that stale entries are ignored rather than misread
+const jobSpecCacheVersion = 1
+
+// JobSpecCache is an on-disk cache of the contents of job archives that are
+// needed to load a release: the job.MF and the templates it references.
+// Entries are keyed by the strongest digest of the job archive, so they never
+// need to be invalidated; an archive with different contents has a different key.
+type JobSpecCache struct {
+	Path string
+}
+
+// jobSpecCacheEntry is the serialized form of a cached job archive
+type jobSpecCacheEntry struct {
+	Version   int               `json:"version"`
+	Spec      string            `json:"spec"`
+	Templates map[string]string `json:"templates"`
+}
+
+// NewJobSpecCache returns a cache that stores its entries below path
+func NewJobSpecCache(path string) *JobSpecCache {
+	return &JobSpecCache{Path: path}
+}
+
+// load returns the cached entry for the job, if there is a valid one
+func (c *JobSpecCache) load(j *Job) (*jobSpecCacheEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	path, err := c.entryPath(j)
+	if err != nil {
+		return nil, false
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry jobSpecCacheEntry
+	if err := json.Unmarshal(contents, &entry); err != nil || entry.Version != jobSpecCacheVersion {
+		return nil, false
+	}
+
+	return &entry, true
+}
+
+// store saves the entry for the job. The entry is written to a temporary file
+// first, so that concurrent readers never see a partial entry.
+func (c *JobSpecCache) store(j *Job, entry *jobSpecCacheEntry) error {
+	if c == nil {
+		return nil
+	}
+
+	path, err := c.entryPath(j)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Path, 0755); err != nil {
+		return fmt.Errorf("Error creating job spec cache directory %s: %s", c.Path, err.Error())
+	}
+
+	entry.Version = jobSpecCacheVersion
+	contents, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(c.Path, ".entry-")
+	if err != nil {
+		return fmt.Errorf("Error writing job spec cache entry: %s", err.Error())
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("Error writing job spec cache entry: %s", err.Error())
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
+
+func (c *JobSpecCache) entryPath(j *Job) (string, error) {
+	digest, err := ParseMultiDigest(j.SHA1)
+	if err != nil {
+		return "", err
+	}
+
+	algorithm, value := digest.Strongest()
+	return filepath.Join(c.Path, fmt.Sprintf("%s-%s.json", algorithm, value)), nil
+}
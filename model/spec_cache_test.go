@@ -0,0 +1,135 @@
+package model
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobSpecCacheRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	cacheDir, err := ioutil.TempDir("", "fissile-spec-cache")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(cacheDir)
+
+	releasePath := filepath.Join(workDir, "../test-assets/test-final-release")
+	specCache := NewJobSpecCache(cacheDir)
+
+	uncached, err := NewFinalRelease(releasePath)
+	if !assert.NoError(err) {
+		return
+	}
+
+	release, err := NewFinalReleaseWithSpecCache(releasePath, specCache)
+	if !assert.NoError(err) {
+		return
+	}
+
+	entries, err := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	assert.NoError(err)
+	assert.Len(entries, len(release.Jobs))
+
+	// Loading from the cache must give the same result as extracting the archives
+	cached, err := NewFinalReleaseWithSpecCache(releasePath, specCache)
+	if !assert.NoError(err) {
+		return
+	}
+	if assert.Len(cached.Jobs, len(uncached.Jobs)) {
+		for idx, job := range uncached.Jobs {
+			cachedJob := cached.Jobs[idx]
+			assert.Equal(job.Name, cachedJob.Name)
+			assert.Equal(job.Description, cachedJob.Description)
+			assert.Equal(len(job.Packages), len(cachedJob.Packages))
+			assert.Equal(len(job.Properties), len(cachedJob.Properties))
+			if assert.Equal(len(job.Templates), len(cachedJob.Templates)) {
+				for _, template := range job.Templates {
+					found := false
+					for _, cachedTemplate := range cachedJob.Templates {
+						if cachedTemplate.SourcePath == template.SourcePath {
+							found = true
+							assert.Equal(template.Content, cachedTemplate.Content)
+						}
+					}
+					assert.True(found, "Template %s missing from cached job %s", template.SourcePath, job.Name)
+				}
+			}
+		}
+	}
+}
+
+func TestJobSpecCacheIsUsed(t *testing.T) {
+	assert := assert.New(t)
+
+	workDir, err := os.Getwd()
+	assert.NoError(err)
+
+	cacheDir, err := ioutil.TempDir("", "fissile-spec-cache")
+	if !assert.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(cacheDir)
+
+	releasePath := filepath.Join(workDir, "../test-assets/test-final-release")
+	specCache := NewJobSpecCache(cacheDir)
+
+	release, err := NewFinalReleaseWithSpecCache(releasePath, specCache)
+	if !assert.NoError(err) {
+		return
+	}
+	job, err := release.LookupJob("foo")
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Tamper with the cached entry; the next load must pick it up
+	entryPath, err := specCache.entryPath(job)
+	if !assert.NoError(err) {
+		return
+	}
+	contents, err := ioutil.ReadFile(entryPath)
+	if !assert.NoError(err) {
+		return
+	}
+	var entry jobSpecCacheEntry
+	if !assert.NoError(json.Unmarshal(contents, &entry)) {
+		return
+	}
+	entry.Spec = entry.Spec + "\ndescription: from the cache\n"
+	contents, err = json.Marshal(entry)
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(entryPath, contents, 0644))
+
+	release, err = NewFinalReleaseWithSpecCache(releasePath, specCache)
+	if !assert.NoError(err) {
+		return
+	}
+	job, err = release.LookupJob("foo")
+	if assert.NoError(err) {
+		assert.Equal("from the cache", job.Description)
+	}
+
+	// Entries from a different cache format version are ignored
+	entry.Version = jobSpecCacheVersion + 1
+	contents, err = json.Marshal(entry)
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(entryPath, contents, 0644))
+
+	release, err = NewFinalReleaseWithSpecCache(releasePath, specCache)
+	if !assert.NoError(err) {
+		return
+	}
+	job, err = release.LookupJob("foo")
+	if assert.NoError(err) {
+		assert.NotEqual("from the cache", job.Description)
+	}
+}
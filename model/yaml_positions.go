@@ -0,0 +1,220 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// yamlPosition is the position of a node of a YAML document, with the
+// positions of its children. The vendored YAML parser does not report node
+// positions, so they are found by indexBlockYAML, which understands the block
+// style YAML used for role manifests and opinions.
+type yamlPosition struct {
+	line   int
+	column int
+	value  string // The scalar value of the node, if it is on the same line
+	keys   map[string]*yamlPosition
+	items  []*yamlPosition
+}
+
+// yamlPositionFrame is a collection on the stack of indexBlockYAML, with the
+// indentation of its children
+type yamlPositionFrame struct {
+	indent   int
+	node     *yamlPosition
+	sequence bool
+}
+
+// indexBlockYAML returns the positions of the nodes of a block style YAML
+// document. Lines and columns start at 1. Flow style collections and the
+// contents of block scalars are not indexed, only the nodes holding them.
+func indexBlockYAML(contents []byte) *yamlPosition {
+	root := &yamlPosition{line: 1, column: 1}
+	stack := []yamlPositionFrame{{indent: -1, node: root}}
+
+	var pending *yamlPosition // A node whose value starts on the next line
+	pendingIndent := 0
+	blockScalarIndent := -1 // The indentation of the key of a block scalar
+
+	for index, line := range strings.Split(string(contents), "\n") {
+		lineNumber := index + 1
+		line = strings.TrimRight(line, " \t\r")
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+
+		if blockScalarIndent >= 0 {
+			if content == "" || indent > blockScalarIndent {
+				continue
+			}
+			blockScalarIndent = -1
+		}
+		if content == "" || strings.HasPrefix(content, "#") ||
+			content == "---" || strings.HasPrefix(content, "--- ") || content == "..." {
+			continue
+		}
+
+		if pending != nil {
+			if indent > pendingIndent || (indent == pendingIndent && isYAMLSequenceItem(content)) {
+				stack = append(stack, yamlPositionFrame{
+					indent:   indent,
+					node:     pending,
+					sequence: isYAMLSequenceItem(content),
+				})
+			}
+			pending = nil
+		}
+
+		for len(stack) > 1 {
+			top := stack[len(stack)-1]
+			if top.indent > indent || (top.indent == indent && top.sequence && !isYAMLSequenceItem(content)) {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			break
+		}
+
+		column := indent
+		for content != "" {
+			top := stack[len(stack)-1].node
+
+			if isYAMLSequenceItem(content) {
+				item := &yamlPosition{line: lineNumber, column: column + 1}
+				top.items = append(top.items, item)
+
+				rest := content[1:]
+				spaces := len(rest) - len(strings.TrimLeft(rest, " "))
+				rest = rest[spaces:]
+				if rest == "" || strings.HasPrefix(rest, "#") {
+					pending = item
+					pendingIndent = column
+					break
+				}
+
+				column += 1 + spaces
+				content = rest
+				stack = append(stack, yamlPositionFrame{indent: column, node: item})
+				continue
+			}
+
+			key, value, ok := splitYAMLKey(content)
+			if !ok {
+				if top.value == "" {
+					top.value = yamlScalar(content)
+				}
+				break
+			}
+
+			node := &yamlPosition{line: lineNumber, column: column + 1}
+			if top.keys == nil {
+				top.keys = map[string]*yamlPosition{}
+			}
+			top.keys[key] = node
+
+			switch {
+			case value == "" || strings.HasPrefix(value, "#"):
+				pending = node
+				pendingIndent = column
+			case strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+				blockScalarIndent = column
+			default:
+				node.value = yamlScalar(value)
+			}
+			break
+		}
+	}
+
+	return root
+}
+
+// isYAMLSequenceItem returns whether the line content starts a sequence item
+func isYAMLSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// splitYAMLKey splits a line of a block mapping into its key and value
+func splitYAMLKey(content string) (string, string, bool) {
+	if content[0] == '"' || content[0] == '\'' {
+		end := strings.IndexByte(content[1:], content[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key := content[1 : end+1]
+		rest := content[end+2:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+
+	if content[0] == '[' || content[0] == '{' {
+		return "", "", false
+	}
+
+	separator := strings.Index(content, ": ")
+	if separator < 0 {
+		if !strings.HasSuffix(content, ":") {
+			return "", "", false
+		}
+		separator = len(content) - 1
+	}
+	if comment := strings.Index(content, " #"); comment >= 0 && comment < separator {
+		return "", "", false
+	}
+	return content[:separator], strings.TrimSpace(content[separator+1:]), true
+}
+
+// yamlScalar returns the value of a plain or quoted scalar on a single line
+func yamlScalar(value string) string {
+	if value == "" {
+		return value
+	}
+	if value[0] == '"' || value[0] == '\'' {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+		return value
+	}
+	if comment := strings.Index(value, " #"); comment >= 0 {
+		value = value[:comment]
+	}
+	return strings.TrimSpace(value)
+}
+
+// child returns the child of the node with the given name: a map key, a
+// list index, or the name or tag of a list item
+func (p *yamlPosition) child(name string) *yamlPosition {
+	if child, ok := p.keys[name]; ok {
+		return child
+	}
+	if len(p.items) == 0 {
+		return nil
+	}
+	if index, err := strconv.Atoi(name); err == nil {
+		if index >= 0 && index < len(p.items) {
+			return p.items[index]
+		}
+		return nil
+	}
+	for _, key := range []string{"name", "tag"} {
+		for _, item := range p.items {
+			if itemKey, ok := item.keys[key]; ok && itemKey.value == name {
+				return item
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the node at the path, or the closest node containing it.
+// The second result is whether the node itself was found.
+func (p *yamlPosition) lookup(path []string) (*yamlPosition, bool) {
+	node := p
+	for _, name := range path {
+		child := node.child(name)
+		if child == nil {
+			return node, false
+		}
+		node = child
+	}
+	return node, true
+}
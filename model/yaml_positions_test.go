@@ -0,0 +1,66 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexBlockYAML(t *testing.T) {
+	assert := assert.New(t)
+
+	root := indexBlockYAML([]byte(`---
+# A role manifest
+roles:
+- name: api # The API
+  run:
+    memory: 128
+    exposed-ports:
+      - name: http
+        internal: 8080
+    volumes:
+    - tag: "data"
+      path: /data
+  scripts: [a.sh, b.sh]
+  description: |
+    roles:
+    - name: fake
+- name: worker
+  jobs:
+  -
+    name: job
+configuration:
+  templates:
+    properties.tor.hostname: '((FOO))'
+    "quoted key": value
+`))
+
+	tests := []struct {
+		path   []string
+		line   int
+		column int
+		exact  bool
+	}{
+		{[]string{"roles"}, 3, 1, true},
+		{[]string{"roles", "api"}, 4, 1, true},
+		{[]string{"roles", "0", "name"}, 4, 3, true},
+		{[]string{"roles", "api", "run", "memory"}, 6, 5, true},
+		{[]string{"roles", "api", "run", "exposed-ports", "http", "internal"}, 9, 9, true},
+		{[]string{"roles", "api", "run", "volumes", "data", "path"}, 12, 7, true},
+		{[]string{"roles", "api", "scripts"}, 13, 3, true},
+		{[]string{"roles", "api", "run", "scaling", "max"}, 5, 3, false},
+		{[]string{"roles", "fake"}, 3, 1, false},
+		{[]string{"roles", "worker", "jobs", "job", "name"}, 20, 5, true},
+		{[]string{"configuration", "templates", "properties.tor.hostname"}, 23, 5, true},
+		{[]string{"configuration", "templates", "quoted key"}, 24, 5, true},
+	}
+
+	for _, test := range tests {
+		node, exact := root.lookup(test.path)
+		require.NotNil(t, node, "%v", test.path)
+		assert.Equal(test.exact, exact, "%v", test.path)
+		assert.Equal(test.line, node.line, "%v", test.path)
+		assert.Equal(test.column, node.column, "%v", test.path)
+	}
+}
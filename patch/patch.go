@@ -0,0 +1,382 @@
+// Package patch applies BOSH style ops files to YAML documents. It supports
+// the replace and remove operations of go-patch
+// (https://github.com/cppforlife/go-patch), with the same path expressions.
+package patch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// These are the supported operation types
+const (
+	OpTypeReplace = "replace" // Set the value at the path, creating it if needed
+	OpTypeRemove  = "remove"  // Remove the value at the path
+)
+
+// Op is a single operation of an ops file
+type Op struct {
+	Type  string      `yaml:"type"`
+	Path  string      `yaml:"path"`
+	Value interface{} `yaml:"value,omitempty"`
+}
+
+// Ops is a list of operations, applied in order
+type Ops []Op
+
+// LoadOps reads an ops file
+func LoadOps(path string) (Ops, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops Ops
+	if err := yaml.Unmarshal(contents, &ops); err != nil {
+		return nil, fmt.Errorf("Error loading ops file %s: %s", path, err.Error())
+	}
+
+	for i, op := range ops {
+		if _, err := op.pointer(); err != nil {
+			return nil, fmt.Errorf("Error loading ops file %s: operation %d: %s", path, i, err.Error())
+		}
+	}
+
+	return ops, nil
+}
+
+// Apply applies all operations to the document in order, and returns the
+// resulting document. The document is not modified.
+func (ops Ops) Apply(doc interface{}) (interface{}, error) {
+	var err error
+	for i, op := range ops {
+		doc, err = op.Apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %s", i, op.Type, op.Path, err.Error())
+		}
+	}
+	return doc, nil
+}
+
+// Apply applies the operation to the document, and returns the resulting
+// document. The document is not modified.
+func (op Op) Apply(doc interface{}) (interface{}, error) {
+	tokens, err := op.pointer()
+	if err != nil {
+		return nil, err
+	}
+
+	doc = deepCopy(doc)
+	switch op.Type {
+	case OpTypeReplace:
+		return replace(doc, tokens, 0, deepCopy(op.Value))
+	case OpTypeRemove:
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("Cannot remove the whole document")
+		}
+		return remove(doc, tokens, 0)
+	default:
+		return nil, fmt.Errorf("Unknown operation type '%s', expected one of %s or %s", op.Type, OpTypeReplace, OpTypeRemove)
+	}
+}
+
+func (op Op) pointer() ([]token, error) {
+	switch op.Type {
+	case OpTypeReplace, OpTypeRemove:
+	default:
+		return nil, fmt.Errorf("Unknown operation type '%s', expected one of %s or %s", op.Type, OpTypeReplace, OpTypeRemove)
+	}
+	return parsePointer(op.Path)
+}
+
+// tokenKind is the kind of a single step of a path
+type tokenKind int
+
+const (
+	tokenKey      tokenKind = iota // A map key: /key
+	tokenIndex                     // A list index: /0
+	tokenAppend                    // After the last list element: /-
+	tokenMatching                  // The list element with a matching key: /name=value
+)
+
+// token is a single step of a path
+type token struct {
+	kind     tokenKind
+	key      string
+	value    string
+	index    int
+	optional bool
+	path     string // The path up to and including this token, for errors
+}
+
+// parsePointer parses a go-patch path expression, such as
+// /roles/name=api/run/scaling/max
+func parsePointer(path string) ([]token, error) {
+	if path == "" {
+		return nil, fmt.Errorf("Missing path")
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("Path '%s' must start with /", path)
+	}
+	if path == "/" {
+		return nil, nil
+	}
+
+	var tokens []token
+	var optional bool
+	parts := strings.Split(path[1:], "/")
+	for i, part := range parts {
+		part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+		t := token{path: "/" + strings.Join(parts[:i+1], "/")}
+
+		// Once a token is optional, all the following ones are as well
+		if strings.HasSuffix(part, "?") {
+			optional = true
+			part = strings.TrimSuffix(part, "?")
+		}
+		t.optional = optional
+
+		if part == "-" {
+			t.kind = tokenAppend
+		} else if index, err := strconv.Atoi(part); err == nil {
+			t.kind = tokenIndex
+			t.index = index
+		} else if equals := strings.Index(part, "="); equals > 0 {
+			t.kind = tokenMatching
+			t.key = part[:equals]
+			t.value = part[equals+1:]
+		} else {
+			t.kind = tokenKey
+			t.key = part
+		}
+
+		if t.kind == tokenAppend && i != len(parts)-1 {
+			return nil, fmt.Errorf("Path '%s' can only use - as its last step", path)
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, nil
+}
+
+// replace sets the value at the path below obj, and returns the updated obj
+func replace(obj interface{}, tokens []token, i int, value interface{}) (interface{}, error) {
+	if i == len(tokens) {
+		return value, nil
+	}
+	t := tokens[i]
+	isLast := i == len(tokens)-1
+
+	switch t.kind {
+	case tokenKey:
+		typed, ok := obj.(map[interface{}]interface{})
+		if !ok {
+			if obj != nil || !t.optional {
+				return nil, fmt.Errorf("Expected to find a map at path '%s' but found '%T'", t.path, obj)
+			}
+			typed = map[interface{}]interface{}{}
+		}
+		child, found := typed[t.key]
+		if !found && !isLast && !t.optional {
+			return nil, fmt.Errorf("Expected to find a map key '%s' for path '%s' (found map keys: %s)",
+				t.key, t.path, describeKeys(typed))
+		}
+		child, err := replace(child, tokens, i+1, value)
+		if err != nil {
+			return nil, err
+		}
+		typed[t.key] = child
+		return typed, nil
+
+	case tokenIndex, tokenAppend:
+		typed, ok := obj.([]interface{})
+		if !ok {
+			if obj != nil || !t.optional {
+				return nil, fmt.Errorf("Expected to find an array at path '%s' but found '%T'", t.path, obj)
+			}
+		}
+		if t.kind == tokenAppend {
+			return append(typed, value), nil
+		}
+		index := t.index
+		if index < 0 {
+			index += len(typed)
+		}
+		if index < 0 || index >= len(typed) {
+			return nil, fmt.Errorf("Expected to find array index %d but found array of length %d for path '%s'",
+				t.index, len(typed), t.path)
+		}
+		child, err := replace(typed[index], tokens, i+1, value)
+		if err != nil {
+			return nil, err
+		}
+		typed[index] = child
+		return typed, nil
+
+	case tokenMatching:
+		typed, ok := obj.([]interface{})
+		if !ok {
+			if obj != nil || !t.optional {
+				return nil, fmt.Errorf("Expected to find an array at path '%s' but found '%T'", t.path, obj)
+			}
+		}
+		matches := findMatching(typed, t)
+		switch len(matches) {
+		case 1:
+			child, err := replace(typed[matches[0]], tokens, i+1, value)
+			if err != nil {
+				return nil, err
+			}
+			typed[matches[0]] = child
+			return typed, nil
+		case 0:
+			if !t.optional {
+				return nil, fmt.Errorf("Expected to find exactly one matching array item for path '%s' but found 0", t.path)
+			}
+			var child interface{} = map[interface{}]interface{}{t.key: t.value}
+			if isLast {
+				child = value
+			} else {
+				var err error
+				child, err = replace(child, tokens, i+1, value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return append(typed, child), nil
+		default:
+			return nil, fmt.Errorf("Expected to find exactly one matching array item for path '%s' but found %d", t.path, len(matches))
+		}
+	}
+
+	return nil, fmt.Errorf("Unexpected path step at '%s'", t.path)
+}
+
+// remove removes the value at the path below obj, and returns the updated obj
+func remove(obj interface{}, tokens []token, i int) (interface{}, error) {
+	t := tokens[i]
+	isLast := i == len(tokens)-1
+
+	switch t.kind {
+	case tokenKey:
+		typed, ok := obj.(map[interface{}]interface{})
+		if !ok {
+			if obj == nil && t.optional {
+				return obj, nil
+			}
+			return nil, fmt.Errorf("Expected to find a map at path '%s' but found '%T'", t.path, obj)
+		}
+		child, found := typed[t.key]
+		if !found {
+			if t.optional {
+				return typed, nil
+			}
+			return nil, fmt.Errorf("Expected to find a map key '%s' for path '%s' (found map keys: %s)",
+				t.key, t.path, describeKeys(typed))
+		}
+		if isLast {
+			delete(typed, t.key)
+			return typed, nil
+		}
+		child, err := remove(child, tokens, i+1)
+		if err != nil {
+			return nil, err
+		}
+		typed[t.key] = child
+		return typed, nil
+
+	case tokenIndex, tokenMatching:
+		typed, ok := obj.([]interface{})
+		if !ok {
+			if obj == nil && t.optional {
+				return obj, nil
+			}
+			return nil, fmt.Errorf("Expected to find an array at path '%s' but found '%T'", t.path, obj)
+		}
+		var index int
+		if t.kind == tokenIndex {
+			index = t.index
+			if index < 0 {
+				index += len(typed)
+			}
+			if index < 0 || index >= len(typed) {
+				return nil, fmt.Errorf("Expected to find array index %d but found array of length %d for path '%s'",
+					t.index, len(typed), t.path)
+			}
+		} else {
+			matches := findMatching(typed, t)
+			if len(matches) == 0 && t.optional {
+				return typed, nil
+			}
+			if len(matches) != 1 {
+				return nil, fmt.Errorf("Expected to find exactly one matching array item for path '%s' but found %d", t.path, len(matches))
+			}
+			index = matches[0]
+		}
+		if isLast {
+			return append(typed[:index], typed[index+1:]...), nil
+		}
+		child, err := remove(typed[index], tokens, i+1)
+		if err != nil {
+			return nil, err
+		}
+		typed[index] = child
+		return typed, nil
+	}
+
+	return nil, fmt.Errorf("Cannot remove at path '%s'", t.path)
+}
+
+// findMatching returns the indexes of the list items that are maps with the
+// key and value of the token
+func findMatching(list []interface{}, t token) []int {
+	var matches []int
+	for index, item := range list {
+		typed, ok := item.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		if value, ok := typed[t.key]; ok && fmt.Sprintf("%v", value) == t.value {
+			matches = append(matches, index)
+		}
+	}
+	return matches
+}
+
+func describeKeys(obj map[interface{}]interface{}) string {
+	var keys []string
+	for key := range obj {
+		keys = append(keys, fmt.Sprintf("'%v'", key))
+	}
+	if len(keys) == 0 {
+		return "none"
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// deepCopy copies the maps and lists of a YAML document, so that operations
+// do not modify the documents they are applied to
+func deepCopy(obj interface{}) interface{} {
+	switch typed := obj.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(typed))
+		for key, value := range typed {
+			result[key] = deepCopy(value)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for index, value := range typed {
+			result[index] = deepCopy(value)
+		}
+		return result
+	default:
+		return obj
+	}
+}
@@ -0,0 +1,172 @@
+package patch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+const patchTestDoc = `
+roles:
+- name: api
+  run:
+    scaling:
+      min: 1
+      max: 3
+- name: worker
+  tags: [a, b, c]
+`
+
+func loadPatchTestDoc(t *testing.T) interface{} {
+	var doc interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(patchTestDoc), &doc))
+	return doc
+}
+
+func marshalPatchTestDoc(t *testing.T, doc interface{}) string {
+	contents, err := yaml.Marshal(doc)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestApplyReplace(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := loadPatchTestDoc(t)
+	result, err := Ops{
+		{Type: OpTypeReplace, Path: "/roles/name=api/run/scaling/max", Value: 5},
+		{Type: OpTypeReplace, Path: "/roles/1/tags/-", Value: "d"},
+		{Type: OpTypeReplace, Path: "/roles/name=worker/tags/0", Value: "z"},
+		{Type: OpTypeReplace, Path: "/roles/name=api/run/memory?", Value: 128},
+		{Type: OpTypeReplace, Path: "/roles/name=cron?/run/flight-stage", Value: "manual"},
+	}.Apply(doc)
+	require.NoError(t, err)
+
+	assert.Equal(`roles:
+- name: api
+  run:
+    memory: 128
+    scaling:
+      max: 5
+      min: 1
+- name: worker
+  tags:
+  - z
+  - b
+  - c
+  - d
+- name: cron
+  run:
+    flight-stage: manual
+`, marshalPatchTestDoc(t, result))
+
+	// The original document is left alone
+	assert.Equal(loadPatchTestDoc(t), doc)
+}
+
+func TestApplyRemove(t *testing.T) {
+	assert := assert.New(t)
+
+	result, err := Ops{
+		{Type: OpTypeRemove, Path: "/roles/name=api/run/scaling/min"},
+		{Type: OpTypeRemove, Path: "/roles/name=worker/tags/-1"},
+		{Type: OpTypeRemove, Path: "/roles/name=cron?"},
+		{Type: OpTypeRemove, Path: "/configuration?/templates"},
+	}.Apply(loadPatchTestDoc(t))
+	require.NoError(t, err)
+
+	assert.Equal(`roles:
+- name: api
+  run:
+    scaling:
+      max: 3
+- name: worker
+  tags:
+  - a
+  - b
+`, marshalPatchTestDoc(t, result))
+}
+
+func TestApplyErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		op      Op
+		message string
+	}{
+		{
+			Op{Type: OpTypeReplace, Path: "/roles/name=cron/run", Value: 1},
+			"operation 0 (replace /roles/name=cron/run): Expected to find exactly one matching array item for path '/roles/name=cron' but found 0",
+		},
+		{
+			Op{Type: OpTypeReplace, Path: "/configuration/templates", Value: 1},
+			"operation 0 (replace /configuration/templates): Expected to find a map key 'configuration' for path '/configuration' (found map keys: 'roles')",
+		},
+		{
+			Op{Type: OpTypeRemove, Path: "/roles/5"},
+			"operation 0 (remove /roles/5): Expected to find array index 5 but found array of length 2 for path '/roles/5'",
+		},
+		{
+			Op{Type: OpTypeReplace, Path: "/roles/key", Value: 1},
+			"operation 0 (replace /roles/key): Expected to find a map at path '/roles/key' but found '[]interface {}'",
+		},
+		{
+			Op{Type: "move", Path: "/roles"},
+			"operation 0 (move /roles): Unknown operation type 'move', expected one of replace or remove",
+		},
+		{
+			Op{Type: OpTypeRemove, Path: "/"},
+			"operation 0 (remove /): Cannot remove the whole document",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := Ops{test.op}.Apply(loadPatchTestDoc(t))
+		if assert.Error(err) {
+			assert.Equal(test.message, err.Error())
+		}
+	}
+}
+
+func TestLoadOps(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-patch-tests")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	goodPath := filepath.Join(dir, "good.yml")
+	require.NoError(t, ioutil.WriteFile(goodPath, []byte(`
+- type: replace
+  path: /roles/name=api/run/scaling/max
+  value: 5
+- type: remove
+  path: /roles/name=worker
+`), 0644))
+
+	ops, err := LoadOps(goodPath)
+	require.NoError(t, err)
+	assert.Equal(Ops{
+		{Type: OpTypeReplace, Path: "/roles/name=api/run/scaling/max", Value: 5},
+		{Type: OpTypeRemove, Path: "/roles/name=worker"},
+	}, ops)
+
+	badPath := filepath.Join(dir, "bad.yml")
+	require.NoError(t, ioutil.WriteFile(badPath, []byte(`
+- type: replace
+  path: roles/-/name
+`), 0644))
+
+	_, err = LoadOps(badPath)
+	if assert.Error(err) {
+		assert.Equal("Error loading ops file "+badPath+": operation 0: Path 'roles/-/name' must start with /", err.Error())
+	}
+
+	_, err = LoadOps(filepath.Join(dir, "missing.yml"))
+	assert.Error(err)
+}
@@ -0,0 +1,108 @@
+// Package pkgcache shares compiled BOSH packages between builds, through a
+// cache in a local (or shared) directory, on an HTTP server or in an S3
+// compatible object store.
+package pkgcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by backends for keys they hold nothing for
+var ErrNotFound = errors.New("not found in the package cache")
+
+// Backend is the storage of a package cache, holding blobs by key. Keys are
+// relative paths with forward slashes.
+type Backend interface {
+	// Get returns the contents stored for the key, or ErrNotFound
+	Get(key string) (io.ReadCloser, error)
+	// Put stores the contents for the key, replacing any existing ones
+	Put(key string, contents io.Reader, size int64) error
+	// String describes the backend for messages, without credentials
+	String() string
+}
+
+// NewBackend returns the backend for the location of a package cache: a
+// directory, or a file, http, https or s3 URL. S3 URLs are of the form
+// s3://<bucket>/<prefix>, optionally with region and endpoint query
+// parameters for S3 compatible object stores; the credentials are taken from
+// the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+func NewBackend(location string) (Backend, error) {
+	if !strings.Contains(location, "://") {
+		return &localBackend{dir: location}, nil
+	}
+
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing package cache location %s: %s", location, err.Error())
+	}
+
+	switch locationURL.Scheme {
+	case "file":
+		return &localBackend{dir: locationURL.Path}, nil
+	case "http", "https":
+		return &httpBackend{
+			baseURL: strings.TrimSuffix(location, "/"),
+			client:  http.DefaultClient,
+		}, nil
+	case "s3":
+		return newS3Backend(locationURL)
+	default:
+		return nil, fmt.Errorf("Unsupported package cache location %s, expected a directory, or a file, http, https or s3 URL", location)
+	}
+}
+
+// localBackend stores the blobs as files in a directory
+type localBackend struct {
+	dir string
+}
+
+func (b *localBackend) path(key string) string {
+	return filepath.Join(b.dir, filepath.FromSlash(key))
+}
+
+func (b *localBackend) Get(key string) (io.ReadCloser, error) {
+	file, err := os.Open(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Put writes the contents to a temporary file first, so that concurrent
+// readers never see partial contents
+func (b *localBackend) Put(key string, contents io.Reader, size int64) error {
+	path := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (b *localBackend) String() string {
+	return b.dir
+}
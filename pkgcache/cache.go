@@ -0,0 +1,130 @@
+package pkgcache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/SUSE/fissile/util"
+)
+
+// Cache holds compiled packages, addressed by the fingerprint of the package
+// and the stemcell it was compiled on. Each package is stored as a tar.gz
+// archive of its compiled directory, next to the SHA256 digest of the archive,
+// which is checked before the package is used. As the digest is kept in the
+// same backend, it detects corrupted archives, but not archives replaced on
+// purpose together with their digest: whoever can write to the backend is
+// trusted with the packages.
+type Cache struct {
+	backend  Backend
+	stemcell string
+}
+
+// New returns a cache of packages compiled on the stemcell, stored in the
+// backend. The stemcell key identifies the stemcell, e.g. by image ID and OS.
+func New(backend Backend, stemcellKey string) *Cache {
+	return &Cache{
+		backend:  backend,
+		stemcell: fmt.Sprintf("%x", sha1.Sum([]byte(stemcellKey))),
+	}
+}
+
+// String describes the cache for messages
+func (c *Cache) String() string {
+	return c.backend.String()
+}
+
+// key returns the key of the compiled package, without extension
+func (c *Cache) key(fingerprint string) string {
+	return c.stemcell + "/" + fingerprint
+}
+
+// Pull extracts the compiled package with the fingerprint from the cache into
+// the destination directory. It returns false if the cache does not hold the
+// package. The archive is verified against its digest before it is extracted.
+func (c *Cache) Pull(fingerprint, destination string) (bool, error) {
+	key := c.key(fingerprint)
+
+	digest, err := c.backend.Get(key + ".sha256")
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	expected, err := ioutil.ReadAll(io.LimitReader(digest, 1024))
+	digest.Close()
+	if err != nil {
+		return false, fmt.Errorf("Error reading the digest of %s from %s: %s", key, c, err.Error())
+	}
+
+	archive, err := c.backend.Get(key + ".tgz")
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer archive.Close()
+
+	tempFile, err := ioutil.TempFile("", "fissile-package-cache-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tempFile, hash), archive); err != nil {
+		return false, fmt.Errorf("Error downloading %s from %s: %s", key, c, err.Error())
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != strings.TrimSpace(string(expected)) {
+		return false, fmt.Errorf("Checksum mismatch for %s in %s: expected %s, got %s",
+			key, c, strings.TrimSpace(string(expected)), actual)
+	}
+
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if err := util.ExtractCompiledPackageTargz(key+".tgz", tempFile, destination); err != nil {
+		return false, fmt.Errorf("Error extracting %s from %s: %s", key, c, err.Error())
+	}
+	return true, nil
+}
+
+// Push stores the compiled package in the directory in the cache, under the
+// fingerprint. The digest is stored last, so the package is not seen by
+// others before it is complete.
+func (c *Cache) Push(fingerprint, dir string) error {
+	key := c.key(fingerprint)
+
+	tempFile, err := ioutil.TempFile("", "fissile-package-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	hash := sha256.New()
+	if err := util.WriteTargz(dir, io.MultiWriter(tempFile, hash)); err != nil {
+		return fmt.Errorf("Error archiving %s: %s", dir, err.Error())
+	}
+	size, err := tempFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := c.backend.Put(key+".tgz", tempFile, size); err != nil {
+		return err
+	}
+
+	digest := []byte(hex.EncodeToString(hash.Sum(nil)) + "\n")
+	return c.backend.Put(key+".sha256", bytes.NewReader(digest), int64(len(digest)))
+}
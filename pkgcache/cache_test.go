@@ -0,0 +1,170 @@
+package pkgcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryServer is an HTTP server holding uploaded blobs in memory
+type memoryServer struct {
+	sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *memoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	switch r.Method {
+	case "GET":
+		blob, ok := s.blobs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(blob)
+	case "PUT":
+		blob, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.blobs[r.URL.Path] = blob
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeTestPackage(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fissile-pkgcache-test")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bin", "tor"), []byte("tor binary"), 0755))
+	// Compiled packages link to other packages, absolutely and relatively
+	require.NoError(t, os.Symlink("/var/vcap/packages/libevent/lib", filepath.Join(dir, "lib")))
+	require.NoError(t, os.Symlink("../../openssl/bin/openssl", filepath.Join(dir, "bin", "openssl")))
+	return dir
+}
+
+func testRoundTrip(t *testing.T, backend Backend) {
+	assert := assert.New(t)
+
+	source := writeTestPackage(t)
+	defer os.RemoveAll(source)
+	destination, err := ioutil.TempDir("", "fissile-pkgcache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(destination)
+
+	cache := New(backend, "stemcell:42")
+	found, err := cache.Pull("abcdef", destination)
+	assert.NoError(err)
+	assert.False(found)
+
+	require.NoError(t, cache.Push("abcdef", source))
+
+	found, err = cache.Pull("abcdef", destination)
+	assert.NoError(err)
+	assert.True(found)
+	contents, err := ioutil.ReadFile(filepath.Join(destination, "bin", "tor"))
+	assert.NoError(err)
+	assert.Equal("tor binary", string(contents))
+	for link, expected := range map[string]string{
+		"lib":                           "/var/vcap/packages/libevent/lib",
+		filepath.Join("bin", "openssl"): "../../openssl/bin/openssl",
+	} {
+		actual, err := os.Readlink(filepath.Join(destination, link))
+		if assert.NoError(err, link) {
+			assert.Equal(expected, actual, link)
+		}
+	}
+
+	// Packages compiled on other stemcells are not shared
+	found, err = New(backend, "stemcell:43").Pull("abcdef", destination)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fissile-pkgcache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	backend, err := NewBackend(dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, backend.String())
+	testRoundTrip(t, backend)
+
+	backend, err = NewBackend("file://" + dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, backend.String())
+}
+
+func TestHTTPBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	server := &memoryServer{blobs: map[string][]byte{}}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	backend, err := NewBackend(httpServer.URL + "/cache/")
+	require.NoError(t, err)
+	testRoundTrip(t, backend)
+
+	var keys []string
+	for key := range server.blobs {
+		keys = append(keys, key)
+	}
+	assert.Len(keys, 2)
+	for _, key := range keys {
+		assert.True(strings.HasPrefix(key, "/cache/"), key)
+	}
+
+	backend, err = NewBackend(strings.Replace(httpServer.URL, "http://", "http://user:secret@", 1))
+	require.NoError(t, err)
+	assert.Equal(httpServer.URL, backend.String())
+}
+
+func TestCacheRejectsCorruptPackages(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-pkgcache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	backend, err := NewBackend(dir)
+	require.NoError(t, err)
+
+	source := writeTestPackage(t)
+	defer os.RemoveAll(source)
+	cache := New(backend, "stemcell:42")
+	require.NoError(t, cache.Push("abcdef", source))
+
+	digest := []byte("0000\n")
+	require.NoError(t, backend.Put(cache.key("abcdef")+".sha256", bytes.NewReader(digest), int64(len(digest))))
+
+	destination, err := ioutil.TempDir("", "fissile-pkgcache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(destination)
+
+	found, err := cache.Pull("abcdef", destination)
+	assert.False(found)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Checksum mismatch")
+	}
+	_, err = os.Stat(filepath.Join(destination, "bin"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestNewBackendUnsupported(t *testing.T) {
+	_, err := NewBackend("ftp://example.com/cache")
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package pkgcache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// httpBackend stores the blobs on an HTTP server, which has to support GET
+// and PUT requests below the base URL. Credentials in the URL are used for
+// basic authentication.
+type httpBackend struct {
+	baseURL string
+	client  *http.Client
+	sign    func(*http.Request) error // Authenticates requests, if set
+}
+
+func (b *httpBackend) do(method, key string, body io.Reader, size int64) (*http.Response, error) {
+	request, err := http.NewRequest(method, b.baseURL+"/"+key, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.ContentLength = size
+	}
+	if b.sign != nil {
+		if err := b.sign(request); err != nil {
+			return nil, err
+		}
+	}
+	return b.client.Do(request)
+}
+
+func (b *httpBackend) Get(key string) (io.ReadCloser, error) {
+	response, err := b.do("GET", key, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching %s from %s: %s", key, b, err.Error())
+	}
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrNotFound
+	}
+	if response.StatusCode/100 != 2 {
+		response.Body.Close()
+		return nil, fmt.Errorf("Error fetching %s from %s: %s", key, b, response.Status)
+	}
+	return response.Body, nil
+}
+
+func (b *httpBackend) Put(key string, contents io.Reader, size int64) error {
+	response, err := b.do("PUT", key, contents, size)
+	if err != nil {
+		return fmt.Errorf("Error storing %s in %s: %s", key, b, err.Error())
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("Error storing %s in %s: %s", key, b, response.Status)
+	}
+	return nil
+}
+
+// String returns the base URL without credentials
+func (b *httpBackend) String() string {
+	baseURL, err := url.Parse(b.baseURL)
+	if err != nil {
+		return b.baseURL
+	}
+	baseURL.User = nil
+	return baseURL.String()
+}
@@ -0,0 +1,71 @@
+package pkgcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SUSE/fissile/util"
+)
+
+// now returns the time S3 requests are signed at; tests replace it
+var now = time.Now
+
+// newS3Backend returns a backend for an s3://<bucket>/<prefix> URL, talking
+// path style to the endpoint from the URL query, or to AWS
+func newS3Backend(location *url.URL) (Backend, error) {
+	if location.Host == "" {
+		return nil, fmt.Errorf("Package cache location %s does not name a bucket", location)
+	}
+
+	query := location.Query()
+	signer := &s3Signer{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		region:       firstNonEmpty(query.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+	}
+	if signer.accessKey == "" || signer.secretKey == "" {
+		return nil, fmt.Errorf("Package cache %s needs the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables", location)
+	}
+
+	endpoint := firstNonEmpty(query.Get("endpoint"), fmt.Sprintf("https://s3.%s.amazonaws.com", signer.region))
+	baseURL := strings.TrimSuffix(endpoint, "/") + "/" + location.Host
+	if prefix := strings.Trim(location.Path, "/"); prefix != "" {
+		baseURL += "/" + prefix
+	}
+
+	return &httpBackend{
+		baseURL: baseURL,
+		client:  http.DefaultClient,
+		sign:    signer.sign,
+	}, nil
+}
+
+// firstNonEmpty returns the first of the values which is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// s3Signer authenticates S3 requests with AWS signature version 4
+type s3Signer struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	region       string
+}
+
+// sign adds the date and authorization headers to the request, signing the
+// host and all headers set on it; uploads are streamed with an unsigned body
+func (s *s3Signer) sign(request *http.Request) error {
+	util.SignS3Request(request, s.accessKey, s.secretKey, s.sessionToken, s.region, now())
+	return nil
+}
@@ -0,0 +1,33 @@
+#!/usr/bin/env bash
+# Runs the compilation script in a chroot of the stemcell root filesystem.
+# This runs in new user and mount namespaces, as root of the user namespace.
+set -o errexit -o nounset
+
+usage() {
+  echo "${1} not specified" >&2
+  echo "Usage: ${0} <rootfs> <sources> <compiled> <scratch> <package> <version>" >&2
+  exit 1
+}
+
+rootfs="${1:-}"
+sources="${2:-}"
+compiled="${3:-}"
+scratch="${4:-}"
+test -n "${rootfs}" || usage "Root filesystem"
+test -n "${sources}" || usage "Sources"
+test -n "${compiled}" || usage "Compiled directory"
+test -n "${scratch}" || usage "Scratch directory"
+shift 4
+
+# Keep the mounts below to this mount namespace
+mount --make-rprivate /
+
+mount --rbind /dev "${rootfs}/dev"
+mount --rbind /proc "${rootfs}/proc"
+mount --rbind /sys "${rootfs}/sys"
+mount --bind "${scratch}/tmp" "${rootfs}/tmp"
+mount --bind "${scratch}/vcap" "${rootfs}/var/vcap"
+mount --bind "${sources}" "${rootfs}/fissile-in"
+mount --bind "${compiled}" "${rootfs}/fissile-out"
+
+exec chroot "${rootfs}" /bin/bash /fissile-in/compile.sh "$@"
@@ -17,6 +17,9 @@ const (
 	CompilationScript = "compile"
 	// PrerequisitesScript is the script that installs prerequisites
 	PrerequisitesScript = "prerequisites"
+	// ChrootScript is the script that runs the compilation script in a
+	// chroot of the stemcell root filesystem
+	ChrootScript = "chroot"
 )
 
 // SaveScript will write a script to the disk
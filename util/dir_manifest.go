@@ -0,0 +1,196 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The types of the entries of a DirManifest
+const (
+	DirManifestFile    = "file"
+	DirManifestDir     = "dir"
+	DirManifestSymlink = "symlink"
+)
+
+// DirManifest lists the contents of a directory, to detect changes to it
+type DirManifest struct {
+	Entries []DirManifestEntry `json:"entries"`
+}
+
+// DirManifestEntry is a file, directory or symbolic link of a DirManifest.
+// Path is relative to the directory, with forward slashes. Mode holds the
+// permission bits, in octal. Files have their size and SHA-256 digest, and
+// symbolic links their target.
+type DirManifestEntry struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Mode   string `json:"mode"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// NewDirManifest returns the manifest of the directory. Entries other than
+// regular files, directories and symbolic links are skipped.
+func NewDirManifest(dir string) (*DirManifest, error) {
+	manifest := &DirManifest{Entries: []DirManifestEntry{}}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+
+		entry, err := NewDirManifestEntry(relPath, path, info)
+		if err != nil || entry == nil {
+			return err
+		}
+		manifest.Entries = append(manifest.Entries, *entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// NewDirManifestEntry returns the manifest entry of the file at the path,
+// or nil if the file is neither a regular file, directory or symbolic link
+func NewDirManifestEntry(relPath, path string, info os.FileInfo) (*DirManifestEntry, error) {
+	entry := &DirManifestEntry{
+		Path: filepath.ToSlash(relPath),
+		Mode: fmt.Sprintf("%04o", info.Mode().Perm()),
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		entry.Type = DirManifestSymlink
+		entry.Link = link
+	case info.IsDir():
+		entry.Type = DirManifestDir
+	case info.Mode().IsRegular():
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		hasher := sha256.New()
+		size, err := io.Copy(hasher, file)
+		if err != nil {
+			return nil, err
+		}
+		entry.Type = DirManifestFile
+		entry.Size = size
+		entry.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	default:
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+// ReadDirManifest reads the manifest written by Write
+func ReadDirManifest(path string) (*DirManifest, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest DirManifest
+	if err := json.Unmarshal(buf, &manifest); err != nil {
+		return nil, fmt.Errorf("Error reading the manifest %s: %s", path, err.Error())
+	}
+	return &manifest, nil
+}
+
+// Write writes the manifest to the path
+func (m *DirManifest) Write(path string) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(buf, '\n'), 0644)
+}
+
+// Diff returns the differences of the actual manifest to this one, one
+// description per entry, sorted by path
+func (m *DirManifest) Diff(actual *DirManifest) []string {
+	expected := map[string]DirManifestEntry{}
+	for _, entry := range m.Entries {
+		expected[entry.Path] = entry
+	}
+
+	var diffs []string
+	for _, entry := range actual.Entries {
+		want, ok := expected[entry.Path]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected %s", entry.Path, entry.Type))
+			continue
+		}
+		delete(expected, entry.Path)
+		if diff := want.Diff(entry); diff != "" {
+			diffs = append(diffs, fmt.Sprintf("%s: %s", entry.Path, diff))
+		}
+	}
+	for path, entry := range expected {
+		diffs = append(diffs, fmt.Sprintf("%s: missing %s", path, entry.Type))
+	}
+
+	sort.Strings(diffs)
+	return diffs
+}
+
+// Diff describes how the actual entry differs from this one, or returns an
+// empty string if they match
+func (e DirManifestEntry) Diff(actual DirManifestEntry) string {
+	switch {
+	case e.Type != actual.Type:
+		return fmt.Sprintf("%s instead of %s", actual.Type, e.Type)
+	case e.Mode != actual.Mode:
+		return fmt.Sprintf("mode %s instead of %s", actual.Mode, e.Mode)
+	case e.Link != actual.Link:
+		return fmt.Sprintf("links to %s instead of %s", actual.Link, e.Link)
+	case e.Size != actual.Size:
+		return fmt.Sprintf("size %d instead of %d", actual.Size, e.Size)
+	case e.SHA256 != actual.SHA256:
+		return "contents changed"
+	}
+	return ""
+}
+
+// Verify checks that the directory matches the manifest
+func (m *DirManifest) Verify(dir string) error {
+	actual, err := NewDirManifest(dir)
+	if err != nil {
+		return err
+	}
+	return DirManifestError(m.Diff(actual))
+}
+
+// DirManifestError returns an error summarizing the differences to a
+// manifest, or nil if there are none
+func DirManifestError(diffs []string) error {
+	if len(diffs) == 0 {
+		return nil
+	}
+	const shown = 3
+	if len(diffs) > shown {
+		diffs = append(diffs[:shown:shown], fmt.Sprintf("and %d more", len(diffs)-shown))
+	}
+	return fmt.Errorf("%s", strings.Join(diffs, "; "))
+}
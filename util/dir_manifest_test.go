@@ -0,0 +1,54 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirManifest(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-dir-manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bin", "tor"), []byte("tor"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("readme"), 0644))
+	require.NoError(t, os.Symlink("bin/tor", filepath.Join(dir, "tor")))
+
+	manifest, err := NewDirManifest(dir)
+	require.NoError(t, err)
+	assert.Equal([]DirManifestEntry{
+		{Path: "README", Type: DirManifestFile, Mode: "0644", Size: 6, SHA256: "711a6108ba2ce6ca93dd47d6817f2361db10d8ab6eec89460b2dfc2c325efabe"},
+		{Path: "bin", Type: DirManifestDir, Mode: "0755"},
+		{Path: "bin/tor", Type: DirManifestFile, Mode: "0755", Size: 3, SHA256: "bd144d4b9250b5395989271bf922dc5efcc4f66c8ee2e180e1a29c96db47f8b5"},
+		{Path: "tor", Type: DirManifestSymlink, Mode: "0777", Link: "bin/tor"},
+	}, manifest.Entries)
+
+	manifestPath := filepath.Join(dir, "..", filepath.Base(dir)+".json")
+	require.NoError(t, manifest.Write(manifestPath))
+	defer os.Remove(manifestPath)
+	manifest, err = ReadDirManifest(manifestPath)
+	require.NoError(t, err)
+	assert.NoError(manifest.Verify(dir))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bin", "tor"), []byte("rot"), 0755))
+	require.NoError(t, os.Chmod(filepath.Join(dir, "README"), 0600))
+	require.NoError(t, os.Remove(filepath.Join(dir, "tor")))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "extra"), []byte{}, 0644))
+
+	err = manifest.Verify(dir)
+	if assert.Error(err) {
+		assert.Equal("README: mode 0600 instead of 0644; bin/tor: contents changed; extra: unexpected file; and 1 more", err.Error())
+	}
+
+	actual, err := NewDirManifest(dir)
+	require.NoError(t, err)
+	assert.Contains(manifest.Diff(actual), "tor: missing symlink")
+}
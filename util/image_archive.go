@@ -0,0 +1,418 @@
+package util
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Media types of the indexes which list the manifests of an image for
+// several platforms
+const (
+	ociImageIndexMediaType      = "application/vnd.oci.image.index.v1+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// dockerArchiveImage is an image of the manifest.json of a `docker save`
+// archive
+type dockerArchiveImage struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ociDescriptor points to a blob of an OCI image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+type ociIndex struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// ExtractImageArchive unpacks the root filesystem of the image in the tarball
+// at archivePath, as written by `docker save` or as an OCI image layout, into
+// targetDir, applying its layers in order. If the archive holds several
+// images, reference names the one to unpack. Ownership and device files are
+// not restored, and directories stay writable by their owner, so that no
+// privileges are needed to unpack the root filesystem or to remove it. It
+// returns the ID of the image, the digest of its configuration.
+func ExtractImageArchive(archivePath, reference, targetDir string) (string, error) {
+	archiveDir, err := ioutil.TempDir(filepath.Dir(targetDir), ".image-archive-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(archiveDir)
+
+	if err := applyImageLayer(archivePath, archiveDir); err != nil {
+		return "", fmt.Errorf("Error reading image archive %s: %s", archivePath, err.Error())
+	}
+
+	var imageID string
+	var layers []string
+	if _, err = os.Stat(filepath.Join(archiveDir, "manifest.json")); err == nil {
+		imageID, layers, err = readDockerArchiveManifest(archiveDir, reference)
+	} else if _, err = os.Stat(filepath.Join(archiveDir, "index.json")); err == nil {
+		imageID, layers, err = readOCIArchiveIndex(archiveDir, reference)
+	} else {
+		return "", fmt.Errorf("Image archive %s is neither a docker save archive nor an OCI image layout", archivePath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error reading image archive %s: %s", archivePath, err.Error())
+	}
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return "", err
+	}
+	for _, layer := range layers {
+		layerPath, err := resolveInRoot(archiveDir, layer, true)
+		if err == nil {
+			err = applyImageLayer(layerPath, targetDir)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Error applying layer %s of image archive %s: %s", layer, archivePath, err.Error())
+		}
+	}
+
+	return imageID, nil
+}
+
+func readDockerArchiveManifest(archiveDir, reference string) (string, []string, error) {
+	var images []dockerArchiveImage
+	if err := readArchiveJSON(archiveDir, "manifest.json", &images); err != nil {
+		return "", nil, err
+	}
+
+	var image *dockerArchiveImage
+	if len(images) == 1 {
+		image = &images[0]
+	}
+	for i := range images {
+		for _, tag := range images[i].RepoTags {
+			if tag == reference {
+				image = &images[i]
+			}
+		}
+	}
+	if image == nil {
+		return "", nil, fmt.Errorf("The archive holds %d images, none of them tagged %s", len(images), reference)
+	}
+
+	// The configuration is <hex>.json in older archives, and
+	// blobs/<algorithm>/<hex> in newer ones
+	parts := strings.Split(path.Clean(image.Config), "/")
+	if len(parts) == 3 && parts[0] == "blobs" {
+		return parts[1] + ":" + parts[2], image.Layers, nil
+	}
+	return "sha256:" + strings.TrimSuffix(path.Base(image.Config), ".json"), image.Layers, nil
+}
+
+func readOCIArchiveIndex(archiveDir, reference string) (string, []string, error) {
+	var index ociIndex
+	if err := readArchiveJSON(archiveDir, "index.json", &index); err != nil {
+		return "", nil, err
+	}
+
+	descriptor, err := selectOCIImage(index.Manifests, reference)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Images built for several platforms point to another index
+	for descriptor.MediaType == ociImageIndexMediaType || descriptor.MediaType == dockerManifestListMediaType {
+		if err := readArchiveJSON(archiveDir, ociBlobPath(descriptor.Digest), &index); err != nil {
+			return "", nil, err
+		}
+		if descriptor, err = selectOCIPlatform(index.Manifests); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var manifest ociManifest
+	if err := readArchiveJSON(archiveDir, ociBlobPath(descriptor.Digest), &manifest); err != nil {
+		return "", nil, err
+	}
+
+	layers := make([]string, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		if strings.Contains(layer.MediaType, "zstd") {
+			return "", nil, fmt.Errorf("Layer %s is compressed with zstd, which is not supported", layer.Digest)
+		}
+		layers = append(layers, ociBlobPath(layer.Digest))
+	}
+	return manifest.Config.Digest, layers, nil
+}
+
+// selectOCIImage returns the image named by reference, by its full name or
+// by its tag, or the only image of the index
+func selectOCIImage(manifests []ociDescriptor, reference string) (ociDescriptor, error) {
+	tag := reference
+	if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") {
+		tag = reference[i+1:]
+	}
+
+	for _, descriptor := range manifests {
+		if descriptor.Annotations["io.containerd.image.name"] == reference {
+			return descriptor, nil
+		}
+	}
+	for _, descriptor := range manifests {
+		if name := descriptor.Annotations["org.opencontainers.image.ref.name"]; name == reference || name == tag {
+			return descriptor, nil
+		}
+	}
+	if len(manifests) == 1 {
+		return manifests[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf("The archive holds %d images, none of them named %s", len(manifests), reference)
+}
+
+// selectOCIPlatform returns the image for Linux on the current architecture,
+// or the only image with a known platform
+func selectOCIPlatform(manifests []ociDescriptor) (ociDescriptor, error) {
+	var known []ociDescriptor
+	for _, descriptor := range manifests {
+		if descriptor.Platform == nil {
+			known = append(known, descriptor)
+			continue
+		}
+		if descriptor.Platform.OS == "linux" && descriptor.Platform.Architecture == runtime.GOARCH {
+			return descriptor, nil
+		}
+		if descriptor.Platform.OS != "unknown" {
+			known = append(known, descriptor)
+		}
+	}
+	if len(known) == 1 {
+		return known[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf("The archive holds no image for linux/%s", runtime.GOARCH)
+}
+
+// ociBlobPath returns the path of the blob with the digest in an OCI image
+// layout
+func ociBlobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+func readArchiveJSON(archiveDir, name string, v interface{}) error {
+	jsonPath, err := resolveInRoot(archiveDir, name, true)
+	if err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(contents, v); err != nil {
+		return fmt.Errorf("%s: %s", name, err.Error())
+	}
+	return nil
+}
+
+// applyImageLayer unpacks the layer tarball, compressed with gzip or not, on
+// top of root, honouring the whiteouts of files removed by the layer
+func applyImageLayer(layerPath, root string) error {
+	file, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var layer io.Reader = reader
+	magic, _ := reader.Peek(len(zstdMagic))
+	if len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		layer = gzipReader
+	} else if bytes.Equal(magic, zstdMagic) {
+		return fmt.Errorf("Layers compressed with zstd are not supported")
+	}
+
+	// Opaque whiteouts only hide what lower layers hold
+	created := map[string]bool{}
+
+	tarReader := tar.NewReader(layer)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := applyImageLayerEntry(root, tarReader, header, created); err != nil {
+			return fmt.Errorf("%s: %s", header.Name, err.Error())
+		}
+	}
+}
+
+func applyImageLayerEntry(root string, reader io.Reader, header *tar.Header, created map[string]bool) error {
+	name := path.Clean("/" + header.Name)
+	if name == "/" {
+		return nil
+	}
+
+	dir, base := path.Split(name)
+	parent, err := resolveInRoot(root, dir, true)
+	if err != nil {
+		return err
+	}
+
+	if base == ".wh..wh..opq" {
+		entries, err := ioutil.ReadDir(parent)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			entryPath := filepath.Join(parent, entry.Name())
+			if !created[entryPath] {
+				if err := os.RemoveAll(entryPath); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if strings.HasPrefix(base, ".wh.") {
+		return os.RemoveAll(filepath.Join(parent, strings.TrimPrefix(base, ".wh.")))
+	}
+
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(parent, base)
+	created[target] = true
+	mode := header.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(target, mode|0700)
+
+	case tar.TypeReg, tar.TypeRegA:
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, reader)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		return os.Chmod(target, mode)
+
+	case tar.TypeSymlink:
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		return os.Symlink(header.Linkname, target)
+
+	case tar.TypeLink:
+		source, err := resolveInRoot(root, header.Linkname, false)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		return os.Link(source, target)
+	}
+
+	// Device files and FIFOs can not be created without privileges
+	return nil
+}
+
+// resolveInRoot returns the path of name below root, following symbolic
+// links as if root was the root directory, so that they can not lead out of
+// it. The last element of name is only followed with followLast set.
+func resolveInRoot(root, name string, followLast bool) (string, error) {
+	isSeparator := func(r rune) bool { return r == '/' }
+	pending := strings.FieldsFunc(name, isSeparator)
+	resolved := "/"
+	links := 0
+
+	for len(pending) > 0 {
+		element := pending[0]
+		pending = pending[1:]
+		switch element {
+		case ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, element)
+		if len(pending) == 0 && !followLast {
+			resolved = next
+			continue
+		}
+
+		hostPath := filepath.Join(root, filepath.FromSlash(next))
+		info, err := os.Lstat(hostPath)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// Missing elements are created by the caller
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > 255 {
+			return "", fmt.Errorf("Too many levels of symbolic links in %s", name)
+		}
+		link, err := os.Readlink(hostPath)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			resolved = "/"
+		}
+		pending = append(strings.FieldsFunc(link, isSeparator), pending...)
+	}
+
+	return filepath.Join(root, filepath.FromSlash(resolved)), nil
+}
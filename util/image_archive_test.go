@@ -0,0 +1,222 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	contents string
+	link     string
+	mode     int64
+}
+
+func testTar(t *testing.T, compress bool, entries ...testTarEntry) []byte {
+	buf := &bytes.Buffer{}
+	var gzipWriter *gzip.Writer
+	tarWriter := tar.NewWriter(buf)
+	if compress {
+		gzipWriter = gzip.NewWriter(buf)
+		tarWriter = tar.NewWriter(gzipWriter)
+	}
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.link,
+			Mode:     entry.mode,
+			Size:     int64(len(entry.contents)),
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+			if entry.typeflag == tar.TypeDir {
+				header.Mode = 0755
+			}
+		}
+		require.NoError(t, tarWriter.WriteHeader(header))
+		_, err := tarWriter.Write([]byte(entry.contents))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tarWriter.Close())
+	if compress {
+		require.NoError(t, gzipWriter.Close())
+	}
+	return buf.Bytes()
+}
+
+func testJSON(t *testing.T, v interface{}) string {
+	contents, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func testFile(name string, contents []byte) testTarEntry {
+	return testTarEntry{name: name, typeflag: tar.TypeReg, contents: string(contents)}
+}
+
+// testLayers are the layers of the test images: the second one changes a
+// file, removes another, replaces the contents of a directory, and writes
+// through symbolic links, one of them trying to lead out of the image
+func testLayers(t *testing.T) [][]byte {
+	return [][]byte{
+		testTar(t, false,
+			testTarEntry{name: "etc/", typeflag: tar.TypeDir},
+			testTarEntry{name: "etc/os-release", typeflag: tar.TypeReg, contents: "leap"},
+			testTarEntry{name: "etc/old", typeflag: tar.TypeReg, contents: "old"},
+			testTarEntry{name: "usr/lib/", typeflag: tar.TypeDir},
+			testTarEntry{name: "lib", typeflag: tar.TypeSymlink, link: "usr/lib"},
+			testTarEntry{name: "escape", typeflag: tar.TypeSymlink, link: "/"},
+			testTarEntry{name: "opt/old", typeflag: tar.TypeReg, contents: "old"},
+			testTarEntry{name: "readonly/", typeflag: tar.TypeDir, mode: 0555},
+			testTarEntry{name: "bin/bash", typeflag: tar.TypeReg, contents: "bash", mode: 0755},
+			testTarEntry{name: "bin/sh", typeflag: tar.TypeLink, link: "bin/bash"},
+			testTarEntry{name: "dev/null", typeflag: tar.TypeChar},
+		),
+		testTar(t, true,
+			testTarEntry{name: "etc/os-release", typeflag: tar.TypeReg, contents: "leap 15"},
+			testTarEntry{name: "etc/.wh.old", typeflag: tar.TypeReg},
+			testTarEntry{name: "lib/libc.so", typeflag: tar.TypeReg, contents: "libc"},
+			testTarEntry{name: "escape/etc/passwd", typeflag: tar.TypeReg, contents: "root"},
+			testTarEntry{name: "../../outside", typeflag: tar.TypeReg, contents: "outside"},
+			testTarEntry{name: "opt/new", typeflag: tar.TypeReg, contents: "new"},
+			testTarEntry{name: "opt/.wh..wh..opq", typeflag: tar.TypeReg},
+			testTarEntry{name: "readonly/file", typeflag: tar.TypeReg, contents: "file"},
+		),
+	}
+}
+
+func assertTestRootfs(t *testing.T, rootfs string) {
+	assert := assert.New(t)
+
+	for name, expected := range map[string]string{
+		"etc/os-release":   "leap 15",
+		"usr/lib/libc.so":  "libc",
+		"etc/passwd":       "root",
+		"outside":          "outside",
+		"opt/new":          "new",
+		"readonly/file":    "file",
+		"bin/sh":           "bash",
+		"lib/../etc/hosts": "",
+	} {
+		contents, err := ioutil.ReadFile(filepath.Join(rootfs, name))
+		if expected == "" {
+			assert.True(os.IsNotExist(err), name)
+			continue
+		}
+		if assert.NoError(err, name) {
+			assert.Equal(expected, string(contents), name)
+		}
+	}
+
+	for _, name := range []string{"etc/old", "opt/old", "dev/null"} {
+		_, err := os.Lstat(filepath.Join(rootfs, name))
+		assert.True(os.IsNotExist(err), "%s should not exist", name)
+	}
+
+	info, err := os.Stat(filepath.Join(rootfs, "readonly"))
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0755), info.Mode().Perm(), "Directories stay writable by their owner")
+	}
+	info, err = os.Stat(filepath.Join(rootfs, "bin", "bash"))
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0755), info.Mode().Perm())
+	}
+}
+
+func TestExtractImageArchiveDocker(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-image-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	layers := testLayers(t)
+	manifest := testJSON(t, []dockerArchiveImage{
+		{Config: "0123abcd.json", RepoTags: []string{"stemcell:latest"}, Layers: []string{"l1/layer.tar", "l2/layer.tar"}},
+		{Config: "4567cdef.json", RepoTags: []string{"other:latest"}, Layers: []string{"l1/layer.tar"}},
+	})
+	archivePath := filepath.Join(dir, "stemcell.tar")
+	require.NoError(t, ioutil.WriteFile(archivePath, testTar(t, false,
+		testFile("l1/layer.tar", layers[0]),
+		testFile("l2/layer.tar", layers[1]),
+		testFile("0123abcd.json", []byte("{}")),
+		testFile("manifest.json", []byte(manifest)),
+	), 0644))
+
+	rootfs := filepath.Join(dir, "rootfs")
+	imageID, err := ExtractImageArchive(archivePath, "stemcell:latest", rootfs)
+	require.NoError(t, err)
+	assert.Equal("sha256:0123abcd", imageID)
+	assertTestRootfs(t, rootfs)
+
+	_, err = ExtractImageArchive(archivePath, "missing:latest", filepath.Join(dir, "missing"))
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "holds 2 images, none of them tagged missing:latest")
+	}
+}
+
+func TestExtractImageArchiveOCI(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-image-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	layers := testLayers(t)
+	platforms := testJSON(t, map[string]interface{}{
+		"mediaType": ociImageIndexMediaType,
+		"manifests": []map[string]interface{}{
+			{"digest": "sha256:attestation", "platform": map[string]string{"os": "unknown", "architecture": "unknown"}},
+			{"digest": "sha256:image", "platform": map[string]string{"os": "linux", "architecture": runtime.GOARCH}},
+		},
+	})
+	index := testJSON(t, map[string]interface{}{
+		"manifests": []map[string]interface{}{
+			{"mediaType": ociImageIndexMediaType, "digest": "sha256:platforms",
+				"annotations": map[string]string{"io.containerd.image.name": "registry.example.com/stemcell:latest"}},
+			{"digest": "sha256:other", "annotations": map[string]string{"org.opencontainers.image.ref.name": "other"}},
+		},
+	})
+	manifest := testJSON(t, map[string]interface{}{
+		"config": map[string]string{"digest": "sha256:config"},
+		"layers": []map[string]string{
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar", "digest": "sha256:layer1"},
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": "sha256:layer2"},
+		},
+	})
+	archivePath := filepath.Join(dir, "stemcell.tar")
+	require.NoError(t, ioutil.WriteFile(archivePath, testTar(t, true,
+		testFile("oci-layout", []byte(`{"imageLayoutVersion": "1.0.0"}`)),
+		testFile("index.json", []byte(index)),
+		testFile("blobs/sha256/platforms", []byte(platforms)),
+		testFile("blobs/sha256/image", []byte(manifest)),
+		testFile("blobs/sha256/layer1", layers[0]),
+		testFile("blobs/sha256/layer2", layers[1]),
+	), 0644))
+
+	rootfs := filepath.Join(dir, "rootfs")
+	imageID, err := ExtractImageArchive(archivePath, "registry.example.com/stemcell:latest", rootfs)
+	require.NoError(t, err)
+	assert.Equal("sha256:config", imageID)
+	assertTestRootfs(t, rootfs)
+
+	require.NoError(t, ioutil.WriteFile(archivePath, testTar(t, false, testFile("layer.tar", layers[0])), 0644))
+	_, err = ExtractImageArchive(archivePath, "stemcell:latest", filepath.Join(dir, "invalid"))
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "neither a docker save archive nor an OCI image layout")
+	}
+}
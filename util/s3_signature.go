@@ -0,0 +1,128 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// S3EmptyPayloadHash is the SHA256 of the empty body of GET requests
+	S3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// S3UnsignedPayload is the content hash of S3 requests whose body is not
+	// part of the signature, so uploads can be streamed
+	S3UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// SignS3Request adds an AWS signature version 4 Authorization header to an S3
+// request, signing the host and all headers already set on the request. The
+// body is signed by the hash in the X-Amz-Content-Sha256 header, and left
+// unsigned without it. The path of the request is sent the way it is signed.
+func SignS3Request(request *http.Request, accessKeyID, secretAccessKey, sessionToken, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	if request.Header.Get("X-Amz-Content-Sha256") == "" {
+		request.Header.Set("X-Amz-Content-Sha256", S3UnsignedPayload)
+	}
+	if sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders []string
+	for _, name := range names {
+		canonicalHeaders = append(canonicalHeaders, name+":"+headers[name]+"\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	request.URL.RawPath = s3Escape(request.URL.Path, true)
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.RawPath,
+		s3CanonicalQuery(request),
+		strings.Join(canonicalHeaders, ""),
+		signedHeaders,
+		request.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(s3SHA256([]byte(canonicalRequest))),
+	}, "\n")
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = s3HMAC(key, part)
+	}
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+// s3CanonicalQuery returns the query parameters of the request, encoded and
+// sorted as signed
+func s3CanonicalQuery(request *http.Request) string {
+	var parameters []string
+	for name, values := range request.URL.Query() {
+		for _, value := range values {
+			parameters = append(parameters, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(parameters)
+	return strings.Join(parameters, "&")
+}
+
+// s3Escape URI-encodes everything but the unreserved characters, as required
+// for signing; slashes are kept in paths
+func s3Escape(s string, path bool) string {
+	var escaped []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (path && c == '/') || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			escaped = append(escaped, c)
+		} else {
+			escaped = append(escaped, []byte(fmt.Sprintf("%%%02X", c))...)
+		}
+	}
+
+	return string(escaped)
+}
+
+func s3SHA256(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
 var (
@@ -72,6 +74,158 @@ func TargzIterate(filename string, targz io.Reader, fn func(*tar.Reader, *tar.He
 	}
 }
 
+// ExtractTargz extracts a tar.gz stream into the destination directory,
+// creating it as needed. Entries pointing outside of the destination, by
+// their name or through symbolic links, are rejected. Filename is only used
+// for error generation.
+func ExtractTargz(filename string, targz io.Reader, destination string) error {
+	return extractTargz(filename, targz, destination, false)
+}
+
+// ExtractCompiledPackageTargz extracts a tar.gz stream of a compiled package,
+// as written by WriteTargz, into the destination directory. Unlike
+// ExtractTargz, symbolic links are kept as they are, as compiled packages
+// link to /var/vcap/packages and to other packages; they are only followed
+// inside the destination while extracting, so entries can still not be
+// written outside of it.
+func ExtractCompiledPackageTargz(filename string, targz io.Reader, destination string) error {
+	return extractTargz(filename, targz, destination, true)
+}
+
+// extractTargz implements ExtractTargz and ExtractCompiledPackageTargz;
+// with keepLinks set, symbolic links leading outside of the destination are
+// extracted rather than rejected
+func extractTargz(filename string, targz io.Reader, destination string, keepLinks bool) error {
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return err
+	}
+	root := filepath.Clean(destination)
+
+	return TargzIterate(filename, targz, func(reader *tar.Reader, header *tar.Header) error {
+		name := path.Clean(filepath.ToSlash(header.Name))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("%s contains entry %s outside of the extraction directory", filename, header.Name)
+		}
+		if name == "." {
+			return nil
+		}
+
+		// Resolve the parent directories inside the destination, so that
+		// symbolic links extracted earlier can not lead out of it
+		dir, base := path.Split(name)
+		parent, err := resolveInRoot(root, dir, true)
+		if err != nil {
+			return fmt.Errorf("%s: failed to extract %s: %v", filename, header.Name, err)
+		}
+		target := filepath.Join(parent, base)
+
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			target, err = resolveInRoot(root, name, true)
+			if err != nil {
+				return fmt.Errorf("%s: failed to extract %s: %v", filename, header.Name, err)
+			}
+			return os.MkdirAll(target, mode|0700)
+		case tar.TypeSymlink:
+			link := path.Clean(header.Linkname)
+			linked := path.Join(path.Dir(name), link)
+			if !keepLinks && (path.IsAbs(link) || linked == ".." || strings.HasPrefix(linked, "../")) {
+				return fmt.Errorf("%s contains symbolic link %s to %s outside of the extraction directory", filename, header.Name, header.Linkname)
+			}
+			if err := os.MkdirAll(parent, 0755); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			return os.Symlink(header.Linkname, target)
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(parent, 0755); err != nil {
+				return err
+			}
+			// Replace whatever is there, rather than writing through it
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(file, reader); err != nil {
+				file.Close()
+				return fmt.Errorf("%s: failed to extract %s: %v", filename, header.Name, err)
+			}
+			return file.Close()
+		default:
+			// Ignore devices, hard links and other special entries; BOSH
+			// release tarballs do not contain them.
+			return nil
+		}
+	})
+}
+
+// WriteTargz writes the contents of the directory as a tar.gz stream, with
+// entry names relative to the directory. Regular files, directories and
+// symbolic links are written; other entries are skipped.
+func WriteTargz(dir string, w io.Writer) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+
+		link := ""
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case info.IsDir(), info.Mode().IsRegular():
+		default:
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
 // writeHeaderToTarStream writes a tar header with default values as appropriate
 func writeHeaderToTarStream(stream *tar.Writer, header tar.Header) error {
 	if header.Mode == 0 {
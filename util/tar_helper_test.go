@@ -85,3 +85,140 @@ func TestCopyFileToTarStream(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(expected, actual, "Incorrect data read")
 }
+
+func TestWriteTargz(t *testing.T) {
+	assert := assert.New(t)
+
+	source, err := ioutil.TempDir("", "fissile-tar-test")
+	assert.NoError(err)
+	defer os.RemoveAll(source)
+	assert.NoError(os.MkdirAll(filepath.Join(source, "bin"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(source, "bin", "tool"), []byte("#!/bin/sh"), 0755))
+	assert.NoError(os.Symlink("bin/tool", filepath.Join(source, "link")))
+
+	buf := bytes.Buffer{}
+	assert.NoError(WriteTargz(source, &buf))
+
+	destination, err := ioutil.TempDir("", "fissile-tar-test")
+	assert.NoError(err)
+	defer os.RemoveAll(destination)
+	assert.NoError(ExtractTargz("test.tgz", &buf, destination))
+
+	contents, err := ioutil.ReadFile(filepath.Join(destination, "bin", "tool"))
+	assert.NoError(err)
+	assert.Equal("#!/bin/sh", string(contents))
+	info, err := os.Stat(filepath.Join(destination, "bin", "tool"))
+	assert.NoError(err)
+	assert.EqualValues(0755, info.Mode().Perm())
+	link, err := os.Readlink(filepath.Join(destination, "link"))
+	assert.NoError(err)
+	assert.Equal("bin/tool", link)
+}
+
+func TestExtractTargzEscape(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-tar-test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	assert.NoError(os.MkdirAll(outside, 0755))
+
+	for _, entries := range [][]testTarEntry{
+		{
+			{name: "x", typeflag: tar.TypeSymlink, link: outside},
+			{name: "x/passwd", typeflag: tar.TypeReg, contents: "root"},
+		},
+		{
+			{name: "sub/", typeflag: tar.TypeDir},
+			{name: "sub/x", typeflag: tar.TypeSymlink, link: "../../outside"},
+			{name: "sub/x/passwd", typeflag: tar.TypeReg, contents: "root"},
+		},
+		{
+			{name: "../outside/passwd", typeflag: tar.TypeReg, contents: "root"},
+		},
+	} {
+		destination := filepath.Join(dir, "destination")
+		assert.NoError(os.RemoveAll(destination))
+		err := ExtractTargz("test.tgz", bytes.NewReader(testTar(t, true, entries...)), destination)
+		if assert.Error(err, entries[0].name) {
+			assert.Contains(err.Error(), "outside of the extraction directory")
+		}
+		_, err = os.Stat(filepath.Join(outside, "passwd"))
+		assert.True(os.IsNotExist(err), "%s: no file may be written outside of the destination", entries[0].name)
+	}
+
+	// Links already in the destination are followed inside of it, and files
+	// replace links instead of writing through them
+	destination := filepath.Join(dir, "destination")
+	assert.NoError(os.RemoveAll(destination))
+	assert.NoError(os.MkdirAll(destination, 0755))
+	assert.NoError(os.Symlink(outside, filepath.Join(destination, "x")))
+	assert.NoError(ioutil.WriteFile(filepath.Join(outside, "target"), []byte("outside"), 0644))
+	assert.NoError(os.Symlink(filepath.Join(outside, "target"), filepath.Join(destination, "y")))
+	err = ExtractTargz("test.tgz", bytes.NewReader(testTar(t, true,
+		testTarEntry{name: "x/passwd", typeflag: tar.TypeReg, contents: "root"},
+		testTarEntry{name: "y", typeflag: tar.TypeReg, contents: "inside"},
+		testTarEntry{name: "lib", typeflag: tar.TypeSymlink, link: "usr/lib"},
+		testTarEntry{name: "usr/lib/libc.so", typeflag: tar.TypeReg, contents: "libc"},
+	)), destination)
+	assert.NoError(err)
+	_, err = os.Stat(filepath.Join(outside, "passwd"))
+	assert.True(os.IsNotExist(err), "No file may be written outside of the destination")
+	contents, err := ioutil.ReadFile(filepath.Join(outside, "target"))
+	assert.NoError(err)
+	assert.Equal("outside", string(contents))
+	contents, err = ioutil.ReadFile(filepath.Join(destination, "y"))
+	assert.NoError(err)
+	assert.Equal("inside", string(contents))
+	contents, err = ioutil.ReadFile(filepath.Join(destination, "lib", "libc.so"))
+	assert.NoError(err)
+	assert.Equal("libc", string(contents))
+}
+
+func TestExtractCompiledPackageTargz(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fissile-tar-test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	assert.NoError(os.MkdirAll(outside, 0755))
+	destination := filepath.Join(dir, "destination")
+
+	// Links leading out of the destination are kept as they are, but are
+	// followed inside of it while extracting
+	err = ExtractCompiledPackageTargz("test.tgz", bytes.NewReader(testTar(t, true,
+		testTarEntry{name: "lib", typeflag: tar.TypeSymlink, link: "/var/vcap/packages/libevent/lib"},
+		testTarEntry{name: "bin/", typeflag: tar.TypeDir},
+		testTarEntry{name: "bin/openssl", typeflag: tar.TypeSymlink, link: "../../openssl/bin/openssl"},
+		testTarEntry{name: "x", typeflag: tar.TypeSymlink, link: outside},
+		testTarEntry{name: "x/passwd", typeflag: tar.TypeReg, contents: "root"},
+		testTarEntry{name: "up", typeflag: tar.TypeSymlink, link: "../outside"},
+		testTarEntry{name: "up/shadow", typeflag: tar.TypeReg, contents: "root"},
+	)), destination)
+	assert.NoError(err)
+
+	for name, expected := range map[string]string{
+		"lib":                           "/var/vcap/packages/libevent/lib",
+		filepath.Join("bin", "openssl"): "../../openssl/bin/openssl",
+		"x":                             outside,
+		"up":                            "../outside",
+	} {
+		link, err := os.Readlink(filepath.Join(destination, name))
+		if assert.NoError(err, name) {
+			assert.Equal(expected, link, name)
+		}
+	}
+	for _, name := range []string{"passwd", "shadow"} {
+		_, err = os.Stat(filepath.Join(outside, name))
+		assert.True(os.IsNotExist(err), "%s: no file may be written outside of the destination", name)
+	}
+
+	err = ExtractCompiledPackageTargz("test.tgz", bytes.NewReader(testTar(t, true,
+		testTarEntry{name: "../outside/passwd", typeflag: tar.TypeReg, contents: "root"},
+	)), destination)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "outside of the extraction directory")
+	}
+}
@@ -13,13 +13,50 @@ type Error struct {
 	Field    string
 	BadValue interface{}
 	Detail   string
+	File     string   // The file which introduced the field, if known
+	Line     int      // The line of the field in the file, if known
+	Column   int      // The column of the field in the file, if known
+	Rule     string   // The ID of the rule which was violated, if any
+	Severity Severity // How serious the violation is; errors by default
 }
 
 // Error implements the error interface.
 func (v *Error) Error() string {
+	if location := v.Location(); location != "" {
+		return fmt.Sprintf("%s: %s: %s", location, v.Field, v.ErrorBody())
+	}
 	return fmt.Sprintf("%s: %s", v.Field, v.ErrorBody())
 }
 
+// Location returns where the field is, as file:line:column, or just the file
+// if the position in the file is not known. It is empty if the file is not
+// known.
+func (v *Error) Location() string {
+	if v.File == "" {
+		return ""
+	}
+	if v.Line == 0 {
+		return v.File
+	}
+	return fmt.Sprintf("%s:%d:%d", v.File, v.Line, v.Column)
+}
+
+// Locate records where the field is, and returns the error
+func (v *Error) Locate(file string, line, column int) *Error {
+	v.File = file
+	v.Line = line
+	v.Column = column
+	return v
+}
+
+// WithRule records the ID of the violated rule and its severity, and returns
+// the error
+func (v *Error) WithRule(rule string, severity Severity) *Error {
+	v.Rule = rule
+	v.Severity = severity
+	return v
+}
+
 // ErrorBody returns the error message without the field name.  This is useful
 // for building nice-looking higher-level error reporting.
 func (v *Error) ErrorBody() string {
@@ -43,6 +80,34 @@ func (v *Error) ErrorBody() string {
 	return s
 }
 
+// Severity is how serious a validation error is. Only errors fail a
+// validation; warnings fail only a strict one, and infos never do.
+type Severity int
+
+const (
+	// SeverityError is used for violations which must be fixed. It is the
+	// default severity.
+	SeverityError Severity = iota
+	// SeverityWarning is used for violations which should be fixed
+	SeverityWarning
+	// SeverityInfo is used for noteworthy findings which need no fixing
+	SeverityInfo
+)
+
+// String converts a Severity into its name
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		panic(fmt.Sprintf("unrecognized validation severity: %d", int(s)))
+	}
+}
+
 // ErrorType is a machine readable value providing more detail about why
 // a field is invalid.
 type ErrorType string
@@ -105,26 +170,26 @@ func (t ErrorType) String() string {
 // NotFound returns a *Error indicating "value not found".  This is
 // used to report failure to find a requested value (e.g. looking up an ID).
 func NotFound(field string, value interface{}) *Error {
-	return &Error{ErrorTypeNotFound, field, value, ""}
+	return &Error{Type: ErrorTypeNotFound, Field: field, BadValue: value}
 }
 
 // Required returns a *Error indicating "value required".  This is used
 // to report required values that are not provided (e.g. empty strings, null
 // values, or empty arrays).
 func Required(field string, detail string) *Error {
-	return &Error{ErrorTypeRequired, field, "", detail}
+	return &Error{Type: ErrorTypeRequired, Field: field, BadValue: "", Detail: detail}
 }
 
 // Duplicate returns a *Error indicating "duplicate value".  This is
 // used to report collisions of values that must be unique (e.g. names or IDs).
 func Duplicate(field string, value interface{}) *Error {
-	return &Error{ErrorTypeDuplicate, field, value, ""}
+	return &Error{Type: ErrorTypeDuplicate, Field: field, BadValue: value}
 }
 
 // Invalid returns a *Error indicating "invalid value".  This is used
 // to report malformed values (e.g. failed regex match, too long, out of bounds).
 func Invalid(field string, value interface{}, detail string) *Error {
-	return &Error{ErrorTypeInvalid, field, value, detail}
+	return &Error{Type: ErrorTypeInvalid, Field: field, BadValue: value, Detail: detail}
 }
 
 // NotSupported returns a *Error indicating "unsupported value".
@@ -135,7 +200,7 @@ func NotSupported(field string, value interface{}, validValues []string) *Error
 	if validValues != nil && len(validValues) > 0 {
 		detail = "supported values: " + strings.Join(validValues, ", ")
 	}
-	return &Error{ErrorTypeNotSupported, field, value, detail}
+	return &Error{Type: ErrorTypeNotSupported, Field: field, BadValue: value, Detail: detail}
 }
 
 // Forbidden returns a *Error indicating "forbidden".  This is used to
@@ -143,7 +208,7 @@ func NotSupported(field string, value interface{}, validValues []string) *Error
 // some conditions, but which are not permitted by current conditions (e.g.
 // security policy).
 func Forbidden(field string, detail string) *Error {
-	return &Error{ErrorTypeForbidden, field, "", detail}
+	return &Error{Type: ErrorTypeForbidden, Field: field, BadValue: "", Detail: detail}
 }
 
 // TooLong returns a *Error indicating "too long".  This is used to
@@ -151,14 +216,14 @@ func Forbidden(field string, detail string) *Error {
 // Invalid, but the returned error will not include the too-long
 // value.
 func TooLong(field string, value interface{}, maxLength int) *Error {
-	return &Error{ErrorTypeTooLong, field, value, fmt.Sprintf("must have at most %d characters", maxLength)}
+	return &Error{Type: ErrorTypeTooLong, Field: field, BadValue: value, Detail: fmt.Sprintf("must have at most %d characters", maxLength)}
 }
 
 // InternalError returns a *Error indicating "internal error".  This is used
 // to signal that an error was found that was not directly related to user
 // input.  The err argument must be non-nil.
 func InternalError(field string, err error) *Error {
-	return &Error{ErrorTypeInternal, field, nil, err.Error()}
+	return &Error{Type: ErrorTypeInternal, Field: field, BadValue: nil, Detail: err.Error()}
 }
 
 // ErrorList holds a set of Errors.  It is plausible that we might one day have
@@ -176,3 +241,29 @@ func (v *ErrorList) Errors() string {
 
 	return strings.Join(values, "\n")
 }
+
+// Error implements the error interface, so that the errors can be returned
+// as a whole and inspected by the caller.
+func (v ErrorList) Error() string {
+	return v.Errors()
+}
+
+// OfSeverity returns the errors of the given severity
+func (v ErrorList) OfSeverity(severity Severity) ErrorList {
+	result := ErrorList{}
+	for _, err := range v {
+		if err.Severity == severity {
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
+// PromoteWarnings turns all warnings into errors, for strict validation
+func (v ErrorList) PromoteWarnings() {
+	for _, err := range v {
+		if err.Severity == SeverityWarning {
+			err.Severity = SeverityError
+		}
+	}
+}
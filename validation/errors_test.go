@@ -74,3 +74,45 @@ func TestErrorUsefulMessage(t *testing.T) {
 		assert.Contains(t, s, part)
 	}
 }
+
+func TestErrorMessageWithFile(t *testing.T) {
+	err := Invalid("foo", "bar", "deet")
+	assert.Equal(t, `foo: Invalid value: "bar": deet`, err.Error())
+
+	err.File = "ops.yml"
+	assert.Equal(t, `ops.yml: foo: Invalid value: "bar": deet`, err.Error())
+}
+
+func TestErrorMessageWithPosition(t *testing.T) {
+	err := Invalid("foo", "bar", "deet")
+	assert.Empty(t, err.Location())
+
+	// A position without a file is not shown
+	err.Line = 3
+	assert.Equal(t, `foo: Invalid value: "bar": deet`, err.Error())
+
+	assert.Equal(t, err, err.Locate("role-manifest.yml", 12, 5))
+	assert.Equal(t, "role-manifest.yml:12:5", err.Location())
+	assert.Equal(t, `role-manifest.yml:12:5: foo: Invalid value: "bar": deet`, err.Error())
+}
+
+func TestErrorSeverity(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := ErrorList{
+		Invalid("a", "b", "c"),
+		Invalid("d", "e", "f").WithRule("rule-d", SeverityWarning),
+		Invalid("g", "h", "i").WithRule("rule-g", SeverityInfo),
+	}
+	assert.Equal(SeverityError, errs[0].Severity)
+	assert.Equal("rule-d", errs[1].Rule)
+	assert.Equal("warning", errs[1].Severity.String())
+
+	assert.Equal(ErrorList{errs[0]}, errs.OfSeverity(SeverityError))
+	assert.Equal(ErrorList{errs[1]}, errs.OfSeverity(SeverityWarning))
+	assert.Equal(ErrorList{errs[2]}, errs.OfSeverity(SeverityInfo))
+
+	errs.PromoteWarnings()
+	assert.Equal(ErrorList{errs[0], errs[1]}, errs.OfSeverity(SeverityError))
+	assert.Equal(ErrorList{errs[2]}, errs.OfSeverity(SeverityInfo))
+}
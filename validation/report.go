@@ -0,0 +1,295 @@
+package validation
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// reportError is a validation error as written in machine readable reports
+type reportError struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Field    string `json:"field"`
+	Type     string `json:"type"`
+	Rule     string `json:"rule,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// reportErrors converts the errors for reports, with files relative to the
+// base directory if they are inside it
+func reportErrors(errs ErrorList, baseDir string) []reportError {
+	result := make([]reportError, 0, len(errs))
+	for _, err := range errs {
+		result = append(result, reportError{
+			File:     relativeReportPath(err.File, baseDir),
+			Line:     err.Line,
+			Column:   err.Column,
+			Field:    err.Field,
+			Type:     string(err.Type),
+			Rule:     err.Rule,
+			Severity: err.Severity.String(),
+			Message:  err.ErrorBody(),
+		})
+	}
+	return result
+}
+
+// relativeReportPath returns the path relative to the base directory, if it
+// is inside it, and the path as it is otherwise
+func relativeReportPath(path, baseDir string) string {
+	if path == "" || baseDir == "" || !filepath.IsAbs(path) {
+		return path
+	}
+	relPath, err := filepath.Rel(baseDir, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// WriteJSON writes the errors as a JSON document. It is valid if none of
+// them have error severity.
+func WriteJSON(w io.Writer, errs ErrorList, baseDir string) error {
+	report := struct {
+		Valid  bool          `json:"valid"`
+		Errors []reportError `json:"errors"`
+	}{
+		Valid:  len(errs.OfSeverity(SeverityError)) == 0,
+		Errors: reportErrors(errs, baseDir),
+	}
+
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the errors as a JUnit XML report, with a failed test
+// case per error, grouped into a test suite per file. Warnings and infos are
+// passing test cases, with their message as output. Without any errors, the
+// report has a single passing test case.
+func WriteJUnit(w io.Writer, errs ErrorList, baseDir string) error {
+	suitesByFile := map[string]*junitTestSuite{}
+	var files []string
+
+	for _, err := range reportErrors(errs, baseDir) {
+		suite, ok := suitesByFile[err.File]
+		if !ok {
+			suite = &junitTestSuite{Name: err.File}
+			if suite.Name == "" {
+				suite.Name = "fissile validate"
+			}
+			suitesByFile[err.File] = suite
+			files = append(files, err.File)
+		}
+
+		location := err.File
+		if err.Line != 0 {
+			location = fmt.Sprintf("%s:%d:%d", err.File, err.Line, err.Column)
+		}
+		text := strings.TrimPrefix(fmt.Sprintf("%s: %s: %s", location, err.Field, err.Message), ": ")
+		testCase := junitTestCase{ClassName: suite.Name, Name: err.Field}
+		if err.Rule != "" {
+			testCase.Name = fmt.Sprintf("%s [%s]", err.Field, err.Rule)
+		}
+		if err.Severity == SeverityError.String() {
+			suite.Failures++
+			testCase.Failure = &junitFailure{Type: err.Type, Message: err.Message, Text: text}
+		} else {
+			testCase.SystemOut = fmt.Sprintf("%s: %s", err.Severity, text)
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	report := junitTestSuites{}
+	sort.Strings(files)
+	for _, file := range files {
+		report.Suites = append(report.Suites, *suitesByFile[file])
+	}
+	if len(report.Suites) == 0 {
+		report.Suites = []junitTestSuite{{
+			Name:  "fissile validate",
+			Tests: 1,
+			Cases: []junitTestCase{{ClassName: "fissile validate", Name: "validate"}},
+		}}
+	}
+
+	buf, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, buf)
+	return err
+}
+
+// sarifSchema is the JSON schema of the SARIF reports written
+const sarifSchema = "https://schemastore.azurewebsites.net/schemas/json/sarif-2.1.0-rtm.4.json"
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// sarifURI returns the URI of a file for SARIF logs; relative paths are
+// kept, as they are relative to the repository checked
+func sarifURI(path string) string {
+	if filepath.IsAbs(path) {
+		return "file://" + filepath.ToSlash(path)
+	}
+	return path
+}
+
+// sarifLevels maps severities to the levels of SARIF results
+var sarifLevels = map[Severity]string{
+	SeverityError:   "error",
+	SeverityWarning: "warning",
+	SeverityInfo:    "note",
+}
+
+// WriteSARIF writes the errors as a SARIF 2.1.0 log, as understood by code
+// review tools, with a rule per violated rule ID, or per error type for errors
+// without one. Rules are described by the given descriptions of the rule IDs,
+// or by the error type.
+func WriteSARIF(w io.Writer, errs ErrorList, baseDir, version string, ruleDescriptions map[string]string) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "fissile",
+			Version:        version,
+			InformationURI: "https://github.com/SUSE/fissile",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+
+	rules := map[string]bool{}
+	for index, err := range reportErrors(errs, baseDir) {
+		ruleID := err.Rule
+		description, ok := ruleDescriptions[ruleID]
+		if ruleID == "" {
+			ruleID = err.Type
+			description = errs[index].Type.String()
+		} else if !ok {
+			description = ruleID
+		}
+		if !rules[ruleID] {
+			rules[ruleID] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               ruleID,
+				ShortDescription: sarifMessage{Text: description},
+			})
+		}
+
+		result := sarifResult{
+			RuleID:  ruleID,
+			Level:   sarifLevels[errs[index].Severity],
+			Message: sarifMessage{Text: fmt.Sprintf("%s: %s", err.Field, err.Message)},
+		}
+		if err.File != "" {
+			location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: sarifURI(err.File)},
+			}}
+			if err.Line != 0 {
+				location.PhysicalLocation.Region = &sarifRegion{StartLine: err.Line, StartColumn: err.Column}
+			}
+			result.Locations = []sarifLocation{location}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	buf, err := json.MarshalIndent(sarifLog{
+		Version: "2.1.0",
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", buf)
+	return err
+}
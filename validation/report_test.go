@@ -0,0 +1,144 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReportErrors() ErrorList {
+	return ErrorList{
+		NotFound("variables", "UNDECLARED").Locate("/work/defaults.env", 2, 1),
+		Invalid("configuration.templates", "b", "Does not sort before 'a'").Locate("/work/role-manifest.yml", 21, 5),
+		Forbidden("roles[myrole]", "Unknown role").Locate("/elsewhere/ops.yml", 0, 0).WithRule("unknown-role", SeverityWarning),
+		Required("roles", ""),
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteJSON(buf, ErrorList{}, "/work"))
+	assert.JSONEq(`{"valid": true, "errors": []}`, buf.String())
+
+	// Warnings do not make it invalid
+	buf.Reset()
+	require.NoError(t, WriteJSON(buf, testReportErrors()[2:3], "/work"))
+	assert.JSONEq(`{
+		"valid": true,
+		"errors": [
+			{"file": "/elsewhere/ops.yml", "field": "roles[myrole]", "type": "FieldValueForbidden",
+				"rule": "unknown-role", "severity": "warning", "message": "Forbidden: Unknown role"}
+		]
+	}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteJSON(buf, testReportErrors(), "/work"))
+	assert.JSONEq(`{
+		"valid": false,
+		"errors": [
+			{"file": "defaults.env", "line": 2, "column": 1, "field": "variables", "type": "FieldValueNotFound",
+				"severity": "error", "message": "Not found: \"UNDECLARED\""},
+			{"file": "role-manifest.yml", "line": 21, "column": 5, "field": "configuration.templates", "type": "FieldValueInvalid",
+				"severity": "error", "message": "Invalid value: \"b\": Does not sort before 'a'"},
+			{"file": "/elsewhere/ops.yml", "field": "roles[myrole]", "type": "FieldValueForbidden",
+				"rule": "unknown-role", "severity": "warning", "message": "Forbidden: Unknown role"},
+			{"field": "roles", "type": "FieldValueRequired", "severity": "error", "message": "Required value"}
+		]
+	}`, buf.String())
+}
+
+func TestWriteJUnit(t *testing.T) {
+	assert := assert.New(t)
+
+	var report junitTestSuites
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteJUnit(buf, ErrorList{}, "/work"))
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &report))
+	if assert.Len(report.Suites, 1) {
+		assert.Equal(1, report.Suites[0].Tests)
+		assert.Equal(0, report.Suites[0].Failures)
+	}
+
+	buf.Reset()
+	require.NoError(t, WriteJUnit(buf, testReportErrors(), "/work"))
+	report = junitTestSuites{}
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &report))
+
+	var names []string
+	for _, suite := range report.Suites {
+		names = append(names, suite.Name)
+	}
+	assert.Equal([]string{"fissile validate", "/elsewhere/ops.yml", "defaults.env", "role-manifest.yml"}, names)
+
+	// Warnings are passing test cases
+	if assert.Len(report.Suites[1].Cases, 1) {
+		assert.Equal(0, report.Suites[1].Failures)
+		assert.Nil(report.Suites[1].Cases[0].Failure)
+		assert.Equal("roles[myrole] [unknown-role]", report.Suites[1].Cases[0].Name)
+		assert.Equal("warning: /elsewhere/ops.yml: roles[myrole]: Forbidden: Unknown role", report.Suites[1].Cases[0].SystemOut)
+	}
+
+	if assert.Len(report.Suites, 4) && assert.Len(report.Suites[3].Cases, 1) {
+		testCase := report.Suites[3].Cases[0]
+		assert.Equal("configuration.templates", testCase.Name)
+		if assert.NotNil(testCase.Failure) {
+			assert.Equal("FieldValueInvalid", testCase.Failure.Type)
+			assert.Equal(`role-manifest.yml:21:5: configuration.templates: Invalid value: "b": Does not sort before 'a'`, testCase.Failure.Text)
+		}
+	}
+	if assert.Len(report.Suites[0].Cases, 1) && assert.NotNil(report.Suites[0].Cases[0].Failure) {
+		assert.Equal("roles: Required value", report.Suites[0].Cases[0].Failure.Text)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteSARIF(buf, append(testReportErrors(),
+		Invalid("roles[other]", "x", "Something else").WithRule("unknown-role", SeverityWarning),
+		Invalid("roles", "y", "Not described").WithRule("undescribed", SeverityError),
+	), "/work", "1.2.3", map[string]string{"unknown-role": "Roles are known"}))
+
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Equal("2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+
+	run := log.Runs[0]
+	assert.Equal("fissile", run.Tool.Driver.Name)
+	assert.Equal("1.2.3", run.Tool.Driver.Version)
+	require.Len(t, run.Results, 6)
+
+	// Rules are described by their fixed descriptions, or by the error type
+	assert.Equal([]sarifRule{
+		{ID: "FieldValueNotFound", ShortDescription: sarifMessage{Text: "Not found"}},
+		{ID: "FieldValueInvalid", ShortDescription: sarifMessage{Text: "Invalid value"}},
+		{ID: "unknown-role", ShortDescription: sarifMessage{Text: "Roles are known"}},
+		{ID: "FieldValueRequired", ShortDescription: sarifMessage{Text: "Required value"}},
+		{ID: "undescribed", ShortDescription: sarifMessage{Text: "undescribed"}},
+	}, run.Tool.Driver.Rules)
+
+	assert.Equal("FieldValueInvalid", run.Results[1].RuleID)
+	assert.Equal("error", run.Results[1].Level)
+	if assert.Len(run.Results[1].Locations, 1) {
+		location := run.Results[1].Locations[0].PhysicalLocation
+		assert.Equal("role-manifest.yml", location.ArtifactLocation.URI)
+		assert.Equal(&sarifRegion{StartLine: 21, StartColumn: 5}, location.Region)
+	}
+	assert.Equal("unknown-role", run.Results[2].RuleID)
+	assert.Equal("warning", run.Results[2].Level)
+	if assert.Len(run.Results[2].Locations, 1) {
+		location := run.Results[2].Locations[0].PhysicalLocation
+		assert.Equal("file:///elsewhere/ops.yml", location.ArtifactLocation.URI)
+		assert.Nil(location.Region)
+	}
+	assert.Empty(run.Results[3].Locations)
+}